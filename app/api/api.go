@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"crypto/tls"
+	"encoding/hex"
 	"fmt"
 	"io"
 	golog "log"
@@ -10,9 +11,12 @@ import (
 	gonet "net"
 	gohttp "net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime/debug"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/datarhei/core/v16/app"
@@ -87,6 +91,7 @@ type api struct {
 	errorChan chan error
 
 	gcTickerStop context.CancelFunc
+	signalStop   context.CancelFunc
 
 	log struct {
 		writer io.Writer
@@ -472,7 +477,7 @@ func (a *api) start() error {
 
 	var portrange net.Portranger
 
-	if cfg.Playout.Enable {
+	if cfg.Playout.Enable && len(cfg.Playout.SocketDir) == 0 {
 		portrange, err = net.NewPortrange(cfg.Playout.MinPort, cfg.Playout.MaxPort)
 		if err != nil {
 			return fmt.Errorf("playout port range: %w", err)
@@ -489,6 +494,26 @@ func (a *api) start() error {
 		return fmt.Errorf("output address validator: %w", err)
 	}
 
+	ffmpegAlt := []ffmpeg.FFmpeg{}
+
+	for _, binary := range cfg.FFmpeg.AltBinaries {
+		alt, err := ffmpeg.New(ffmpeg.Config{
+			Binary:           binary,
+			MaxProc:          cfg.FFmpeg.MaxProcesses,
+			MaxLogLines:      cfg.FFmpeg.Log.MaxLines,
+			LogHistoryLength: cfg.FFmpeg.Log.MaxHistory,
+			ValidatorInput:   validatorIn,
+			ValidatorOutput:  validatorOut,
+			Portrange:        portrange,
+			Collector:        a.sessions.Collector("ffmpeg"),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to create alternative ffmpeg (%s): %w", binary, err)
+		}
+
+		ffmpegAlt = append(ffmpegAlt, alt)
+	}
+
 	ffmpeg, err := ffmpeg.New(ffmpeg.Config{
 		Binary:           cfg.FFmpeg.Binary,
 		MaxProc:          cfg.FFmpeg.MaxProcesses,
@@ -506,71 +531,7 @@ func (a *api) start() error {
 	a.ffmpeg = ffmpeg
 
 	a.replacer = replace.New()
-
-	{
-		a.replacer.RegisterTemplateFunc("diskfs", func(config *restreamapp.Config, section string) string {
-			return a.diskfs.Metadata("base")
-		}, nil)
-
-		a.replacer.RegisterTemplateFunc("fs:disk", func(config *restreamapp.Config, section string) string {
-			return a.diskfs.Metadata("base")
-		}, nil)
-
-		a.replacer.RegisterTemplateFunc("memfs", func(config *restreamapp.Config, section string) string {
-			return a.memfs.Metadata("base")
-		}, nil)
-
-		a.replacer.RegisterTemplateFunc("fs:mem", func(config *restreamapp.Config, section string) string {
-			return a.memfs.Metadata("base")
-		}, nil)
-
-		for name, s3 := range a.s3fs {
-			a.replacer.RegisterTemplate("fs:"+name, s3.Metadata("base"), nil)
-		}
-
-		a.replacer.RegisterTemplateFunc("rtmp", func(config *restreamapp.Config, section string) string {
-			host, port, _ := gonet.SplitHostPort(cfg.RTMP.Address)
-			if len(host) == 0 {
-				host = "localhost"
-			}
-
-			template := "rtmp://" + host + ":" + port
-			if cfg.RTMP.App != "/" {
-				template += cfg.RTMP.App
-			}
-			template += "/{name}"
-
-			if len(cfg.RTMP.Token) != 0 {
-				template += "?token=" + cfg.RTMP.Token
-			}
-
-			return template
-		}, nil)
-
-		a.replacer.RegisterTemplateFunc("srt", func(config *restreamapp.Config, section string) string {
-			host, port, _ = gonet.SplitHostPort(cfg.SRT.Address)
-			if len(host) == 0 {
-				host = "localhost"
-			}
-
-			template := "srt://" + host + ":" + port + "?mode=caller&transtype=live&latency={latency}&streamid={name}"
-			if section == "output" {
-				template += ",mode:publish"
-			} else {
-				template += ",mode:request"
-			}
-			if len(cfg.SRT.Token) != 0 {
-				template += ",token:" + cfg.SRT.Token
-			}
-			if len(cfg.SRT.Passphrase) != 0 {
-				template += "&passphrase=" + cfg.SRT.Passphrase
-			}
-
-			return template
-		}, map[string]string{
-			"latency": "20000", // 20 milliseconds, FFmpeg requires microseconds
-		})
-	}
+	a.registerTemplates(cfg)
 
 	filesystems := []fs.Filesystem{
 		a.diskfs,
@@ -590,25 +551,115 @@ func (a *api) start() error {
 		if err != nil {
 			return err
 		}
-		store, err = restreamstore.NewJSON(restreamstore.JSONConfig{
-			Filesystem: fs,
-			Filepath:   "/db.json",
-			Logger:     a.log.logger.core.WithComponent("ProcessStore"),
-		})
+
+		// The key for encrypting the DB file is hex encoded
+		var key []byte
+		if len(cfg.DB.EncryptionKey) != 0 {
+			key, err = hex.DecodeString(cfg.DB.EncryptionKey)
+			if err != nil {
+				return fmt.Errorf("invalid DB encryption key: %w", err)
+			}
+		}
+
+		switch cfg.DB.Store {
+		case "bolt":
+			// An existing JSON store will be migrated into the empty database
+			var jsonstore restreamstore.Store
+			jsonstore, err = restreamstore.NewJSON(restreamstore.JSONConfig{
+				Filesystem:    fs,
+				Filepath:      "/db.json",
+				Logger:        a.log.logger.core.WithComponent("ProcessStore"),
+				EncryptionKey: key,
+			})
+			if err != nil {
+				return err
+			}
+
+			store, err = restreamstore.NewBolt(restreamstore.BoltConfig{
+				Filepath: filepath.Join(cfg.DB.Dir, "db.bolt"),
+				Logger:   a.log.logger.core.WithComponent("ProcessStore"),
+				Migrate:  jsonstore,
+			})
+		case "journal":
+			store, err = restreamstore.NewJournal(restreamstore.JournalConfig{
+				Filesystem:    fs,
+				Filepath:      "/db.json",
+				Logger:        a.log.logger.core.WithComponent("ProcessStore"),
+				EncryptionKey: key,
+			})
+		case "partitioned":
+			store, err = restreamstore.NewPartitioned(restreamstore.PartitionedConfig{
+				Filesystem:    fs,
+				Filepath:      "/db.json",
+				Logger:        a.log.logger.core.WithComponent("ProcessStore"),
+				EncryptionKey: key,
+			})
+		default:
+			store, err = restreamstore.NewJSON(restreamstore.JSONConfig{
+				Filesystem:    fs,
+				Filepath:      "/db.json",
+				Logger:        a.log.logger.core.WithComponent("ProcessStore"),
+				EncryptionKey: key,
+			})
+		}
 		if err != nil {
 			return err
 		}
 	}
 
+	playoutSocketDir := ""
+	playoutBindHost := ""
+	playoutAdvertiseHost := ""
+	if cfg.Playout.Enable {
+		playoutSocketDir = cfg.Playout.SocketDir
+		playoutBindHost = cfg.Playout.BindHost
+		playoutAdvertiseHost = cfg.Playout.AdvertiseHost
+	}
+
+	devices := []restream.Device{}
+	for _, d := range cfg.FFmpeg.Devices {
+		devices = append(devices, restream.Device{
+			ID:       d.ID,
+			HWAccel:  d.HWAccel,
+			Device:   d.Device,
+			Capacity: d.Capacity,
+		})
+	}
+
 	restream, err := restream.New(restream.Config{
-		ID:           cfg.ID,
-		Name:         cfg.Name,
-		Store:        store,
-		Filesystems:  filesystems,
-		Replace:      a.replacer,
-		FFmpeg:       a.ffmpeg,
-		MaxProcesses: cfg.FFmpeg.MaxProcesses,
-		Logger:       a.log.logger.core.WithComponent("Process"),
+		ID:                   cfg.ID,
+		Name:                 cfg.Name,
+		Store:                store,
+		Filesystems:          filesystems,
+		Replace:              a.replacer,
+		FFmpeg:               a.ffmpeg,
+		FFmpegAlt:            ffmpegAlt,
+		MaxProcesses:         cfg.FFmpeg.MaxProcesses,
+		MaxConcurrent:        cfg.FFmpeg.MaxConcurrent,
+		Preempt:              cfg.FFmpeg.Preempt,
+		Devices:              devices,
+		StoreWatchInterval:   time.Duration(cfg.DB.WatchInterval) * time.Second,
+		Logger:               a.log.logger.core.WithComponent("Process"),
+		HookBinaries:         cfg.FFmpeg.Hooks.Allow,
+		ChangeRate:           cfg.FFmpeg.ChangeRate,
+		ChangeBurst:          cfg.FFmpeg.ChangeBurst,
+		PlayoutSocketDir:     playoutSocketDir,
+		PlayoutBindHost:      playoutBindHost,
+		PlayoutAdvertiseHost: playoutAdvertiseHost,
+		ProbeTimeout:         time.Duration(cfg.FFmpeg.ProbeTimeout) * time.Second,
+		ConfigDir:            cfg.FFmpeg.ConfigDir,
+		ConfigDirReconcile:   cfg.FFmpeg.ConfigDirReconcile,
+		OptionsAllowlist:     cfg.FFmpeg.OptionsAllowlist,
+		OutputSchemes:        cfg.FFmpeg.OutputSchemes,
+		AutostartInterval:    time.Duration(cfg.FFmpeg.AutostartInterval) * time.Second,
+		ValidateMapping:      cfg.FFmpeg.ValidateMapping,
+		Webhook: restream.WebhookConfig{
+			URL:        cfg.FFmpeg.Webhook.URL,
+			Events:     cfg.FFmpeg.Webhook.Events,
+			Timeout:    time.Duration(cfg.FFmpeg.Webhook.Timeout) * time.Second,
+			Retries:    cfg.FFmpeg.Webhook.Retries,
+			RetryDelay: time.Duration(cfg.FFmpeg.Webhook.RetryDelay) * time.Second,
+		},
 	})
 
 	if err != nil {
@@ -1011,6 +1062,11 @@ func (a *api) start() error {
 		Cors: http.CorsConfig{
 			Origins: cfg.Storage.CORS.Origins,
 		},
+		Playout: http.PlayoutConfig{
+			Attempts:          cfg.Playout.Attempts,
+			Backoff:           time.Duration(cfg.Playout.Backoff) * time.Millisecond,
+			MaxErrorframeSize: cfg.Playout.MaxErrorframeSize * 1024 * 1024,
+		},
 		RTMP:     a.rtmpserver,
 		SRT:      a.srtserver,
 		JWT:      a.httpjwt,
@@ -1259,6 +1315,17 @@ func (a *api) start() error {
 	// Start the restream processes
 	restream.Start()
 
+	if cfg.FFmpeg.ReloadOnSignal {
+		// Register for the signal right away, such that no signal gets lost
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGHUP)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		a.signalStop = cancel
+
+		go a.watchSignal(ctx, signals, restream)
+	}
+
 	// Start the service
 	if a.service != nil {
 		a.service.Start()
@@ -1312,10 +1379,22 @@ func (a *api) stop() {
 		a.service = nil
 	}
 
+	// Stop reloading the processes on a signal
+	if a.signalStop != nil {
+		a.signalStop()
+		a.signalStop = nil
+	}
+
 	// Stop all restream processes
 	if a.restream != nil {
 		logger.Info().Log("Stopping all processes ...")
-		a.restream.Stop()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := a.restream.Close(ctx); err != nil {
+			logger.Warn().WithError(err).Log("Stopping all processes")
+		}
+		cancel()
+
 		a.restream = nil
 	}
 
@@ -1426,3 +1505,129 @@ func (a *api) Destroy() {
 		a.memfs = nil
 	}
 }
+
+// registerTemplates sets the globals and the environment of the replacer and registers the
+// templates for the placeholders according to the config.
+func (a *api) registerTemplates(cfg *config.Config) {
+	a.replacer.SetGlobals(cfg.FFmpeg.Globals)
+
+	if len(cfg.FFmpeg.Environment) != 0 {
+		a.replacer.SetEnvironment(cfg.FFmpeg.Environment, cfg.FFmpeg.EnvironmentStrict)
+	} else {
+		a.replacer.SetEnvironment(nil, false)
+	}
+
+	a.replacer.RegisterTemplateFunc("diskfs", func(config *restreamapp.Config, section string) string {
+		return a.diskfs.Metadata("base")
+	}, nil)
+
+	a.replacer.RegisterTemplateFunc("fs:disk", func(config *restreamapp.Config, section string) string {
+		return a.diskfs.Metadata("base")
+	}, nil)
+
+	a.replacer.RegisterTemplateFunc("memfs", func(config *restreamapp.Config, section string) string {
+		return a.memfs.Metadata("base")
+	}, nil)
+
+	a.replacer.RegisterTemplateFunc("fs:mem", func(config *restreamapp.Config, section string) string {
+		return a.memfs.Metadata("base")
+	}, nil)
+
+	for name, s3 := range a.s3fs {
+		a.replacer.RegisterTemplate("fs:"+name, s3.Metadata("base"), nil)
+	}
+
+	a.replacer.RegisterTemplateFunc("rtmp", func(config *restreamapp.Config, section string) string {
+		host, port, _ := gonet.SplitHostPort(cfg.RTMP.Address)
+		if len(host) == 0 {
+			host = "localhost"
+		}
+
+		template := "rtmp://" + host + ":" + port
+		if cfg.RTMP.App != "/" {
+			template += cfg.RTMP.App
+		}
+		template += "/{name}"
+
+		if len(cfg.RTMP.Token) != 0 {
+			template += "?token=" + cfg.RTMP.Token
+		}
+
+		return template
+	}, nil)
+
+	a.replacer.RegisterTemplateFunc("srt", func(config *restreamapp.Config, section string) string {
+		host, port, _ := gonet.SplitHostPort(cfg.SRT.Address)
+		if len(host) == 0 {
+			host = "localhost"
+		}
+
+		template := "srt://" + host + ":" + port + "?mode=caller&transtype=live&latency={latency}&streamid={name}"
+		if section == "output" {
+			template += ",mode:publish"
+		} else {
+			template += ",mode:request"
+		}
+		if len(cfg.SRT.Token) != 0 {
+			template += ",token:" + cfg.SRT.Token
+		}
+		if len(cfg.SRT.Passphrase) != 0 {
+			template += "&passphrase=" + cfg.SRT.Passphrase
+		}
+
+		return template
+	}, map[string]string{
+		"latency": "20000", // 20 milliseconds, FFmpeg requires microseconds
+	})
+}
+
+// watchSignal reloads the processes whenever a signal is received. The config is read again
+// and the globals, the environment, and the templates of the replacer are registered from it.
+// Then the config dir is loaded again and all processes whose command changed are reloaded.
+func (a *api) watchSignal(ctx context.Context, signals chan os.Signal, rs restream.Restreamer) {
+	defer signal.Stop(signals)
+
+	logger := a.log.logger.core.WithComponent("Process")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			logger.Info().WithField("signal", sig.String()).Log("Reloading all processes")
+
+			rootfs, _ := fs.NewDiskFilesystem(fs.DiskConfig{})
+			store, err := configstore.NewJSON(rootfs, a.config.path, nil)
+			if err != nil {
+				logger.Error().WithError(err).Log("Reading config file failed")
+				continue
+			}
+
+			cfg := store.Get()
+
+			cfg.Merge()
+			cfg.Validate(false)
+
+			if cfg.HasErrors() {
+				logger.Error().WithField("path", a.config.path).Log("The config file has errors")
+				continue
+			}
+
+			a.registerTemplates(cfg)
+
+			if len(cfg.FFmpeg.ConfigDir) != 0 {
+				for name, err := range rs.LoadConfigDir(cfg.FFmpeg.ConfigDir, cfg.FFmpeg.ConfigDirReconcile) {
+					logger.Warn().WithField("file", name).WithError(err).Log("Ignoring process config")
+				}
+			}
+
+			reloaded, errs := rs.ReloadAll()
+
+			for _, err := range errs {
+				logger.Warn().WithError(err).Log("Reloading process failed")
+			}
+
+			logger.Info().WithField("processes", reloaded).Log("Reloaded processes")
+		}
+	}
+}
@@ -3,7 +3,9 @@ package config
 
 import (
 	"context"
+	"encoding/hex"
 	"net"
+	"path/filepath"
 	"time"
 
 	"github.com/datarhei/core/v16/config/copy"
@@ -126,6 +128,14 @@ func (d *Config) Clone() *Config {
 	data.FFmpeg.Access.Input.Block = copy.Slice(d.FFmpeg.Access.Input.Block)
 	data.FFmpeg.Access.Output.Allow = copy.Slice(d.FFmpeg.Access.Output.Allow)
 	data.FFmpeg.Access.Output.Block = copy.Slice(d.FFmpeg.Access.Output.Block)
+	data.FFmpeg.Hooks.Allow = copy.Slice(d.FFmpeg.Hooks.Allow)
+	data.FFmpeg.Devices = copy.Slice(d.FFmpeg.Devices)
+	data.FFmpeg.AltBinaries = copy.Slice(d.FFmpeg.AltBinaries)
+	data.FFmpeg.Webhook.Events = copy.Slice(d.FFmpeg.Webhook.Events)
+	data.FFmpeg.OptionsAllowlist = copy.Slice(d.FFmpeg.OptionsAllowlist)
+	data.FFmpeg.OutputSchemes = copy.Slice(d.FFmpeg.OutputSchemes)
+	data.FFmpeg.Globals = copy.StringMap(d.FFmpeg.Globals)
+	data.FFmpeg.Environment = copy.StringMap(d.FFmpeg.Environment)
 
 	data.Sessions.IPIgnoreList = copy.Slice(d.Sessions.IPIgnoreList)
 
@@ -154,6 +164,9 @@ func (d *Config) init() {
 
 	// DB
 	d.vars.Register(value.NewMustDir(&d.DB.Dir, "./config", d.fs), "db.dir", "CORE_DB_DIR", nil, "Directory for holding the operational data", false, false)
+	d.vars.Register(value.NewString(&d.DB.Store, "json"), "db.store", "CORE_DB_STORE", nil, "Store for the processes: json, partitioned for a separate file for the processes of each tenant, bolt for an embedded database, or journal for json with a journal for crash recovery", false, false)
+	d.vars.Register(value.NewString(&d.DB.EncryptionKey, ""), "db.encryption_key", "CORE_DB_ENCRYPTION_KEY", nil, "Hex encoded key with 16, 24, or 32 bytes for encrypting the stored processes, empty for no encryption", false, true)
+	d.vars.Register(value.NewInt(&d.DB.WatchInterval, 0), "db.watch_interval_sec", "CORE_DB_WATCH_INTERVAL_SEC", nil, "Interval in seconds for checking the store for changes by other writers, 0 for disabled. Only supported by the json store", false, false)
 
 	// Host
 	d.vars.Register(value.NewStringList(&d.Host.Name, []string{}, ","), "host.name", "CORE_HOST_NAME", nil, "Comma separated list of public host/domain names or IPs", false, false)
@@ -229,6 +242,7 @@ func (d *Config) init() {
 
 	// FFmpeg
 	d.vars.Register(value.NewExec(&d.FFmpeg.Binary, "ffmpeg", d.fs), "ffmpeg.binary", "CORE_FFMPEG_BINARY", nil, "Path to ffmpeg binary", true, false)
+	d.vars.Register(value.NewStringList(&d.FFmpeg.AltBinaries, []string{}, " "), "ffmpeg.alt_binaries", "CORE_FFMPEG_ALT_BINARIES", nil, "Paths to alternative ffmpeg binaries, selected by the version constraint of a process", false, false)
 	d.vars.Register(value.NewInt64(&d.FFmpeg.MaxProcesses, 0), "ffmpeg.max_processes", "CORE_FFMPEG_MAXPROCESSES", nil, "Max. allowed simultaneously running ffmpeg instances, 0 for unlimited", false, false)
 	d.vars.Register(value.NewStringList(&d.FFmpeg.Access.Input.Allow, []string{}, " "), "ffmpeg.access.input.allow", "CORE_FFMPEG_ACCESS_INPUT_ALLOW", nil, "List of allowed expression to match against the input addresses", false, false)
 	d.vars.Register(value.NewStringList(&d.FFmpeg.Access.Input.Block, []string{}, " "), "ffmpeg.access.input.block", "CORE_FFMPEG_ACCESS_INPUT_BLOCK", nil, "List of blocked expression to match against the input addresses", false, false)
@@ -236,11 +250,39 @@ func (d *Config) init() {
 	d.vars.Register(value.NewStringList(&d.FFmpeg.Access.Output.Block, []string{}, " "), "ffmpeg.access.output.block", "CORE_FFMPEG_ACCESS_OUTPUT_BLOCK", nil, "List of blocked expression to match against the output addresses", false, false)
 	d.vars.Register(value.NewInt(&d.FFmpeg.Log.MaxLines, 50), "ffmpeg.log.max_lines", "CORE_FFMPEG_LOG_MAXLINES", nil, "Number of latest log lines to keep for each process", false, false)
 	d.vars.Register(value.NewInt(&d.FFmpeg.Log.MaxHistory, 3), "ffmpeg.log.max_history", "CORE_FFMPEG_LOG_MAXHISTORY", nil, "Number of latest logs to keep for each process", false, false)
+	d.vars.Register(value.NewStringList(&d.FFmpeg.Hooks.Allow, []string{}, " "), "ffmpeg.hooks.allow", "CORE_FFMPEG_HOOKS_ALLOW", nil, "List of absolute paths of the binaries that may be used for the hooks of processes, empty for none", false, false)
+	d.vars.Register(value.NewFloat64(&d.FFmpeg.ChangeRate, 0), "ffmpeg.change_rate", "CORE_FFMPEG_CHANGE_RATE", nil, "Max. allowed changes of processes per second, 0 for unlimited", false, false)
+	d.vars.Register(value.NewInt(&d.FFmpeg.ChangeBurst, 1), "ffmpeg.change_burst", "CORE_FFMPEG_CHANGE_BURST", nil, "Max. allowed changes of processes at once before the change rate applies", false, false)
+	d.vars.Register(value.NewInt64(&d.FFmpeg.MaxConcurrent, 0), "ffmpeg.max_concurrent", "CORE_FFMPEG_MAXCONCURRENT", nil, "Max. number of concurrently running processes, further started processes are queued, 0 for unlimited", false, false)
+	d.vars.Register(value.NewBool(&d.FFmpeg.Preempt, false), "ffmpeg.preempt", "CORE_FFMPEG_PREEMPT", nil, "Whether a process with a higher priority may preempt a running process with a lower priority if the max. number of concurrent processes is reached", false, false)
+	d.vars.Register(value.NewFFmpegDeviceList(&d.FFmpeg.Devices, []value.FFmpegDevice{}, " "), "ffmpeg.devices", "CORE_FFMPEG_DEVICES", nil, "List of devices for hardware acceleration in the form [id]:[hwaccel]:[device]:[capacity], device and capacity are optional", false, false)
+	d.vars.Register(value.NewBool(&d.FFmpeg.ReloadOnSignal, false), "ffmpeg.reload_on_signal", "CORE_FFMPEG_RELOAD_ON_SIGNAL", nil, "Whether to read the templates and globals from the config again and reload the processes whose command changed when the core receives a SIGHUP", false, false)
+	d.vars.Register(value.NewInt(&d.FFmpeg.ProbeTimeout, 20), "ffmpeg.probe_timeout_sec", "CORE_FFMPEG_PROBE_TIMEOUT", nil, "Default timeout in seconds for probing the inputs of a process or an address, at most 300 seconds", false, false)
+	d.vars.Register(value.NewBool(&d.FFmpeg.ValidateMapping, false), "ffmpeg.validate_mapping", "CORE_FFMPEG_VALIDATE_MAPPING", nil, "Whether to probe the inputs of a process when it is added and check that the -map options of the outputs refer to existing streams", false, false)
+	d.vars.Register(value.NewDir(&d.FFmpeg.ConfigDir, "", d.fs), "ffmpeg.config_dir", "CORE_FFMPEG_CONFIG_DIR", nil, "Directory with JSON files that contain one process config each, loaded on startup", false, false)
+	d.vars.Register(value.NewBool(&d.FFmpeg.ConfigDirReconcile, false), "ffmpeg.config_dir_reconcile", "CORE_FFMPEG_CONFIG_DIR_RECONCILE", nil, "Whether to remove the processes on startup that don't have a file in the config dir", false, false)
+	d.vars.Register(value.NewStringList(&d.FFmpeg.OptionsAllowlist, []string{}, " "), "ffmpeg.options_allowlist", "CORE_FFMPEG_OPTIONS_ALLOWLIST", nil, "List of option flags that are allowed in the options of a process, e.g. -f, empty for all", false, false)
+	d.vars.Register(value.NewStringList(&d.FFmpeg.OutputSchemes, []string{}, " "), "ffmpeg.output_schemes", "CORE_FFMPEG_OUTPUT_SCHEMES", nil, "List of URL schemes that are allowed for the outputs of a process, e.g. rtmp, empty for all", false, false)
+	d.vars.Register(value.NewStringMapString(&d.FFmpeg.Globals, nil), "ffmpeg.globals", "CORE_FFMPEG_GLOBALS", nil, "List of key:value pairs for the {global:key} placeholders in the configs of the processes", false, false)
+	d.vars.Register(value.NewStringMapString(&d.FFmpeg.Environment, nil), "ffmpeg.environment", "CORE_FFMPEG_ENVIRONMENT", nil, "List of key:value pairs for the ${VAR} variables in the configs of the processes, empty for no substitution", false, true)
+	d.vars.Register(value.NewBool(&d.FFmpeg.EnvironmentStrict, false), "ffmpeg.environment_strict", "CORE_FFMPEG_ENVIRONMENT_STRICT", nil, "Whether a ${VAR} variable in the config of a process that isn't in ffmpeg.environment is an error", false, false)
+	d.vars.Register(value.NewInt(&d.FFmpeg.AutostartInterval, 0), "ffmpeg.autostart_interval_sec", "CORE_FFMPEG_AUTOSTART_INTERVAL_SEC", nil, "Interval in seconds between the starts of the processes that are started on startup, 0 for all at once", false, false)
+	d.vars.Register(value.NewURL(&d.FFmpeg.Webhook.URL, ""), "ffmpeg.webhook.url", "CORE_FFMPEG_WEBHOOK_URL", nil, "URL to POST the events of the processes to, empty for no notifications", false, false)
+	d.vars.Register(value.NewStringList(&d.FFmpeg.Webhook.Events, []string{}, " "), "ffmpeg.webhook.events", "CORE_FFMPEG_WEBHOOK_EVENTS", nil, "List of events to notify about: crash, recover, stale, start, stop, add, update, remove, empty for all", false, false)
+	d.vars.Register(value.NewInt(&d.FFmpeg.Webhook.Timeout, 10), "ffmpeg.webhook.timeout_sec", "CORE_FFMPEG_WEBHOOK_TIMEOUT_SEC", nil, "Timeout in seconds for a single notification", false, false)
+	d.vars.Register(value.NewInt(&d.FFmpeg.Webhook.Retries, 0), "ffmpeg.webhook.retries", "CORE_FFMPEG_WEBHOOK_RETRIES", nil, "Number of additional attempts if a notification fails", false, false)
+	d.vars.Register(value.NewInt(&d.FFmpeg.Webhook.RetryDelay, 1), "ffmpeg.webhook.retry_delay_sec", "CORE_FFMPEG_WEBHOOK_RETRY_DELAY_SEC", nil, "Delay in seconds between the attempts of a notification", false, false)
 
 	// Playout
 	d.vars.Register(value.NewBool(&d.Playout.Enable, false), "playout.enable", "CORE_PLAYOUT_ENABLE", nil, "Enable playout proxy where available", false, false)
 	d.vars.Register(value.NewPort(&d.Playout.MinPort, 0), "playout.min_port", "CORE_PLAYOUT_MINPORT", nil, "Min. playout server port", false, false)
 	d.vars.Register(value.NewPort(&d.Playout.MaxPort, 0), "playout.max_port", "CORE_PLAYOUT_MAXPORT", nil, "Max. playout server port", false, false)
+	d.vars.Register(value.NewString(&d.Playout.SocketDir, ""), "playout.socket_dir", "CORE_PLAYOUT_SOCKETDIR", nil, "Directory for the unix sockets of playout sidecars, instead of the playout server ports", false, false)
+	d.vars.Register(value.NewString(&d.Playout.BindHost, ""), "playout.bind_host", "CORE_PLAYOUT_BINDHOST", nil, "Host the playout servers bind to, e.g. 0.0.0.0, empty for the loopback interface", false, false)
+	d.vars.Register(value.NewString(&d.Playout.AdvertiseHost, "127.0.0.1"), "playout.advertise_host", "CORE_PLAYOUT_ADVERTISEHOST", nil, "Routable host the playout servers are reachable at", false, false)
+	d.vars.Register(value.NewInt(&d.Playout.Attempts, 3), "playout.attempts", "CORE_PLAYOUT_ATTEMPTS", nil, "Number of attempts for idempotent requests to the playout API of a process", false, false)
+	d.vars.Register(value.NewInt(&d.Playout.Backoff, 100), "playout.backoff_ms", "CORE_PLAYOUT_BACKOFFMS", nil, "Milliseconds to wait before the first retry of a request to the playout API, doubled with each further retry", false, false)
+	d.vars.Register(value.NewInt64(&d.Playout.MaxErrorframeSize, 5), "playout.max_errorframe_size_mbytes", "CORE_PLAYOUT_MAXERRORFRAMESIZEMBYTES", nil, "Max. megabytes of an uploaded error frame", false, false)
 
 	// Debug
 	d.vars.Register(value.NewBool(&d.Debug.Profiling, false), "debug.profiling", "CORE_DEBUG_PROFILING", nil, "Enable profiling endpoint on /profiling", false, false)
@@ -290,6 +332,31 @@ func (d *Config) Validate(resetLogs bool) {
 
 	// Individual sanity checks
 
+	// Check that the store for the processes is known
+	switch d.DB.Store {
+	case "json", "partitioned", "bolt", "journal":
+	default:
+		d.vars.Log("error", "db.store", "unknown store '%s', must be json, partitioned, bolt, or journal", d.DB.Store)
+	}
+
+	// Check that the encryption key for the store is a valid AES key
+	if len(d.DB.EncryptionKey) != 0 {
+		if key, err := hex.DecodeString(d.DB.EncryptionKey); err != nil {
+			d.vars.Log("error", "db.encryption_key", "must be hex encoded: %s", err.Error())
+		} else if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+			d.vars.Log("error", "db.encryption_key", "must be 16, 24, or 32 bytes long, found %d bytes", len(key))
+		}
+
+		// The bolt store doesn't support encryption
+		if d.DB.Store == "bolt" {
+			d.vars.Log("error", "db.encryption_key", "the bolt store doesn't support encryption")
+		}
+	}
+
+	if d.DB.WatchInterval < 0 {
+		d.vars.Log("error", "db.watch_interval_sec", "must not be negative")
+	}
+
 	// If HTTP Auth is enabled, check that the username and password are set
 	if d.API.Auth.Enable {
 		if len(d.API.Auth.Username) == 0 || len(d.API.Auth.Password) == 0 {
@@ -403,11 +470,87 @@ func (d *Config) Validate(resetLogs bool) {
 		}
 	}
 
-	// If playout is enabled, check that the port range is sane
+	// The binaries for the hooks must be given by their absolute path
+	for _, binary := range d.FFmpeg.Hooks.Allow {
+		if !filepath.IsAbs(binary) {
+			d.vars.Log("error", "ffmpeg.hooks.allow", "the path of the binary '%s' must be absolute", binary)
+		}
+	}
+
+	// The rate limit for changes of processes must be sane
+	if d.FFmpeg.ChangeRate < 0 {
+		d.vars.Log("error", "ffmpeg.change_rate", "must not be negative")
+	}
+
+	if d.FFmpeg.ChangeRate > 0 && d.FFmpeg.ChangeBurst <= 0 {
+		d.vars.Log("error", "ffmpeg.change_burst", "must be positive if ffmpeg.change_rate is set")
+	}
+
+	// Check that the alternative ffmpeg binaries are executable
+	for _, binary := range d.FFmpeg.AltBinaries {
+		if _, err := d.fs.LookPath(binary); err != nil {
+			d.vars.Log("error", "ffmpeg.alt_binaries", "%s not found or is not executable", binary)
+		}
+	}
+
+	if d.FFmpeg.MaxConcurrent < 0 {
+		d.vars.Log("error", "ffmpeg.max_concurrent", "must not be negative")
+	}
+
+	if d.FFmpeg.ProbeTimeout <= 0 || d.FFmpeg.ProbeTimeout > 300 {
+		d.vars.Log("error", "ffmpeg.probe_timeout_sec", "must be between 1 and 300 seconds")
+	}
+
+	if d.FFmpeg.AutostartInterval < 0 {
+		d.vars.Log("error", "ffmpeg.autostart_interval_sec", "must not be negative")
+	}
+
+	// Check that the events of the webhook are known
+	for _, event := range d.FFmpeg.Webhook.Events {
+		switch event {
+		case "crash", "recover", "stale", "start", "stop", "add", "update", "remove":
+		default:
+			d.vars.Log("error", "ffmpeg.webhook.events", "unknown event '%s', must be crash, recover, stale, start, stop, add, update, or remove", event)
+		}
+	}
+
+	if d.FFmpeg.Webhook.Timeout <= 0 {
+		d.vars.Log("error", "ffmpeg.webhook.timeout_sec", "must be positive")
+	}
+
+	if d.FFmpeg.Webhook.Retries < 0 {
+		d.vars.Log("error", "ffmpeg.webhook.retries", "must not be negative")
+	}
+
+	if d.FFmpeg.Webhook.RetryDelay < 0 {
+		d.vars.Log("error", "ffmpeg.webhook.retry_delay_sec", "must not be negative")
+	}
+
+	// If playout is enabled, check that the port range is sane, unless sockets are used
 	if d.Playout.Enable {
-		if d.Playout.MinPort >= d.Playout.MaxPort {
+		if len(d.Playout.SocketDir) != 0 {
+			if !filepath.IsAbs(d.Playout.SocketDir) {
+				d.vars.Log("error", "playout.socket_dir", "the path '%s' must be absolute", d.Playout.SocketDir)
+			}
+		} else if d.Playout.MinPort >= d.Playout.MaxPort {
 			d.vars.Log("error", "playout.min_port", "must be bigger than playout.max_port")
 		}
+
+		if ip := net.ParseIP(d.Playout.AdvertiseHost); ip != nil && (ip.IsUnspecified() || ip.IsMulticast()) {
+			d.vars.Log("error", "playout.advertise_host", "the address '%s' is not routable", d.Playout.AdvertiseHost)
+		}
+	}
+
+	if d.Playout.Attempts <= 0 {
+		d.vars.Log("error", "playout.attempts", "must be greater than 0")
+	}
+
+	if d.Playout.Backoff < 0 {
+		d.vars.Log("error", "playout.backoff_ms", "must not be negative")
+	}
+
+	if d.Playout.MaxErrorframeSize <= 0 {
+		d.vars.Log("error", "playout.max_errorframe_size_mbytes", "must be greater than 0")
 	}
 
 	// If cache is enabled, a valid TTL has to be set to a useful value
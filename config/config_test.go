@@ -82,3 +82,75 @@ func TestValidateDefault(t *testing.T) {
 	require.Equal(t, 0, len(cfg.Overrides()))
 	require.Equal(t, false, cfg.HasErrors(), errors)
 }
+
+func TestValidateEncryptionKey(t *testing.T) {
+	fs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	_, _, err = fs.WriteFileReader("./mime.types", strings.NewReader("xxxxx"))
+	require.NoError(t, err)
+
+	_, _, err = fs.WriteFileReader("/bin/ffmpeg", strings.NewReader("xxxxx"))
+	require.NoError(t, err)
+
+	cfg := New(fs)
+
+	for key, valid := range map[string]bool{
+		"":                                 true,
+		"000102030405060708090a0b0c0d0e0f": true,
+		"000102030405060708090a0b0c0d0e":   false,
+		"foobar":                           false,
+	} {
+		cfg.DB.EncryptionKey = key
+		cfg.Validate(true)
+
+		require.Equal(t, !valid, cfg.HasErrors(), key)
+	}
+
+	cfg.DB.Store = "bolt"
+	cfg.DB.EncryptionKey = ""
+	cfg.Validate(true)
+	require.False(t, cfg.HasErrors())
+
+	cfg.DB.EncryptionKey = "000102030405060708090a0b0c0d0e0f"
+	cfg.Validate(true)
+	require.True(t, cfg.HasErrors())
+}
+
+func TestValidatePlayout(t *testing.T) {
+	fs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	_, _, err = fs.WriteFileReader("./mime.types", strings.NewReader("xxxxx"))
+	require.NoError(t, err)
+
+	_, _, err = fs.WriteFileReader("/bin/ffmpeg", strings.NewReader("xxxxx"))
+	require.NoError(t, err)
+
+	cfg := New(fs)
+
+	require.Equal(t, 3, cfg.Playout.Attempts)
+	require.Equal(t, 100, cfg.Playout.Backoff)
+	require.Equal(t, int64(5), cfg.Playout.MaxErrorframeSize)
+
+	cfg.Validate(true)
+	require.False(t, cfg.HasErrors())
+
+	cfg.Playout.Attempts = 0
+	cfg.Validate(true)
+	require.True(t, cfg.HasErrors())
+
+	cfg.Playout.Attempts = 1
+	cfg.Playout.Backoff = -1
+	cfg.Validate(true)
+	require.True(t, cfg.HasErrors())
+
+	cfg.Playout.Backoff = 0
+	cfg.Playout.MaxErrorframeSize = 0
+	cfg.Validate(true)
+	require.True(t, cfg.HasErrors())
+
+	cfg.Playout.MaxErrorframeSize = 1
+	cfg.Validate(true)
+	require.False(t, cfg.HasErrors())
+}
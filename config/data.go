@@ -25,7 +25,10 @@ type Data struct {
 		MaxLines int      `json:"max_lines" format:"int"`
 	} `json:"log"`
 	DB struct {
-		Dir string `json:"dir"`
+		Dir           string `json:"dir"`
+		Store         string `json:"store" enums:"json,partitioned,bolt,journal" jsonschema:"enum=json,enum=partitioned,enum=bolt,enum=journal"`
+		EncryptionKey string `json:"encryption_key"`
+		WatchInterval int    `json:"watch_interval_sec" format:"int"`
 	} `json:"db"`
 	Host struct {
 		Name []string `json:"name"`
@@ -130,11 +133,44 @@ type Data struct {
 			MaxLines   int `json:"max_lines" format:"int"`
 			MaxHistory int `json:"max_history" format:"int"`
 		} `json:"log"`
+		Hooks struct {
+			Allow []string `json:"allow"`
+		} `json:"hooks"`
+		ChangeRate         float64              `json:"change_rate" format:"float64"`
+		ChangeBurst        int                  `json:"change_burst" format:"int"`
+		ProbeTimeout       int                  `json:"probe_timeout_sec" format:"int"`
+		MaxConcurrent      int64                `json:"max_concurrent" format:"int64"`
+		Preempt            bool                 `json:"preempt"`
+		Devices            []value.FFmpegDevice `json:"devices"`
+		ReloadOnSignal     bool                 `json:"reload_on_signal"`
+		AltBinaries        []string             `json:"alt_binaries"`
+		ValidateMapping    bool                 `json:"validate_mapping"`
+		ConfigDir          string               `json:"config_dir"`
+		ConfigDirReconcile bool                 `json:"config_dir_reconcile"`
+		OptionsAllowlist   []string             `json:"options_allowlist"`
+		OutputSchemes      []string             `json:"output_schemes"`
+		Globals            map[string]string    `json:"globals"`
+		Environment        map[string]string    `json:"environment"`
+		EnvironmentStrict  bool                 `json:"environment_strict"`
+		AutostartInterval  int                  `json:"autostart_interval_sec" format:"int"`
+		Webhook            struct {
+			URL        string   `json:"url"`
+			Events     []string `json:"events"`
+			Timeout    int      `json:"timeout_sec" format:"int"`
+			Retries    int      `json:"retries" format:"int"`
+			RetryDelay int      `json:"retry_delay_sec" format:"int"`
+		} `json:"webhook"`
 	} `json:"ffmpeg"`
 	Playout struct {
-		Enable  bool `json:"enable"`
-		MinPort int  `json:"min_port" format:"int"`
-		MaxPort int  `json:"max_port" format:"int"`
+		Enable            bool   `json:"enable"`
+		MinPort           int    `json:"min_port" format:"int"`
+		MaxPort           int    `json:"max_port" format:"int"`
+		SocketDir         string `json:"socket_dir"`
+		BindHost          string `json:"bind_host"`
+		AdvertiseHost     string `json:"advertise_host"`
+		Attempts          int    `json:"attempts" format:"int"`
+		Backoff           int    `json:"backoff_ms" format:"int"`
+		MaxErrorframeSize int64  `json:"max_errorframe_size_mbytes" format:"int64"`
 	} `json:"playout"`
 	Debug struct {
 		Profiling   bool  `json:"profiling"`
@@ -185,13 +221,18 @@ func MergeV2toV3(data *Data, d *v2.Data) (*Data, error) {
 	data.CheckForUpdates = d.CheckForUpdates
 
 	data.Log = d.Log
-	data.DB = d.DB
+	data.DB.Dir = d.DB.Dir
 	data.Host = d.Host
 	data.API = d.API
 	data.RTMP = d.RTMP
 	data.SRT = d.SRT
-	data.FFmpeg = d.FFmpeg
-	data.Playout = d.Playout
+	data.FFmpeg.Binary = d.FFmpeg.Binary
+	data.FFmpeg.MaxProcesses = d.FFmpeg.MaxProcesses
+	data.FFmpeg.Access = d.FFmpeg.Access
+	data.FFmpeg.Log = d.FFmpeg.Log
+	data.Playout.Enable = d.Playout.Enable
+	data.Playout.MinPort = d.Playout.MinPort
+	data.Playout.MaxPort = d.Playout.MaxPort
 	data.Metrics = d.Metrics
 	data.Sessions = d.Sessions
 	data.Service = d.Service
@@ -268,13 +309,18 @@ func DowngradeV3toV2(d *Data) (*v2.Data, error) {
 	data.CheckForUpdates = d.CheckForUpdates
 
 	data.Log = d.Log
-	data.DB = d.DB
+	data.DB.Dir = d.DB.Dir
 	data.Host = d.Host
 	data.API = d.API
 	data.RTMP = d.RTMP
 	data.SRT = d.SRT
-	data.FFmpeg = d.FFmpeg
-	data.Playout = d.Playout
+	data.FFmpeg.Binary = d.FFmpeg.Binary
+	data.FFmpeg.MaxProcesses = d.FFmpeg.MaxProcesses
+	data.FFmpeg.Access = d.FFmpeg.Access
+	data.FFmpeg.Log = d.FFmpeg.Log
+	data.Playout.Enable = d.Playout.Enable
+	data.Playout.MinPort = d.Playout.MinPort
+	data.Playout.MaxPort = d.Playout.MaxPort
 	data.Metrics = d.Metrics
 	data.Sessions = d.Sessions
 	data.Service = d.Service
@@ -0,0 +1,133 @@
+package value
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// array of hardware acceleration devices
+
+type FFmpegDevice struct {
+	ID       string `json:"id"`
+	HWAccel  string `json:"hwaccel"`
+	Device   string `json:"device"`
+	Capacity int    `json:"capacity"`
+}
+
+func (d *FFmpegDevice) String() string {
+	s := d.ID + ":" + d.HWAccel
+
+	if len(d.Device) != 0 || d.Capacity != 0 {
+		s += ":" + d.Device
+	}
+
+	if d.Capacity != 0 {
+		s += ":" + strconv.Itoa(d.Capacity)
+	}
+
+	return s
+}
+
+type FFmpegDeviceList struct {
+	p         *[]FFmpegDevice
+	separator string
+}
+
+func NewFFmpegDeviceList(p *[]FFmpegDevice, val []FFmpegDevice, separator string) *FFmpegDeviceList {
+	v := &FFmpegDeviceList{
+		p:         p,
+		separator: separator,
+	}
+
+	*p = val
+
+	return v
+}
+
+// Set allows to set a device list as a separator separated list of devices in
+// the representation [id]:[hwaccel]:[device]:[capacity], where device and capacity
+// are optional, e.g. "gpu0:cuda:0:4".
+func (s *FFmpegDeviceList) Set(val string) error {
+	list := []FFmpegDevice{}
+
+	for i, elm := range strings.Split(val, s.separator) {
+		elm = strings.TrimSpace(elm)
+		if len(elm) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(elm, ":", 4)
+		if len(parts) < 2 {
+			return fmt.Errorf("invalid device %d (%s): the ID and the hardware acceleration method are required", i, elm)
+		}
+
+		d := FFmpegDevice{
+			ID:      parts[0],
+			HWAccel: parts[1],
+		}
+
+		if len(parts) > 2 {
+			d.Device = parts[2]
+		}
+
+		if len(parts) > 3 {
+			capacity, err := strconv.Atoi(parts[3])
+			if err != nil {
+				return fmt.Errorf("invalid capacity of device %d (%s): %w", i, elm, err)
+			}
+
+			d.Capacity = capacity
+		}
+
+		list = append(list, d)
+	}
+
+	*s.p = list
+
+	return nil
+}
+
+func (s *FFmpegDeviceList) String() string {
+	if s.IsEmpty() {
+		return "(empty)"
+	}
+
+	list := []string{}
+
+	for _, d := range *s.p {
+		list = append(list, d.String())
+	}
+
+	return strings.Join(list, s.separator)
+}
+
+func (s *FFmpegDeviceList) Validate() error {
+	ids := map[string]struct{}{}
+
+	for i, d := range *s.p {
+		if len(d.ID) == 0 {
+			return fmt.Errorf("the ID for device %d is missing", i)
+		}
+
+		if len(d.HWAccel) == 0 {
+			return fmt.Errorf("the hardware acceleration method for device %d is missing", i)
+		}
+
+		if d.Capacity < 0 {
+			return fmt.Errorf("the capacity for device %d must not be negative", i)
+		}
+
+		if _, ok := ids[d.ID]; ok {
+			return fmt.Errorf("the ID %s for device %d is already in use", d.ID, i)
+		}
+
+		ids[d.ID] = struct{}{}
+	}
+
+	return nil
+}
+
+func (s *FFmpegDeviceList) IsEmpty() bool {
+	return len(*s.p) == 0
+}
@@ -0,0 +1,50 @@
+package value
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFFmpegDeviceValue(t *testing.T) {
+	devices := []FFmpegDevice{}
+
+	v := NewFFmpegDeviceList(&devices, nil, " ")
+	require.Equal(t, "(empty)", v.String())
+
+	err := v.Set("gpu0:cuda:0:4 gpu1:vaapi:/dev/dri/renderD128 qsv:qsv")
+	require.NoError(t, err)
+	require.Equal(t, []FFmpegDevice{
+		{
+			ID:       "gpu0",
+			HWAccel:  "cuda",
+			Device:   "0",
+			Capacity: 4,
+		},
+		{
+			ID:      "gpu1",
+			HWAccel: "vaapi",
+			Device:  "/dev/dri/renderD128",
+		},
+		{
+			ID:      "qsv",
+			HWAccel: "qsv",
+		},
+	}, devices)
+	require.Equal(t, "gpu0:cuda:0:4 gpu1:vaapi:/dev/dri/renderD128 qsv:qsv", v.String())
+	require.NoError(t, v.Validate())
+
+	err = v.Set("gpu0")
+	require.Error(t, err)
+
+	err = v.Set("gpu0:cuda:0:four")
+	require.Error(t, err)
+
+	err = v.Set("gpu0:cuda gpu0:vaapi")
+	require.NoError(t, err)
+	require.Error(t, v.Validate())
+
+	err = v.Set("gpu0:cuda:0:-1")
+	require.NoError(t, err)
+	require.Error(t, v.Validate())
+}
@@ -145,3 +145,23 @@ func TestUint64Value(t *testing.T) {
 
 	require.Equal(t, uint64(77), x)
 }
+
+func TestFloat64Value(t *testing.T) {
+	var x float64
+
+	val := NewFloat64(&x, 11.5)
+
+	require.Equal(t, "11.5", val.String())
+	require.Equal(t, nil, val.Validate())
+	require.Equal(t, false, val.IsEmpty())
+
+	x = 42
+
+	require.Equal(t, "42", val.String())
+	require.Equal(t, nil, val.Validate())
+	require.Equal(t, false, val.IsEmpty())
+
+	val.Set("0.25")
+
+	require.Equal(t, float64(0.25), x)
+}
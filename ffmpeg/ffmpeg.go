@@ -3,6 +3,8 @@ package ffmpeg
 import (
 	"fmt"
 	"os/exec"
+	"path/filepath"
+	"runtime"
 	"sync"
 	"time"
 
@@ -16,6 +18,7 @@ import (
 )
 
 type FFmpeg interface {
+	Binary() string
 	New(config ProcessConfig) (process.Process, error)
 	NewProcessParser(logger log.Logger, id, reference string) parse.Parser
 	NewProbeParser(logger log.Logger) probe.Parser
@@ -29,15 +32,28 @@ type FFmpeg interface {
 }
 
 type ProcessConfig struct {
-	Reconnect      bool
-	ReconnectDelay time.Duration
-	StaleTimeout   time.Duration
-	Command        []string
-	Parser         process.Parser
-	Logger         log.Logger
-	OnExit         func()
-	OnStart        func()
-	OnStateChange  func(from, to string)
+	Reconnect       bool
+	ReconnectDelay  time.Duration
+	ReconnectJitter time.Duration
+	RestartPolicy   string
+	MaxRestarts     int
+	RestartWindow   time.Duration
+	StaleTimeout    time.Duration
+	Command         []string
+	Environment     map[string]string
+	WorkingDir      string
+	Parser          process.Parser
+	LogStdout       bool
+	ProgressPipe    bool
+	LevelPrefix     bool
+	CPUAffinity     []int
+	Nice            int
+	Logger          log.Logger
+	OnExit          func()
+	OnStart         func()
+	OnRestart       func() error
+	OnStateChange   func(from, to string)
+	OnStale         func()
 }
 
 // Config is the configuration for ffmpeg that is part of the configuration
@@ -77,6 +93,12 @@ func New(config Config) (FFmpeg, error) {
 		return nil, fmt.Errorf("invalid ffmpeg binary given: %w", err)
 	}
 
+	// The processes may run in a different working directory
+	binary, err = filepath.Abs(binary)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ffmpeg binary given: %w", err)
+	}
+
 	f.binary = binary
 	f.historyLength = config.LogHistoryLength
 	f.logLines = config.MaxLogLines
@@ -110,17 +132,45 @@ func New(config Config) (FFmpeg, error) {
 	return f, nil
 }
 
+func (f *ffmpeg) Binary() string {
+	return f.binary
+}
+
 func (f *ffmpeg) New(config ProcessConfig) (process.Process, error) {
+	args := config.Command
+
+	if config.LevelPrefix {
+		// With the level flag ffmpeg prefixes each log line with its level. A later
+		// -loglevel with a plain level from the command keeps this flag.
+		args = append([]string{"-loglevel", "+level"}, args...)
+	}
+
+	if config.ProgressPipe && runtime.GOOS != "windows" {
+		args = append([]string{"-progress", "pipe:3"}, args...)
+	}
+
 	ffmpeg, err := process.New(process.Config{
-		Binary:         f.binary,
-		Args:           config.Command,
-		Reconnect:      config.Reconnect,
-		ReconnectDelay: config.ReconnectDelay,
-		StaleTimeout:   config.StaleTimeout,
-		Parser:         config.Parser,
-		Logger:         config.Logger,
-		OnStart:        config.OnStart,
-		OnExit:         config.OnExit,
+		Binary:          f.binary,
+		Args:            args,
+		Env:             config.Environment,
+		Dir:             config.WorkingDir,
+		Reconnect:       config.Reconnect,
+		ReconnectDelay:  config.ReconnectDelay,
+		ReconnectJitter: config.ReconnectJitter,
+		RestartPolicy:   config.RestartPolicy,
+		MaxRestarts:     config.MaxRestarts,
+		RestartWindow:   config.RestartWindow,
+		StaleTimeout:    config.StaleTimeout,
+		Parser:          config.Parser,
+		LogStdout:       config.LogStdout,
+		ProgressPipe:    config.ProgressPipe,
+		CPUAffinity:     config.CPUAffinity,
+		Nice:            config.Nice,
+		Logger:          config.Logger,
+		OnStart:         config.OnStart,
+		OnRestart:       config.OnRestart,
+		OnExit:          config.OnExit,
+		OnStale:         config.OnStale,
 		OnStateChange: func(from, to string) {
 			f.statesLock.Lock()
 			switch to {
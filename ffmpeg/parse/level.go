@@ -0,0 +1,56 @@
+package parse
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Severity of the log levels of ffmpeg, in ascending order.
+var logLevels = map[string]int{
+	"trace":   0,
+	"debug":   1,
+	"verbose": 2,
+	"info":    3,
+	"warning": 4,
+	"error":   5,
+	"fatal":   6,
+	"panic":   7,
+}
+
+// With the "level" flag for -loglevel, ffmpeg prefixes each line with its level. The
+// level follows the optional contexts, e.g. "[tee @ 0x7fa96a800600] [error] ...".
+var reLogLevel = regexp.MustCompile(`^((?:\[[^\]]+ @ 0x[0-9a-f]+\] )*)\[(trace|debug|verbose|info|warning|error|fatal|panic)\] `)
+
+// splitLevel returns the line without the level prefix and the level. The level
+// is empty if the line doesn't have a level prefix.
+func splitLevel(line string) (string, string) {
+	matches := reLogLevel.FindStringSubmatchIndex(line)
+	if matches == nil {
+		return line, ""
+	}
+
+	level := line[matches[4]:matches[5]]
+
+	return line[:matches[3]] + line[matches[1]:], level
+}
+
+// isContinuation returns whether the line is the continuation of a multi-line message.
+func isContinuation(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
+
+// LevelAtLeast returns whether the level is at least as severe as the minimum level.
+// Unknown levels are treated as "info".
+func LevelAtLeast(level, min string) bool {
+	l, ok := logLevels[level]
+	if !ok {
+		l = logLevels["info"]
+	}
+
+	m, ok := logLevels[min]
+	if !ok {
+		m = logLevels["info"]
+	}
+
+	return l >= m
+}
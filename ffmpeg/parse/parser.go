@@ -4,6 +4,7 @@ import (
 	"container/ring"
 	"encoding/json"
 	"fmt"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
@@ -20,7 +21,7 @@ import (
 
 // Parser is an extension to the process.Parser interface
 type Parser interface {
-	process.Parser
+	process.ProgressParser
 
 	// Progress returns the current progress information of the process
 	Progress() app.Progress
@@ -68,13 +69,23 @@ type parser struct {
 	log      *ring.Ring
 	logLines int
 	logStart time.Time
+	logStop  time.Time // Time the process exited, zero while it is running
+	logTotal uint64
+	logExit  struct {
+		code   int
+		signal string
+	}
+	logLevel string // Level of the last line, for multi-line messages
 
 	logHistory       *ring.Ring
 	logHistoryLength int
 
 	progress struct {
 		ffmpeg   ffmpegProgress
-		avstream map[string]ffmpegAVstream
+		avstream map[string]avstreamSample
+		previous map[string]avstreamSample // Previous avstream sample per address, for the derived metrics
+		pipe     ffmpegPipeProgress        // Last complete block from the progress pipe
+		block    ffmpegPipeProgress        // Block from the progress pipe that is currently read
 	}
 
 	process ffmpegProcess
@@ -147,7 +158,7 @@ func New(config Config) Parser {
 	p.lock.prelude.Unlock()
 
 	p.lock.log.Lock()
-	p.log = ring.New(config.LogLines)
+	p.log = ring.New(p.logLines)
 
 	if p.logHistoryLength > 0 {
 		p.logHistory = ring.New(p.logHistoryLength)
@@ -158,6 +169,7 @@ func New(config Config) Parser {
 	}
 
 	p.logStart = time.Now()
+	p.logExit.code = -1
 	p.lock.log.Unlock()
 
 	p.ResetStats()
@@ -166,6 +178,8 @@ func New(config Config) Parser {
 }
 
 func (p *parser) Parse(line string) uint64 {
+	line, level := splitLevel(line)
+
 	isDefaultProgress := strings.HasPrefix(line, "frame=")
 	isFFmpegInputs := strings.HasPrefix(line, "ffmpeg.inputs:")
 	isFFmpegOutputs := strings.HasPrefix(line, "ffmpeg.outputs:")
@@ -211,6 +225,13 @@ func (p *parser) Parse(line string) uint64 {
 			} else {
 				p.logger.WithField("prelude", p.Prelude()).Debug().Log("")
 
+				// The inputs in JSON don't contain the duration, it is only in the prelude
+				inputs, _, _ := prelude.Parse(p.Prelude())
+
+				p.lock.progress.Lock()
+				p.process.duration = inputDuration(inputs)
+				p.lock.progress.Unlock()
+
 				p.lock.prelude.Lock()
 				p.prelude.done = true
 				p.lock.prelude.Unlock()
@@ -234,7 +255,7 @@ func (p *parser) Parse(line string) uint64 {
 
 	if !isDefaultProgress && !isFFmpegProgress && !isAVstreamProgress {
 		// Write the current non-progress line to the log
-		p.addLog(line)
+		p.addLog(line, level, "stderr")
 
 		p.lock.prelude.Lock()
 		if !p.prelude.done {
@@ -498,11 +519,78 @@ func (p *parser) parseAVstreamProgress(line string) error {
 		return err
 	}
 
-	p.progress.avstream[progress.Address] = progress
+	if sample, ok := p.progress.avstream[progress.Address]; ok {
+		p.progress.previous[progress.Address] = sample
+	}
+
+	p.progress.avstream[progress.Address] = avstreamSample{
+		data: progress,
+		at:   time.Now(),
+	}
 
 	return nil
 }
 
+// ParseProgress parses a line of the "key=value" blocks ffmpeg writes to the
+// progress pipe. A block is terminated by the "progress" key. Values that are
+// not available (e.g. "N/A") are ignored.
+func (p *parser) ParseProgress(line string) uint64 {
+	key, value, found := strings.Cut(strings.TrimSpace(line), "=")
+	if !found {
+		return 0
+	}
+
+	value = strings.TrimSpace(value)
+
+	p.lock.progress.Lock()
+	defer p.lock.progress.Unlock()
+
+	block := &p.progress.block
+
+	switch key {
+	case "frame":
+		if x, err := strconv.ParseUint(value, 10, 64); err == nil {
+			block.Frame = x
+		}
+	case "fps":
+		if x, err := strconv.ParseFloat(value, 64); err == nil {
+			block.FPS = x
+		}
+	case "bitrate":
+		if x, err := strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64); err == nil {
+			block.Bitrate = x
+		}
+	case "total_size":
+		if x, err := strconv.ParseUint(value, 10, 64); err == nil {
+			block.Size = x
+		}
+	case "out_time_us":
+		if x, err := strconv.ParseInt(value, 10, 64); err == nil && x >= 0 {
+			block.Time = time.Duration(x) * time.Microsecond
+		}
+	case "speed":
+		if x, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64); err == nil {
+			block.Speed = x
+		}
+	case "drop_frames":
+		if x, err := strconv.ParseUint(value, 10, 64); err == nil {
+			block.Drop = x
+		}
+	case "dup_frames":
+		if x, err := strconv.ParseUint(value, 10, 64); err == nil {
+			block.Dup = x
+		}
+	case "progress":
+		block.valid = true
+		p.progress.pipe = *block
+		p.progress.block = ffmpegPipeProgress{}
+
+		return 1
+	}
+
+	return 0
+}
+
 func (p *parser) Progress() app.Progress {
 	p.lock.progress.RLock()
 	defer p.lock.progress.RUnlock()
@@ -510,6 +598,19 @@ func (p *parser) Progress() app.Progress {
 	progress := p.process.export()
 
 	p.progress.ffmpeg.exportTo(&progress)
+	p.progress.pipe.exportTo(&progress)
+
+	progress.Duration = p.process.duration
+	progress.Completion = -1
+	progress.ETA = -1
+
+	if progress.Duration > 0 {
+		progress.Completion = math.Min(progress.Time/progress.Duration*100, 100)
+
+		if progress.Speed > 0 {
+			progress.ETA = math.Max(progress.Duration-progress.Time, 0) / progress.Speed
+		}
+	}
 
 	for i, io := range progress.Input {
 		av, ok := p.progress.avstream[io.Address]
@@ -517,7 +618,17 @@ func (p *parser) Progress() app.Progress {
 			continue
 		}
 
-		progress.Input[i].AVstream = av.export()
+		progress.Input[i].AVstream = av.data.export()
+
+		var previous *app.AVstream
+		elapsed := time.Duration(0)
+
+		if prev, ok := p.progress.previous[io.Address]; ok {
+			previous = prev.data.export()
+			elapsed = av.at.Sub(prev.at)
+		}
+
+		progress.Input[i].AVstream.Derived = progress.Input[i].AVstream.Metrics(previous, elapsed, io.FPS)
 	}
 
 	return progress
@@ -567,6 +678,8 @@ func (p *parser) parsePrelude() bool {
 		return false
 	}
 
+	p.process.duration = inputDuration(inputs)
+
 	for _, in := range inputs {
 		io := ffmpegProcessIO{
 			Address:  in.Address,
@@ -614,23 +727,74 @@ func (p *parser) parsePrelude() bool {
 	return true
 }
 
-func (p *parser) addLog(line string) {
+// inputDuration returns the duration in seconds of the longest input. It returns 0
+// if the duration of any input is unknown, e.g. because it is a live stream.
+func inputDuration(inputs []prelude.IO) float64 {
+	duration := 0.0
+
+	for _, in := range inputs {
+		if in.Duration <= 0 {
+			return 0
+		}
+
+		duration = math.Max(duration, in.Duration)
+	}
+
+	return duration
+}
+
+// ParseStdout adds the line to the log. The lines on stdout are not part of
+// the prelude and they don't carry any progress information.
+func (p *parser) ParseStdout(line string) {
+	p.lock.log.Lock()
+	if p.logStart.IsZero() {
+		p.logStart = time.Now()
+	}
+	p.lock.log.Unlock()
+
+	p.addLog(line, "info", "stdout")
+}
+
+func (p *parser) addLog(line, level, stream string) {
 	p.lock.log.Lock()
 	defer p.lock.log.Unlock()
 
+	if len(level) == 0 {
+		// Lines without a level are considered to be part of the previous
+		// message if they are indented, otherwise they are informational.
+		if isContinuation(line) && len(p.logLevel) != 0 {
+			level = p.logLevel
+		} else {
+			level = "info"
+		}
+	}
+
+	// The lines on stdout are not related to the messages on stderr
+	if stream == "stderr" {
+		p.logLevel = level
+	}
+
 	p.log.Value = process.Line{
 		Timestamp: time.Now(),
 		Data:      line,
+		Level:     level,
+		Stream:    stream,
 	}
 	p.log = p.log.Next()
+	p.logTotal++
 }
 
 func (p *parser) Log() []process.Line {
-	var log = []process.Line{}
-
 	p.lock.log.RLock()
 	defer p.lock.log.RUnlock()
 
+	return p.lines()
+}
+
+// lines returns the lines of the log. The caller must hold the log lock.
+func (p *parser) lines() []process.Line {
+	var log = []process.Line{}
+
 	p.log.Do(func(l interface{}) {
 		if l == nil {
 			return
@@ -677,7 +841,10 @@ func (p *parser) ResetStats() {
 
 	p.process = ffmpegProcess{}
 	p.progress.ffmpeg = ffmpegProgress{}
-	p.progress.avstream = make(map[string]ffmpegAVstream)
+	p.progress.avstream = make(map[string]avstreamSample)
+	p.progress.previous = make(map[string]avstreamSample)
+	p.progress.pipe = ffmpegPipeProgress{}
+	p.progress.block = ffmpegPipeProgress{}
 
 	p.lock.prelude.Lock()
 	p.prelude.done = false
@@ -697,15 +864,33 @@ func (p *parser) ResetLog() {
 	p.lock.log.Lock()
 	p.log = ring.New(p.logLines)
 	p.logStart = time.Now()
+	p.logStop = time.Time{}
+	p.logTotal = 0
+	p.logLevel = ""
+	p.logExit.code = -1
+	p.logExit.signal = ""
 	p.lock.log.Unlock()
 }
 
+func (p *parser) Exited(code int, signal string) {
+	p.lock.log.Lock()
+	defer p.lock.log.Unlock()
+
+	p.logStop = time.Now()
+	p.logExit.code = code
+	p.logExit.signal = signal
+}
+
 // Report represents a log report, including the prelude and the last log lines
 // of the process.
 type Report struct {
-	CreatedAt time.Time
-	Prelude   []string
-	Log       []process.Line
+	CreatedAt  time.Time
+	StoppedAt  time.Time // Zero if the process didn't exit yet
+	ExitCode   int       // -1 if the process didn't exit yet or has been terminated by a signal
+	ExitSignal string    // Name of the signal the process has been terminated by
+	Prelude    []string
+	Log        []process.Line
+	Lines      uint64 // Number of lines logged since CreatedAt, including the ones that have been dropped
 }
 
 func (p *parser) storeLogHistory() {
@@ -724,11 +909,15 @@ func (p *parser) storeLogHistory() {
 func (p *parser) Report() Report {
 	h := Report{
 		Prelude: p.Prelude(),
-		Log:     p.Log(),
 	}
 
 	p.lock.log.RLock()
+	h.Log = p.lines()
 	h.CreatedAt = p.logStart
+	h.StoppedAt = p.logStop
+	h.ExitCode = p.logExit.code
+	h.ExitSignal = p.logExit.signal
+	h.Lines = p.logTotal
 	p.lock.log.RUnlock()
 
 	return h
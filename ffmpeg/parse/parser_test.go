@@ -116,6 +116,107 @@ func TestParserLog(t *testing.T) {
 	require.Equal(t, 1, len(log))
 }
 
+func TestParserReportLines(t *testing.T) {
+	parser := New(Config{
+		LogLines: 5,
+	})
+
+	for i := 0; i < 12; i++ {
+		parser.Parse("bla")
+	}
+
+	report := parser.Report()
+
+	require.Equal(t, 5, len(report.Log))
+	require.Equal(t, uint64(12), report.Lines)
+
+	parser.ResetLog()
+
+	report = parser.Report()
+
+	require.Equal(t, 0, len(report.Log))
+	require.Equal(t, uint64(0), report.Lines)
+}
+
+func TestParserReportExit(t *testing.T) {
+	parser := New(Config{
+		LogLines:   5,
+		LogHistory: 2,
+	})
+
+	parser.Parse("bla")
+
+	report := parser.Report()
+
+	require.True(t, report.StoppedAt.IsZero())
+	require.Equal(t, -1, report.ExitCode)
+
+	parser.Exited(-1, "killed")
+
+	report = parser.Report()
+
+	require.False(t, report.StoppedAt.IsZero())
+	require.Equal(t, -1, report.ExitCode)
+	require.Equal(t, "killed", report.ExitSignal)
+
+	parser.ResetLog()
+	parser.Parse("bla")
+	parser.Exited(1, "")
+
+	report = parser.Report()
+
+	require.Equal(t, 1, report.ExitCode)
+	require.Equal(t, "", report.ExitSignal)
+
+	history := parser.ReportHistory()
+
+	require.Equal(t, 1, len(history))
+	require.Equal(t, "killed", history[0].ExitSignal)
+
+	parser.ResetLog()
+
+	report = parser.Report()
+
+	require.True(t, report.StoppedAt.IsZero())
+	require.Equal(t, -1, report.ExitCode)
+}
+
+func TestParserLogLevel(t *testing.T) {
+	parser := New(Config{
+		LogLines: 20,
+	})
+
+	parser.Parse("[error] foobar")
+	parser.Parse("    more about foobar")
+	parser.Parse("[tee @ 0x7fa96a800600] [warning] Slave muxer #1 failed")
+	parser.Parse("no level")
+	parser.Parse("[fatal] barfoo")
+	parser.Parse("[debug] [something] in brackets")
+
+	log := parser.Log()
+
+	require.Equal(t, 6, len(log))
+
+	lines := [][2]string{}
+	for _, l := range log {
+		lines = append(lines, [2]string{l.Level, l.Data})
+	}
+
+	require.Equal(t, [][2]string{
+		{"error", "foobar"},
+		{"error", "    more about foobar"},
+		{"warning", "[tee @ 0x7fa96a800600] Slave muxer #1 failed"},
+		{"info", "no level"},
+		{"fatal", "barfoo"},
+		{"debug", "[something] in brackets"},
+	}, lines)
+
+	require.True(t, LevelAtLeast("error", "warning"))
+	require.True(t, LevelAtLeast("warning", "warning"))
+	require.False(t, LevelAtLeast("info", "warning"))
+	require.False(t, LevelAtLeast("foobar", "warning"))
+}
+
 func TestParserReset(t *testing.T) {
 	parser := New(Config{
 		LogLines:         20,
@@ -521,3 +622,159 @@ ffmpeg.progress:{"inputs":[{"index":0,"stream":0,"frame":21,"packet":24,"size_kb
 	require.Equal(t, 1, len(parser.process.input), "expected 1 input")
 	require.Equal(t, 2, len(parser.process.output), "expected 2 outputs")
 }
+
+func TestParserStdout(t *testing.T) {
+	parser := New(Config{
+		LogLines: 5,
+	})
+
+	parser.Parse("[warning] foo")
+	parser.ParseStdout("  bar")
+	parser.Parse("  baz")
+
+	log := parser.Log()
+	require.Len(t, log, 3)
+
+	require.Equal(t, "foo", log[0].Data)
+	require.Equal(t, "warning", log[0].Level)
+	require.Equal(t, "stderr", log[0].Stream)
+
+	require.Equal(t, "  bar", log[1].Data)
+	require.Equal(t, "info", log[1].Level)
+	require.Equal(t, "stdout", log[1].Stream)
+
+	// The continuation of a line on stderr isn't affected by the lines on stdout
+	require.Equal(t, "  baz", log[2].Data)
+	require.Equal(t, "warning", log[2].Level)
+	require.Equal(t, "stderr", log[2].Stream)
+}
+
+func TestParserAVstreamMetrics(t *testing.T) {
+	parser := New(Config{
+		LogLines: 20,
+	}).(*parser)
+
+	rawdata := `ffmpeg.inputs:[{"url":"playout:https://cdn.livespotting.com/vpu/e9slfpe3/z60wzayk.m3u8","format":"playout","index":0,"stream":0,"type":"video","codec":"h264","coder":"h264","bitrate_kbps":0,"duration_sec":0.000000,"language":"und","fps":20.666666,"pix_fmt":"yuvj420p","width":1280,"height":720}]
+ffmpeg.outputs:[{"url":"/dev/null","format":"flv","index":0,"stream":0,"type":"video","codec":"h264","coder":"libx264","bitrate_kbps":0,"duration_sec":0.000000,"language":"und","fps":25.000000,"pix_fmt":"yuvj420p","width":1280,"height":720}]
+ffmpeg.progress:{"inputs":[{"index":0,"stream":0,"frame":2,"packet":6,"size_kb":222}],"outputs":[{"index":0,"stream":0,"frame":2,"packet":0,"q":0.0,"size_kb":0}],"frame":2,"packet":0,"q":0.0,"size_kb":222,"time":"0h0m0.20s","speed":0.281,"dup":0,"drop":0}
+avstream.progress:{"id":"playout:https://cdn.livespotting.com/vpu/e9slfpe3/z60wzayk.m3u8","url":"https://cdn.livespotting.com/vpu/e9slfpe3/z60wzayk.m3u8","stream":0,"queue":140,"aqueue":0,"dup":0,"drop":2,"enc":0,"looping":false,"duplicating":false,"gop":"none","input":{"state":"running","packet":148,"size_kb":1529,"time":5},"output":{"state":"running","packet":8,"size_kb":128,"time":1}}`
+
+	for _, d := range strings.Split(rawdata, "\n") {
+		parser.Parse(d)
+	}
+
+	progress := parser.Progress()
+	require.Equal(t, 1, len(progress.Input))
+	require.NotNil(t, progress.Input[0].AVstream)
+	require.Equal(t, float64(0), progress.Input[0].AVstream.Derived.DropRate)
+
+	// Pretend the first sample has been received 2 seconds ago
+	address := "playout:https://cdn.livespotting.com/vpu/e9slfpe3/z60wzayk.m3u8"
+	sample := parser.progress.avstream[address]
+	sample.at = sample.at.Add(-2 * time.Second)
+	parser.progress.avstream[address] = sample
+
+	parser.Parse(`avstream.progress:{"id":"playout:https://cdn.livespotting.com/vpu/e9slfpe3/z60wzayk.m3u8","url":"https://cdn.livespotting.com/vpu/e9slfpe3/z60wzayk.m3u8","stream":0,"queue":140,"aqueue":0,"dup":4,"drop":12,"enc":0,"looping":false,"duplicating":false,"gop":"none","input":{"state":"running","packet":148,"size_kb":1529,"time":5},"output":{"state":"running","packet":8,"size_kb":128,"time":1}}`)
+
+	progress = parser.Progress()
+	require.NotNil(t, progress.Input[0].AVstream)
+	require.InDelta(t, 5, progress.Input[0].AVstream.Derived.DropRate, 0.1)
+	require.InDelta(t, 2, progress.Input[0].AVstream.Derived.DupRate, 0.1)
+}
+
+func TestParserProgressPipe(t *testing.T) {
+	parser := New(Config{
+		LogLines: 20,
+	})
+
+	parser.Parse("frame= 5968 fps= 25 q=19.4 size=443kB time=00:03:58.44 bitrate=5632kbits/s speed=0.999x skip=9733 drop=3522 dup=87463")
+
+	progress := parser.Progress()
+	require.Equal(t, uint64(5968), progress.Frame)
+
+	rawdata := `frame=6000
+fps=25.00
+stream_0_0_q=19.4
+bitrate=5632.1kbits/s
+total_size=453632
+out_time_us=240000000
+out_time_ms=240000000
+out_time=00:04:00.000000
+dup_frames=87470
+drop_frames=3530
+speed=1.01x`
+
+	for _, d := range strings.Split(rawdata, "\n") {
+		require.Equal(t, uint64(0), parser.ParseProgress(d))
+	}
+
+	// The block is not complete yet
+	progress = parser.Progress()
+	require.Equal(t, uint64(5968), progress.Frame)
+
+	require.Equal(t, uint64(1), parser.ParseProgress("progress=continue"))
+
+	progress = parser.Progress()
+	require.Equal(t, uint64(6000), progress.Frame)
+	require.Equal(t, float64(25), progress.FPS)
+	require.Equal(t, uint64(453632), progress.Size)
+	require.Equal(t, float64(240), progress.Time)
+	require.InDelta(t, 5632.1*1024, progress.Bitrate, 0.1)
+	require.Equal(t, 1.01, progress.Speed)
+	require.Equal(t, uint64(3530), progress.Drop)
+	require.Equal(t, uint64(87470), progress.Dup)
+
+	// Values that are not available are ignored
+	parser.ParseProgress("frame=6025")
+	parser.ParseProgress("bitrate=N/A")
+	parser.ParseProgress("speed=N/A")
+	parser.ParseProgress("progress=end")
+
+	progress = parser.Progress()
+	require.Equal(t, uint64(6025), progress.Frame)
+	require.Equal(t, float64(0), progress.Speed)
+
+	parser.ResetStats()
+
+	progress = parser.Progress()
+	require.Equal(t, uint64(0), progress.Frame)
+}
+
+func TestParserCompletion(t *testing.T) {
+	parser := New(Config{
+		LogLines: 20,
+	}).(*parser)
+
+	rawdata := `ffmpeg version 4.0.2 Copyright (c) 2000-2018 the FFmpeg developers
+Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'movie.mp4':
+  Duration: 00:00:10.00, start: 0.000000, bitrate: 1205 kb/s
+    Stream #0:0(und): Video: h264 (High) (avc1 / 0x31637661), yuv420p, 1280x720 [SAR 1:1 DAR 16:9], 1071 kb/s, 25 fps, 25 tbr, 12800 tbn, 50 tbc (default)
+Output #0, null, to 'pipe:':
+    Stream #0:0(und): Video: h264 (High) (avc1 / 0x31637661), yuv420p, 1280x720 [SAR 1:1 DAR 16:9], q=2-31, 1071 kb/s, 25 fps, 25 tbr, 12800 tbn, 12800 tbc (default)
+Stream mapping:
+  Stream #0:0 -> #0:0 (copy)
+Press [q] to stop, [?] for help
+frame=   58 fps= 25 q=-1.0 Lsize=N/A time=00:00:02.50 bitrate=N/A speed=2.5x`
+
+	for _, d := range strings.Split(rawdata, "\n") {
+		parser.Parse(d)
+	}
+
+	progress := parser.Progress()
+	require.Equal(t, 10.0, progress.Duration)
+	require.Equal(t, 25.0, progress.Completion)
+	require.Equal(t, 3.0, progress.ETA)
+
+	parser.ResetStats()
+
+	rawdata = strings.Replace(rawdata, "Duration: 00:00:10.00", "Duration: N/A", 1)
+
+	for _, d := range strings.Split(rawdata, "\n") {
+		parser.Parse(d)
+	}
+
+	progress = parser.Progress()
+	require.Equal(t, 0.0, progress.Duration)
+	require.Equal(t, -1.0, progress.Completion)
+	require.Equal(t, -1.0, progress.ETA)
+}
@@ -74,6 +74,12 @@ type ffmpegAVstream struct {
 	GOP         string           `json:"gop"`
 }
 
+// avstreamSample is an avstream progress with the time it has been received
+type avstreamSample struct {
+	data ffmpegAVstream
+	at   time.Time
+}
+
 func (av *ffmpegAVstream) export() *app.AVstream {
 	return &app.AVstream{
 		Aqueue:      av.Aqueue,
@@ -162,6 +168,43 @@ func (p *ffmpegProgress) exportTo(progress *app.Progress) {
 	}
 }
 
+// ffmpegPipeProgress is a block of the progress ffmpeg writes to the progress pipe
+type ffmpegPipeProgress struct {
+	valid   bool
+	Frame   uint64
+	FPS     float64
+	Size    uint64  // bytes
+	Bitrate float64 // kbit/s
+	Time    time.Duration
+	Speed   float64
+	Drop    uint64
+	Dup     uint64
+}
+
+// exportTo overwrites the values in progress that are known from the progress
+// pipe. It doesn't change anything if no complete block has been read yet.
+func (p *ffmpegPipeProgress) exportTo(progress *app.Progress) {
+	if !p.valid {
+		return
+	}
+
+	progress.Frame = p.Frame
+	progress.Size = p.Size
+	progress.Time = p.Time.Seconds()
+	progress.Speed = p.Speed
+	progress.Drop = p.Drop
+	progress.Dup = p.Dup
+
+	// Keep the averaged values if ffmpeg doesn't know them yet
+	if p.FPS != 0 {
+		progress.FPS = p.FPS
+	}
+
+	if p.Bitrate != 0 {
+		progress.Bitrate = p.Bitrate * 1024
+	}
+}
+
 type ffmpegProcessIO struct {
 	// common
 	Address string `json:"url"`
@@ -203,8 +246,9 @@ func (io *ffmpegProcessIO) export() app.ProgressIO {
 }
 
 type ffmpegProcess struct {
-	input  []ffmpegProcessIO
-	output []ffmpegProcessIO
+	input    []ffmpegProcessIO
+	output   []ffmpegProcessIO
+	duration float64 // Duration of the inputs in seconds, 0 if unknown
 }
 
 func (p *ffmpegProcess) export() app.Progress {
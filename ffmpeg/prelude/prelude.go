@@ -18,6 +18,7 @@ type IO struct {
 	Coder    string
 	Bitrate  float64 // kbps
 	Duration float64 // sec
+	Program  uint64  // ID of the program the stream is listed in, 0 if none
 
 	// video
 	FPS    float64
@@ -43,6 +44,10 @@ func Parse(lines []string) (inputs, outputs []IO, noutputs int) {
 	// Duration: N/A, start: 0.000000, bitrate: 5895 kb/s
 	reDuration := regexp.MustCompile(`Duration: ([0-9]+):([0-9]+):([0-9]+)\.([0-9]+)`)
 
+	//   Program 1
+	//   No Program
+	reProgram := regexp.MustCompile(`^\s+(?:Program ([0-9]+)|No Program)`)
+
 	// Stream #0:0: Video: rawvideo (RGB[24] / 0x18424752), rgb24, 1280x720 [SAR 1:1 DAR 16:9], 25 tbr, 25 tbn, 25 tbc
 	// Stream #1:0: Audio: pcm_u8, 44100 Hz, stereo, u8, 705 kb/s
 	// Stream #0:0: Video: h264 (libx264), yuv420p(progressive), 1280x720 [SAR 1:1 DAR 16:9], q=-1--1, 25 fps, 90k tbn, 25 tbc
@@ -63,6 +68,7 @@ func Parse(lines []string) (inputs, outputs []IO, noutputs int) {
 	format := ""
 	address := ""
 	var duration float64 = 0.0
+	var program uint64 = 0
 
 	streamMapping := false
 
@@ -87,6 +93,13 @@ func Parse(lines []string) (inputs, outputs []IO, noutputs int) {
 			format = matches[3]
 			address = matches[5]
 			duration = 0
+			program = 0
+
+			continue
+		}
+
+		if matches := reProgram.FindStringSubmatch(line); matches != nil {
+			program, _ = strconv.ParseUint(matches[1], 10, 64)
 
 			continue
 		}
@@ -123,6 +136,7 @@ func Parse(lines []string) (inputs, outputs []IO, noutputs int) {
 			io.Address = address
 			io.Format = format
 			io.Duration = duration
+			io.Program = program
 
 			if x, err := strconv.ParseUint(matches[1], 10, 64); err == nil {
 				io.Index = x
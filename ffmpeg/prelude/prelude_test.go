@@ -211,3 +211,29 @@ frame=   58 fps= 25 q=-1.0 Lsize=N/A time=00:00:02.32 bitrate=N/A speed=0.999x`
 	require.Equal(t, uint64(44100), i.Sampling)
 	require.Equal(t, "stereo", i.Layout)
 }
+
+func TestPreludePrograms(t *testing.T) {
+	rawdata := `Input #0, mpegts, from 'udp://239.0.0.1:1234':
+  Duration: N/A, start: 1.400000, bitrate: N/A
+  Program 1
+    Metadata:
+      service_name    : Service01
+    Stream #0:0[0x100]: Video: h264 (Main) ([27][0][0][0] / 0x001B), yuv420p(progressive), 1280x720, 25 fps, 25 tbr, 90k tbn
+    Stream #0:1[0x101]: Audio: aac (LC) ([15][0][0][0] / 0x000F), 48000 Hz, stereo, fltp, 128 kb/s
+  Program 2
+    Stream #0:2[0x200]: Video: h264 (Main) ([27][0][0][0] / 0x001B), yuv420p(progressive), 640x360, 25 fps, 25 tbr, 90k tbn
+  No Program
+    Stream #0:3[0x300]: Audio: mp2 ([3][0][0][0] / 0x0003), 48000 Hz, stereo, fltp, 128 kb/s
+Input #1, lavfi, from 'testsrc=size=1280x720:rate=25':
+  Duration: N/A, start: 0.000000, bitrate: N/A
+    Stream #1:0: Video: rawvideo (RGB[24] / 0x18424752), rgb24, 1280x720 [SAR 1:1 DAR 16:9], 25 tbr, 25 tbn, 25 tbc`
+
+	inputs, _, _ := Parse(strings.Split(rawdata, "\n"))
+
+	programs := []uint64{}
+	for _, i := range inputs {
+		programs = append(programs, i.Program)
+	}
+
+	require.Equal(t, []uint64{1, 1, 2, 0, 0}, programs)
+}
@@ -63,11 +63,14 @@ func (p *prober) Parse(line string) uint64 {
 	p.data = append(p.data, process.Line{
 		Timestamp: time.Now(),
 		Data:      line,
+		Stream:    "stderr",
 	})
 
 	return 0
 }
 
+func (p *prober) ParseStdout(line string) {}
+
 func (p *prober) parseJSON(line string) {
 	inputs := []probeIO{}
 
@@ -97,6 +100,7 @@ func (p *prober) parseDefault() {
 		p.inputs[i].Address = input.Address
 		p.inputs[i].Format = input.Format
 		p.inputs[i].Duration = input.Duration
+		p.inputs[i].Program = input.Program
 		p.inputs[i].Index = input.Index
 		p.inputs[i].Stream = input.Stream
 		p.inputs[i].Language = input.Language
@@ -140,3 +144,5 @@ func (p *prober) ResetLog() {
 	p.data = []process.Line{}
 	p.inputs = []probeIO{}
 }
+
+func (p *prober) Exited(code int, signal string) {}
@@ -16,6 +16,7 @@ type probeIO struct {
 	Coder    string  `json:"coder"`
 	Bitrate  float64 `json:"bitrate_kbps"`
 	Duration float64 `json:"duration_sec"`
+	Program  uint64  `json:"program"`
 
 	// video
 	FPS    float64 `json:"fps"`
@@ -41,6 +42,7 @@ func (io *probeIO) export() app.ProbeIO {
 		Coder:    io.Coder,
 		Bitrate:  io.Bitrate,
 		Duration: io.Duration,
+		Program:  io.Program,
 		FPS:      io.FPS,
 		Pixfmt:   io.Pixfmt,
 		Width:    io.Width,
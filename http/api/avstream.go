@@ -1,6 +1,8 @@
 package api
 
 import (
+	"encoding/json"
+
 	"github.com/datarhei/core/v16/restream/app"
 )
 
@@ -33,6 +35,14 @@ type AVstream struct {
 	Looping     bool       `json:"looping"`
 	Duplicating bool       `json:"duplicating"`
 	GOP         string     `json:"gop"`
+
+	// derived
+	Latency  json.Number `json:"latency_sec" swaggertype:"number" jsonschema:"type=number"`
+	DropRate json.Number `json:"drop_per_sec" swaggertype:"number" jsonschema:"type=number"`
+	DupRate  json.Number `json:"dup_per_sec" swaggertype:"number" jsonschema:"type=number"`
+
+	// Fill level of the input buffer of the process, 1 means full
+	BufferFill json.Number `json:"buffer_fill" swaggertype:"number" jsonschema:"type=number"`
 }
 
 func (a *AVstream) Unmarshal(av *app.AVstream) {
@@ -49,6 +59,11 @@ func (a *AVstream) Unmarshal(av *app.AVstream) {
 	a.Duplicating = av.Duplicating
 	a.GOP = av.GOP
 
+	a.Latency = toNumber(av.Derived.Latency)
+	a.DropRate = toNumber(av.Derived.DropRate)
+	a.DupRate = toNumber(av.Derived.DupRate)
+	a.BufferFill = toNumber(av.Derived.BufferFill)
+
 	a.Input.Unmarshal(&av.Input)
 	a.Output.Unmarshal(&av.Output)
 }
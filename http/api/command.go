@@ -2,5 +2,25 @@ package api
 
 // Command is a command to send to a process
 type Command struct {
-	Command string `json:"command" validate:"required" enums:"start,stop,restart,reload" jsonschema:"enum=start,enum=stop,enum=restart,enum=reload"`
+	Command string `json:"command" validate:"required" enums:"start,stop,restart,reload,rotate,pause,resume" jsonschema:"enum=start,enum=stop,enum=restart,enum=reload,enum=rotate,enum=pause,enum=resume"`
+}
+
+// OutputOrder is the new order of the outputs of a process
+type OutputOrder struct {
+	Order []string `json:"order" validate:"required"`
+}
+
+// AutostartOrder is the order in which the processes are started when the core starts
+type AutostartOrder struct {
+	Order []string `json:"order" validate:"required"`
+}
+
+// ProcessRename is the new ID of a process
+type ProcessRename struct {
+	ID string `json:"id" validate:"required"`
+}
+
+// ProcessDrain lists the processes that have been stopped by draining or started by undraining
+type ProcessDrain struct {
+	Processes []string `json:"processes"`
 }
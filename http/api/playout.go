@@ -1,6 +1,12 @@
 package api
 
-import "github.com/datarhei/core/v16/playout"
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/datarhei/core/v16/playout"
+	"github.com/datarhei/core/v16/restream/app"
+)
 
 type PlayoutStatusIO struct {
 	State  string `json:"state" enums:"running,idle" jsonschema:"enum=running,enum=idle"`
@@ -66,3 +72,67 @@ func (s *PlayoutStatus) Unmarshal(status playout.Status) {
 	s.Output.Unmarshal(status.Output)
 	s.Swap.Unmarshal(status.Swap)
 }
+
+// PlayoutStreamInfoStream describes a stream of the currently playing input
+type PlayoutStreamInfoStream struct {
+	Index   uint64      `json:"index" format:"uint64"`
+	Stream  uint64      `json:"stream" format:"uint64"`
+	Type    string      `json:"type"`
+	Codec   string      `json:"codec"`
+	FPS     json.Number `json:"fps" swaggertype:"number" jsonschema:"type=number"`
+	Bitrate json.Number `json:"bitrate_kbit" swaggertype:"number" jsonschema:"type=number"` // kbit/s
+
+	// Video
+	Width  uint64 `json:"width,omitempty" format:"uint64"`
+	Height uint64 `json:"height,omitempty" format:"uint64"`
+
+	// Audio
+	Sampling uint64 `json:"sampling_hz,omitempty" format:"uint64"`
+	Layout   string `json:"layout,omitempty"`
+	Channels uint64 `json:"channels,omitempty" format:"uint64"`
+}
+
+func (s *PlayoutStreamInfoStream) Unmarshal(io *app.ProgressIO) {
+	s.Index = io.Index
+	s.Stream = io.Stream
+	s.Type = io.Type
+	s.Codec = io.Codec
+	s.FPS = json.Number(fmt.Sprintf("%.3f", io.FPS))
+	s.Bitrate = json.Number(fmt.Sprintf("%.3f", io.Bitrate/1024))
+	s.Width = io.Width
+	s.Height = io.Height
+	s.Sampling = io.Sampling
+	s.Layout = io.Layout
+	s.Channels = io.Channels
+}
+
+// PlayoutStreamInfo describes the stream that is currently playing on an input. If
+// no stream is active, the list of streams is empty.
+type PlayoutStreamInfo struct {
+	ID      string                    `json:"id"`
+	Address string                    `json:"url"`
+	Active  bool                      `json:"active"`
+	Streams []PlayoutStreamInfoStream `json:"streams"`
+}
+
+// PlayoutControl controls the playback of a file input
+type PlayoutControl struct {
+	Action   string  `json:"action" validate:"required" enums:"pause,resume,seek,rate" jsonschema:"enum=pause,enum=resume,enum=seek,enum=rate"`
+	Position float64 `json:"position,omitempty"` // seconds, only for seek
+	Rate     float64 `json:"rate,omitempty"`     // playback rate, only for rate
+}
+
+// PlayoutPort describes a port that is assigned to the playout API of an input of a process
+type PlayoutPort struct {
+	Port       int    `json:"port"`
+	ProcessID  string `json:"process_id"`
+	InputID    string `json:"input_id"`
+	AssignedAt int64  `json:"assigned_at" format:"int64"`
+}
+
+func (p *PlayoutPort) Unmarshal(info app.PlayoutPortInfo) {
+	p.Port = info.Port
+	p.ProcessID = info.ProcessID
+	p.InputID = info.InputID
+	p.AssignedAt = info.AssignedAt
+}
@@ -19,6 +19,7 @@ type ProbeIO struct {
 	Coder    string      `json:"coder"`
 	Bitrate  json.Number `json:"bitrate_kbps" swaggertype:"number" jsonschema:"type=number"`
 	Duration json.Number `json:"duration_sec"  swaggertype:"number" jsonschema:"type=number"`
+	Program  uint64      `json:"program" format:"uint64"`
 
 	// video
 	FPS    json.Number `json:"fps" swaggertype:"number" jsonschema:"type=number"`
@@ -47,6 +48,7 @@ func (i *ProbeIO) Unmarshal(io *app.ProbeIO) {
 	i.Coder = io.Coder
 	i.Bitrate = toNumber(io.Bitrate)
 	i.Duration = toNumber(io.Duration)
+	i.Program = io.Program
 
 	i.FPS = toNumber(io.FPS)
 	i.Pixfmt = io.Pixfmt
@@ -80,3 +82,10 @@ func (probe *Probe) Unmarshal(p *app.Probe) {
 
 	copy(probe.Log, p.Log)
 }
+
+// ProbeRequest represents an address to probe that is not an input of a process
+type ProbeRequest struct {
+	Address string   `json:"address" validate:"required" jsonschema:"minLength=1"`
+	Options []string `json:"options"`
+	Timeout uint64   `json:"timeout_sec" format:"uint64"`
+}
@@ -26,6 +26,7 @@ type ProcessConfigIO struct {
 	Address string                   `json:"address" validate:"required" jsonschema:"minLength=1"`
 	Options []string                 `json:"options"`
 	Cleanup []ProcessConfigIOCleanup `json:"cleanup,omitempty"`
+	Each    []map[string]string      `json:"each,omitempty"`
 }
 
 type ProcessConfigIOCleanup struct {
@@ -41,34 +42,113 @@ type ProcessConfigLimits struct {
 	WaitFor uint64  `json:"waitfor_seconds" jsonschema:"minimum=0" format:"uint64"`
 }
 
+// ProcessConfigHook represents a command that is executed before the start or after the stop of a process
+type ProcessConfigHook struct {
+	Binary  string   `json:"binary" validate:"required" jsonschema:"minLength=1"`
+	Args    []string `json:"args"`
+	Timeout uint64   `json:"timeout_seconds" format:"uint64"`
+}
+
+func (hook *ProcessConfigHook) marshal() *app.ConfigHook {
+	if hook == nil {
+		return nil
+	}
+
+	h := &app.ConfigHook{
+		Binary:  hook.Binary,
+		Timeout: hook.Timeout,
+	}
+
+	h.Args = make([]string, len(hook.Args))
+	copy(h.Args, hook.Args)
+
+	return h
+}
+
+func unmarshalProcessConfigHook(hook *app.ConfigHook) *ProcessConfigHook {
+	if hook == nil {
+		return nil
+	}
+
+	h := &ProcessConfigHook{
+		Binary:  hook.Binary,
+		Timeout: hook.Timeout,
+	}
+
+	h.Args = make([]string, len(hook.Args))
+	copy(h.Args, hook.Args)
+
+	return h
+}
+
 // ProcessConfig represents the configuration of an ffmpeg process
 type ProcessConfig struct {
-	ID             string              `json:"id"`
-	Type           string              `json:"type" validate:"oneof='ffmpeg' ''" jsonschema:"enum=ffmpeg,enum="`
-	Reference      string              `json:"reference"`
-	Input          []ProcessConfigIO   `json:"input" validate:"required"`
-	Output         []ProcessConfigIO   `json:"output" validate:"required"`
-	Options        []string            `json:"options"`
-	Reconnect      bool                `json:"reconnect"`
-	ReconnectDelay uint64              `json:"reconnect_delay_seconds" format:"uint64"`
-	Autostart      bool                `json:"autostart"`
-	StaleTimeout   uint64              `json:"stale_timeout_seconds" format:"uint64"`
-	Limits         ProcessConfigLimits `json:"limits"`
+	ID              string              `json:"id"`
+	Type            string              `json:"type" validate:"oneof='ffmpeg' ''" jsonschema:"enum=ffmpeg,enum="`
+	Reference       string              `json:"reference"`
+	Tenant          string              `json:"tenant,omitempty"`
+	Input           []ProcessConfigIO   `json:"input" validate:"required"`
+	Output          []ProcessConfigIO   `json:"output" validate:"required"`
+	Options         []string            `json:"options"`
+	Environment     map[string]string   `json:"environment,omitempty"`
+	WorkingDir      string              `json:"working_dir,omitempty"`
+	PreStart        *ProcessConfigHook  `json:"pre_start,omitempty"`
+	PostStop        *ProcessConfigHook  `json:"post_stop,omitempty"`
+	Priority        int                 `json:"priority,omitempty" format:"int"`
+	OnDemand        bool                `json:"on_demand,omitempty"`
+	ReloadConsumers bool                `json:"reload_consumers,omitempty"`
+	LogStdout       bool                `json:"log_stdout,omitempty"`
+	Reconnect       bool                `json:"reconnect"`
+	ReconnectDelay  uint64              `json:"reconnect_delay_seconds" format:"uint64"`
+	ReconnectJitter uint64              `json:"reconnect_jitter_seconds,omitempty" format:"uint64"`
+	RestartPolicy   string              `json:"restart_policy,omitempty" enums:"always,on-failure,never" jsonschema:"enum=always,enum=on-failure,enum=never"`
+	MaxRestarts     int                 `json:"max_restarts,omitempty" format:"int"`
+	RestartWindow   uint64              `json:"restart_window_seconds,omitempty" format:"uint64"`
+	Autostart       bool                `json:"autostart"`
+	AutostartDelay  uint64              `json:"autostart_delay_seconds,omitempty" format:"uint64"`
+	AutostartJitter uint64              `json:"autostart_jitter_seconds,omitempty" format:"uint64"`
+	AutostartOrder  int                 `json:"autostart_order,omitempty" format:"int"`
+	StaleTimeout    uint64              `json:"stale_timeout_seconds" format:"uint64"`
+	Limits          ProcessConfigLimits `json:"limits"`
+	CPUAffinity     []int               `json:"cpu_affinity,omitempty"`
+	Nice            int                 `json:"nice,omitempty" format:"int"`
+	Device          string              `json:"device,omitempty"`
+	InputBuffer     uint64              `json:"input_buffer_ms,omitempty" format:"uint64"`
 }
 
 // Marshal converts a process config in API representation to a restreamer process config
 func (cfg *ProcessConfig) Marshal() *app.Config {
 	p := &app.Config{
-		ID:             cfg.ID,
-		Reference:      cfg.Reference,
-		Options:        cfg.Options,
-		Reconnect:      cfg.Reconnect,
-		ReconnectDelay: cfg.ReconnectDelay,
-		Autostart:      cfg.Autostart,
-		StaleTimeout:   cfg.StaleTimeout,
-		LimitCPU:       cfg.Limits.CPU,
-		LimitMemory:    cfg.Limits.Memory * 1024 * 1024,
-		LimitWaitFor:   cfg.Limits.WaitFor,
+		ID:              cfg.ID,
+		Reference:       cfg.Reference,
+		Tenant:          cfg.Tenant,
+		Options:         cfg.Options,
+		Environment:     cfg.Environment,
+		WorkingDir:      cfg.WorkingDir,
+		PreStart:        cfg.PreStart.marshal(),
+		PostStop:        cfg.PostStop.marshal(),
+		Priority:        cfg.Priority,
+		OnDemand:        cfg.OnDemand,
+		ReloadConsumers: cfg.ReloadConsumers,
+		LogStdout:       cfg.LogStdout,
+		Reconnect:       cfg.Reconnect,
+		ReconnectDelay:  cfg.ReconnectDelay,
+		ReconnectJitter: cfg.ReconnectJitter,
+		RestartPolicy:   cfg.RestartPolicy,
+		MaxRestarts:     cfg.MaxRestarts,
+		RestartWindow:   cfg.RestartWindow,
+		Autostart:       cfg.Autostart,
+		AutostartDelay:  cfg.AutostartDelay,
+		AutostartJitter: cfg.AutostartJitter,
+		AutostartOrder:  cfg.AutostartOrder,
+		StaleTimeout:    cfg.StaleTimeout,
+		LimitCPU:        cfg.Limits.CPU,
+		LimitMemory:     cfg.Limits.Memory * 1024 * 1024,
+		LimitWaitFor:    cfg.Limits.WaitFor,
+		CPUAffinity:     cfg.CPUAffinity,
+		Nice:            cfg.Nice,
+		Device:          cfg.Device,
+		InputBuffer:     cfg.InputBuffer,
 	}
 
 	cfg.generateInputOutputIDs(cfg.Input)
@@ -88,6 +168,7 @@ func (cfg *ProcessConfig) Marshal() *app.Config {
 			ID:      x.ID,
 			Address: x.Address,
 			Options: x.Options,
+			Each:    x.Each,
 		}
 
 		for _, c := range x.Cleanup {
@@ -140,18 +221,48 @@ func (cfg *ProcessConfig) Unmarshal(c *app.Config) {
 
 	cfg.ID = c.ID
 	cfg.Reference = c.Reference
+	cfg.Tenant = c.Tenant
+	cfg.WorkingDir = c.WorkingDir
+	cfg.PreStart = unmarshalProcessConfigHook(c.PreStart)
+	cfg.PostStop = unmarshalProcessConfigHook(c.PostStop)
+	cfg.Priority = c.Priority
+	cfg.OnDemand = c.OnDemand
+	cfg.ReloadConsumers = c.ReloadConsumers
+	cfg.LogStdout = c.LogStdout
 	cfg.Type = "ffmpeg"
 	cfg.Reconnect = c.Reconnect
 	cfg.ReconnectDelay = c.ReconnectDelay
+	cfg.ReconnectJitter = c.ReconnectJitter
+	cfg.RestartPolicy = c.RestartPolicy
+	cfg.MaxRestarts = c.MaxRestarts
+	cfg.RestartWindow = c.RestartWindow
 	cfg.Autostart = c.Autostart
+	cfg.AutostartDelay = c.AutostartDelay
+	cfg.AutostartJitter = c.AutostartJitter
+	cfg.AutostartOrder = c.AutostartOrder
 	cfg.StaleTimeout = c.StaleTimeout
 	cfg.Limits.CPU = c.LimitCPU
 	cfg.Limits.Memory = c.LimitMemory / 1024 / 1024
 	cfg.Limits.WaitFor = c.LimitWaitFor
+	cfg.Nice = c.Nice
+	cfg.Device = c.Device
+	cfg.InputBuffer = c.InputBuffer
 
 	cfg.Options = make([]string, len(c.Options))
 	copy(cfg.Options, c.Options)
 
+	if c.CPUAffinity != nil {
+		cfg.CPUAffinity = make([]int, len(c.CPUAffinity))
+		copy(cfg.CPUAffinity, c.CPUAffinity)
+	}
+
+	if c.Environment != nil {
+		cfg.Environment = make(map[string]string, len(c.Environment))
+		for k, v := range c.Environment {
+			cfg.Environment[k] = v
+		}
+	}
+
 	for _, x := range c.Input {
 		io := ProcessConfigIO{
 			ID:      x.ID,
@@ -182,6 +293,8 @@ func (cfg *ProcessConfig) Unmarshal(c *app.Config) {
 			})
 		}
 
+		io.Each = x.Clone().Each
+
 		cfg.Output = append(cfg.Output, io)
 	}
 }
@@ -233,15 +346,30 @@ func (report *ProcessReport) Unmarshal(l *app.Log) {
 
 // ProcessState represents the current state of an ffmpeg process
 type ProcessState struct {
-	Order     string      `json:"order" jsonschema:"enum=start,enum=stop"`
-	State     string      `json:"exec" jsonschema:"enum=finished,enum=starting,enum=running,enum=finishing,enum=killed,enum=failed"`
-	Runtime   int64       `json:"runtime_seconds" jsonschema:"minimum=0" format:"int64"`
-	Reconnect int64       `json:"reconnect_seconds" format:"int64"`
-	LastLog   string      `json:"last_logline"`
-	Progress  *Progress   `json:"progress"`
-	Memory    uint64      `json:"memory_bytes" format:"uint64"`
-	CPU       json.Number `json:"cpu_usage" swaggertype:"number" jsonschema:"type=number"`
-	Command   []string    `json:"command"`
+	Order      string      `json:"order" jsonschema:"enum=start,enum=stop"`
+	Version    uint64      `json:"version" format:"uint64"`
+	State      string      `json:"exec" jsonschema:"enum=finished,enum=starting,enum=running,enum=finishing,enum=killed,enum=failed,enum=queued"`
+	Runtime    int64       `json:"runtime_seconds" jsonschema:"minimum=0" format:"int64"`
+	Reconnect  int64       `json:"reconnect_seconds" format:"int64"`
+	StartIn    int64       `json:"start_in_seconds" format:"int64"`
+	LastLog    string      `json:"last_logline"`
+	Progress   *Progress   `json:"progress"`
+	Memory     uint64      `json:"memory_bytes" format:"uint64"`
+	CPU        json.Number `json:"cpu_usage" swaggertype:"number" jsonschema:"type=number"`
+	Command    []string    `json:"command"`
+	ExitCode   int         `json:"exit_code" format:"int"`
+	ExitSignal string      `json:"exit_signal"`
+	QueuePos   int         `json:"queue_position" format:"int"`
+	Priority   int         `json:"priority" format:"int"`
+	Consumers  int         `json:"consumers" format:"int"`
+	Restarts   int         `json:"restarts" format:"int"`
+	Breaker    string      `json:"breaker" jsonschema:"enum=closed,enum=open"`
+	Policy     string      `json:"restart_policy" jsonschema:"enum=always,enum=on-failure,enum=never"`
+	Decision   string      `json:"restart_decision"`
+	RotatedAt  int64       `json:"rotated_at" format:"int64"`
+	Paused     bool        `json:"paused"`
+	Reason     string      `json:"reason,omitempty"`
+	Detail     string      `json:"detail,omitempty"`
 }
 
 // Unmarshal converts a restreamer ffmpeg process state to a state in API representation
@@ -251,10 +379,25 @@ func (s *ProcessState) Unmarshal(state *app.State) {
 	}
 
 	s.Order = state.Order
+	s.Version = state.Version
 	s.State = state.State
 	s.Runtime = int64(state.Duration)
 	s.Reconnect = int64(state.Reconnect)
+	s.StartIn = int64(state.StartIn)
 	s.LastLog = state.LastLog
+	s.ExitCode = state.ExitCode
+	s.ExitSignal = state.ExitSignal
+	s.QueuePos = state.QueuePosition
+	s.Priority = state.Priority
+	s.Consumers = state.Consumers
+	s.Restarts = state.Restarts
+	s.Breaker = state.Breaker
+	s.Policy = state.RestartPolicy
+	s.Decision = state.Restart
+	s.RotatedAt = state.RotatedAt
+	s.Paused = state.Paused
+	s.Reason = state.Reason
+	s.Detail = state.Detail
 	s.Progress = &Progress{}
 	s.Memory = state.Memory
 	s.CPU = toNumber(state.CPU)
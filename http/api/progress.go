@@ -89,6 +89,10 @@ type Progress struct {
 	Speed     json.Number  `json:"speed" swaggertype:"number" jsonschema:"type=number"`
 	Drop      uint64       `json:"drop" format:"uint64"`
 	Dup       uint64       `json:"dup" format:"uint64"`
+
+	Duration   json.Number `json:"duration_sec" swaggertype:"number" jsonschema:"type=number"` // 0 if unknown, e.g. for live inputs
+	Completion json.Number `json:"completion" swaggertype:"number" jsonschema:"type=number"`   // percent, -1 if unknown
+	ETA        json.Number `json:"eta_sec" swaggertype:"number" jsonschema:"type=number"`      // -1 if unknown
 }
 
 // Unmarshal converts a restreamer Progress to a Progress in API representation
@@ -112,6 +116,9 @@ func (progress *Progress) Unmarshal(p *app.Progress) {
 	progress.Speed = toNumber(p.Speed)
 	progress.Drop = p.Drop
 	progress.Dup = p.Dup
+	progress.Duration = toNumber(p.Duration)
+	progress.Completion = toNumber(p.Completion)
+	progress.ETA = toNumber(p.ETA)
 
 	for i, io := range p.Input {
 		progress.Input[i].Unmarshal(&io)
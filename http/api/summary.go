@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// RestreamSummary represents totals over all processes
+type RestreamSummary struct {
+	Processes     int                   `json:"processes"`
+	States        map[string]int        `json:"states"`
+	InputBitrate  json.Number           `json:"input_bitrate_kbit" swaggertype:"number" jsonschema:"type=number"`  // kbit/s
+	OutputBitrate json.Number           `json:"output_bitrate_kbit" swaggertype:"number" jsonschema:"type=number"` // kbit/s
+	Restarts      int                   `json:"restarts_last_hour"`
+	CPU           json.Number           `json:"cpu_usage" swaggertype:"number" jsonschema:"type=number"`
+	Memory        uint64                `json:"memory_bytes" format:"uint64"`
+	Devices       map[string]DeviceLoad `json:"devices"`
+}
+
+// DeviceLoad represents the load of a hardware acceleration device
+type DeviceLoad struct {
+	Processes []string `json:"processes"`
+	Capacity  int      `json:"capacity" format:"int"`
+}
+
+// Unmarshal converts a restreamer summary to a RestreamSummary in API representation
+func (s *RestreamSummary) Unmarshal(summary *app.RestreamSummary) {
+	if summary == nil {
+		return
+	}
+
+	s.Processes = summary.Processes
+	s.States = make(map[string]int, len(summary.States))
+
+	for state, n := range summary.States {
+		s.States[state] = n
+	}
+
+	s.InputBitrate = toNumber(summary.InputBitrate / 1024)
+	s.OutputBitrate = toNumber(summary.OutputBitrate / 1024)
+	s.Restarts = summary.Restarts
+	s.CPU = toNumber(summary.CPU)
+	s.Memory = summary.Memory
+	s.Devices = make(map[string]DeviceLoad, len(summary.Devices))
+
+	for id, load := range summary.Devices {
+		s.Devices[id] = DeviceLoad{
+			Processes: append([]string{}, load.Processes...),
+			Capacity:  load.Capacity,
+		}
+	}
+}
+
+// ProcessSummary represents the essential information about a process for a list of processes
+type ProcessSummary struct {
+	ID        string `json:"id"`
+	Reference string `json:"reference"`
+	Order     string `json:"order" enums:"start,stop" jsonschema:"enum=start,enum=stop"`
+	State     string `json:"state"`
+	ExitCode  int    `json:"exit_code" format:"int"`
+}
+
+// Unmarshal converts a restreamer process summary to a ProcessSummary in API representation
+func (s *ProcessSummary) Unmarshal(summary *app.ProcessSummary) {
+	if summary == nil {
+		return
+	}
+
+	s.ID = summary.ID
+	s.Reference = summary.Reference
+	s.Order = summary.Order
+	s.State = summary.State
+	s.ExitCode = summary.ExitCode
+}
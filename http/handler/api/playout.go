@@ -3,29 +3,99 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
 	"net/http"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/datarhei/core/v16/http/api"
 	"github.com/datarhei/core/v16/http/handler/util"
+	"github.com/datarhei/core/v16/log"
 	"github.com/datarhei/core/v16/playout"
 	"github.com/datarhei/core/v16/restream"
+	"github.com/datarhei/core/v16/restream/app"
 
 	"github.com/labstack/echo/v4"
 )
 
+type PlayoutConfig struct {
+	Restream restream.Restreamer
+
+	// Number of attempts for idempotent requests to the playout API. Optional. Default value 3.
+	Attempts int
+
+	// Time to wait before the first retry. The time will be doubled with each further
+	// retry. Optional. Default value 100ms.
+	Backoff time.Duration
+
+	// Maximum size of an uploaded error frame in bytes. Optional. Default value 5MB.
+	MaxErrorframeSize int64
+
+	Logger log.Logger
+}
+
 // The PlayoutHandler type provides handlers for accessing the playout API of a process
 type PlayoutHandler struct {
-	restream restream.Restreamer
+	restream          restream.Restreamer
+	attempts          int
+	backoff           time.Duration
+	maxErrorframeSize int64
+	logger            log.Logger
+
+	// Durations of the file inputs for seeking, by process and input ID
+	durations     map[[2]string]cachedDuration
+	durationsLock sync.Mutex
+}
+
+// cachedDuration is the duration of the file at the address of an input
+type cachedDuration struct {
+	address  string
+	duration float64
 }
 
 // NewPlayout returns a new Playout type. You have to provide a Restreamer instance.
 func NewPlayout(restream restream.Restreamer) *PlayoutHandler {
-	return &PlayoutHandler{
-		restream: restream,
+	return NewPlayoutWithConfig(PlayoutConfig{
+		Restream: restream,
+	})
+}
+
+// NewPlayoutWithConfig returns a new Playout type with the given config.
+func NewPlayoutWithConfig(config PlayoutConfig) *PlayoutHandler {
+	h := &PlayoutHandler{
+		restream:          config.Restream,
+		attempts:          config.Attempts,
+		backoff:           config.Backoff,
+		maxErrorframeSize: config.MaxErrorframeSize,
+		logger:            config.Logger,
+		durations:         map[[2]string]cachedDuration{},
 	}
+
+	if h.logger == nil {
+		h.logger = log.New("")
+	}
+
+	if h.attempts <= 0 {
+		h.attempts = 3
+	}
+
+	if h.backoff <= 0 {
+		h.backoff = 100 * time.Millisecond
+	}
+
+	if h.maxErrorframeSize <= 0 {
+		h.maxErrorframeSize = 5 * 1024 * 1024
+	}
+
+	return h
 }
 
 // Status return the current playout status
@@ -39,20 +109,21 @@ func NewPlayout(restream restream.Restreamer) *PlayoutHandler {
 // @Success 200 {object} api.PlayoutStatus
 // @Failure 404 {object} api.Error
 // @Failure 500 {object} api.Error
+// @Failure 503 {object} api.Error
 // @Security ApiKeyAuth
 // @Router /api/v3/process/{id}/playout/{inputid}/status [get]
 func (h *PlayoutHandler) Status(c echo.Context) error {
 	id := util.PathParam(c, "id")
 	inputid := util.PathParam(c, "inputid")
 
-	addr, err := h.restream.GetPlayout(id, inputid)
+	info, err := h.playoutInfo(id, inputid)
 	if err != nil {
-		return api.Err(http.StatusNotFound, "Unknown process or input", "%s", err)
+		return err
 	}
 
 	path := "/v1/status"
 
-	response, err := h.request(http.MethodGet, addr, path, "", nil)
+	response, err := h.requestWithRetry(http.MethodGet, info, path, "", nil)
 	if err != nil {
 		return api.Err(http.StatusInternalServerError, "", "%s", err)
 	}
@@ -79,7 +150,87 @@ func (h *PlayoutHandler) Status(c echo.Context) error {
 		return c.JSON(http.StatusOK, apistatus)
 	}
 
-	return c.Blob(response.StatusCode, response.Header.Get("content-type"), data)
+	return h.response(c, response, data)
+}
+
+// StreamInfo returns the stream that is currently playing
+// @Summary Get the currently playing stream
+// @Description Get the codec, resolution, framerate, and bitrate of the stream that is currently playing on an input of a process. If no stream is active, the list of streams is empty.
+// @Tags v16.7.2
+// @ID process-3-playout-streaminfo
+// @Produce json
+// @Param id path string true "Process ID"
+// @Param inputid path string true "Process Input ID"
+// @Success 200 {object} api.PlayoutStreamInfo
+// @Failure 404 {object} api.Error
+// @Failure 500 {object} api.Error
+// @Failure 503 {object} api.Error
+// @Security ApiKeyAuth
+// @Router /api/v3/process/{id}/playout/{inputid}/streaminfo [get]
+func (h *PlayoutHandler) StreamInfo(c echo.Context) error {
+	id := util.PathParam(c, "id")
+	inputid := util.PathParam(c, "inputid")
+
+	info, err := h.playoutInfo(id, inputid)
+	if err != nil {
+		return err
+	}
+
+	path := "/v1/status"
+
+	response, err := h.requestWithRetry(http.MethodGet, info, path, "", nil)
+	if err != nil {
+		return api.Err(http.StatusInternalServerError, "", "%s", err)
+	}
+
+	defer response.Body.Close()
+
+	// Read the whole response
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return api.Err(http.StatusInternalServerError, "", "%s", err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return h.response(c, response, data)
+	}
+
+	status := playout.Status{}
+
+	if err := json.Unmarshal(data, &status); err != nil {
+		return api.Err(http.StatusInternalServerError, "", "%s", err)
+	}
+
+	streaminfo := api.PlayoutStreamInfo{
+		ID:      status.ID,
+		Address: status.Address,
+		Active:  status.Input.State == "running",
+		Streams: []api.PlayoutStreamInfoStream{},
+	}
+
+	if !streaminfo.Active {
+		// Nothing is playing, there's no stream to describe
+		return c.JSON(http.StatusOK, streaminfo)
+	}
+
+	// The playout API doesn't know about the streams, they are taken from the progress of the process
+	state, err := h.restream.GetProcessState(id)
+	if err != nil {
+		return api.Err(http.StatusNotFound, "Unknown process", "%s", err)
+	}
+
+	for i := range state.Progress.Input {
+		if state.Progress.Input[i].ID != inputid {
+			continue
+		}
+
+		stream := api.PlayoutStreamInfoStream{}
+		stream.Unmarshal(&state.Progress.Input[i])
+
+		streaminfo.Streams = append(streaminfo.Streams, stream)
+	}
+
+	return c.JSON(http.StatusOK, streaminfo)
 }
 
 // Keyframe returns the last keyframe
@@ -92,10 +243,12 @@ func (h *PlayoutHandler) Status(c echo.Context) error {
 // @Produce json
 // @Param id path string true "Process ID"
 // @Param inputid path string true "Process Input ID"
-// @Param name path string true "Any filename with an extension of .jpg or .png"
+// @Param name path string true "Any filename with an extension of .jpg, .jpeg, or .png"
 // @Success 200 {file} byte
+// @Failure 400 {object} api.Error
 // @Failure 404 {object} api.Error
 // @Failure 500 {object} api.Error
+// @Failure 503 {object} api.Error
 // @Security ApiKeyAuth
 // @Router /api/v3/process/{id}/playout/{inputid}/keyframe/{name} [get]
 func (h *PlayoutHandler) Keyframe(c echo.Context) error {
@@ -103,33 +256,50 @@ func (h *PlayoutHandler) Keyframe(c echo.Context) error {
 	inputid := util.PathParam(c, "inputid")
 	name := util.PathWildcardParam(c)
 
-	addr, err := h.restream.GetPlayout(id, inputid)
-	if err != nil {
-		return api.Err(http.StatusNotFound, "Unknown process or input", "%s", err)
-	}
-
 	path := "/v1/keyframe/last."
+	contentType := ""
 
-	if strings.HasSuffix(name, ".png") {
-		path = path + "png"
-	} else {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg":
 		path = path + "jpg"
+		contentType = "image/jpeg"
+	case ".png":
+		path = path + "png"
+		contentType = "image/png"
+	default:
+		return api.Err(http.StatusBadRequest, "Unsupported file extension", "allowed extensions are .jpg, .jpeg, and .png")
+	}
+
+	info, err := h.playoutInfo(id, inputid)
+	if err != nil {
+		return err
 	}
 
-	response, err := h.request(http.MethodGet, addr, path, "", nil)
+	response, err := h.requestWithRetry(http.MethodGet, info, path, "", nil)
 	if err != nil {
 		return api.Err(http.StatusInternalServerError, "", "%s", err)
 	}
 
 	defer response.Body.Close()
 
+	if response.StatusCode == http.StatusOK {
+		if upstreamContentType := response.Header.Get("content-type"); !strings.HasPrefix(upstreamContentType, contentType) {
+			h.logger.Warn().WithFields(log.Fields{
+				"id":       id,
+				"input":    inputid,
+				"expected": contentType,
+				"actual":   upstreamContentType,
+			}).Log("Keyframe content type doesn't match the requested extension")
+		}
+	}
+
 	// Read the whole response
 	data, err := io.ReadAll(response.Body)
 	if err != nil {
 		return api.Err(http.StatusInternalServerError, "", "%s", err)
 	}
 
-	return c.Blob(response.StatusCode, response.Header.Get("content-type"), data)
+	return h.response(c, response, data)
 }
 
 // EncodeErrorframe encodes the errorframe
@@ -144,20 +314,21 @@ func (h *PlayoutHandler) Keyframe(c echo.Context) error {
 // @Success 204 {string} string
 // @Failure 404 {object} api.Error
 // @Failure 500 {object} api.Error
+// @Failure 503 {object} api.Error
 // @Security ApiKeyAuth
 // @Router /api/v3/process/{id}/playout/{inputid}/errorframe/encode [get]
 func (h *PlayoutHandler) EncodeErrorframe(c echo.Context) error {
 	id := util.PathParam(c, "id")
 	inputid := util.PathParam(c, "inputid")
 
-	addr, err := h.restream.GetPlayout(id, inputid)
+	info, err := h.playoutInfo(id, inputid)
 	if err != nil {
-		return api.Err(http.StatusNotFound, "Unknown process or input", "%s", err)
+		return err
 	}
 
 	path := "/v1/errorframe/encode"
 
-	response, err := h.request(http.MethodGet, addr, path, "", nil)
+	response, err := h.requestWithRetry(http.MethodGet, info, path, "", nil)
 	if err != nil {
 		return api.Err(http.StatusInternalServerError, "", "%s", err)
 	}
@@ -170,7 +341,7 @@ func (h *PlayoutHandler) EncodeErrorframe(c echo.Context) error {
 		return api.Err(http.StatusInternalServerError, "", "%s", err)
 	}
 
-	return c.Blob(response.StatusCode, response.Header.Get("content-type"), data)
+	return h.response(c, response, data)
 }
 
 // SetErrorframe sets an errorframe
@@ -186,27 +357,39 @@ func (h *PlayoutHandler) EncodeErrorframe(c echo.Context) error {
 // @Param name path string true "Any filename with a suitable extension"
 // @Param image body []byte true "Image to be used a error frame"
 // @Success 204 {string} string
+// @Failure 400 {object} api.Error
 // @Failure 404 {object} api.Error
+// @Failure 413 {object} api.Error
 // @Failure 500 {object} api.Error
+// @Failure 503 {object} api.Error
 // @Security ApiKeyAuth
 // @Router /api/v3/process/{id}/playout/{inputid}/errorframe/{name} [post]
 func (h *PlayoutHandler) SetErrorframe(c echo.Context) error {
 	id := util.PathParam(c, "id")
 	inputid := util.PathParam(c, "inputid")
 
-	addr, err := h.restream.GetPlayout(id, inputid)
+	// Reading one more byte than allowed tells whether the error frame is too large
+	data, err := io.ReadAll(io.LimitReader(c.Request().Body, h.maxErrorframeSize+1))
 	if err != nil {
-		return api.Err(http.StatusNotFound, "Unknown process or input", "%s", err)
+		return api.Err(http.StatusBadRequest, "Failed to read request body", "%s", err)
 	}
 
-	data, err := io.ReadAll(c.Request().Body)
+	if int64(len(data)) > h.maxErrorframeSize {
+		return api.Err(http.StatusRequestEntityTooLarge, "Error frame too large", "the maximum size is %d bytes", h.maxErrorframeSize)
+	}
+
+	if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+		return api.Err(http.StatusBadRequest, "Invalid image", "%s", err)
+	}
+
+	info, err := h.playoutInfo(id, inputid)
 	if err != nil {
-		return api.Err(http.StatusBadRequest, "Failed to read request body", "%s", err)
+		return err
 	}
 
 	path := "/v1/errorframe.jpg"
 
-	response, err := h.request(http.MethodPut, addr, path, "application/octet-stream", data)
+	response, err := h.request(http.MethodPut, info, path, "application/octet-stream", data)
 	if err != nil {
 		return api.Err(http.StatusInternalServerError, "", "%s", err)
 	}
@@ -219,7 +402,7 @@ func (h *PlayoutHandler) SetErrorframe(c echo.Context) error {
 		return api.Err(http.StatusInternalServerError, "", "%s", err)
 	}
 
-	return c.Blob(response.StatusCode, response.Header.Get("content-type"), data)
+	return h.response(c, response, data)
 }
 
 // ReopenInput closes the current input stream
@@ -233,20 +416,21 @@ func (h *PlayoutHandler) SetErrorframe(c echo.Context) error {
 // @Success 200 {string} string
 // @Failure 404 {object} api.Error
 // @Failure 500 {object} api.Error
+// @Failure 503 {object} api.Error
 // @Security ApiKeyAuth
 // @Router /api/v3/process/{id}/playout/{inputid}/reopen [get]
 func (h *PlayoutHandler) ReopenInput(c echo.Context) error {
 	id := util.PathParam(c, "id")
 	inputid := util.PathParam(c, "inputid")
 
-	addr, err := h.restream.GetPlayout(id, inputid)
+	info, err := h.playoutInfo(id, inputid)
 	if err != nil {
-		return api.Err(http.StatusNotFound, "Unknown process or input", "%s", err)
+		return err
 	}
 
 	path := "/v1/reopen"
 
-	response, err := h.request(http.MethodGet, addr, path, "", nil)
+	response, err := h.requestWithRetry(http.MethodGet, info, path, "", nil)
 	if err != nil {
 		return api.Err(http.StatusInternalServerError, "", "%s", err)
 	}
@@ -259,7 +443,7 @@ func (h *PlayoutHandler) ReopenInput(c echo.Context) error {
 		return api.Err(http.StatusInternalServerError, "", "%s", err)
 	}
 
-	return c.Blob(response.StatusCode, response.Header.Get("content-type"), data)
+	return h.response(c, response, data)
 }
 
 // SetStream replaces the current stream
@@ -276,15 +460,16 @@ func (h *PlayoutHandler) ReopenInput(c echo.Context) error {
 // @Success 204 {string} string
 // @Failure 404 {object} api.Error
 // @Failure 500 {object} api.Error
+// @Failure 503 {object} api.Error
 // @Security ApiKeyAuth
 // @Router /api/v3/process/{id}/playout/{inputid}/stream [put]
 func (h *PlayoutHandler) SetStream(c echo.Context) error {
 	id := util.PathParam(c, "id")
 	inputid := util.PathParam(c, "inputid")
 
-	addr, err := h.restream.GetPlayout(id, inputid)
+	info, err := h.playoutInfo(id, inputid)
 	if err != nil {
-		return api.Err(http.StatusNotFound, "Unknown process or input", "%s", err)
+		return err
 	}
 
 	data, err := io.ReadAll(c.Request().Body)
@@ -294,7 +479,92 @@ func (h *PlayoutHandler) SetStream(c echo.Context) error {
 
 	path := "/v1/stream"
 
-	response, err := h.request(http.MethodPut, addr, path, "text/plain", data)
+	response, err := h.request(http.MethodPut, info, path, "text/plain", data)
+	if err != nil {
+		return api.Err(http.StatusInternalServerError, "", "%s", err)
+	}
+
+	defer response.Body.Close()
+
+	// Read the whole response
+	data, err = io.ReadAll(response.Body)
+	if err != nil {
+		return api.Err(http.StatusInternalServerError, "", "%s", err)
+	}
+
+	return h.response(c, response, data)
+}
+
+// Control controls the playback of a file input
+// @Summary Control the playback of a file input
+// @Description Pause, resume, seek, or change the playback rate of a file input. The position for seeking is given in seconds and must be within the duration of the file. The rate must be positive. Live inputs don't support playback control.
+// @Tags v16.7.2
+// @ID process-3-playout-control
+// @Accept json
+// @Produce json
+// @Param id path string true "Process ID"
+// @Param inputid path string true "Process Input ID"
+// @Param control body api.PlayoutControl true "Playback control"
+// @Success 204 {string} string
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Failure 409 {object} api.Error
+// @Failure 500 {object} api.Error
+// @Failure 503 {object} api.Error
+// @Security ApiKeyAuth
+// @Router /api/v3/process/{id}/playout/{inputid}/control [put]
+func (h *PlayoutHandler) Control(c echo.Context) error {
+	id := util.PathParam(c, "id")
+	inputid := util.PathParam(c, "inputid")
+
+	info, err := h.playoutInfo(id, inputid)
+	if err != nil {
+		return err
+	}
+
+	control := api.PlayoutControl{}
+
+	if err := util.ShouldBindJSON(c, &control); err != nil {
+		return api.Err(http.StatusBadRequest, "Invalid JSON", "%s", err)
+	}
+
+	switch control.Action {
+	case "pause", "resume":
+	case "seek":
+		if control.Position < 0 {
+			return api.Err(http.StatusBadRequest, "Invalid position", "The position must not be negative")
+		}
+	case "rate":
+		if control.Rate <= 0 {
+			return api.Err(http.StatusBadRequest, "Invalid rate", "The rate must be positive")
+		}
+	default:
+		return api.Err(http.StatusBadRequest, "Unknown action provided", "Known actions are: pause, resume, seek, rate")
+	}
+
+	if info.Protocol != "file" {
+		return api.Err(http.StatusConflict, "Playback control is not supported", "The input is a live source (%s)", info.Protocol)
+	}
+
+	if control.Action == "seek" {
+		duration, err := h.inputDuration(id, inputid)
+		if err != nil {
+			return api.Err(http.StatusInternalServerError, "Failed to determine the duration", "%s", err)
+		}
+
+		if control.Position > duration {
+			return api.Err(http.StatusBadRequest, "Invalid position", "The position must be within the duration of %.3f seconds", duration)
+		}
+	}
+
+	data, err := json.Marshal(control)
+	if err != nil {
+		return api.Err(http.StatusInternalServerError, "", "%s", err)
+	}
+
+	path := "/v1/control"
+
+	response, err := h.request(http.MethodPut, info, path, "application/json", data)
 	if err != nil {
 		return api.Err(http.StatusInternalServerError, "", "%s", err)
 	}
@@ -307,11 +577,136 @@ func (h *PlayoutHandler) SetStream(c echo.Context) error {
 		return api.Err(http.StatusInternalServerError, "", "%s", err)
 	}
 
-	return c.Blob(response.StatusCode, response.Header.Get("content-type"), data)
+	return h.response(c, response, data)
 }
 
-func (h *PlayoutHandler) request(method, addr, path, contentType string, data []byte) (*http.Response, error) {
-	endpoint := "http://" + addr + path
+// Ports lists the assigned playout ports
+// @Summary List the assigned playout ports
+// @Description List the ports from the port range that are currently assigned to the playout API of the inputs of the processes, ordered by the port.
+// @Tags v16.7.2
+// @ID process-3-playout-ports
+// @Produce json
+// @Success 200 {array} api.PlayoutPort
+// @Security ApiKeyAuth
+// @Router /api/v3/playout/ports [get]
+func (h *PlayoutHandler) Ports(c echo.Context) error {
+	infos := h.restream.ListPlayoutPorts()
+
+	ports := make([]api.PlayoutPort, len(infos))
+	for i, info := range infos {
+		ports[i].Unmarshal(info)
+	}
+
+	return c.JSON(http.StatusOK, ports)
+}
+
+// inputDuration probes the input of a process and returns the duration of its longest
+// stream in seconds. The duration is cached as long as the address of the input doesn't
+// change, because probing takes a while.
+func (h *PlayoutHandler) inputDuration(id, inputid string) (float64, error) {
+	process, err := h.restream.GetProcess(id)
+	if err != nil {
+		return 0, err
+	}
+
+	index := -1
+
+	for i, input := range process.Config.Input {
+		if input.ID == inputid {
+			index = i
+			break
+		}
+	}
+
+	if index == -1 {
+		return 0, fmt.Errorf("unknown input '%s'", inputid)
+	}
+
+	key := [2]string{id, inputid}
+	address := process.Config.Input[index].Address
+
+	h.durationsLock.Lock()
+	cached, ok := h.durations[key]
+	h.durationsLock.Unlock()
+
+	if ok && cached.address == address {
+		return cached.duration, nil
+	}
+
+	probe := h.restream.Probe(id)
+
+	duration := -1.0
+
+	for _, stream := range probe.Streams {
+		if stream.Index != uint64(index) {
+			continue
+		}
+
+		if stream.Duration > duration {
+			duration = stream.Duration
+		}
+	}
+
+	if duration < 0 {
+		return 0, fmt.Errorf("no streams found for input '%s'", inputid)
+	}
+
+	h.durationsLock.Lock()
+	h.durations[key] = cachedDuration{
+		address:  address,
+		duration: duration,
+	}
+	h.durationsLock.Unlock()
+
+	return duration, nil
+}
+
+// playoutInfo returns the connection details of the playout API of an input of a process. A
+// playout that is not ready, e.g. because its port is being reassigned, results in a 503.
+func (h *PlayoutHandler) playoutInfo(id, inputid string) (app.PlayoutInfo, error) {
+	info, err := h.restream.GetPlayoutInfo(id, inputid)
+	if err != nil {
+		if errors.Is(err, restream.ErrPlayoutNotReady) {
+			return info, api.Err(http.StatusServiceUnavailable, "Playout not ready", "%s", err)
+		}
+
+		return info, api.Err(http.StatusNotFound, "Unknown process or input", "%s", err)
+	}
+
+	return info, nil
+}
+
+// response passes a successful response of the playout API through to the client. An error
+// response is converted into an api.Error with the status code and message of the playout API.
+func (h *PlayoutHandler) response(c echo.Context, response *http.Response, data []byte) error {
+	if response.StatusCode < 400 {
+		return c.Blob(response.StatusCode, response.Header.Get("content-type"), data)
+	}
+
+	message := strings.TrimSpace(string(data))
+
+	// The playout API may respond with a JSON error
+	upstream := struct {
+		Message string `json:"message"`
+	}{}
+
+	if err := json.Unmarshal(data, &upstream); err == nil && len(upstream.Message) != 0 {
+		message = upstream.Message
+	}
+
+	if len(message) == 0 {
+		message = response.Status
+	}
+
+	return api.Err(response.StatusCode, "Playout API error", "%s", message)
+}
+
+func (h *PlayoutHandler) request(method string, info app.PlayoutInfo, path, contentType string, data []byte) (*http.Response, error) {
+	endpoint := info.Scheme + "://" + info.Address() + path
+	if len(info.Socket) != 0 {
+		// The host is irrelevant, the connection is made to the socket
+		endpoint = info.Scheme + "://playout" + path
+	}
 
 	body := bytes.NewBuffer(data)
 
@@ -323,14 +718,41 @@ func (h *PlayoutHandler) request(method, addr, path, contentType string, data []
 	request.Header.Set("Content-Type", contentType)
 
 	// Submit the request
-	client := &http.Client{
-		Timeout: time.Duration(10) * time.Second,
-	}
-
-	response, err := client.Do(request)
+	response, err := playout.Client(info.Socket).Do(request)
 	if err != nil {
 		return nil, err
 	}
 
 	return response, nil
 }
+
+// requestWithRetry does the same as request, but retries the request with an exponential backoff
+// if the playout API is not reachable or responds with a server error. Only use it for idempotent
+// requests.
+func (h *PlayoutHandler) requestWithRetry(method string, info app.PlayoutInfo, path, contentType string, data []byte) (*http.Response, error) {
+	backoff := h.backoff
+
+	var response *http.Response
+	var err error
+
+	for attempt := 1; attempt <= h.attempts; attempt++ {
+		response, err = h.request(method, info, path, contentType, data)
+		if err == nil {
+			if response.StatusCode < 500 {
+				return response, nil
+			}
+
+			err = fmt.Errorf("playout API responded with %s", response.Status)
+			response.Body.Close()
+		}
+
+		if attempt == h.attempts {
+			break
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	return nil, fmt.Errorf("playout request failed after %d attempts: %w", h.attempts, err)
+}
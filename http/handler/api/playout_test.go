@@ -0,0 +1,487 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	gonet "net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/datarhei/core/v16/http/api"
+	"github.com/datarhei/core/v16/http/mock"
+	"github.com/datarhei/core/v16/restream"
+	"github.com/datarhei/core/v16/restream/app"
+
+	"github.com/stretchr/testify/require"
+)
+
+func getPlayoutInfo(t *testing.T, server *httptest.Server) app.PlayoutInfo {
+	host, port, err := gonet.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	return app.PlayoutInfo{
+		Scheme: "http",
+		Host:   host,
+		Port:   p,
+	}
+}
+
+func TestPlayoutRequestRetry(t *testing.T) {
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	h := NewPlayoutWithConfig(PlayoutConfig{
+		Attempts: 3,
+		Backoff:  10 * time.Millisecond,
+	})
+
+	response, err := h.requestWithRetry(http.MethodGet, getPlayoutInfo(t, server), "/v1/status", "", nil)
+	require.NoError(t, err)
+
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "ok", string(data))
+	require.Equal(t, int32(3), atomic.LoadInt32(&requests))
+}
+
+func TestPlayoutRequestAddress(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:0", "[::1]:0"} {
+		listener, err := gonet.Listen("tcp", address)
+		if err != nil {
+			t.Logf("skipping %s: %s", address, err)
+			continue
+		}
+
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.URL.Path))
+		}))
+		server.Listener.Close()
+		server.Listener = listener
+		server.Start()
+
+		h := NewPlayout(nil)
+
+		response, err := h.request(http.MethodGet, getPlayoutInfo(t, server), "/v1/status", "", nil)
+		require.NoError(t, err, address)
+
+		data, err := io.ReadAll(response.Body)
+		require.NoError(t, err)
+
+		response.Body.Close()
+		server.Close()
+
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.Equal(t, "/v1/status", string(data))
+	}
+}
+
+func TestPlayoutRequestRetryFailed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	info := getPlayoutInfo(t, server)
+	server.Close()
+
+	h := NewPlayoutWithConfig(PlayoutConfig{
+		Attempts: 2,
+		Backoff:  10 * time.Millisecond,
+	})
+
+	_, err := h.requestWithRetry(http.MethodGet, info, "/v1/status", "", nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "after 2 attempts")
+}
+
+func TestPlayoutKeyframeExtension(t *testing.T) {
+	rs, err := mock.DummyRestreamer("../../mock")
+	require.NoError(t, err)
+
+	h := NewPlayout(rs)
+
+	router := mock.DummyEcho()
+	router.GET("/:id/playout/:inputid/keyframe/*", h.Keyframe)
+
+	mock.Request(t, http.StatusBadRequest, router, "GET", "/foobar/playout/in/keyframe/last.gif", nil)
+	mock.Request(t, http.StatusBadRequest, router, "GET", "/foobar/playout/in/keyframe/last", nil)
+	mock.Request(t, http.StatusNotFound, router, "GET", "/foobar/playout/in/keyframe/last.JPG", nil)
+	mock.Request(t, http.StatusNotFound, router, "GET", "/foobar/playout/in/keyframe/last.png", nil)
+}
+
+func TestPlayoutErrorframeUpload(t *testing.T) {
+	rs, err := mock.DummyRestreamer("../../mock")
+	require.NoError(t, err)
+
+	h := NewPlayoutWithConfig(PlayoutConfig{
+		Restream:          rs,
+		MaxErrorframeSize: 1024,
+	})
+
+	router := mock.DummyEcho()
+	router.POST("/:id/playout/:inputid/errorframe/*", h.SetErrorframe)
+
+	mock.Request(t, http.StatusRequestEntityTooLarge, router, "POST", "/foobar/playout/in/errorframe/error.jpg", bytes.NewReader(make([]byte, 2048)))
+	mock.Request(t, http.StatusRequestEntityTooLarge, router, "POST", "/foobar/playout/in/errorframe/error.jpg", bytes.NewReader(make([]byte, 1025)))
+
+	// An error frame of exactly the maximum size is not too large, but it's not an image
+	mock.Request(t, http.StatusBadRequest, router, "POST", "/foobar/playout/in/errorframe/error.jpg", bytes.NewReader(make([]byte, 1024)))
+	mock.Request(t, http.StatusBadRequest, router, "POST", "/foobar/playout/in/errorframe/error.jpg", strings.NewReader("garbage"))
+
+	buf := bytes.Buffer{}
+	err = png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2)))
+	require.NoError(t, err)
+
+	mock.Request(t, http.StatusNotFound, router, "POST", "/foobar/playout/in/errorframe/error.png", &buf)
+}
+
+type playoutRestreamer struct {
+	restream.Restreamer
+
+	info    app.PlayoutInfo
+	state   *app.State
+	process *app.Process
+	probe   app.Probe
+	probes  int
+	err     error
+}
+
+func (r *playoutRestreamer) GetPlayoutInfo(id, inputid string) (app.PlayoutInfo, error) {
+	if r.err != nil {
+		return app.PlayoutInfo{}, r.err
+	}
+
+	return r.info, nil
+}
+
+func (r *playoutRestreamer) GetProcess(id string) (*app.Process, error) {
+	if r.process == nil {
+		return nil, restream.ErrUnknownProcess
+	}
+
+	return r.process, nil
+}
+
+func (r *playoutRestreamer) Probe(id string) app.Probe {
+	r.probes++
+
+	return r.probe
+}
+
+func (r *playoutRestreamer) GetProcessState(id string) (*app.State, error) {
+	if r.state == nil {
+		return nil, restream.ErrUnknownProcess
+	}
+
+	return r.state, nil
+}
+
+func TestPlayoutUpload(t *testing.T) {
+	var method, path, contentType, body string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+
+		method, path, contentType, body = r.Method, r.URL.Path, r.Header.Get("Content-Type"), string(data)
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	h := NewPlayout(&playoutRestreamer{
+		info: getPlayoutInfo(t, server),
+	})
+
+	router := mock.DummyEcho()
+	router.POST("/:id/playout/:inputid/errorframe/*", h.SetErrorframe)
+	router.PUT("/:id/playout/:inputid/stream", h.SetStream)
+
+	buf := bytes.Buffer{}
+	err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2)))
+	require.NoError(t, err)
+
+	frame := buf.String()
+
+	mock.RequestWithHeaders(t, http.StatusNoContent, router, "POST", "/foobar/playout/in/errorframe/error.png", map[string]string{
+		"Content-Type": "application/octet-stream",
+	}, &buf)
+
+	require.Equal(t, http.MethodPut, method)
+	require.Equal(t, "/v1/errorframe.jpg", path)
+	require.Equal(t, "application/octet-stream", contentType)
+	require.Equal(t, frame, body)
+
+	mock.RequestWithHeaders(t, http.StatusNoContent, router, "PUT", "/foobar/playout/in/stream", map[string]string{
+		"Content-Type": "text/plain",
+	}, strings.NewReader("rtmp://example.com/live/stream"))
+
+	require.Equal(t, http.MethodPut, method)
+	require.Equal(t, "/v1/stream", path)
+	require.Equal(t, "text/plain", contentType)
+	require.Equal(t, "rtmp://example.com/live/stream", body)
+}
+
+func TestPlayoutStreamInfo(t *testing.T) {
+	var inputState atomic.Value
+	inputState.Store("running")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"in","url":"rtmp://example.com/live/stream","input":{"state":"` + inputState.Load().(string) + `"}}`))
+	}))
+	defer server.Close()
+
+	state := &app.State{}
+	state.Progress.Input = []app.ProgressIO{
+		{ID: "in", Index: 0, Stream: 0, Type: "video", Codec: "h264", FPS: 25, Bitrate: 2048 * 1024, Width: 1920, Height: 1080},
+		{ID: "in", Index: 0, Stream: 1, Type: "audio", Codec: "aac", Bitrate: 128 * 1024, Sampling: 44100, Layout: "stereo", Channels: 2},
+		{ID: "other", Index: 1, Stream: 0, Type: "video", Codec: "vp9"},
+	}
+
+	h := NewPlayout(&playoutRestreamer{
+		info:  getPlayoutInfo(t, server),
+		state: state,
+	})
+
+	router := mock.DummyEcho()
+	router.GET("/:id/playout/:inputid/streaminfo", h.StreamInfo)
+
+	response := mock.Request(t, http.StatusOK, router, "GET", "/foobar/playout/in/streaminfo", nil)
+
+	mock.Validate(t, &api.PlayoutStreamInfo{}, response.Data)
+
+	data, err := json.Marshal(response.Data)
+	require.NoError(t, err)
+
+	streaminfo := api.PlayoutStreamInfo{}
+	err = json.Unmarshal(data, &streaminfo)
+	require.NoError(t, err)
+
+	require.True(t, streaminfo.Active)
+	require.Equal(t, "rtmp://example.com/live/stream", streaminfo.Address)
+	require.Equal(t, 2, len(streaminfo.Streams))
+	require.Equal(t, "h264", streaminfo.Streams[0].Codec)
+	require.Equal(t, uint64(1920), streaminfo.Streams[0].Width)
+	require.Equal(t, uint64(1080), streaminfo.Streams[0].Height)
+	fps, _ := streaminfo.Streams[0].FPS.Float64()
+	require.Equal(t, float64(25), fps)
+	bitrate, _ := streaminfo.Streams[0].Bitrate.Float64()
+	require.Equal(t, float64(2048), bitrate)
+	require.Equal(t, "aac", streaminfo.Streams[1].Codec)
+	require.Equal(t, uint64(2), streaminfo.Streams[1].Channels)
+
+	inputState.Store("idle")
+
+	response = mock.Request(t, http.StatusOK, router, "GET", "/foobar/playout/in/streaminfo", nil)
+
+	mock.Validate(t, &api.PlayoutStreamInfo{}, response.Data)
+
+	data, err = json.Marshal(response.Data)
+	require.NoError(t, err)
+
+	streaminfo = api.PlayoutStreamInfo{}
+	err = json.Unmarshal(data, &streaminfo)
+	require.NoError(t, err)
+
+	require.False(t, streaminfo.Active)
+	require.Equal(t, 0, len(streaminfo.Streams))
+}
+
+func TestPlayoutControl(t *testing.T) {
+	var requests int32
+	var body string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	info := getPlayoutInfo(t, server)
+	info.Protocol = "file"
+
+	rs := &playoutRestreamer{
+		info: info,
+		process: &app.Process{
+			Config: &app.Config{
+				Input: []app.ConfigIO{
+					{ID: "in", Address: "playout:/media/video.mp4"},
+				},
+			},
+		},
+		probe: app.Probe{
+			Streams: []app.ProbeIO{
+				{Index: 0, Stream: 0, Type: "video", Duration: 60},
+				{Index: 0, Stream: 1, Type: "audio", Duration: 61.5},
+			},
+		},
+	}
+
+	h := NewPlayout(rs)
+
+	router := mock.DummyEcho()
+	router.PUT("/:id/playout/:inputid/control", h.Control)
+
+	mock.Request(t, http.StatusNoContent, router, "PUT", "/foobar/playout/in/control", strings.NewReader(`{"action":"pause"}`))
+	require.Equal(t, `{"action":"pause"}`, body)
+
+	mock.Request(t, http.StatusNoContent, router, "PUT", "/foobar/playout/in/control", strings.NewReader(`{"action":"seek","position":61}`))
+	require.Equal(t, `{"action":"seek","position":61}`, body)
+
+	mock.Request(t, http.StatusNoContent, router, "PUT", "/foobar/playout/in/control", strings.NewReader(`{"action":"rate","rate":1.5}`))
+	require.Equal(t, `{"action":"rate","rate":1.5}`, body)
+
+	require.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	mock.Request(t, http.StatusBadRequest, router, "PUT", "/foobar/playout/in/control", strings.NewReader(`{"action":"seek","position":62}`))
+
+	// The duration is probed only once for the same address
+	require.Equal(t, 1, rs.probes)
+
+	mock.Request(t, http.StatusBadRequest, router, "PUT", "/foobar/playout/in/control", strings.NewReader(`{"action":"seek","position":-1}`))
+	mock.Request(t, http.StatusBadRequest, router, "PUT", "/foobar/playout/in/control", strings.NewReader(`{"action":"rate","rate":0}`))
+	mock.Request(t, http.StatusBadRequest, router, "PUT", "/foobar/playout/in/control", strings.NewReader(`{"action":"rewind"}`))
+
+	rs.process.Config.Input[0].Address = "playout:/media/other.mp4"
+
+	mock.Request(t, http.StatusNoContent, router, "PUT", "/foobar/playout/in/control", strings.NewReader(`{"action":"seek","position":10}`))
+	require.Equal(t, 2, rs.probes)
+
+	rs.info.Protocol = "rtmp"
+
+	mock.Request(t, http.StatusConflict, router, "PUT", "/foobar/playout/in/control", strings.NewReader(`{"action":"seek","position":10}`))
+
+	rs.info.Protocol = "lavfi"
+
+	mock.Request(t, http.StatusConflict, router, "PUT", "/foobar/playout/in/control", strings.NewReader(`{"action":"pause"}`))
+
+	require.Equal(t, int32(4), atomic.LoadInt32(&requests))
+}
+
+func TestPlayoutUpstreamError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/keyframe/last.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write([]byte("jpeg"))
+		case "/v1/reopen":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"message":"no input"}`))
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("not found\n"))
+		}
+	}))
+	defer server.Close()
+
+	h := NewPlayout(&playoutRestreamer{
+		info: getPlayoutInfo(t, server),
+	})
+
+	router := mock.DummyEcho()
+	router.GET("/:id/playout/:inputid/status", h.Status)
+	router.GET("/:id/playout/:inputid/reopen", h.ReopenInput)
+	router.GET("/:id/playout/:inputid/keyframe/*", h.Keyframe)
+
+	response := mock.Request(t, http.StatusNotFound, router, "GET", "/foobar/playout/in/status", nil)
+	mock.Validate(t, &api.Error{}, response.Data)
+	require.Equal(t, map[string]interface{}{
+		"code":    float64(http.StatusNotFound),
+		"message": "Playout API error",
+		"details": []interface{}{"not found"},
+	}, response.Data)
+
+	response = mock.Request(t, http.StatusConflict, router, "GET", "/foobar/playout/in/reopen", nil)
+	require.Equal(t, map[string]interface{}{
+		"code":    float64(http.StatusConflict),
+		"message": "Playout API error",
+		"details": []interface{}{"no input"},
+	}, response.Data)
+
+	response = mock.Request(t, http.StatusNotFound, router, "GET", "/foobar/playout/in/keyframe/last.png", nil)
+	mock.Validate(t, &api.Error{}, response.Data)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/foobar/playout/in/keyframe/last.jpg", nil)
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+	require.Equal(t, "jpeg", w.Body.String())
+}
+
+func TestPlayoutNotReady(t *testing.T) {
+	rs := &playoutRestreamer{
+		err: fmt.Errorf("input ID 'in' of process 'foobar': %w", restream.ErrPlayoutNotReady),
+	}
+
+	h := NewPlayout(rs)
+
+	router := mock.DummyEcho()
+	router.GET("/:id/playout/:inputid/status", h.Status)
+
+	mock.Request(t, http.StatusServiceUnavailable, router, "GET", "/foobar/playout/in/status", nil)
+
+	rs.err = restream.ErrUnknownProcess
+
+	mock.Request(t, http.StatusNotFound, router, "GET", "/foobar/playout/in/status", nil)
+}
+
+func TestPlayoutRequestSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "playout.sock")
+
+	listener, err := gonet.Listen("unix", socket)
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	h := NewPlayout(nil)
+
+	response, err := h.request(http.MethodGet, app.PlayoutInfo{
+		Scheme: "http",
+		Socket: socket,
+	}, "/v1/status", "", nil)
+	require.NoError(t, err)
+
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "/v1/status", string(data))
+}
@@ -1,12 +1,19 @@
 package api
 
 import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/datarhei/core/v16/http/api"
 	"github.com/datarhei/core/v16/http/handler/util"
 	"github.com/datarhei/core/v16/restream"
+	"github.com/datarhei/core/v16/restream/app"
 
 	"github.com/labstack/echo/v4"
 	"github.com/lithammer/shortuuid/v4"
@@ -34,6 +41,7 @@ func NewRestream(restream restream.Restreamer) *RestreamHandler {
 // @Param config body api.ProcessConfig true "Process config"
 // @Success 200 {object} api.ProcessConfig
 // @Failure 400 {object} api.Error
+// @Failure 429 {object} api.Error
 // @Security ApiKeyAuth
 // @Router /api/v3/process [post]
 func (h *RestreamHandler) Add(c echo.Context) error {
@@ -58,6 +66,10 @@ func (h *RestreamHandler) Add(c echo.Context) error {
 	config := process.Marshal()
 
 	if err := h.restream.AddProcess(config); err != nil {
+		if err == restream.ErrRateLimited {
+			return api.Err(http.StatusTooManyRequests, "Too many changes", "%s", err)
+		}
+
 		return api.Err(http.StatusBadRequest, "Invalid process config", "%s", err.Error())
 	}
 
@@ -66,6 +78,48 @@ func (h *RestreamHandler) Add(c echo.Context) error {
 	return c.JSON(http.StatusOK, p.Config)
 }
 
+// Validate validates a process config
+// @Summary Validate a process config
+// @Description Validate a process config without adding the process. Returns the config with all placeholders and references resolved.
+// @Tags v16.7.2
+// @ID process-3-validate
+// @Accept json
+// @Produce json
+// @Param config body api.ProcessConfig true "Process config"
+// @Success 200 {object} api.ProcessConfig
+// @Failure 400 {object} api.Error
+// @Security ApiKeyAuth
+// @Router /api/v3/process/validate [post]
+func (h *RestreamHandler) Validate(c echo.Context) error {
+	process := api.ProcessConfig{
+		ID:        shortuuid.New(),
+		Type:      "ffmpeg",
+		Autostart: true,
+	}
+
+	if err := util.ShouldBindJSON(c, &process); err != nil {
+		return api.Err(http.StatusBadRequest, "Invalid JSON", "%s", err)
+	}
+
+	if process.Type != "ffmpeg" {
+		return api.Err(http.StatusBadRequest, "Unsupported process type", "Supported process types are: ffmpeg")
+	}
+
+	if len(process.Input) == 0 || len(process.Output) == 0 {
+		return api.Err(http.StatusBadRequest, "At least one input and one output need to be defined")
+	}
+
+	config, err := h.restream.Validate(process.Marshal())
+	if err != nil {
+		return api.Err(http.StatusBadRequest, "Invalid process config", "%s", err.Error())
+	}
+
+	p := api.ProcessConfig{}
+	p.Unmarshal(config)
+
+	return c.JSON(http.StatusOK, p)
+}
+
 // GetAll returns all known processes
 // @Summary List all known processes
 // @Description List all known processes. Use the query parameter to filter the listed processes.
@@ -77,6 +131,8 @@ func (h *RestreamHandler) Add(c echo.Context) error {
 // @Param id query string false "Comma separated list of process ids to list. Overrides the reference. If empty all IDs will be returned."
 // @Param idpattern query string false "Glob pattern for process IDs. If empty all IDs will be returned. Intersected with results from refpattern."
 // @Param refpattern query string false "Glob pattern for process references. If empty all IDs will be returned. Intersected with results from idpattern."
+// @Param order query string false "Return only the processes with this order (start, stop). Intersected with the other results."
+// @Param state query string false "Return only the processes in this state, e.g. running or queued. Intersected with the other results."
 // @Success 200 {array} api.Process
 // @Security ApiKeyAuth
 // @Router /api/v3/process [get]
@@ -89,7 +145,12 @@ func (h *RestreamHandler) GetAll(c echo.Context) error {
 	idpattern := util.DefaultQuery(c, "idpattern", "")
 	refpattern := util.DefaultQuery(c, "refpattern", "")
 
-	ids := h.restream.GetProcessIDs(idpattern, refpattern)
+	ids := h.restream.GetProcessIDsByFilter(restream.ProcessFilter{
+		ID:        idpattern,
+		Reference: refpattern,
+		Order:     util.DefaultQuery(c, "order", ""),
+		State:     util.DefaultQuery(c, "state", ""),
+	})
 
 	processes := []api.Process{}
 
@@ -148,18 +209,44 @@ func (h *RestreamHandler) Get(c echo.Context) error {
 // @ID process-3-delete
 // @Produce json
 // @Param id path string true "Process ID"
+// @Param unreferenced query bool false "Refuse to delete the process if other processes are referencing it"
 // @Success 200 {string} string
 // @Failure 404 {object} api.Error
+// @Failure 409 {object} api.Error
+// @Failure 429 {object} api.Error
 // @Security ApiKeyAuth
 // @Router /api/v3/process/{id} [delete]
 func (h *RestreamHandler) Delete(c echo.Context) error {
 	id := util.PathParam(c, "id")
+	unreferenced := util.DefaultQuery(c, "unreferenced", "false") == "true"
 
-	if err := h.restream.StopProcess(id); err != nil {
-		return api.Err(http.StatusNotFound, "Unknown process ID", "%s", err)
+	var err error
+	if unreferenced {
+		err = h.restream.DeleteUnreferencedProcess(id)
+	} else {
+		err = h.restream.DeleteProcess(id)
 	}
 
-	if err := h.restream.DeleteProcess(id); err != nil {
+	if err != nil {
+		if err == restream.ErrRateLimited {
+			return api.Err(http.StatusTooManyRequests, "Too many changes", "%s", err)
+		}
+
+		if err == restream.ErrUnknownProcess {
+			return api.Err(http.StatusNotFound, "Unknown process ID", "%s", err)
+		}
+
+		if err == restream.ErrProcessReferenced {
+			inbound, _, _ := h.restream.GetReferences(id)
+
+			ids := []string{}
+			for _, ref := range inbound {
+				ids = append(ids, ref.ProcessID)
+			}
+
+			return api.Err(http.StatusConflict, "Process is referenced by other processes", "%s", strings.Join(ids, ", "))
+		}
+
 		return api.Err(http.StatusInternalServerError, "Process can't be deleted", "%s", err)
 	}
 
@@ -175,9 +262,12 @@ func (h *RestreamHandler) Delete(c echo.Context) error {
 // @Produce json
 // @Param id path string true "Process ID"
 // @Param config body api.ProcessConfig true "Process config"
+// @Param If-Match header string false "Only update the process if it still has this version"
 // @Success 200 {object} api.ProcessConfig
 // @Failure 400 {object} api.Error
 // @Failure 404 {object} api.Error
+// @Failure 409 {object} api.Error
+// @Failure 429 {object} api.Error
 // @Security ApiKeyAuth
 // @Router /api/v3/process/{id} [put]
 func (h *RestreamHandler) Update(c echo.Context) error {
@@ -203,11 +293,31 @@ func (h *RestreamHandler) Update(c echo.Context) error {
 
 	config := process.Marshal()
 
-	if err := h.restream.UpdateProcess(id, config); err != nil {
+	// The process will only be updated if it still has the expected version
+	if version := c.Request().Header.Get("If-Match"); len(version) != 0 {
+		v, perr := strconv.ParseUint(strings.Trim(version, `"`), 10, 64)
+		if perr != nil {
+			return api.Err(http.StatusBadRequest, "Invalid version", "%s", perr)
+		}
+
+		err = h.restream.UpdateProcessIf(id, v, config)
+	} else {
+		err = h.restream.UpdateProcess(id, config)
+	}
+
+	if err != nil {
 		if err == restream.ErrUnknownProcess {
 			return api.Err(http.StatusNotFound, "Process not found", "%s", id)
 		}
 
+		if err == restream.ErrRateLimited {
+			return api.Err(http.StatusTooManyRequests, "Too many changes", "%s", err)
+		}
+
+		if err == restream.ErrVersionConflict {
+			return api.Err(http.StatusConflict, "Process has been changed in the meantime", "%s", err)
+		}
+
 		return api.Err(http.StatusBadRequest, "Process can't be updated", "%s", err)
 	}
 
@@ -218,7 +328,7 @@ func (h *RestreamHandler) Update(c echo.Context) error {
 
 // Command issues a command to a process
 // @Summary Issue a command to a process
-// @Description Issue a command to a process: start, stop, reload, restart
+// @Description Issue a command to a process: start, stop, reload, restart, rotate, pause, resume
 // @Tags v16.7.2
 // @ID process-3-command
 // @Accept json
@@ -228,6 +338,8 @@ func (h *RestreamHandler) Update(c echo.Context) error {
 // @Success 200 {string} string
 // @Failure 400 {object} api.Error
 // @Failure 404 {object} api.Error
+// @Failure 409 {object} api.Error
+// @Failure 429 {object} api.Error
 // @Security ApiKeyAuth
 // @Router /api/v3/process/{id}/command [put]
 func (h *RestreamHandler) Command(c echo.Context) error {
@@ -248,17 +360,196 @@ func (h *RestreamHandler) Command(c echo.Context) error {
 		err = h.restream.RestartProcess(id)
 	} else if command.Command == "reload" {
 		err = h.restream.ReloadProcess(id)
+	} else if command.Command == "rotate" {
+		_, err = h.restream.RotateCredentials(id)
+	} else if command.Command == "pause" {
+		err = h.restream.PauseProcess(id)
+	} else if command.Command == "resume" {
+		err = h.restream.ResumeProcess(id)
 	} else {
-		return api.Err(http.StatusBadRequest, "Unknown command provided", "Known commands are: start, stop, reload, restart")
+		return api.Err(http.StatusBadRequest, "Unknown command provided", "Known commands are: start, stop, reload, restart, rotate, pause, resume")
 	}
 
 	if err != nil {
+		if err == restream.ErrRateLimited {
+			return api.Err(http.StatusTooManyRequests, "Too many changes", "%s", err)
+		}
+
+		if errors.Is(err, restream.ErrPauseNotSupported) {
+			return api.Err(http.StatusConflict, "Command not supported by the process", "%s", err)
+		}
+
 		return api.Err(http.StatusBadRequest, "Command failed", "%s", err)
 	}
 
 	return c.JSON(http.StatusOK, "OK")
 }
 
+// ReorderOutputs rearranges the outputs of a process
+// @Summary Rearrange the outputs of a process
+// @Description Rearrange the outputs of a process in the order of the given output IDs. The list must contain each output ID exactly once. The process will be restarted if it is running and the order changed.
+// @Tags v16.7.2
+// @ID process-3-reorder-outputs
+// @Accept json
+// @Produce json
+// @Param id path string true "Process ID"
+// @Param order body api.OutputOrder true "Output IDs in the new order"
+// @Success 200 {object} api.ProcessConfig
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Failure 429 {object} api.Error
+// @Security ApiKeyAuth
+// @Router /api/v3/process/{id}/output/order [put]
+func (h *RestreamHandler) ReorderOutputs(c echo.Context) error {
+	id := util.PathParam(c, "id")
+
+	var order api.OutputOrder
+
+	if err := util.ShouldBindJSON(c, &order); err != nil {
+		return api.Err(http.StatusBadRequest, "Invalid JSON", "%s", err)
+	}
+
+	if err := h.restream.ReorderOutputs(id, order.Order); err != nil {
+		if err == restream.ErrRateLimited {
+			return api.Err(http.StatusTooManyRequests, "Too many changes", "%s", err)
+		}
+
+		if err == restream.ErrUnknownProcess {
+			return api.Err(http.StatusNotFound, "Process not found", "%s", id)
+		}
+
+		return api.Err(http.StatusBadRequest, "Process can't be updated", "%s", err)
+	}
+
+	p, _ := h.getProcess(id, "config")
+
+	return c.JSON(http.StatusOK, p.Config)
+}
+
+// SetAutostartOrder sets the order in which the processes are started
+// @Summary Set the order in which the processes are started
+// @Description Set the order in which the processes are started when the core starts. The listed processes are started first in the given order, all other processes afterwards.
+// @Tags v16.7.2
+// @ID process-3-autostart-order
+// @Accept json
+// @Produce json
+// @Param order body api.AutostartOrder true "Process IDs in the order they should be started"
+// @Success 200 {object} api.AutostartOrder
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Failure 429 {object} api.Error
+// @Security ApiKeyAuth
+// @Router /api/v3/process/autostart/order [put]
+func (h *RestreamHandler) SetAutostartOrder(c echo.Context) error {
+	var order api.AutostartOrder
+
+	if err := util.ShouldBindJSON(c, &order); err != nil {
+		return api.Err(http.StatusBadRequest, "Invalid JSON", "%s", err)
+	}
+
+	if err := h.restream.SetAutostartOrder(order.Order); err != nil {
+		if err == restream.ErrUnknownProcess {
+			return api.Err(http.StatusNotFound, "Process not found", "%s", err)
+		}
+
+		if err == restream.ErrRateLimited {
+			return api.Err(http.StatusTooManyRequests, "Too many changes", "%s", err)
+		}
+
+		return api.Err(http.StatusBadRequest, "Invalid order", "%s", err)
+	}
+
+	return c.JSON(http.StatusOK, order)
+}
+
+// Drain stops all running processes
+// @Summary Stop all running processes without changing their order
+// @Description Stop all running processes for maintenance without changing their order. The stopped processes will not be started when the core restarts, until they are started again by undraining.
+// @Tags v16.7.2
+// @ID process-3-drain
+// @Produce json
+// @Success 200 {object} api.ProcessDrain
+// @Failure 500 {object} api.Error
+// @Security ApiKeyAuth
+// @Router /api/v3/processes/drain [put]
+func (h *RestreamHandler) Drain(c echo.Context) error {
+	stopped, err := h.restream.Drain()
+	if err != nil {
+		return api.Err(http.StatusInternalServerError, "Draining failed", "%s", err)
+	}
+
+	return c.JSON(http.StatusOK, api.ProcessDrain{
+		Processes: stopped,
+	})
+}
+
+// Undrain starts the drained processes
+// @Summary Start the processes that have been stopped by draining
+// @Description Start exactly the processes that have been stopped by draining.
+// @Tags v16.7.2
+// @ID process-3-undrain
+// @Produce json
+// @Success 200 {object} api.ProcessDrain
+// @Failure 500 {object} api.Error
+// @Security ApiKeyAuth
+// @Router /api/v3/processes/undrain [put]
+func (h *RestreamHandler) Undrain(c echo.Context) error {
+	started, err := h.restream.Undrain()
+	if err != nil {
+		return api.Err(http.StatusInternalServerError, "Undraining failed", "%s", err)
+	}
+
+	return c.JSON(http.StatusOK, api.ProcessDrain{
+		Processes: started,
+	})
+}
+
+// Rename changes the ID of a process
+// @Summary Change the ID of a process
+// @Description Change the ID of a process and the references of other processes to it. The metadata of the process is kept. The process will be restarted if it is running. A referencing process will be restarted only if its command changed.
+// @Tags v16.7.2
+// @ID process-3-rename
+// @Accept json
+// @Produce json
+// @Param id path string true "Process ID"
+// @Param rename body api.ProcessRename true "New process ID"
+// @Success 200 {object} api.ProcessConfig
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Failure 409 {object} api.Error
+// @Failure 429 {object} api.Error
+// @Security ApiKeyAuth
+// @Router /api/v3/process/{id}/rename [put]
+func (h *RestreamHandler) Rename(c echo.Context) error {
+	id := util.PathParam(c, "id")
+
+	var rename api.ProcessRename
+
+	if err := util.ShouldBindJSON(c, &rename); err != nil {
+		return api.Err(http.StatusBadRequest, "Invalid JSON", "%s", err)
+	}
+
+	if err := h.restream.RenameProcess(id, rename.ID); err != nil {
+		if err == restream.ErrUnknownProcess {
+			return api.Err(http.StatusNotFound, "Process not found", "%s", id)
+		}
+
+		if err == restream.ErrProcessExists {
+			return api.Err(http.StatusConflict, "Process already exists", "%s", rename.ID)
+		}
+
+		if err == restream.ErrRateLimited {
+			return api.Err(http.StatusTooManyRequests, "Too many changes", "%s", err)
+		}
+
+		return api.Err(http.StatusBadRequest, "Process can't be renamed", "%s", err)
+	}
+
+	p, _ := h.getProcess(rename.ID, "config")
+
+	return c.JSON(http.StatusOK, p.Config)
+}
+
 // GetConfig returns the configuration of a process
 // @Summary Get the configuration of a process
 // @Description Get the configuration of a process. This is the configuration as provided by Add or Update.
@@ -311,6 +602,33 @@ func (h *RestreamHandler) GetState(c echo.Context) error {
 	return c.JSON(http.StatusOK, state)
 }
 
+// GetCommand returns the command line of a process
+// @Summary Get the command line of a process
+// @Description Get the complete command line ffmpeg is called with for a process, including the options added by the restreamer.
+// @Tags v16.7.2
+// @ID process-3-get-command
+// @Produce json
+// @Param id path string true "Process ID"
+// @Success 200 {array} string
+// @Failure 404 {object} api.Error
+// @Failure 400 {object} api.Error
+// @Security ApiKeyAuth
+// @Router /api/v3/process/{id}/command [get]
+func (h *RestreamHandler) GetCommand(c echo.Context) error {
+	id := util.PathParam(c, "id")
+
+	command, err := h.restream.GetProcessCommand(id)
+	if err != nil {
+		if err == restream.ErrUnknownProcess {
+			return api.Err(http.StatusNotFound, "Unknown process ID", "%s", err)
+		}
+
+		return api.Err(http.StatusBadRequest, "Invalid process", "%s", err)
+	}
+
+	return c.JSON(http.StatusOK, command)
+}
+
 // GetReport return the current log and the log history of a process
 // @Summary Get the logs of a process
 // @Description Get the logs and the log history of a process.
@@ -318,6 +636,7 @@ func (h *RestreamHandler) GetState(c echo.Context) error {
 // @ID process-3-get-report
 // @Produce json
 // @Param id path string true "Process ID"
+// @Param filter query string false "Comma separated list of parts (prelude, log, stdout, stderr) that will be part of the output. stdout and stderr restrict the log to the lines of that output. If empty, all parts will be part of the output."
 // @Success 200 {object} api.ProcessReport
 // @Failure 404 {object} api.Error
 // @Failure 400 {object} api.Error
@@ -325,8 +644,32 @@ func (h *RestreamHandler) GetState(c echo.Context) error {
 // @Router /api/v3/process/{id}/report [get]
 func (h *RestreamHandler) GetReport(c echo.Context) error {
 	id := util.PathParam(c, "id")
+	filter := strings.FieldsFunc(util.DefaultQuery(c, "filter", ""), func(r rune) bool {
+		return r == rune(',')
+	})
 
-	l, err := h.restream.GetProcessLog(id)
+	parts := app.LogAll
+
+	if len(filter) != 0 {
+		parts = 0
+
+		for _, f := range filter {
+			switch f {
+			case "prelude":
+				parts |= app.LogPrelude
+			case "log":
+				parts |= app.LogLines
+			case "stdout":
+				parts |= app.LogLines | app.LogStdout
+			case "stderr":
+				parts |= app.LogLines | app.LogStderr
+			default:
+				return api.Err(http.StatusBadRequest, "Invalid filter", "unknown part '%s', allowed are: prelude, log, stdout, stderr", f)
+			}
+		}
+	}
+
+	l, err := h.restream.GetProcessLogParts(id, parts)
 	if err != nil {
 		return api.Err(http.StatusNotFound, "Unknown process ID", "%s", err)
 	}
@@ -337,6 +680,72 @@ func (h *RestreamHandler) GetReport(c echo.Context) error {
 	return c.JSON(http.StatusOK, report)
 }
 
+// DownloadReport returns the logs of a process as a compressed text file
+// @Summary Download the logs of a process
+// @Description Download the prelude and the log of the current or last run of a process as a gzip compressed text file, optionally including the previous runs. Each run starts with a header and each line of the log starts with its timestamp.
+// @Tags v16.7.2
+// @ID process-3-download-report
+// @Produce application/gzip
+// @Param id path string true "Process ID"
+// @Param history query bool false "Whether to include the previous runs"
+// @Success 200 {file} file
+// @Failure 404 {object} api.Error
+// @Security ApiKeyAuth
+// @Router /api/v3/process/{id}/report/download [get]
+func (h *RestreamHandler) DownloadReport(c echo.Context) error {
+	id := util.PathParam(c, "id")
+	history := util.DefaultQuery(c, "history", "false") == "true"
+
+	l, err := h.restream.GetProcessLog(id)
+	if err != nil {
+		return api.Err(http.StatusNotFound, "Unknown process ID", "%s", err)
+	}
+
+	runs := []app.LogHistoryEntry{}
+
+	if history {
+		runs = append(runs, l.History...)
+	}
+
+	runs = append(runs, l.LogHistoryEntry)
+
+	filename := strings.NewReplacer("/", "_", "\\", "_", "\"", "_").Replace(id) + ".log.gz"
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "application/gzip")
+	res.Header().Set(echo.HeaderContentDisposition, "attachment; filename=\""+filename+"\"")
+	res.WriteHeader(http.StatusOK)
+
+	zw := gzip.NewWriter(res)
+
+	for i, run := range runs {
+		writeLogRun(zw, id, run, i == 0)
+	}
+
+	return zw.Close()
+}
+
+// writeLogRun writes the log of a run as text.
+func writeLogRun(w io.Writer, id string, run app.LogHistoryEntry, first bool) {
+	if !first {
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "=== Process %s, run started at %s ===\n", id, run.CreatedAt.UTC().Format(time.RFC3339Nano))
+
+	fmt.Fprintln(w, "--- Prelude ---")
+
+	for _, line := range run.Prelude {
+		fmt.Fprintln(w, line)
+	}
+
+	fmt.Fprintln(w, "--- Log ---")
+
+	for _, line := range run.Log {
+		fmt.Fprintf(w, "%s [%s] [%s] %s\n", line.Timestamp.UTC().Format(time.RFC3339Nano), line.Level, line.Stream, line.Data)
+	}
+}
+
 // Probe probes a process
 // @Summary Probe a process
 // @Description Probe an existing process to get a detailed stream information on the inputs.
@@ -344,13 +753,49 @@ func (h *RestreamHandler) GetReport(c echo.Context) error {
 // @ID process-3-probe
 // @Produce json
 // @Param id path string true "Process ID"
+// @Param timeout query int false "Timeout in seconds, defaults to the configured probe timeout"
+// @Param analyzeduration_ms query int false "Duration of each input to analyze in milliseconds, defaults to the default of ffmpeg"
+// @Param probesize query int false "Max. number of bytes to read from each input, defaults to the default of ffmpeg"
+// @Param program query int false "ID of the program whose streams are returned, all streams if not given"
 // @Success 200 {object} api.Probe
+// @Failure 400 {object} api.Error
 // @Security ApiKeyAuth
 // @Router /api/v3/process/{id}/probe [get]
 func (h *RestreamHandler) Probe(c echo.Context) error {
 	id := util.PathParam(c, "id")
 
-	probe := h.restream.Probe(id)
+	values := map[string]uint64{}
+
+	for _, name := range []string{"timeout", "analyzeduration_ms", "probesize", "program"} {
+		v := c.QueryParam(name)
+		if len(v) == 0 {
+			continue
+		}
+
+		x, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return api.Err(http.StatusBadRequest, "Invalid "+name, "%s", v)
+		}
+
+		values[name] = x
+	}
+
+	// Longer durations are clamped anyways, this avoids an overflow of the durations
+	limit := uint64(restream.ProbeMaxTimeout / time.Second)
+	if values["timeout"] > limit {
+		values["timeout"] = limit
+	}
+
+	if values["analyzeduration_ms"] > limit*1000 {
+		values["analyzeduration_ms"] = limit * 1000
+	}
+
+	probe := h.restream.ProbeWithOptions(id, app.ProbeOptions{
+		Timeout:         time.Duration(values["timeout"]) * time.Second,
+		AnalyzeDuration: time.Duration(values["analyzeduration_ms"]) * time.Millisecond,
+		ProbeSize:       values["probesize"],
+		Program:         values["program"],
+	})
 
 	apiprobe := api.Probe{}
 	apiprobe.Unmarshal(&probe)
@@ -358,6 +803,145 @@ func (h *RestreamHandler) Probe(c echo.Context) error {
 	return c.JSON(http.StatusOK, apiprobe)
 }
 
+// Thumbnail returns a single frame of an output or input of a process
+// @Summary Extract a single frame of an output or input of a process
+// @Description Extract a single frame as JPEG from the stream of an output or input of a running process. Outputs are preferred if an output and an input have the same ID. The stream must be accessible, i.e. tee outputs and pipes are not supported.
+// @Tags v16.7.2
+// @ID process-3-thumbnail
+// @Produce jpeg
+// @Param id path string true "Process ID"
+// @Param ioid path string true "Output or input ID"
+// @Param timeout query int false "Timeout in seconds, defaults to 10 seconds, at most 30 seconds"
+// @Success 200 {file} byte
+// @Failure 400 {object} api.Error
+// @Failure 404 {object} api.Error
+// @Failure 409 {object} api.Error
+// @Failure 429 {object} api.Error
+// @Security ApiKeyAuth
+// @Router /api/v3/process/{id}/thumbnail/{ioid} [get]
+func (h *RestreamHandler) Thumbnail(c echo.Context) error {
+	id := util.PathParam(c, "id")
+	ioid := util.PathParam(c, "ioid")
+
+	timeout := 0
+	if t := c.QueryParam("timeout"); len(t) != 0 {
+		x, err := strconv.Atoi(t)
+		if err != nil || x < 0 {
+			return api.Err(http.StatusBadRequest, "Invalid timeout", "%s", t)
+		}
+
+		timeout = x
+	}
+
+	// Longer timeouts are clamped anyways, this avoids an overflow of the duration
+	if limit := int(restream.ThumbnailMaxTimeout / time.Second); timeout > limit {
+		timeout = limit
+	}
+
+	data, err := h.restream.Thumbnail(id, ioid, time.Duration(timeout)*time.Second)
+	if err != nil {
+		if errors.Is(err, restream.ErrTooManyThumbnails) {
+			return api.Err(http.StatusTooManyRequests, "Too many thumbnails", "%s", err)
+		}
+
+		if errors.Is(err, restream.ErrUnknownProcess) {
+			return api.Err(http.StatusNotFound, "Process not found", "%s", id)
+		}
+
+		if errors.Is(err, restream.ErrUnknownIO) {
+			return api.Err(http.StatusNotFound, "Unknown input or output ID", "%s", ioid)
+		}
+
+		if errors.Is(err, restream.ErrProcessNotRunning) {
+			return api.Err(http.StatusConflict, "Process not running", "%s", id)
+		}
+
+		return api.Err(http.StatusBadRequest, "Extracting a frame failed", "%s", err)
+	}
+
+	return c.Blob(http.StatusOK, "image/jpeg", data)
+}
+
+// ProbeAddress probes an address
+// @Summary Probe an address
+// @Description Probe an address that is not an input of a process, e.g. in order to test a source before creating a process for it. The address must be an allowed input address.
+// @Tags v16.7.2
+// @ID probe-3
+// @Accept json
+// @Produce json
+// @Param probe body api.ProbeRequest true "Address to probe"
+// @Success 200 {object} api.Probe
+// @Failure 400 {object} api.Error
+// @Security ApiKeyAuth
+// @Router /api/v3/probe [post]
+func (h *RestreamHandler) ProbeAddress(c echo.Context) error {
+	var request api.ProbeRequest
+
+	if err := util.ShouldBindJSON(c, &request); err != nil {
+		return api.Err(http.StatusBadRequest, "Invalid JSON", "%s", err)
+	}
+
+	// Longer timeouts are clamped anyways, this avoids an overflow of the duration
+	if limit := uint64(restream.ProbeMaxTimeout / time.Second); request.Timeout > limit {
+		request.Timeout = limit
+	}
+
+	probe := h.restream.ProbeAddress(request.Address, request.Options, time.Duration(request.Timeout)*time.Second)
+
+	apiprobe := api.Probe{}
+	apiprobe.Unmarshal(&probe)
+
+	return c.JSON(http.StatusOK, apiprobe)
+}
+
+// Summary returns totals over all processes
+// @Summary Totals over all processes
+// @Description Get the number of processes per state, the aggregated bitrates of the inputs and outputs, the number of automatic restarts within the last hour, and the used CPU and memory of all processes.
+// @Tags v16.7.2
+// @ID summary-3
+// @Produce json
+// @Success 200 {object} api.RestreamSummary
+// @Security ApiKeyAuth
+// @Router /api/v3/summary [get]
+func (h *RestreamHandler) Summary(c echo.Context) error {
+	summary := h.restream.Summary()
+
+	apisummary := api.RestreamSummary{}
+	apisummary.Unmarshal(&summary)
+
+	return c.JSON(http.StatusOK, apisummary)
+}
+
+// ListProcesses returns a summary of each process
+// @Summary List the essential information about all processes
+// @Description List the ID, reference, order, state, and last exit code of all processes, sorted by the ID. Use the query parameters to filter the listed processes.
+// @Tags v16.7.2
+// @ID summary-3-processes
+// @Produce json
+// @Param idpattern query string false "Glob pattern for process IDs. If empty all IDs will be returned. Intersected with results from refpattern."
+// @Param refpattern query string false "Glob pattern for process references. If empty all IDs will be returned. Intersected with results from idpattern."
+// @Param order query string false "Return only the processes with this order (start, stop). Intersected with the other results."
+// @Param state query string false "Return only the processes in this state, e.g. running or queued. Intersected with the other results."
+// @Success 200 {array} api.ProcessSummary
+// @Security ApiKeyAuth
+// @Router /api/v3/summary/processes [get]
+func (h *RestreamHandler) ListProcesses(c echo.Context) error {
+	summaries := h.restream.ListProcesses(restream.ProcessFilter{
+		ID:        util.DefaultQuery(c, "idpattern", ""),
+		Reference: util.DefaultQuery(c, "refpattern", ""),
+		Order:     util.DefaultQuery(c, "order", ""),
+		State:     util.DefaultQuery(c, "state", ""),
+	})
+
+	apisummaries := make([]api.ProcessSummary, len(summaries))
+
+	for i := range summaries {
+		apisummaries[i].Unmarshal(&summaries[i])
+	}
+
+	return c.JSON(http.StatusOK, apisummaries)
+}
+
 // Skills returns the detected FFmpeg capabilities
 // @Summary FFmpeg capabilities
 // @Description List all detected FFmpeg capabilities.
@@ -432,6 +1016,7 @@ func (h *RestreamHandler) GetProcessMetadata(c echo.Context) error {
 // @Success 200 {object} api.Metadata
 // @Failure 404 {object} api.Error
 // @Failure 400 {object} api.Error
+// @Failure 429 {object} api.Error
 // @Security ApiKeyAuth
 // @Router /api/v3/process/{id}/metadata/{key} [put]
 func (h *RestreamHandler) SetProcessMetadata(c echo.Context) error {
@@ -449,12 +1034,114 @@ func (h *RestreamHandler) SetProcessMetadata(c echo.Context) error {
 	}
 
 	if err := h.restream.SetProcessMetadata(id, key, data); err != nil {
-		return api.Err(http.StatusNotFound, "Unknown process ID", "%s", err)
+		if err == restream.ErrRateLimited {
+			return api.Err(http.StatusTooManyRequests, "Too many changes", "%s", err)
+		}
+
+		if err == restream.ErrUnknownProcess {
+			return api.Err(http.StatusNotFound, "Unknown process ID", "%s", err)
+		}
+
+		return api.Err(http.StatusBadRequest, "Invalid metadata", "%s", err)
+	}
+
+	return c.JSON(http.StatusOK, data)
+}
+
+// GetProcessMetadataBulk returns the metadata stored with multiple processes
+// @Summary Retrieve JSON metadata stored under a key with multiple processes
+// @Description Retrieve the JSON metadata stored under the given key with all processes whose ID matches the pattern, by the process ID. Processes without metadata for the key are not included.
+// @Tags v16.7.2
+// @ID process-3-get-process-metadata-bulk
+// @Produce json
+// @Param key path string true "Key for data store"
+// @Param id query string false "Glob pattern for process IDs. If empty all processes will be considered."
+// @Success 200 {object} map[string]api.Metadata
+// @Failure 400 {object} api.Error
+// @Security ApiKeyAuth
+// @Router /api/v3/metadata/process/{key} [get]
+func (h *RestreamHandler) GetProcessMetadataBulk(c echo.Context) error {
+	key := util.PathParam(c, "key")
+	idpattern := util.DefaultQuery(c, "id", "")
+
+	data, err := h.restream.GetProcessMetadataBulk(idpattern, key)
+	if err != nil {
+		return api.Err(http.StatusBadRequest, "Invalid key", "%s", err)
 	}
 
 	return c.JSON(http.StatusOK, data)
 }
 
+// SetProcessMetadataBulk stores metadata with multiple processes
+// @Summary Add JSON metadata under the given key with multiple processes
+// @Description Add arbitrary JSON metadata under the given key with all processes whose ID matches the pattern. The null value will remove the key. It returns the IDs of the updated processes.
+// @Tags v16.7.2
+// @ID process-3-set-process-metadata-bulk
+// @Accept json
+// @Produce json
+// @Param key path string true "Key for data store"
+// @Param id query string true "Glob pattern for process IDs, e.g. ingest_*"
+// @Param data body api.Metadata true "Arbitrary JSON data. The null value will remove the key and its contents"
+// @Success 200 {array} string
+// @Failure 400 {object} api.Error
+// @Failure 429 {object} api.Error
+// @Security ApiKeyAuth
+// @Router /api/v3/metadata/process/{key} [put]
+func (h *RestreamHandler) SetProcessMetadataBulk(c echo.Context) error {
+	key := util.PathParam(c, "key")
+	idpattern := util.DefaultQuery(c, "id", "")
+
+	var data api.Metadata
+
+	if err := util.ShouldBindJSONValidation(c, &data, false); err != nil {
+		return api.Err(http.StatusBadRequest, "Invalid JSON", "%s", err)
+	}
+
+	ids, err := h.restream.SetProcessMetadataBulk(idpattern, key, data)
+	if err != nil {
+		if err == restream.ErrRateLimited {
+			return api.Err(http.StatusTooManyRequests, "Too many changes", "%s", err)
+		}
+
+		return api.Err(http.StatusBadRequest, "Invalid metadata", "%s", err)
+	}
+
+	return c.JSON(http.StatusOK, ids)
+}
+
+// DeleteProcessMetadata removes metadata from a process
+// @Summary Remove JSON metadata from a process
+// @Description Remove the JSON metadata stored with a process under the given key. Removing a non-existing key is not an error.
+// @Tags v16.7.2
+// @ID process-3-delete-process-metadata
+// @Produce json
+// @Param id path string true "Process ID"
+// @Param key path string true "Key for data store"
+// @Success 200 {string} string
+// @Failure 404 {object} api.Error
+// @Failure 400 {object} api.Error
+// @Failure 429 {object} api.Error
+// @Security ApiKeyAuth
+// @Router /api/v3/process/{id}/metadata/{key} [delete]
+func (h *RestreamHandler) DeleteProcessMetadata(c echo.Context) error {
+	id := util.PathParam(c, "id")
+	key := util.PathParam(c, "key")
+
+	if len(key) == 0 {
+		return api.Err(http.StatusBadRequest, "Invalid key", "The key must not be of length 0")
+	}
+
+	if err := h.restream.DeleteProcessMetadata(id, key); err != nil {
+		if err == restream.ErrRateLimited {
+			return api.Err(http.StatusTooManyRequests, "Too many changes", "%s", err)
+		}
+
+		return api.Err(http.StatusNotFound, "Unknown process ID", "%s", err)
+	}
+
+	return c.JSON(http.StatusOK, "OK")
+}
+
 // GetMetadata returns the metadata stored with the Restreamer
 // @Summary Retrieve JSON metadata from a key
 // @Description Retrieve the previously stored JSON metadata under the given key. If the key is empty, all metadata will be returned.
@@ -488,6 +1175,7 @@ func (h *RestreamHandler) GetMetadata(c echo.Context) error {
 // @Param data body api.Metadata true "Arbitrary JSON data"
 // @Success 200 {object} api.Metadata
 // @Failure 400 {object} api.Error
+// @Failure 429 {object} api.Error
 // @Security ApiKeyAuth
 // @Router /api/v3/metadata/{key} [put]
 func (h *RestreamHandler) SetMetadata(c echo.Context) error {
@@ -504,12 +1192,46 @@ func (h *RestreamHandler) SetMetadata(c echo.Context) error {
 	}
 
 	if err := h.restream.SetMetadata(key, data); err != nil {
+		if err == restream.ErrRateLimited {
+			return api.Err(http.StatusTooManyRequests, "Too many changes", "%s", err)
+		}
+
 		return api.Err(http.StatusBadRequest, "Invalid metadata", "%s", err)
 	}
 
 	return c.JSON(http.StatusOK, data)
 }
 
+// DeleteMetadata removes metadata from the Restreamer
+// @Summary Remove JSON metadata under the given key
+// @Description Remove the JSON metadata stored under the given key. Removing a non-existing key is not an error.
+// @Tags v16.7.2
+// @ID metadata-3-delete
+// @Produce json
+// @Param key path string true "Key for data store"
+// @Success 200 {string} string
+// @Failure 400 {object} api.Error
+// @Failure 429 {object} api.Error
+// @Security ApiKeyAuth
+// @Router /api/v3/metadata/{key} [delete]
+func (h *RestreamHandler) DeleteMetadata(c echo.Context) error {
+	key := util.PathParam(c, "key")
+
+	if len(key) == 0 {
+		return api.Err(http.StatusBadRequest, "Invalid key", "The key must not be of length 0")
+	}
+
+	if err := h.restream.DeleteMetadata(key); err != nil {
+		if err == restream.ErrRateLimited {
+			return api.Err(http.StatusTooManyRequests, "Too many changes", "%s", err)
+		}
+
+		return api.Err(http.StatusBadRequest, "Invalid metadata", "%s", err)
+	}
+
+	return c.JSON(http.StatusOK, "OK")
+}
+
 func (h *RestreamHandler) getProcess(id, filterString string) (api.Process, error) {
 	filter := strings.FieldsFunc(filterString, func(r rune) bool {
 		return r == rune(',')
@@ -2,12 +2,17 @@ package api
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/datarhei/core/v16/http/api"
 	"github.com/datarhei/core/v16/http/mock"
+	"github.com/datarhei/core/v16/restream/app"
 	"github.com/stretchr/testify/require"
 
 	"github.com/labstack/echo/v4"
@@ -40,11 +45,17 @@ func getDummyRestreamRouter() (*echo.Echo, error) {
 
 	router.GET("/", restream.GetAll)
 	router.POST("/", restream.Add)
+	router.POST("/validate", restream.Validate)
 	router.GET("/:id", restream.Get)
 	router.GET("/:id/report", restream.GetReport)
+	router.GET("/:id/report/download", restream.DownloadReport)
 	router.PUT("/:id", restream.Update)
 	router.DELETE("/:id", restream.Delete)
 	router.PUT("/:id/command", restream.Command)
+	router.PUT("/:id/output/order", restream.ReorderOutputs)
+	router.GET("/:id/metadata/:key", restream.GetProcessMetadata)
+	router.PUT("/:id/metadata/:key", restream.SetProcessMetadata)
+	router.DELETE("/:id/metadata/:key", restream.DeleteProcessMetadata)
 
 	return router, nil
 }
@@ -78,6 +89,34 @@ func TestAddProcess(t *testing.T) {
 	mock.Validate(t, &api.ProcessConfig{}, response.Data)
 }
 
+func TestAddProcessComplete(t *testing.T) {
+	router, err := getDummyRestreamRouter()
+	require.NoError(t, err)
+
+	data := mock.ValidateRequest(t, &api.ProcessConfig{}, mock.Read(t, "./fixtures/addProcessComplete.json"))
+
+	response := mock.Request(t, http.StatusOK, router, "POST", "/", data)
+
+	mock.Validate(t, &api.ProcessConfig{}, response.Data)
+}
+
+func TestValidateProcess(t *testing.T) {
+	router, err := getDummyRestreamRouter()
+	require.NoError(t, err)
+
+	data := mock.Read(t, "./fixtures/addProcess.json")
+
+	response := mock.Request(t, http.StatusOK, router, "POST", "/validate", data)
+
+	mock.Validate(t, &api.ProcessConfig{}, response.Data)
+
+	mock.Request(t, http.StatusNotFound, router, "GET", "/test", nil)
+
+	data = mock.Read(t, "./fixtures/addProcessInvalidType.json")
+
+	mock.Request(t, http.StatusBadRequest, router, "POST", "/validate", data)
+}
+
 func TestUpdateProcessInvalid(t *testing.T) {
 	router, err := getDummyRestreamRouter()
 	require.NoError(t, err)
@@ -177,6 +216,33 @@ func TestUpdateNewProcess(t *testing.T) {
 	mock.Request(t, http.StatusOK, router, "GET", "/test2", nil)
 }
 
+func TestUpdateProcessVersion(t *testing.T) {
+	router, err := getDummyRestreamRouter()
+	require.NoError(t, err)
+
+	data := mock.Read(t, "./fixtures/addProcess.json")
+
+	mock.Request(t, http.StatusOK, router, "POST", "/", data)
+
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+
+	headers["If-Match"] = "foobar"
+	mock.RequestWithHeaders(t, http.StatusBadRequest, router, "PUT", "/test", headers, mock.Read(t, "./fixtures/addProcess.json"))
+
+	headers["If-Match"] = "1"
+	mock.RequestWithHeaders(t, http.StatusConflict, router, "PUT", "/test", headers, mock.Read(t, "./fixtures/addProcess.json"))
+
+	headers["If-Match"] = "0"
+	mock.RequestWithHeaders(t, http.StatusOK, router, "PUT", "/test", headers, mock.Read(t, "./fixtures/addProcess.json"))
+
+	mock.RequestWithHeaders(t, http.StatusConflict, router, "PUT", "/test", headers, mock.Read(t, "./fixtures/addProcess.json"))
+
+	response := mock.Request(t, http.StatusOK, router, "GET", "/test", nil)
+	mock.Validate(t, &api.Process{}, response.Data)
+}
+
 func TestUpdateNonExistentProcess(t *testing.T) {
 	router, err := getDummyRestreamRouter()
 	require.NoError(t, err)
@@ -203,6 +269,30 @@ func TestRemoveProcess(t *testing.T) {
 	mock.Request(t, http.StatusOK, router, "DELETE", "/test", nil)
 }
 
+func TestRemoveReferencedProcess(t *testing.T) {
+	router, err := getDummyRestreamRouter()
+	require.NoError(t, err)
+
+	mock.Request(t, http.StatusOK, router, "POST", "/", mock.Read(t, "./fixtures/addProcess.json"))
+
+	proc := api.ProcessConfig{}
+	err = json.NewDecoder(mock.Read(t, "./fixtures/addProcess.json")).Decode(&proc)
+	require.NoError(t, err)
+
+	proc.ID = "test2"
+	proc.Input[0].Address = "#test:output=null"
+
+	encoded, err := json.Marshal(&proc)
+	require.NoError(t, err)
+
+	mock.Request(t, http.StatusOK, router, "POST", "/", bytes.NewReader(encoded))
+
+	mock.Request(t, http.StatusNotFound, router, "DELETE", "/foobar?unreferenced=true", nil)
+	mock.Request(t, http.StatusConflict, router, "DELETE", "/test?unreferenced=true", nil)
+	mock.Request(t, http.StatusOK, router, "DELETE", "/test2?unreferenced=true", nil)
+	mock.Request(t, http.StatusOK, router, "DELETE", "/test?unreferenced=true", nil)
+}
+
 func TestProcessInfo(t *testing.T) {
 	router, err := getDummyRestreamRouter()
 	require.NoError(t, err)
@@ -215,6 +305,24 @@ func TestProcessInfo(t *testing.T) {
 	mock.Validate(t, &api.Process{}, response.Data)
 }
 
+func TestProcessListFilter(t *testing.T) {
+	router, err := getDummyRestreamRouter()
+	require.NoError(t, err)
+
+	data := mock.Read(t, "./fixtures/addProcess.json")
+
+	mock.Request(t, http.StatusOK, router, "POST", "/", data)
+
+	response := mock.Request(t, http.StatusOK, router, "GET", "/?idpattern=te*&order=stop", nil)
+	require.Len(t, response.Data, 1)
+
+	response = mock.Request(t, http.StatusOK, router, "GET", "/?idpattern=te*&order=start", nil)
+	require.Len(t, response.Data, 0)
+
+	response = mock.Request(t, http.StatusOK, router, "GET", "/?state=finished", nil)
+	require.Len(t, response.Data, 1)
+}
+
 func TestProcessReportNotFound(t *testing.T) {
 	router, err := getDummyRestreamRouter()
 	require.NoError(t, err)
@@ -234,6 +342,22 @@ func TestProcessReport(t *testing.T) {
 	mock.Validate(t, &api.ProcessReport{}, response.Data)
 }
 
+func TestProcessReportFilter(t *testing.T) {
+	router, err := getDummyRestreamRouter()
+	require.NoError(t, err)
+
+	data := mock.Read(t, "./fixtures/addProcess.json")
+
+	mock.Request(t, http.StatusOK, router, "POST", "/", data)
+	response := mock.Request(t, http.StatusOK, router, "GET", "/test/report?filter=prelude", nil)
+
+	mock.Validate(t, &api.ProcessReport{}, response.Data)
+
+	mock.Request(t, http.StatusOK, router, "GET", "/test/report?filter=prelude,log", nil)
+	mock.Request(t, http.StatusOK, router, "GET", "/test/report?filter=prelude,stdout", nil)
+	mock.Request(t, http.StatusBadRequest, router, "GET", "/test/report?filter=foobar", nil)
+}
+
 func TestProcessCommandNotFound(t *testing.T) {
 	router, err := getDummyRestreamRouter()
 	require.NoError(t, err)
@@ -270,3 +394,107 @@ func TestProcessCommand(t *testing.T) {
 	mock.Request(t, http.StatusOK, router, "PUT", "/test/command", command)
 	mock.Request(t, http.StatusOK, router, "GET", "/test", data)
 }
+
+func TestProcessReorderOutputs(t *testing.T) {
+	router, err := getDummyRestreamRouter()
+	require.NoError(t, err)
+
+	data := mock.Read(t, "./fixtures/addProcess.json")
+
+	mock.Request(t, http.StatusOK, router, "POST", "/", data)
+
+	mock.Request(t, http.StatusNotFound, router, "PUT", "/foobar/output/order", strings.NewReader(`{"order":["null"]}`))
+	mock.Request(t, http.StatusBadRequest, router, "PUT", "/test/output/order", strings.NewReader(`"null"`))
+	mock.Request(t, http.StatusBadRequest, router, "PUT", "/test/output/order", strings.NewReader(`{"order":["foobar"]}`))
+
+	response := mock.Request(t, http.StatusOK, router, "PUT", "/test/output/order", strings.NewReader(`{"order":["null"]}`))
+	mock.Validate(t, &api.ProcessConfig{}, response.Data)
+}
+
+func TestGetProcessPreloaded(t *testing.T) {
+	rs, err := mock.DummyRestreamerWithProcesses("../../mock", []*app.Config{
+		{
+			ID: "stopped",
+			Input: []app.ConfigIO{
+				{ID: "in", Address: "testsrc=size=1280x720:rate=25", Options: []string{"-f", "lavfi", "-re"}},
+			},
+			Output: []app.ConfigIO{
+				{ID: "out", Address: "-", Options: []string{"-codec", "copy", "-f", "null"}},
+			},
+		},
+		{
+			ID: "started",
+			Input: []app.ConfigIO{
+				{ID: "in", Address: "testsrc=size=1280x720:rate=25", Options: []string{"-f", "lavfi", "-re"}},
+			},
+			Output: []app.ConfigIO{
+				{ID: "out", Address: "-", Options: []string{"-codec", "copy", "-f", "null"}},
+			},
+			Autostart: true,
+		},
+	})
+	require.NoError(t, err)
+
+	defer rs.StopProcess("started")
+
+	router := mock.DummyEcho()
+
+	restream := NewRestream(rs)
+	router.GET("/:id", restream.Get)
+
+	response := mock.Request(t, http.StatusOK, router, "GET", "/stopped", nil)
+	mock.Validate(t, &api.Process{}, response.Data)
+
+	response = mock.Request(t, http.StatusOK, router, "GET", "/started", nil)
+	mock.Validate(t, &api.Process{}, response.Data)
+
+	mock.Request(t, http.StatusNotFound, router, "GET", "/unknown", nil)
+
+	process, err := rs.GetProcess("started")
+	require.NoError(t, err)
+	require.Equal(t, "start", process.Order)
+}
+
+func TestDeleteProcessMetadata(t *testing.T) {
+	router, err := getDummyRestreamRouter()
+	require.NoError(t, err)
+
+	data := mock.Read(t, "./fixtures/addProcess.json")
+
+	mock.Request(t, http.StatusOK, router, "POST", "/", data)
+	mock.Request(t, http.StatusOK, router, "PUT", "/test/metadata/foo", bytes.NewReader([]byte(`{"bar":42}`)))
+	mock.Request(t, http.StatusOK, router, "GET", "/test/metadata/foo", nil)
+	mock.Request(t, http.StatusOK, router, "DELETE", "/test/metadata/foo", nil)
+	mock.Request(t, http.StatusNotFound, router, "GET", "/test/metadata/foo", nil)
+	mock.Request(t, http.StatusOK, router, "DELETE", "/test/metadata/foo", nil)
+	mock.Request(t, http.StatusNotFound, router, "DELETE", "/foobar/metadata/foo", nil)
+}
+
+func TestProcessDownloadReport(t *testing.T) {
+	router, err := getDummyRestreamRouter()
+	require.NoError(t, err)
+
+	data := mock.Read(t, "./fixtures/addProcess.json")
+
+	mock.Request(t, http.StatusOK, router, "POST", "/", data)
+
+	mock.Request(t, http.StatusNotFound, router, "GET", "/foobar/report/download", nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/test/report/download?history=true", nil)
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/gzip", w.Header().Get("Content-Type"))
+	require.Equal(t, `attachment; filename="test.log.gz"`, w.Header().Get("Content-Disposition"))
+
+	zr, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+
+	text, err := io.ReadAll(zr)
+	require.NoError(t, err)
+
+	require.True(t, strings.HasPrefix(string(text), "=== Process test, run started at "))
+	require.Contains(t, string(text), "--- Prelude ---\n")
+	require.Contains(t, string(text), "--- Log ---\n")
+}
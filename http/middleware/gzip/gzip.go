@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -26,6 +27,43 @@ type Config struct {
 	// Length threshold before gzip compression
 	// is used. Optional. Default value 0
 	MinLength int
+
+	// Content types that are already compressed and will be written
+	// uncompressed. A type ending with "/*" matches all its subtypes.
+	// Optional. Default value DefaultSkipContentTypes. Set to an empty
+	// list in order to compress all content types.
+	SkipContentTypes []string
+
+	// Capacity in bytes above which a buffer will not be reused
+	// for other responses. Optional. Default value 65536.
+	MaxBufferSize int
+
+	// Interval for flushing streaming responses, such that small
+	// writes are not buffered indefinitely. Optional. Default value 0,
+	// i.e. only the handler flushes.
+	FlushInterval time.Duration
+
+	// Content types of streaming responses that will be flushed with
+	// FlushInterval. Optional. Default value ["text/event-stream"].
+	StreamContentTypes []string
+
+	// Max. number of responses that may be compressed at the same time.
+	// Further responses will be written uncompressed instead of waiting.
+	// Optional. Default value 0, i.e. unlimited.
+	MaxConcurrent int
+}
+
+// DefaultSkipContentTypes are content types with compressed payloads.
+var DefaultSkipContentTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+	"video/*",
+	"audio/*",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
 }
 
 type gzipResponseWriter struct {
@@ -37,10 +75,50 @@ type gzipResponseWriter struct {
 	minLengthExceeded bool
 	buffer            *bytes.Buffer
 	code              int
+	skipContentTypes  []string
+	passthrough       bool // Whether the response is written uncompressed because of its content type
+	slots             chan struct{}
+	compressing       bool // Whether a slot has been taken for compressing the response
+
+	flushInterval      time.Duration
+	streamContentTypes []string
+	flushStop          chan struct{} // Stops the automatic flushing, nil if not started
+	flushDone          chan struct{}
+	dirty              bool // Whether there have been writes since the last flush
+	lock               sync.Mutex
+
+	size *countingWriter // Counts the bytes written to the client
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.Writer.Write(b)
+	w.n += int64(n)
+
+	return n, err
 }
 
 const gzipScheme = "gzip"
 
+// Keys of the values the middleware stores in the echo context after the
+// response has been written. They are not set if the middleware is skipped.
+const (
+	// ContextKeyEncoding holds the content encoding of the response body
+	// as string, either "gzip" or "identity", or the encoding the handler
+	// already applied, e.g. for a pre-compressed file.
+	ContextKeyEncoding = "gzip.encoding"
+
+	// ContextKeySize holds the number of bytes of the response body
+	// that have been written to the client as int64, i.e. after the
+	// compression.
+	ContextKeySize = "gzip.size"
+)
+
 const (
 	BestCompression    = gzip.BestCompression
 	BestSpeed          = gzip.BestSpeed
@@ -50,9 +128,16 @@ const (
 
 // DefaultConfig is the default Gzip middleware config.
 var DefaultConfig = Config{
-	Skipper:   middleware.DefaultSkipper,
-	Level:     DefaultCompression,
-	MinLength: 0,
+	Skipper:          middleware.DefaultSkipper,
+	Level:            DefaultCompression,
+	MinLength:        0,
+	SkipContentTypes: DefaultSkipContentTypes,
+	MaxBufferSize:    64 * 1024,
+	FlushInterval:    0,
+	StreamContentTypes: []string{
+		"text/event-stream",
+	},
+	MaxConcurrent: 0,
 }
 
 // ContentTypesSkipper returns a Skipper based on the list of content types
@@ -100,9 +185,31 @@ func NewWithConfig(config Config) echo.MiddlewareFunc {
 		config.MinLength = DefaultConfig.MinLength
 	}
 
+	if config.SkipContentTypes == nil {
+		config.SkipContentTypes = DefaultConfig.SkipContentTypes
+	}
+
+	if config.MaxBufferSize <= 0 {
+		config.MaxBufferSize = DefaultConfig.MaxBufferSize
+	}
+
+	if config.FlushInterval < 0 {
+		config.FlushInterval = DefaultConfig.FlushInterval
+	}
+
+	if config.StreamContentTypes == nil {
+		config.StreamContentTypes = DefaultConfig.StreamContentTypes
+	}
+
 	pool := gzipPool(config)
 	bpool := bufferPool()
 
+	// Slots for the responses that are compressed at the same time, unlimited if nil
+	var slots chan struct{}
+	if config.MaxConcurrent > 0 {
+		slots = make(chan struct{}, config.MaxConcurrent)
+	}
+
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			if config.Skipper(c) {
@@ -112,6 +219,14 @@ func NewWithConfig(config Config) echo.MiddlewareFunc {
 			res := c.Response()
 			res.Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
 
+			// Compressing the response to a range request would break the byte ranges
+			if len(c.Request().Header.Get("Range")) != 0 {
+				err := next(c)
+				setContext(c, "identity", res.Size)
+
+				return err
+			}
+
 			if strings.Contains(c.Request().Header.Get(echo.HeaderAcceptEncoding), gzipScheme) {
 				i := pool.Get()
 				w, ok := i.(*gzip.Writer)
@@ -119,14 +234,29 @@ func NewWithConfig(config Config) echo.MiddlewareFunc {
 					return echo.NewHTTPError(http.StatusInternalServerError, i.(error).Error())
 				}
 				rw := res.Writer
-				w.Reset(rw)
+				cw := &countingWriter{Writer: rw}
+				w.Reset(cw)
 
 				buf := bpool.Get().(*bytes.Buffer)
 				buf.Reset()
 
-				grw := &gzipResponseWriter{Writer: w, ResponseWriter: rw, minLength: config.MinLength, buffer: buf}
+				grw := &gzipResponseWriter{
+					Writer:             w,
+					ResponseWriter:     rw,
+					minLength:          config.MinLength,
+					buffer:             buf,
+					skipContentTypes:   config.SkipContentTypes,
+					flushInterval:      config.FlushInterval,
+					streamContentTypes: config.StreamContentTypes,
+					size:               cw,
+					slots:              slots,
+				}
 
 				defer func() {
+					grw.stopFlusher()
+
+					encoding := "identity"
+
 					if !grw.wroteBody {
 						if res.Header().Get(echo.HeaderContentEncoding) == gzipScheme {
 							res.Header().Del(echo.HeaderContentEncoding)
@@ -136,24 +266,41 @@ func NewWithConfig(config Config) echo.MiddlewareFunc {
 						// See issue #424, #407.
 						res.Writer = rw
 						w.Reset(io.Discard)
+					} else if grw.passthrough {
+						// The response has already been written as it is
+						res.Writer = rw
+						w.Reset(io.Discard)
+
+						if isEncoded(res.Header()) {
+							encoding = res.Header().Get(echo.HeaderContentEncoding)
+						}
 					} else if !grw.minLengthExceeded {
 						// If the minimum content length hasn't exceeded, write the uncompressed response
 						res.Writer = rw
 						if grw.wroteHeader {
 							grw.ResponseWriter.WriteHeader(grw.code)
 						}
-						grw.buffer.WriteTo(rw)
+						grw.buffer.WriteTo(cw)
 						w.Reset(io.Discard)
+					} else {
+						encoding = gzipScheme
 					}
-					w.Close()
-					bpool.Put(buf)
+					grw.Close()
+					putBuffer(&bpool, buf, config.MaxBufferSize)
 					pool.Put(w)
+
+					setContext(c, encoding, cw.n)
 				}()
 
 				res.Writer = grw
+
+				return next(c)
 			}
 
-			return next(c)
+			err := next(c)
+			setContext(c, "identity", res.Size)
+
+			return err
 		}
 	}
 }
@@ -162,6 +309,28 @@ func (w *gzipResponseWriter) WriteHeader(code int) {
 	if code == http.StatusNoContent { // Issue #489
 		w.ResponseWriter.Header().Del(echo.HeaderContentEncoding)
 	}
+
+	if !w.wroteBody && !w.minLengthExceeded && isEncoded(w.Header()) {
+		// The handler already encoded the response, e.g. a pre-compressed file
+		w.passthrough = true
+		w.wroteHeader = true
+		w.code = code
+		w.ResponseWriter.WriteHeader(code)
+
+		return
+	}
+
+	if !w.wroteBody && isRangeResponse(code, w.Header()) {
+		// Compression would break the byte ranges, write the response uncompressed
+		w.passthrough = true
+		w.wroteHeader = true
+		w.code = code
+		w.Header().Del(echo.HeaderContentEncoding)
+		w.ResponseWriter.WriteHeader(code)
+
+		return
+	}
+
 	w.Header().Del(echo.HeaderContentLength) // Issue #444
 
 	w.wroteHeader = true
@@ -171,22 +340,51 @@ func (w *gzipResponseWriter) WriteHeader(code int) {
 }
 
 func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
 	if w.Header().Get(echo.HeaderContentType) == "" {
 		w.Header().Set(echo.HeaderContentType, http.DetectContentType(b))
 	}
 
+	if !w.wroteBody && !w.passthrough {
+		contentType := w.Header().Get(echo.HeaderContentType)
+
+		if !w.minLengthExceeded && isEncoded(w.Header()) {
+			// The handler already encoded the response, e.g. a pre-compressed file
+			w.passthrough = true
+			if w.wroteHeader {
+				w.ResponseWriter.WriteHeader(w.code)
+			}
+		} else if isContentType(contentType, w.skipContentTypes) || (!w.wroteHeader && isRangeResponse(http.StatusOK, w.Header())) {
+			// Compressing an already compressed payload is a waste of CPU
+			w.passthrough = true
+			if w.wroteHeader {
+				w.ResponseWriter.WriteHeader(w.code)
+			}
+		} else if w.flushInterval > 0 && isContentType(contentType, w.streamContentTypes) {
+			w.startFlusher()
+		}
+	}
+
 	w.wroteBody = true
+	w.dirty = true
+
+	if w.passthrough {
+		return w.size.Write(b)
+	}
 
 	if !w.minLengthExceeded {
 		n, err := w.buffer.Write(b)
 
 		if w.buffer.Len() >= w.minLength {
-			w.minLengthExceeded = true
+			// The minimum length is exceeded
+			if !w.compress() {
+				if _, err := w.buffer.WriteTo(w.size); err != nil {
+					return 0, err
+				}
 
-			// The minimum length is exceeded, add Content-Encoding header and write the header
-			w.Header().Set(echo.HeaderContentEncoding, gzipScheme) // Issue #806
-			if w.wroteHeader {
-				w.ResponseWriter.WriteHeader(w.code)
+				return n, err
 			}
 
 			return w.Writer.Write(w.buffer.Bytes())
@@ -199,12 +397,36 @@ func (w *gzipResponseWriter) Write(b []byte) (int, error) {
 }
 
 func (w *gzipResponseWriter) Flush() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.flush()
+}
+
+// flush writes the buffered data, if any, and flushes the compressed data. The first
+// flush enables the compression regardless of the minimum length, if a slot is available.
+// The caller must hold the lock.
+func (w *gzipResponseWriter) flush() {
+	w.dirty = false
+
+	if w.passthrough {
+		if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+			flusher.Flush()
+		}
+
+		return
+	}
+
 	if !w.minLengthExceeded {
 		// Enforce compression
-		w.minLengthExceeded = true
-		w.Header().Set(echo.HeaderContentEncoding, gzipScheme) // Issue #806
-		if w.wroteHeader {
-			w.ResponseWriter.WriteHeader(w.code)
+		if !w.compress() {
+			w.buffer.WriteTo(w.size)
+
+			if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+				flusher.Flush()
+			}
+
+			return
 		}
 
 		w.Writer.Write(w.buffer.Bytes())
@@ -216,6 +438,91 @@ func (w *gzipResponseWriter) Flush() {
 	}
 }
 
+// compress starts the compression of the response, which takes a slot. If no slot
+// is available, the response is written uncompressed instead. In both cases the header
+// is written, but not the buffered data. Returns whether the response is compressed.
+// The caller must hold the lock.
+func (w *gzipResponseWriter) compress() bool {
+	w.minLengthExceeded = true
+
+	if !acquire(w.slots) {
+		w.passthrough = true
+		if w.wroteHeader {
+			w.ResponseWriter.WriteHeader(w.code)
+		}
+
+		return false
+	}
+
+	w.compressing = true
+
+	// Add Content-Encoding header and write the header
+	w.Header().Set(echo.HeaderContentEncoding, gzipScheme) // Issue #806
+	weakenETag(w.Header())
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeader(w.code)
+	}
+
+	return true
+}
+
+// Close closes the gzip writer and frees the slot, if the response has been compressed.
+func (w *gzipResponseWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	err := w.Writer.(*gzip.Writer).Close()
+
+	if w.compressing {
+		w.compressing = false
+		release(w.slots)
+	}
+
+	return err
+}
+
+// startFlusher flushes the response periodically as long as there have been writes
+// since the last flush. The caller must hold the lock.
+func (w *gzipResponseWriter) startFlusher() {
+	w.flushStop = make(chan struct{})
+	w.flushDone = make(chan struct{})
+
+	go func(stop, done chan struct{}) {
+		defer close(done)
+
+		ticker := time.NewTicker(w.flushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				w.lock.Lock()
+				if w.dirty {
+					w.flush()
+				}
+				w.lock.Unlock()
+			}
+		}
+	}(w.flushStop, w.flushDone)
+}
+
+// stopFlusher stops the periodic flushing and waits until it stopped.
+func (w *gzipResponseWriter) stopFlusher() {
+	w.lock.Lock()
+	stop, done := w.flushStop, w.flushDone
+	w.flushStop = nil
+	w.lock.Unlock()
+
+	if stop == nil {
+		return
+	}
+
+	close(stop)
+	<-done
+}
+
 func (w *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return w.ResponseWriter.(http.Hijacker).Hijack()
 }
@@ -227,6 +534,89 @@ func (w *gzipResponseWriter) Push(target string, opts *http.PushOptions) error {
 	return http.ErrNotSupported
 }
 
+// acquire takes a slot for compressing a response without waiting. Returns
+// whether a slot is available. A nil slots is unlimited.
+func acquire(slots chan struct{}) bool {
+	if slots == nil {
+		return true
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release frees a slot that has been taken with acquire.
+func release(slots chan struct{}) {
+	if slots == nil {
+		return
+	}
+
+	<-slots
+}
+
+// setContext stores the encoding and the size of the response body in the context.
+func setContext(c echo.Context, encoding string, size int64) {
+	c.Set(ContextKeyEncoding, encoding)
+	c.Set(ContextKeySize, size)
+}
+
+// isRangeResponse returns whether the response is a partial response. The byte
+// ranges of such a response refer to the uncompressed content.
+func isRangeResponse(code int, header http.Header) bool {
+	if code == http.StatusPartialContent {
+		return true
+	}
+
+	if len(header.Get("Content-Range")) != 0 {
+		return true
+	}
+
+	return false
+}
+
+// weakenETag turns a strong ETag into a weak one. A strong ETag identifies the exact
+// bytes of the body, which doesn't hold anymore for the compressed body.
+func weakenETag(header http.Header) {
+	etag := header.Get("ETag")
+	if len(etag) == 0 || strings.HasPrefix(etag, "W/") {
+		return
+	}
+
+	header.Set("ETag", "W/"+etag)
+}
+
+// isEncoded returns whether the response has a content encoding other than identity.
+func isEncoded(header http.Header) bool {
+	encoding := strings.TrimSpace(header.Get(echo.HeaderContentEncoding))
+
+	return len(encoding) != 0 && !strings.EqualFold(encoding, "identity")
+}
+
+// isContentType returns whether the media type of the content type is in the list of types.
+func isContentType(contentType string, types []string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+
+	for _, t := range types {
+		if strings.HasSuffix(t, "/*") {
+			if strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*")) {
+				return true
+			}
+
+			continue
+		}
+
+		if mediaType == t {
+			return true
+		}
+	}
+
+	return false
+}
+
 func gzipPool(config Config) sync.Pool {
 	return sync.Pool{
 		New: func() interface{} {
@@ -239,6 +629,19 @@ func gzipPool(config Config) sync.Pool {
 	}
 }
 
+// putBuffer returns the buffer to the pool, unless its capacity is above the maximum
+// size. A buffer never shrinks, so a spike of large responses would otherwise keep
+// all these large buffers in the pool. Returns whether the buffer has been pooled.
+func putBuffer(pool *sync.Pool, buf *bytes.Buffer, maxSize int) bool {
+	if buf.Cap() > maxSize {
+		return false
+	}
+
+	pool.Put(buf)
+
+	return true
+}
+
 func bufferPool() sync.Pool {
 	return sync.Pool{
 		New: func() interface{} {
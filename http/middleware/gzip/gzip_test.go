@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
@@ -238,3 +239,407 @@ func BenchmarkGzip(b *testing.B) {
 		h(c)
 	}
 }
+
+func TestGzipSkipContentTypes(t *testing.T) {
+	// The magic bytes of a JPEG for content type sniffing
+	jpeg := append([]byte{0xFF, 0xD8, 0xFF, 0xE0}, []byte("jpegdata")...)
+
+	e := echo.New()
+	e.Use(New())
+	e.GET("/image", func(c echo.Context) error {
+		c.Response().WriteHeader(http.StatusOK)
+		c.Response().Write(jpeg)
+		return nil
+	})
+	e.GET("/video", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, "video/MP2T; charset=binary")
+		c.Response().Write([]byte("segment"))
+		return nil
+	})
+	e.GET("/text", func(c echo.Context) error {
+		c.Response().Write([]byte("text"))
+		return nil
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/image", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "", rec.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, "image/jpeg", rec.Header().Get(echo.HeaderContentType))
+	assert.Equal(t, jpeg, rec.Body.Bytes())
+
+	req = httptest.NewRequest(http.MethodGet, "/video", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, "", rec.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, "segment", rec.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, "/text", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, gzipScheme, rec.Header().Get(echo.HeaderContentEncoding))
+
+	// An empty list compresses all content types
+	e = echo.New()
+	e.Use(NewWithConfig(Config{SkipContentTypes: []string{}}))
+	e.GET("/image", func(c echo.Context) error {
+		c.Response().Write(jpeg)
+		return nil
+	})
+
+	req = httptest.NewRequest(http.MethodGet, "/image", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, gzipScheme, rec.Header().Get(echo.HeaderContentEncoding))
+	r, err := gzip.NewReader(rec.Body)
+	if assert.NoError(t, err) {
+		buf := new(bytes.Buffer)
+		defer r.Close()
+		buf.ReadFrom(r)
+		assert.Equal(t, jpeg, buf.Bytes())
+	}
+}
+
+func TestGzipBufferPool(t *testing.T) {
+	pool := bufferPool()
+
+	small := &bytes.Buffer{}
+	small.Write(make([]byte, 1024))
+
+	assert.True(t, putBuffer(&pool, small, 4096))
+
+	large := &bytes.Buffer{}
+	large.Write(make([]byte, 8192))
+
+	assert.False(t, putBuffer(&pool, large, 4096))
+
+	for i := 0; i < 10; i++ {
+		buf := pool.Get().(*bytes.Buffer)
+		assert.LessOrEqual(t, buf.Cap(), 4096)
+	}
+}
+
+func BenchmarkGzipLargeResponses(b *testing.B) {
+	data := bytes.Repeat([]byte("a"), 1024*1024)
+
+	e := echo.New()
+	e.Use(NewWithConfig(Config{MinLength: len(data)}))
+	e.GET("/", func(c echo.Context) error {
+		c.Response().Write(data)
+		return nil
+	})
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+	}
+}
+
+func TestGzipFlushInterval(t *testing.T) {
+	handler := func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+		c.Response().Write([]byte("data: foobar\n\n"))
+
+		time.Sleep(300 * time.Millisecond)
+
+		return nil
+	}
+
+	// Without a flush interval, the response is too small to be compressed
+	e := echo.New()
+	e.Use(NewWithConfig(Config{MinLength: 1024}))
+	e.GET("/", handler)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, "", rec.Header().Get(echo.HeaderContentEncoding))
+	assert.False(t, rec.Flushed)
+	assert.Equal(t, "data: foobar\n\n", rec.Body.String())
+
+	// The periodic flush enables the compression
+	e = echo.New()
+	e.Use(NewWithConfig(Config{MinLength: 1024, FlushInterval: 50 * time.Millisecond}))
+	e.GET("/", handler)
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, gzipScheme, rec.Header().Get(echo.HeaderContentEncoding))
+	assert.True(t, rec.Flushed)
+	r, err := gzip.NewReader(rec.Body)
+	if assert.NoError(t, err) {
+		buf := new(bytes.Buffer)
+		defer r.Close()
+		buf.ReadFrom(r)
+		assert.Equal(t, "data: foobar\n\n", buf.String())
+	}
+}
+
+func TestGzipRangeRequest(t *testing.T) {
+	e := echo.New()
+	e.Use(New())
+	e.GET("/", func(c echo.Context) error {
+		c.Response().Header().Set("Content-Range", "bytes 0-3/10")
+		c.Response().WriteHeader(http.StatusPartialContent)
+		c.Response().Write([]byte("test"))
+		return nil
+	})
+	e.Static("/test", "./")
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "", rec.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, "bytes 0-3/10", rec.Header().Get("Content-Range"))
+	assert.Equal(t, "test", rec.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, "/test/gzip.go", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+	req.Header.Set("Range", "bytes=0-7")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "", rec.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, "8", rec.Header().Get(echo.HeaderContentLength))
+	assert.Contains(t, rec.Header().Get("Content-Range"), "bytes 0-7/")
+	assert.Equal(t, "package ", rec.Body.String())
+}
+
+func TestGzipContext(t *testing.T) {
+	var encoding interface{}
+	var size interface{}
+
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+			encoding = c.Get(ContextKeyEncoding)
+			size = c.Get(ContextKeySize)
+			return err
+		}
+	})
+	e.Use(NewWithConfig(Config{MinLength: 10}))
+	e.GET("/", func(c echo.Context) error {
+		return c.String(http.StatusOK, c.QueryParam("body"))
+	})
+	e.GET("/image", func(c echo.Context) error {
+		return c.Blob(http.StatusOK, "image/png", []byte("foobarfoobar"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/?body=foobarfoobar", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, gzipScheme, rec.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, gzipScheme, encoding)
+	assert.Equal(t, int64(rec.Body.Len()), size)
+
+	req = httptest.NewRequest(http.MethodGet, "/?body=foo", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, "", rec.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, "identity", encoding)
+	assert.Equal(t, int64(3), size)
+
+	req = httptest.NewRequest(http.MethodGet, "/image", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, "", rec.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, "identity", encoding)
+	assert.Equal(t, int64(12), size)
+
+	req = httptest.NewRequest(http.MethodGet, "/?body=foobarfoobar", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, "", rec.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, "identity", encoding)
+	assert.Equal(t, int64(12), size)
+}
+
+func TestGzipAlreadyEncoded(t *testing.T) {
+	var encoding interface{}
+
+	compressed := &bytes.Buffer{}
+	gw := gzip.NewWriter(compressed)
+	gw.Write([]byte("foobarfoobarfoobar"))
+	gw.Close()
+
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+			encoding = c.Get(ContextKeyEncoding)
+			return err
+		}
+	})
+	e.Use(New())
+	e.GET("/", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentEncoding, gzipScheme)
+		c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextPlain)
+		return c.Blob(http.StatusOK, echo.MIMETextPlain, compressed.Bytes())
+	})
+	e.GET("/write", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentEncoding, "br")
+		c.Response().Write([]byte("brotli"))
+		return nil
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, gzipScheme, rec.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, compressed.Bytes(), rec.Body.Bytes(), "the body should not be compressed twice")
+	assert.Equal(t, gzipScheme, encoding)
+
+	r, err := gzip.NewReader(rec.Body)
+	assert.NoError(t, err)
+	body, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "foobarfoobarfoobar", string(body))
+
+	req = httptest.NewRequest(http.MethodGet, "/write", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, "br", rec.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, "brotli", rec.Body.String())
+	assert.Equal(t, "br", encoding)
+}
+
+func TestGzipMaxConcurrent(t *testing.T) {
+	started := make(chan struct{})
+	blocked := make(chan struct{})
+
+	e := echo.New()
+	e.Use(NewWithConfig(Config{MaxConcurrent: 1}))
+	e.GET("/idle", func(c echo.Context) error {
+		close(started)
+		<-blocked
+		return c.String(http.StatusOK, "test")
+	})
+	e.GET("/block", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextPlain)
+		c.Response().WriteHeader(http.StatusOK)
+		c.Response().Write([]byte("test"))
+		close(started)
+		<-blocked
+		return nil
+	})
+	e.GET("/", func(c echo.Context) error {
+		return c.String(http.StatusOK, "test")
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+
+	serve := func(path string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		done <- rec
+	}
+
+	go serve("/idle")
+
+	<-started
+
+	// A handler that didn't write yet doesn't take the slot
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, gzipScheme, rec.Header().Get(echo.HeaderContentEncoding))
+
+	close(blocked)
+	<-done
+
+	started = make(chan struct{})
+	blocked = make(chan struct{})
+
+	go serve("/block")
+
+	<-started
+
+	// The only slot is taken, the response is written uncompressed
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, "", rec.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, "test", rec.Body.String())
+
+	close(blocked)
+
+	rec = <-done
+	assert.Equal(t, gzipScheme, rec.Header().Get(echo.HeaderContentEncoding))
+
+	// The slot is free again
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, gzipScheme, rec.Header().Get(echo.HeaderContentEncoding))
+}
+
+func TestGzipETag(t *testing.T) {
+	e := echo.New()
+	e.Use(NewWithConfig(Config{MinLength: 10}))
+	e.GET("/", func(c echo.Context) error {
+		c.Response().Header().Set("ETag", c.QueryParam("etag"))
+		return c.String(http.StatusOK, c.QueryParam("body"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, `/?body=foobarfoobar&etag="abc"`, nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, gzipScheme, rec.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, `W/"abc"`, rec.Header().Get("ETag"))
+
+	req = httptest.NewRequest(http.MethodGet, `/?body=foobarfoobar&etag=W/"abc"`, nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, gzipScheme, rec.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, `W/"abc"`, rec.Header().Get("ETag"))
+
+	// Below the min. length the response is not compressed
+	req = httptest.NewRequest(http.MethodGet, `/?body=foo&etag="abc"`, nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, gzipScheme)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, "", rec.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, `"abc"`, rec.Header().Get("ETag"))
+}
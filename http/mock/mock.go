@@ -19,6 +19,7 @@ import (
 	"github.com/datarhei/core/v16/internal/testhelper"
 	"github.com/datarhei/core/v16/io/fs"
 	"github.com/datarhei/core/v16/restream"
+	"github.com/datarhei/core/v16/restream/app"
 	"github.com/datarhei/core/v16/restream/store"
 
 	"github.com/invopop/jsonschema"
@@ -63,6 +64,23 @@ func DummyRestreamer(pathPrefix string) (restream.Restreamer, error) {
 	return rs, nil
 }
 
+// DummyRestreamerWithProcesses returns a restreamer that already contains the processes
+// with the given configs. The processes that have autostart enabled are started.
+func DummyRestreamerWithProcesses(pathPrefix string, configs []*app.Config) (restream.Restreamer, error) {
+	rs, err := DummyRestreamer(pathPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, config := range configs {
+		if err := rs.AddProcess(config); err != nil {
+			return nil, fmt.Errorf("failed to add process '%s': %w", config.ID, err)
+		}
+	}
+
+	return rs, nil
+}
+
 func DummyEcho() *echo.Echo {
 	router := echo.New()
 	router.HideBanner = true
@@ -81,10 +99,20 @@ type Response struct {
 }
 
 func Request(t *testing.T, httpstatus int, router *echo.Echo, method, path string, data io.Reader) *Response {
+	headers := map[string]string{}
+	if data != nil {
+		headers["Content-Type"] = "application/json"
+	}
+
+	return RequestWithHeaders(t, httpstatus, router, method, path, headers, data)
+}
+
+// RequestWithHeaders is like Request, but the headers of the request, e.g. the content type, are set as provided.
+func RequestWithHeaders(t *testing.T, httpstatus int, router *echo.Echo, method, path string, headers map[string]string, data io.Reader) *Response {
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest(method, path, data)
-	if data != nil {
-		req.Header.Add("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
 	}
 	router.ServeHTTP(w, req)
 
@@ -132,6 +160,24 @@ func Validate(t *testing.T, datatype, data interface{}) bool {
 	return true
 }
 
+// ValidateRequest validates the JSON payload against the schema of the request datatype. It returns
+// a reader with the same payload such that it can be sent afterwards.
+func ValidateRequest(t *testing.T, datatype interface{}, data io.Reader) io.Reader {
+	payload, err := io.ReadAll(data)
+	require.Equal(t, nil, err)
+
+	schema, _ := jsonschema.Reflect(datatype).MarshalJSON()
+
+	schemaLoader := gojsonschema.NewStringLoader(string(schema))
+	documentLoader := gojsonschema.NewBytesLoader(payload)
+
+	result, err := gojsonschema.Validate(schemaLoader, documentLoader)
+	require.Equal(t, nil, err)
+	require.Equal(t, true, result.Valid(), result.Errors())
+
+	return bytes.NewReader(payload)
+}
+
 func Read(t *testing.T, path string) io.Reader {
 	data, err := os.ReadFile(path)
 	require.Equal(t, nil, err)
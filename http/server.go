@@ -32,6 +32,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	cfgstore "github.com/datarhei/core/v16/config/store"
 	"github.com/datarhei/core/v16/http/cache"
@@ -84,6 +85,7 @@ type Config struct {
 	IPLimiter     net.IPLimiter
 	Profiling     bool
 	Cors          CorsConfig
+	Playout       PlayoutConfig
 	RTMP          rtmp.Server
 	SRT           srt.Server
 	JWT           jwt.JWT
@@ -98,6 +100,12 @@ type CorsConfig struct {
 	Origins []string
 }
 
+type PlayoutConfig struct {
+	Attempts          int
+	Backoff           time.Duration
+	MaxErrorframeSize int64
+}
+
 type Server interface {
 	ServeHTTP(w http.ResponseWriter, r *http.Request)
 }
@@ -233,9 +241,13 @@ func NewServer(config Config) (Server, error) {
 			config.Restream,
 		)
 
-		s.v3handler.playout = api.NewPlayout(
-			config.Restream,
-		)
+		s.v3handler.playout = api.NewPlayoutWithConfig(api.PlayoutConfig{
+			Restream:          config.Restream,
+			Attempts:          config.Playout.Attempts,
+			Backoff:           config.Playout.Backoff,
+			MaxErrorframeSize: config.Playout.MaxErrorframeSize,
+			Logger:            s.logger.WithComponent("Playout"),
+		})
 	}
 
 	if config.Prometheus != nil {
@@ -549,32 +561,51 @@ func (s *server) setRoutesV3(v3 *echo.Group) {
 		v3.GET("/skills", s.v3handler.restream.Skills)
 		v3.GET("/skills/reload", s.v3handler.restream.ReloadSkills)
 
+		v3.GET("/summary", s.v3handler.restream.Summary)
+		v3.GET("/summary/processes", s.v3handler.restream.ListProcesses)
+
 		v3.GET("/process", s.v3handler.restream.GetAll)
 		v3.GET("/process/:id", s.v3handler.restream.Get)
 
 		v3.GET("/process/:id/config", s.v3handler.restream.GetConfig)
 		v3.GET("/process/:id/state", s.v3handler.restream.GetState)
+		v3.GET("/process/:id/command", s.v3handler.restream.GetCommand)
 		v3.GET("/process/:id/report", s.v3handler.restream.GetReport)
+		v3.GET("/process/:id/report/download", s.v3handler.restream.DownloadReport)
 		v3.GET("/process/:id/probe", s.v3handler.restream.Probe)
+		v3.GET("/process/:id/thumbnail/:ioid", s.v3handler.restream.Thumbnail)
 
 		v3.GET("/process/:id/metadata", s.v3handler.restream.GetProcessMetadata)
 		v3.GET("/process/:id/metadata/:key", s.v3handler.restream.GetProcessMetadata)
 
 		v3.GET("/metadata", s.v3handler.restream.GetMetadata)
 		v3.GET("/metadata/:key", s.v3handler.restream.GetMetadata)
+		v3.GET("/metadata/process/:key", s.v3handler.restream.GetProcessMetadataBulk)
 
 		if !s.readOnly {
 			v3.POST("/process", s.v3handler.restream.Add)
+			v3.POST("/process/validate", s.v3handler.restream.Validate)
+			v3.POST("/probe", s.v3handler.restream.ProbeAddress)
 			v3.PUT("/process/:id", s.v3handler.restream.Update)
 			v3.DELETE("/process/:id", s.v3handler.restream.Delete)
 			v3.PUT("/process/:id/command", s.v3handler.restream.Command)
+			v3.PUT("/process/:id/output/order", s.v3handler.restream.ReorderOutputs)
+			v3.PUT("/process/:id/rename", s.v3handler.restream.Rename)
+			v3.PUT("/process/autostart/order", s.v3handler.restream.SetAutostartOrder)
+			v3.PUT("/processes/drain", s.v3handler.restream.Drain)
+			v3.PUT("/processes/undrain", s.v3handler.restream.Undrain)
 			v3.PUT("/process/:id/metadata/:key", s.v3handler.restream.SetProcessMetadata)
+			v3.DELETE("/process/:id/metadata/:key", s.v3handler.restream.DeleteProcessMetadata)
 			v3.PUT("/metadata/:key", s.v3handler.restream.SetMetadata)
+			v3.PUT("/metadata/process/:key", s.v3handler.restream.SetProcessMetadataBulk)
+			v3.DELETE("/metadata/:key", s.v3handler.restream.DeleteMetadata)
 		}
 
 		// v3 Playout
 		if s.v3handler.playout != nil {
+			v3.GET("/playout/ports", s.v3handler.playout.Ports)
 			v3.GET("/process/:id/playout/:inputid/status", s.v3handler.playout.Status)
+			v3.GET("/process/:id/playout/:inputid/streaminfo", s.v3handler.playout.StreamInfo)
 			v3.GET("/process/:id/playout/:inputid/reopen", s.v3handler.playout.ReopenInput)
 			v3.GET("/process/:id/playout/:inputid/keyframe/*", s.v3handler.playout.Keyframe)
 			v3.GET("/process/:id/playout/:inputid/errorframe/encode", s.v3handler.playout.EncodeErrorframe)
@@ -584,6 +615,7 @@ func (s *server) setRoutesV3(v3 *echo.Group) {
 				v3.POST("/process/:id/playout/:inputid/errorframe/*", s.v3handler.playout.SetErrorframe)
 
 				v3.PUT("/process/:id/playout/:inputid/stream", s.v3handler.playout.SetStream)
+				v3.PUT("/process/:id/playout/:inputid/control", s.v3handler.playout.Control)
 			}
 		}
 	}
@@ -71,6 +71,14 @@ Output #0, hls, to './data/testsrc.m3u8':
 
 	fmt.Fprintf(os.Stderr, "%s\n", prelude)
 
+	if value, ok := os.LookupEnv("FFMPEG_TEST_ECHO"); ok {
+		fmt.Fprintf(os.Stderr, "FFMPEG_TEST_ECHO=%s\n", value)
+	}
+
+	if value, ok := os.LookupEnv("FFMPEG_TEST_LINE"); ok {
+		fmt.Fprintf(os.Stderr, "%s\n", value)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	go func(ctx context.Context) {
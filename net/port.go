@@ -3,7 +3,11 @@ package net
 import (
 	"errors"
 	"fmt"
+	gonet "net"
+	"sort"
+	"strconv"
 	"sync"
+	"syscall"
 )
 
 // The Portranger interface allows to get an available port from a pool and to put
@@ -67,24 +71,37 @@ func (r *portrange) Get() (int, error) {
 		return -1, fmt.Errorf("no more ports available from range [%d,%d]", r.min, r.min+len(r.ports)-1)
 	}
 
-	// Calculate new port and mark as used
-	var port int = r.min + r.minUnused
+	for i := r.minUnused; i < len(r.ports); i++ {
+		if r.ports[i] {
+			continue
+		}
+
+		// Calculate new port and check whether it's not already in use by someone else
+		var port int = r.min + i
+
+		if !isPortAvailable(port) {
+			continue
+		}
 
-	r.ports[r.minUnused] = true
+		// Mark as used
+		r.ports[i] = true
 
-	// Find next unused index
-	var minUnused int = -1
+		// Find next unused index
+		var minUnused int = -1
 
-	for i := range r.ports {
-		if !r.ports[i] {
-			minUnused = i
-			break
+		for i := range r.ports {
+			if !r.ports[i] {
+				minUnused = i
+				break
+			}
 		}
-	}
 
-	r.minUnused = minUnused
+		r.minUnused = minUnused
+
+		return port, nil
+	}
 
-	return port, nil
+	return -1, fmt.Errorf("no more ports available from range [%d,%d], all free ports are in use by other programs", r.min, r.min+len(r.ports)-1)
 }
 
 func (r *portrange) Put(port int) {
@@ -107,6 +124,107 @@ func (r *portrange) Put(port int) {
 	}
 }
 
+type portlist struct {
+	// Sorted list of the available port numbers
+	list []int
+
+	// Array to store which ports of the list are
+	// used. An unused port is false.
+	ports []bool
+
+	lock sync.Mutex
+}
+
+// NewPortrangeFromList returns a new instance of a Portranger implementation that hands
+// out the ports from the given list, e.g. for multiple disjoint ranges. Duplicate ports and
+// ports outside of [1,65535] are ignored. If the list doesn't contain any valid port, nil
+// and an error is returned.
+func NewPortrangeFromList(ports []int) (Portranger, error) {
+	r := &portlist{}
+
+	seen := map[int]struct{}{}
+
+	for _, port := range ports {
+		if port <= 0 || port > 65535 {
+			continue
+		}
+
+		if _, ok := seen[port]; ok {
+			continue
+		}
+
+		seen[port] = struct{}{}
+		r.list = append(r.list, port)
+	}
+
+	if len(r.list) == 0 {
+		return nil, fmt.Errorf("invalid port list")
+	}
+
+	sort.Ints(r.list)
+
+	r.ports = make([]bool, len(r.list))
+
+	return r, nil
+}
+
+func (r *portlist) Get() (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i, used := range r.ports {
+		if used {
+			continue
+		}
+
+		if !isPortAvailable(r.list[i]) {
+			continue
+		}
+
+		r.ports[i] = true
+
+		return r.list[i], nil
+	}
+
+	return -1, fmt.Errorf("no more ports available from list of %d ports", len(r.list))
+}
+
+func (r *portlist) Put(port int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	i := sort.SearchInts(r.list, port)
+	if i == len(r.list) || r.list[i] != port {
+		return
+	}
+
+	r.ports[i] = false
+}
+
+// isPortAvailable returns whether the port is not already bound by another program, neither
+// for TCP nor for UDP. Ports that are skipped because of this remain in the pool and will be
+// probed again.
+func isPortAvailable(port int) bool {
+	address := ":" + strconv.Itoa(port)
+
+	listener, err := gonet.Listen("tcp", address)
+	if err != nil {
+		// Other errors, e.g. missing permissions, are left to whoever is going to use the port
+		return !errors.Is(err, syscall.EADDRINUSE)
+	}
+
+	listener.Close()
+
+	conn, err := gonet.ListenPacket("udp", address)
+	if err != nil {
+		return !errors.Is(err, syscall.EADDRINUSE)
+	}
+
+	conn.Close()
+
+	return true
+}
+
 var ErrNoPortrangerProvided = errors.New("no portranger provided")
 
 type dummy struct{}
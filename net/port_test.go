@@ -1,6 +1,8 @@
 package net
 
 import (
+	gonet "net"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -108,3 +110,102 @@ func TestDummyPortranger(t *testing.T) {
 
 	portrange.Put(42)
 }
+
+func TestPortlist(t *testing.T) {
+	_, err := NewPortrangeFromList([]int{})
+	require.Error(t, err)
+
+	_, err = NewPortrangeFromList([]int{-1, 70000})
+	require.Error(t, err)
+
+	portrange, err := NewPortrangeFromList([]int{5000, 3001, 3000, 3001, 70000})
+	require.NoError(t, err)
+
+	port, err := portrange.Get()
+	require.NoError(t, err)
+	require.Equal(t, 3000, port)
+
+	port, err = portrange.Get()
+	require.NoError(t, err)
+	require.Equal(t, 3001, port)
+
+	port, err = portrange.Get()
+	require.NoError(t, err)
+	require.Equal(t, 5000, port)
+
+	port, err = portrange.Get()
+	require.Error(t, err)
+	require.Less(t, port, 0)
+
+	portrange.Put(4000)
+	portrange.Put(3001)
+
+	port, err = portrange.Get()
+	require.NoError(t, err)
+	require.Equal(t, 3001, port)
+}
+
+// freePortPair returns a port such that the port and the next port are available
+func freePortPair(t *testing.T) int {
+	listener, err := gonet.Listen("tcp", ":0")
+	require.NoError(t, err)
+
+	port := listener.Addr().(*gonet.TCPAddr).Port
+	listener.Close()
+
+	if !isPortAvailable(port) || !isPortAvailable(port+1) {
+		t.Skipf("the ports %d and %d are not available", port, port+1)
+	}
+
+	return port
+}
+
+func TestGetPortInUse(t *testing.T) {
+	min := freePortPair(t)
+
+	listener, err := gonet.Listen("tcp", ":"+strconv.Itoa(min))
+	require.NoError(t, err)
+
+	defer listener.Close()
+
+	portrange, _ := NewPortrange(min, min+1)
+
+	port, err := portrange.Get()
+	require.NoError(t, err)
+	require.Equal(t, min+1, port)
+
+	_, err = portrange.Get()
+	require.Error(t, err)
+
+	listener.Close()
+
+	port, err = portrange.Get()
+	require.NoError(t, err)
+	require.Equal(t, min, port)
+
+	portlist, _ := NewPortrangeFromList([]int{min, min + 1})
+
+	listener, err = gonet.Listen("tcp", ":"+strconv.Itoa(min))
+	require.NoError(t, err)
+
+	defer listener.Close()
+
+	port, err = portlist.Get()
+	require.NoError(t, err)
+	require.Equal(t, min+1, port)
+}
+
+func TestGetPortInUseUDP(t *testing.T) {
+	min := freePortPair(t)
+
+	conn, err := gonet.ListenPacket("udp", ":"+strconv.Itoa(min))
+	require.NoError(t, err)
+
+	defer conn.Close()
+
+	portrange, _ := NewPortrange(min, min+1)
+
+	port, err := portrange.Get()
+	require.NoError(t, err)
+	require.Equal(t, min+1, port)
+}
@@ -4,6 +4,7 @@ import (
 	"net"
 	"net/url"
 	"regexp"
+	"strings"
 )
 
 var reScheme = regexp.MustCompile(`(?i)^([a-z][a-z0-9.+-:]*)://`)
@@ -34,6 +35,17 @@ func HasScheme(address string) bool {
 	return false
 }
 
+// Scheme returns the lower-cased URL scheme of the address, or an empty
+// string if the address doesn't have an URL scheme prefix.
+func Scheme(address string) string {
+	matches := reScheme.FindStringSubmatch(address)
+	if matches == nil {
+		return ""
+	}
+
+	return strings.ToLower(matches[1])
+}
+
 // Lookup returns the first resolved IP address of the host in the address.
 // If the address doesn't have an URL scheme prefix, an empty string and no
 // error is returned. If the address is an URL and the lookup fails, an
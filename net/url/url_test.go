@@ -47,4 +47,9 @@ func TestScheme(t *testing.T) {
 
 	r = HasScheme("//localhost/foobar")
 	require.False(t, r)
+
+	require.Equal(t, "rtmp", Scheme("rtmp://localhost/live/foobar"))
+	require.Equal(t, "https", Scheme("HTTPS://example.com"))
+	require.Equal(t, "", Scheme("/foo/bar.mp4"))
+	require.Equal(t, "", Scheme("testsrc=size=1280x720:rate=25"))
 }
@@ -0,0 +1,70 @@
+package playout
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var clients = struct {
+	tcp    *http.Client
+	socket map[string]*http.Client
+	lock   sync.Mutex
+}{
+	tcp: &http.Client{
+		Transport: http.DefaultTransport.(*http.Transport).Clone(),
+		Timeout:   10 * time.Second,
+	},
+	socket: map[string]*http.Client{},
+}
+
+// Client returns a client for the playout API. If a socket is given, all connections are made
+// to this unix socket, regardless of the host in the request. The clients are shared, such that
+// connections to the playout API are reused.
+func Client(socket string) *http.Client {
+	if len(socket) == 0 {
+		return clients.tcp
+	}
+
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	client, ok := clients.socket[socket]
+	if ok {
+		return client
+	}
+
+	client = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				dialer := net.Dialer{}
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		Timeout: 10 * time.Second,
+	}
+
+	clients.socket[socket] = client
+
+	return client
+}
+
+// CloseClient closes the idle connections of the client for the socket and removes it, e.g.
+// because the socket has been removed.
+func CloseClient(socket string) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	client, ok := clients.socket[socket]
+	if !ok {
+		return
+	}
+
+	client.CloseIdleConnections()
+
+	delete(clients.socket, socket)
+}
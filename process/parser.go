@@ -12,6 +12,10 @@ type Parser interface {
 	// or previous line, ...)
 	Parse(line string) uint64
 
+	// ParseStdout adds the given line the process wrote to stdout
+	// to the log. These lines don't indicate any progress.
+	ParseStdout(line string)
+
 	// Reset resets any collected statistics or temporary data.
 	// This is called before the process starts and after the
 	// process stopped. The stats are meant to be collected
@@ -22,15 +26,32 @@ type Parser interface {
 	// before the process starts.
 	ResetLog()
 
+	// Exited is called after the process exited with its exit
+	// code, or -1 and the name of the signal if it has been
+	// terminated by a signal.
+	Exited(code int, signal string)
+
 	// Log returns a slice of collected log lines
 	Log() []Line
 }
 
+// ProgressParser is a Parser that additionally parses the progress the
+// process writes to the progress pipe, see Config.ProgressPipe.
+type ProgressParser interface {
+	Parser
+
+	// ParseProgress parses the given line from the progress pipe and
+	// returns an indicator for progress, like Parse.
+	ParseProgress(line string) uint64
+}
+
 // Line represents a line from the output with its timestamp. The
 // line doesn't include any newline character.
 type Line struct {
 	Timestamp time.Time
 	Data      string
+	Level     string // Severity of the line, e.g. "info", "warning", "error"
+	Stream    string // Output the line has been written to, "stdout" or "stderr"
 }
 
 type nullParser struct{}
@@ -45,8 +66,12 @@ var _ Parser = &nullParser{}
 
 func (p *nullParser) Parse(line string) uint64 { return 1 }
 
+func (p *nullParser) ParseStdout(line string) {}
+
 func (p *nullParser) Log() []Line { return []Line{} }
 
 func (p *nullParser) ResetStats() {}
 
 func (p *nullParser) ResetLog() {}
+
+func (p *nullParser) Exited(code int, signal string) {}
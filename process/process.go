@@ -1,17 +1,20 @@
 // Package process is a wrapper of exec.Cmd for controlling a ffmpeg process.
 // It could be used to run other executables but it is tailored to the specifics
-// of ffmpeg, e.g. only stderr is captured, and some exit codes != 0 plus certain
+// of ffmpeg, e.g. only stderr is captured by default, and some exit codes != 0 plus certain
 // signals are still considered as a non-error exit condition.
 package process
 
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
 	"os/exec"
 	"runtime"
+	"sort"
 	"sync"
 	"syscall"
 	"time"
@@ -42,25 +45,63 @@ type Process interface {
 	// IsRunning returns whether the process is currently
 	// running or not.
 	IsRunning() bool
+
+	// Args returns the arguments the binary is called with.
+	Args() []string
 }
 
 // Config is the configuration of a process
 type Config struct {
-	Binary         string                // Path to the ffmpeg binary
-	Args           []string              // List of arguments for the binary
-	Reconnect      bool                  // Whether to restart the process if it exited
-	ReconnectDelay time.Duration         // Duration to wait before restarting the process
-	StaleTimeout   time.Duration         // Kill the process after this duration if it doesn't produce any output
-	LimitCPU       float64               // Kill the process if the CPU usage in percent is above this value
-	LimitMemory    uint64                // Kill the process if the memory consumption in bytes is above this value
-	LimitDuration  time.Duration         // Kill the process if the limits are exceeded for this duration
-	Parser         Parser                // A parser for the output of the process
-	OnStart        func()                // A callback which is called after the process started
-	OnExit         func()                // A callback which is called after the process exited
-	OnStateChange  func(from, to string) // A callback which is called after a state changed
-	Logger         log.Logger
+	Binary          string                // Path to the ffmpeg binary
+	Args            []string              // List of arguments for the binary
+	Env             map[string]string     // Environment variables for the binary
+	Dir             string                // Working directory of the binary, the current directory if empty
+	Reconnect       bool                  // Whether to restart the process if it exited, only if RestartPolicy is empty
+	ReconnectDelay  time.Duration         // Duration to wait before restarting the process
+	ReconnectJitter time.Duration         // Maximum random duration that is added to ReconnectDelay, such that processes don't restart all at once
+	RestartPolicy   string                // When to restart the process if it exited, one of RestartAlways, RestartOnFailure, or RestartNever
+	MaxRestarts     int                   // Maximum number of restarts within RestartWindow before restarting is paused, unlimited if 0
+	RestartWindow   time.Duration         // Window for MaxRestarts, restarting is paused until the next start if 0
+	StaleTimeout    time.Duration         // Kill the process after this duration if it doesn't produce any output
+	LimitCPU        float64               // Kill the process if the CPU usage in percent is above this value
+	LimitMemory     uint64                // Kill the process if the memory consumption in bytes is above this value
+	LimitDuration   time.Duration         // Kill the process if the limits are exceeded for this duration
+	Parser          Parser                // A parser for the output of the process
+	LogStdout       bool                  // Whether to pass the output on stdout to the parser as well
+	ProgressPipe    bool                  // Whether to pass a pipe as file descriptor 3 whose output goes to the parser, if it is a ProgressParser. Not supported on Windows
+	CPUAffinity     []int                 // CPUs the process is allowed to run on, all CPUs if empty. Only supported on Linux
+	Nice            int                   // Niceness of the process, 0 keeps the default. Only supported on Linux
+	OnStart         func()                // A callback which is called after the process started
+	OnRestart       func() error          // A callback which is called before the process is restarted automatically. It isn't restarted if the callback returns an error
+	OnExit          func()                // A callback which is called after the process exited and it has been decided whether to restart it
+	OnStateChange   func(from, to string) // A callback which is called after a state changed
+	OnStale         func()                // A callback which is called before the process gets stopped because it is stale
+	Logger          log.Logger
 }
 
+// Restart policies
+const (
+	RestartAlways    = "always"     // Restart the process whenever it exited
+	RestartOnFailure = "on-failure" // Restart the process only if it didn't finish normally
+	RestartNever     = "never"      // Never restart the process
+)
+
+// Restart decisions made after the process exited
+const (
+	RestartDecisionRestart = "restart" // A restart is scheduled
+	RestartDecisionBreaker = "breaker" // Restarts are paused because there have been too many of them
+	RestartDecisionNone    = "none"    // The restart policy doesn't allow a restart
+)
+
+// Reasons why the last run of a process failed
+const (
+	ReasonBinaryNotFound      = "binary_not_found"      // The binary doesn't exist
+	ReasonStartFailed         = "start_failed"          // The process couldn't be started for another reason
+	ReasonCPULimitExceeded    = "cpu_limit_exceeded"    // The process has been stopped because it used too much CPU
+	ReasonMemoryLimitExceeded = "memory_limit_exceeded" // The process has been stopped because it used too much memory
+	ReasonStale               = "stale"                 // The process has been stopped because it didn't make any progress
+)
+
 // Status represents the current status of a process
 type Status struct {
 	// State is the current state of the process. See stateType for the known states.
@@ -83,6 +124,48 @@ type Status struct {
 
 	// Used memory in bytes
 	Memory uint64
+
+	// ExitCode is the exit code of the last run of the process. It is -1 if
+	// the process didn't exit yet or it has been terminated by a signal.
+	ExitCode int
+
+	// ExitSignal is the name of the signal that terminated the last run of
+	// the process. It is empty if the process exited by itself.
+	ExitSignal string
+
+	// Restarts is the number of automatic restarts within the restart window.
+	Restarts int
+
+	// Breaker is whether automatic restarts are paused because there have
+	// been too many of them.
+	Breaker bool
+
+	// BreakerReset is the time when automatic restarts will resume. It is
+	// zero if the breaker is not open or restarts resume only with the next start.
+	BreakerReset time.Time
+
+	// RestartPolicy is the policy for restarting the process after it exited.
+	RestartPolicy string
+
+	// RestartDecision is the decision whether the process will be restarted after
+	// it exited, one of the RestartDecision constants. It is empty if the process
+	// didn't exit since it has been started or stopped.
+	RestartDecision string
+
+	// ReconnectAt is the time of the scheduled restart, including the jitter. It is
+	// zero if no restart is scheduled.
+	ReconnectAt time.Time
+
+	// RecentRestarts is the number of automatic restarts within the last hour,
+	// regardless of the restart window and of starts in between.
+	RecentRestarts int
+
+	// Reason is why the last run of the process failed, one of the Reason constants.
+	// It is empty if the process didn't fail since it has been started the last time.
+	Reason string
+
+	// Detail is a human readable description of the reason.
+	Detail string
 }
 
 // States
@@ -155,17 +238,30 @@ type States struct {
 
 // Process represents a ffmpeg process
 type process struct {
-	binary   string
-	args     []string
-	cmd      *exec.Cmd
-	pid      int32
-	stdout   io.ReadCloser
-	lastLine string
-	state    struct {
-		state  stateType
-		time   time.Time
-		states States
-		lock   sync.Mutex
+	binary    string
+	args      []string
+	env       map[string]string
+	dir       string
+	cmd       *exec.Cmd
+	pid       int32
+	stdout    io.ReadCloser
+	logout    io.ReadCloser // stdout of the process, if it should be logged
+	logStdout bool
+	progress  *os.File // read end of the progress pipe, if enabled
+	progPipe  bool
+	readers   sync.WaitGroup
+	affinity  []int
+	nice      int
+	lastLine  string
+	state     struct {
+		state      stateType
+		time       time.Time
+		states     States
+		exitCode   int
+		exitSignal string
+		reason     string // Reason why the last run failed, empty if it didn't fail
+		detail     string
+		lock       sync.Mutex
 	}
 	order struct {
 		order string
@@ -179,10 +275,19 @@ type process struct {
 		lock    sync.Mutex
 	}
 	reconn struct {
-		enable bool
-		delay  time.Duration
-		timer  *time.Timer
-		lock   sync.Mutex
+		policy      string
+		decision    string
+		delay       time.Duration
+		jitter      time.Duration
+		timer       *time.Timer
+		at          time.Time // Time of the scheduled restart, zero if none is scheduled
+		maxRestarts int
+		window      time.Duration
+		restarts    []time.Time // Times of the automatic restarts since the last start
+		recent      []time.Time // Times of the automatic restarts within the last hour
+		breaker     bool
+		reset       time.Time
+		lock        sync.Mutex
 	}
 	killTimer     *time.Timer
 	killTimerLock sync.Mutex
@@ -190,8 +295,11 @@ type process struct {
 	debuglogger   log.Logger
 	callbacks     struct {
 		onStart       func()
+		onRestart     func() error
 		onExit        func()
 		onStateChange func(from, to string)
+		onStale       func()
+		onStopped     []func() // Waiting calls of Stop or Kill
 		lock          sync.Mutex
 	}
 	limits Limiter
@@ -204,9 +312,16 @@ func New(config Config) (Process, error) {
 	p := &process{
 		binary: config.Binary,
 		args:   config.Args,
+		env:    map[string]string{},
+		dir:    config.Dir,
 		cmd:    nil,
 		parser: config.Parser,
 		logger: config.Logger,
+
+		logStdout: config.LogStdout,
+		progPipe:  config.ProgressPipe,
+		affinity:  config.CPUAffinity,
+		nice:      config.Nice,
 	}
 
 	// This is a loose check on purpose. If the e.g. the binary
@@ -216,6 +331,10 @@ func New(config Config) (Process, error) {
 		return nil, fmt.Errorf("no valid binary given")
 	}
 
+	for k, v := range config.Env {
+		p.env[k] = v
+	}
+
 	if p.parser == nil {
 		p.parser = NewNullParser()
 	}
@@ -233,15 +352,27 @@ func New(config Config) (Process, error) {
 
 	p.initState(stateFinished)
 
-	p.reconn.enable = config.Reconnect
+	p.reconn.policy = config.RestartPolicy
+	if len(p.reconn.policy) == 0 {
+		p.reconn.policy = RestartNever
+		if config.Reconnect {
+			p.reconn.policy = RestartAlways
+		}
+	}
+
 	p.reconn.delay = config.ReconnectDelay
+	p.reconn.jitter = config.ReconnectJitter
+	p.reconn.maxRestarts = config.MaxRestarts
+	p.reconn.window = config.RestartWindow
 
 	p.stale.last = time.Now()
 	p.stale.timeout = config.StaleTimeout
 
 	p.callbacks.onStart = config.OnStart
+	p.callbacks.onRestart = config.OnRestart
 	p.callbacks.onExit = config.OnExit
 	p.callbacks.onStateChange = config.OnStateChange
+	p.callbacks.onStale = config.OnStale
 
 	p.limits = NewLimiter(LimiterConfig{
 		CPU:     config.LimitCPU,
@@ -252,6 +383,13 @@ func New(config Config) (Process, error) {
 				"cpu":    cpu,
 				"memory": memory,
 			}).Warn().Log("Stopping because limits are exceeded")
+
+			if config.LimitMemory > 0 && memory > config.LimitMemory {
+				p.setFailure(ReasonMemoryLimitExceeded, fmt.Sprintf("the memory consumption of %d bytes exceeded the limit of %d bytes", memory, config.LimitMemory))
+			} else {
+				p.setFailure(ReasonCPULimitExceeded, fmt.Sprintf("the CPU usage of %.2f%% exceeded the limit of %.2f%%", cpu, config.LimitCPU))
+			}
+
 			p.Kill(false)
 		},
 	})
@@ -268,6 +406,26 @@ func (p *process) initState(state stateType) {
 
 	p.state.state = state
 	p.state.time = time.Now()
+	p.state.exitCode = -1
+	p.state.exitSignal = ""
+}
+
+// setFailure records why the current run of the process failed.
+func (p *process) setFailure(reason, detail string) {
+	p.state.lock.Lock()
+	p.state.reason = reason
+	p.state.detail = detail
+	p.state.lock.Unlock()
+}
+
+// setExit records how the process exited.
+func (p *process) setExit(code int, signal string) {
+	p.state.lock.Lock()
+	p.state.exitCode = code
+	p.state.exitSignal = signal
+	p.state.lock.Unlock()
+
+	p.parser.Exited(code, signal)
 }
 
 // setState sets a new state. It also checks if the transition
@@ -395,20 +553,45 @@ func (p *process) Status() Status {
 	stateTime := p.state.time
 	stateString := p.state.state.String()
 	states := p.state.states
+	exitCode := p.state.exitCode
+	exitSignal := p.state.exitSignal
+	reason := p.state.reason
+	detail := p.state.detail
 	p.state.lock.Unlock()
 
 	p.order.lock.Lock()
 	order := p.order.order
 	p.order.lock.Unlock()
 
+	p.reconn.lock.Lock()
+	restarts := p.restarts(time.Now())
+	recent := p.recentRestarts(time.Now())
+	breaker := p.reconn.breaker
+	breakerReset := p.reconn.reset
+	decision := p.reconn.decision
+	reconnectAt := p.reconn.at
+	p.reconn.lock.Unlock()
+
 	s := Status{
-		State:    stateString,
-		States:   states,
-		Order:    order,
-		Duration: time.Since(stateTime),
-		Time:     stateTime,
-		CPU:      cpu,
-		Memory:   memory,
+		State:        stateString,
+		States:       states,
+		Order:        order,
+		Duration:     time.Since(stateTime),
+		Time:         stateTime,
+		CPU:          cpu,
+		Memory:       memory,
+		ExitCode:     exitCode,
+		ExitSignal:   exitSignal,
+		Restarts:     restarts,
+		Breaker:      breaker,
+		BreakerReset: breakerReset,
+
+		RestartPolicy:   p.reconn.policy,
+		RestartDecision: decision,
+		RecentRestarts:  recent,
+		ReconnectAt:     reconnectAt,
+		Reason:          reason,
+		Detail:          detail,
 	}
 
 	return s
@@ -419,17 +602,32 @@ func (p *process) IsRunning() bool {
 	return p.isRunning()
 }
 
+// Args returns a copy of the arguments the binary is called with
+func (p *process) Args() []string {
+	args := make([]string, len(p.args))
+	copy(args, p.args)
+
+	return args
+}
+
 // Start will start the process and sets the order to "start". If the
-// process has alread the "start" order, nothing will be done. Returns
-// an error if start failed.
+// process has alread the "start" order, nothing will be done, unless
+// automatic restarts are paused because there have been too many of them.
+// Returns an error if start failed.
 func (p *process) Start() error {
 	p.order.lock.Lock()
 	defer p.order.lock.Unlock()
 
-	if p.order.order == "start" {
+	p.reconn.lock.Lock()
+	breaker := p.reconn.breaker
+	p.reconn.lock.Unlock()
+
+	if p.order.order == "start" && !breaker {
 		return nil
 	}
 
+	p.resetRestarts()
+
 	p.order.order = "start"
 
 	err := p.start()
@@ -444,6 +642,40 @@ func (p *process) Start() error {
 	return err
 }
 
+// inheritedEnvironment are the variables of the environment of the core that are
+// passed on to a process. Any other variable, e.g. the credentials for the API or
+// for S3, isn't passed on.
+var inheritedEnvironment = []string{"HOME", "LANG", "LC_ALL", "LD_LIBRARY_PATH", "PATH", "TMPDIR", "TZ"}
+
+// Environment returns the environment for a process. The variables of env are set
+// on top of the allowed variables of the environment of the core.
+func Environment(env map[string]string) []string {
+	environment := make([]string, 0, len(inheritedEnvironment)+len(env))
+
+	for _, name := range inheritedEnvironment {
+		if _, ok := env[name]; ok {
+			continue
+		}
+
+		if value, ok := os.LookupEnv(name); ok {
+			environment = append(environment, name+"="+value)
+		}
+	}
+
+	for k, v := range env {
+		environment = append(environment, k+"="+v)
+	}
+
+	sort.Strings(environment)
+
+	return environment
+}
+
+// environment returns the environment for the binary, see Environment.
+func (p *process) environment() []string {
+	return Environment(p.env)
+}
+
 // start will start the process considering the current order. Returns an
 // error in case something goes wrong, and it will try to restart the process.
 func (p *process) start() error {
@@ -463,10 +695,12 @@ func (p *process) start() error {
 	// Stop any restart timer in order to start the process immediately
 	p.unreconnect()
 
+	p.setFailure("", "")
 	p.setState(stateStarting)
 
 	p.cmd = exec.Command(p.binary, p.args...)
-	p.cmd.Env = []string{}
+	p.cmd.Env = p.environment()
+	p.cmd.Dir = p.dir
 
 	p.stdout, err = p.cmd.StderrPipe()
 	if err != nil {
@@ -478,7 +712,62 @@ func (p *process) start() error {
 
 		return err
 	}
-	if err := p.cmd.Start(); err != nil {
+
+	p.logout = nil
+
+	if p.logStdout {
+		p.logout, err = p.cmd.StdoutPipe()
+		if err != nil {
+			p.setState(stateFailed)
+
+			p.parser.Parse(err.Error())
+			p.logger.WithError(err).Error().Log("Command failed")
+			p.reconnect()
+
+			return err
+		}
+	}
+
+	p.progress = nil
+
+	var progressWriter *os.File
+
+	if p.progPipe && runtime.GOOS != "windows" {
+		if _, ok := p.parser.(ProgressParser); ok {
+			p.progress, progressWriter, err = os.Pipe()
+			if err != nil {
+				p.setState(stateFailed)
+
+				p.parser.Parse(err.Error())
+				p.logger.WithError(err).Error().Log("Command failed")
+				p.reconnect()
+
+				return err
+			}
+
+			p.cmd.ExtraFiles = []*os.File{progressWriter}
+		}
+	}
+
+	schedErr, err := startCommand(p.cmd, p.affinity, p.nice)
+
+	// The write end of the progress pipe belongs to the process now
+	if progressWriter != nil {
+		progressWriter.Close()
+	}
+
+	if err != nil {
+		if p.progress != nil {
+			p.progress.Close()
+			p.progress = nil
+		}
+
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+			p.setFailure(ReasonBinaryNotFound, err.Error())
+		} else {
+			p.setFailure(ReasonStartFailed, err.Error())
+		}
+
 		p.setState(stateFailed)
 
 		p.parser.Parse(err.Error())
@@ -490,6 +779,10 @@ func (p *process) start() error {
 
 	p.pid = int32(p.cmd.Process.Pid)
 
+	if schedErr != nil {
+		p.logger.WithError(schedErr).Warn().Log("Failed to set the scheduling of the process")
+	}
+
 	if proc, err := psutil.NewProcess(p.pid); err == nil {
 		p.limits.Start(proc)
 	}
@@ -504,6 +797,7 @@ func (p *process) start() error {
 	}
 
 	// Start the reader
+	p.readers.Add(1)
 	go p.reader()
 
 	// Wait for the process to finish
@@ -534,6 +828,8 @@ func (p *process) Stop(wait bool) error {
 
 	p.order.order = "stop"
 
+	p.resetRestarts()
+
 	err := p.stop(wait)
 	if err != nil {
 		p.debuglogger.WithFields(log.Fields{
@@ -590,19 +886,7 @@ func (p *process) stop(wait bool) error {
 		wg.Add(1)
 
 		p.callbacks.lock.Lock()
-		if p.callbacks.onExit == nil {
-			p.callbacks.onExit = func() {
-				wg.Done()
-				p.callbacks.onExit = nil
-			}
-		} else {
-			cb := p.callbacks.onExit
-			p.callbacks.onExit = func() {
-				cb()
-				wg.Done()
-				p.callbacks.onExit = cb
-			}
-		}
+		p.callbacks.onStopped = append(p.callbacks.onStopped, wg.Done)
 		p.callbacks.lock.Unlock()
 	}
 
@@ -649,27 +933,144 @@ func (p *process) stop(wait bool) error {
 
 // reconnect will setup a timer to restart the  process
 func (p *process) reconnect() {
-	// If restarting a process is not enabled, don't do anything
-	if !p.reconn.enable {
+	// If the restart policy doesn't allow restarting the process, don't do anything
+	if !p.restartAllowed() {
+		p.reconn.lock.Lock()
+		p.reconn.decision = RestartDecisionNone
+		p.reconn.lock.Unlock()
+
+		p.debuglogger.WithField("policy", p.reconn.policy).Debug().Log("Not restarting because of the restart policy")
+
 		return
 	}
 
 	// Stop a currently running timer
 	p.unreconnect()
 
-	p.logger.Info().Log("Scheduling restart in %s", p.reconn.delay)
-
 	p.reconn.lock.Lock()
 	defer p.reconn.lock.Unlock()
 
-	p.reconn.timer = time.AfterFunc(p.reconn.delay, func() {
+	delay := p.reconn.delay
+
+	if p.reconn.jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(p.reconn.jitter)))
+	}
+
+	p.reconn.decision = RestartDecisionRestart
+
+	if now := time.Now(); p.reconn.maxRestarts > 0 && p.restarts(now) >= p.reconn.maxRestarts {
+		p.reconn.breaker = true
+		p.reconn.decision = RestartDecisionBreaker
+
+		if p.reconn.window == 0 {
+			p.logger.Warn().WithField("restarts", p.reconn.maxRestarts).Log("Too many restarts, not restarting until the next start")
+			return
+		}
+
+		// Resume as soon as the oldest restart leaves the window
+		restarts := p.reconn.restarts
+		p.reconn.reset = restarts[len(restarts)-p.reconn.maxRestarts].Add(p.reconn.window)
+
+		if d := p.reconn.reset.Sub(now); d > delay {
+			delay = d
+		}
+
+		p.logger.Warn().WithField("restarts", p.reconn.maxRestarts).Log("Too many restarts within %s, pausing restarts", p.reconn.window)
+	}
+
+	p.logger.Info().Log("Scheduling restart in %s", delay)
+
+	p.reconn.at = time.Now().Add(delay)
+	p.reconn.timer = time.AfterFunc(delay, func() {
+		p.reconn.lock.Lock()
+		p.reconn.at = time.Time{}
+		p.reconn.restarts = append(p.reconn.restarts, time.Now())
+		p.reconn.recent = append(p.reconn.recent, time.Now())
+		p.reconn.breaker = false
+		p.reconn.reset = time.Time{}
+		p.reconn.decision = RestartDecisionRestart
+		p.reconn.lock.Unlock()
+
+		// The callback may take a while, the process can be started or stopped in the meantime
+		if p.callbacks.onRestart != nil {
+			if err := p.callbacks.onRestart(); err != nil {
+				p.logger.WithError(err).Warn().Log("Not restarting")
+
+				p.order.lock.Lock()
+				if p.order.order == "start" && !p.isRunning() {
+					p.reconnect()
+				}
+				p.order.lock.Unlock()
+
+				return
+			}
+		}
+
 		p.order.lock.Lock()
 		defer p.order.lock.Unlock()
 
+		if p.order.order != "start" {
+			return
+		}
+
 		p.start()
 	})
 }
 
+// restartAllowed returns whether the restart policy allows to restart the process
+// after it exited.
+func (p *process) restartAllowed() bool {
+	switch p.reconn.policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return p.getState() != stateFinished
+	}
+
+	return false
+}
+
+// restarts returns the number of automatic restarts within the restart window and
+// discards the older ones. The caller must hold the reconn lock.
+func (p *process) restarts(now time.Time) int {
+	if p.reconn.window == 0 {
+		return len(p.reconn.restarts)
+	}
+
+	i := 0
+	for i < len(p.reconn.restarts) && now.Sub(p.reconn.restarts[i]) >= p.reconn.window {
+		i++
+	}
+
+	p.reconn.restarts = p.reconn.restarts[i:]
+
+	return len(p.reconn.restarts)
+}
+
+// recentRestarts returns the number of automatic restarts within the last hour and
+// discards the older ones. The caller must hold the reconn lock.
+func (p *process) recentRestarts(now time.Time) int {
+	i := 0
+	for i < len(p.reconn.recent) && now.Sub(p.reconn.recent[i]) >= time.Hour {
+		i++
+	}
+
+	p.reconn.recent = p.reconn.recent[i:]
+
+	return len(p.reconn.recent)
+}
+
+// resetRestarts forgets about the automatic restarts and closes the breaker.
+func (p *process) resetRestarts() {
+	p.reconn.lock.Lock()
+	defer p.reconn.lock.Unlock()
+
+	p.reconn.restarts = nil
+	p.reconn.breaker = false
+	p.reconn.reset = time.Time{}
+	p.reconn.decision = ""
+}
+
 // unreconnect will stop the restart timer
 func (p *process) unreconnect() {
 	p.reconn.lock.Lock()
@@ -681,6 +1082,7 @@ func (p *process) unreconnect() {
 
 	p.reconn.timer.Stop()
 	p.reconn.timer = nil
+	p.reconn.at = time.Time{}
 }
 
 // staler checks if the currently running process is stale, i.e. the reader
@@ -710,6 +1112,17 @@ func (p *process) staler(ctx context.Context) {
 			d := t.Sub(last)
 			if d.Seconds() > timeout.Seconds() {
 				p.logger.Info().Log("Stale timeout after %s (%.2f).", timeout, d.Seconds())
+
+				p.setFailure(ReasonStale, fmt.Sprintf("no progress for %s", timeout))
+
+				p.callbacks.lock.Lock()
+				onStale := p.callbacks.onStale
+				p.callbacks.lock.Unlock()
+
+				if onStale != nil {
+					onStale()
+				}
+
 				p.stop(false)
 				return
 			}
@@ -723,6 +1136,8 @@ func (p *process) staler(ctx context.Context) {
 // of the last progress will not be updated thus the stale timeout
 // may kick in.
 func (p *process) reader() {
+	defer p.readers.Done()
+
 	scanner := bufio.NewScanner(p.stdout)
 	scanner.Split(scanLine)
 
@@ -732,6 +1147,18 @@ func (p *process) reader() {
 	// Reset the parser logs
 	p.parser.ResetLog()
 
+	// The output on stdout is read only after the reset of
+	// the logs, such that it ends up in the log of this run.
+	if p.logout != nil {
+		p.readers.Add(1)
+		go p.stdoutReader(p.logout)
+	}
+
+	if p.progress != nil {
+		p.readers.Add(1)
+		go p.progressReader(p.progress)
+	}
+
 	var n uint64 = 0
 
 	for scanner.Scan() {
@@ -752,6 +1179,50 @@ func (p *process) reader() {
 	}
 }
 
+// stdoutReader reads the output on stdout from the process line
+// by line and adds each line to the log of the parser.
+func (p *process) stdoutReader(stdout io.Reader) {
+	defer p.readers.Done()
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Split(scanLine)
+
+	for scanner.Scan() {
+		p.parser.ParseStdout(scanner.Text())
+	}
+
+	// Keep on reading if the scanner stopped because of a too long
+	// line, otherwise the process would block on writing to stdout.
+	io.Copy(io.Discard, stdout)
+}
+
+// progressReader reads the output on the progress pipe line by
+// line and gives each line to the parser. Like in reader, the
+// stale progress timer is reset if the parser reports progress.
+func (p *process) progressReader(progress *os.File) {
+	defer p.readers.Done()
+	defer progress.Close()
+
+	parser, ok := p.parser.(ProgressParser)
+	if !ok {
+		io.Copy(io.Discard, progress)
+		return
+	}
+
+	scanner := bufio.NewScanner(progress)
+	scanner.Split(scanLine)
+
+	for scanner.Scan() {
+		if n := parser.ParseProgress(scanner.Text()); n != 0 {
+			p.stale.lock.Lock()
+			p.stale.last = time.Now()
+			p.stale.lock.Unlock()
+		}
+	}
+
+	io.Copy(io.Discard, progress)
+}
+
 // waiter waits for the process to finish. If enabled, the process will
 // be scheduled for a restart.
 func (p *process) waiter() {
@@ -759,6 +1230,9 @@ func (p *process) waiter() {
 		p.stop(false)
 	}
 
+	// The pipes will be closed by Wait, so all output has to be read before.
+	p.readers.Wait()
+
 	if err := p.cmd.Wait(); err != nil {
 		// The process exited abnormally, i.e. the return code is non-zero or a signal
 		// has been raised.
@@ -775,6 +1249,12 @@ func (p *process) waiter() {
 				"signal":      status.Signal(),
 			}).Debug().Log("Exited")
 
+			if status.Signaled() {
+				p.setExit(-1, status.Signal().String())
+			} else {
+				p.setExit(status.ExitStatus(), "")
+			}
+
 			if status.Exited() {
 				if status.ExitStatus() == 255 {
 					// If ffmpeg has been killed with a SIGINT, SIGTERM, etc., then it exited normally,
@@ -798,6 +1278,7 @@ func (p *process) waiter() {
 			}
 		} else {
 			// Some other error regarding I/O triggered during Wait()
+			p.setExit(-1, "")
 			p.logger.Info().Log("Killed")
 			p.logger.WithError(err).Debug().Log("Killed")
 			p.setState(stateKilled)
@@ -805,6 +1286,7 @@ func (p *process) waiter() {
 	} else {
 		// The process exited normally, i.e. the return code is zero and no signal
 		// has been raised
+		p.setExit(0, "")
 		p.setState(stateFinished)
 	}
 
@@ -832,15 +1314,15 @@ func (p *process) waiter() {
 	// Reset the parser stats
 	p.parser.ResetStats()
 
-	// Call the onExit callback
+	// Release the waiting calls of Stop or Kill, they are holding the order lock
 	p.callbacks.lock.Lock()
-	if p.callbacks.onExit != nil {
-		go p.callbacks.onExit()
+	for _, done := range p.callbacks.onStopped {
+		done()
 	}
+	p.callbacks.onStopped = nil
 	p.callbacks.lock.Unlock()
 
 	p.order.lock.Lock()
-	defer p.order.lock.Unlock()
 
 	p.debuglogger.WithFields(log.Fields{
 		"state": p.getStateString(),
@@ -851,6 +1333,16 @@ func (p *process) waiter() {
 	if p.order.order == "start" {
 		p.reconnect()
 	}
+
+	p.order.lock.Unlock()
+
+	// Call the onExit callback after the decision about restarting the process
+	// has been made, such that it is reflected in the status
+	p.callbacks.lock.Lock()
+	if p.callbacks.onExit != nil {
+		go p.callbacks.onExit()
+	}
+	p.callbacks.lock.Unlock()
 }
 
 // scanLine splits the data on \r, \n, or \r\n line endings
@@ -1,6 +1,9 @@
 package process
 
 import (
+	"fmt"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 
@@ -162,6 +165,57 @@ func TestProcessFailed(t *testing.T) {
 	require.Equal(t, "failed", p.Status().State)
 }
 
+func TestProcessExit(t *testing.T) {
+	p, _ := New(Config{
+		Binary: "sh",
+		Args: []string{
+			"-c", "exit 0",
+		},
+	})
+
+	require.Equal(t, -1, p.Status().ExitCode)
+	require.Equal(t, "", p.Status().ExitSignal)
+
+	p.Start()
+
+	require.Eventually(t, func() bool {
+		return p.Status().State == "finished"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	require.Equal(t, 0, p.Status().ExitCode)
+	require.Equal(t, "", p.Status().ExitSignal)
+
+	p, _ = New(Config{
+		Binary: "sh",
+		Args: []string{
+			"-c", "exit 3",
+		},
+	})
+
+	p.Start()
+
+	require.Eventually(t, func() bool {
+		return p.Status().State == "failed"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	require.Equal(t, 3, p.Status().ExitCode)
+	require.Equal(t, "", p.Status().ExitSignal)
+
+	p, _ = New(Config{
+		Binary: "sleep",
+		Args: []string{
+			"10",
+		},
+	})
+
+	p.Start()
+	p.Stop(true)
+
+	require.Equal(t, "killed", p.Status().State)
+	require.Equal(t, -1, p.Status().ExitCode)
+	require.Equal(t, "interrupt", p.Status().ExitSignal)
+}
+
 func TestFFmpegWaitStop(t *testing.T) {
 	binary, err := testhelper.BuildBinary("sigintwait", "../internal/testhelper")
 	require.NoError(t, err, "Failed to build helper program")
@@ -235,3 +289,376 @@ func TestProcessForceKill(t *testing.T) {
 
 	require.Equal(t, "killed", p.Status().State)
 }
+
+type streamParser struct {
+	nullParser
+
+	lines []Line
+	lock  sync.Mutex
+}
+
+func (p *streamParser) Parse(line string) uint64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.lines = append(p.lines, Line{Data: line, Stream: "stderr"})
+
+	return 1
+}
+
+func (p *streamParser) ParseStdout(line string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.lines = append(p.lines, Line{Data: line, Stream: "stdout"})
+}
+
+func (p *streamParser) Log() []Line {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return append([]Line{}, p.lines...)
+}
+
+func TestProcessLogStdout(t *testing.T) {
+	for _, logStdout := range []bool{false, true} {
+		parser := &streamParser{}
+
+		p, err := New(Config{
+			Binary:    "sh",
+			Args:      []string{"-c", "echo out; echo err >&2"},
+			Parser:    parser,
+			LogStdout: logStdout,
+		})
+		require.NoError(t, err)
+
+		p.Start()
+
+		require.Eventually(t, func() bool {
+			return p.Status().State == "finished"
+		}, 5*time.Second, 100*time.Millisecond)
+
+		expected := []Line{{Data: "err", Stream: "stderr"}}
+		if logStdout {
+			expected = append(expected, Line{Data: "out", Stream: "stdout"})
+		}
+
+		require.Eventually(t, func() bool {
+			return len(parser.Log()) == len(expected)
+		}, 5*time.Second, 100*time.Millisecond)
+
+		require.ElementsMatch(t, expected, parser.Log())
+	}
+}
+
+type progressParser struct {
+	streamParser
+}
+
+func (p *progressParser) ParseProgress(line string) uint64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.lines = append(p.lines, Line{Data: line, Stream: "progress"})
+
+	return 1
+}
+
+func TestProcessProgressPipe(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the progress pipe is not supported on windows")
+	}
+
+	parser := &progressParser{}
+
+	p, err := New(Config{
+		Binary:       "sh",
+		Args:         []string{"-c", "echo frame=1 >&3; echo progress=continue >&3; echo err >&2"},
+		Parser:       parser,
+		ProgressPipe: true,
+	})
+	require.NoError(t, err)
+
+	p.Start()
+
+	require.Eventually(t, func() bool {
+		return p.Status().State == "finished"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	expected := []Line{
+		{Data: "err", Stream: "stderr"},
+		{Data: "frame=1", Stream: "progress"},
+		{Data: "progress=continue", Stream: "progress"},
+	}
+
+	require.Eventually(t, func() bool {
+		return len(parser.Log()) == len(expected)
+	}, 5*time.Second, 100*time.Millisecond)
+
+	require.ElementsMatch(t, expected, parser.Log())
+}
+
+func TestProcessReconnectJitter(t *testing.T) {
+	p, err := New(Config{
+		Binary:          "false",
+		Reconnect:       true,
+		ReconnectDelay:  2 * time.Second,
+		ReconnectJitter: 3 * time.Second,
+	})
+	require.NoError(t, err)
+
+	require.True(t, p.Status().ReconnectAt.IsZero())
+
+	start := time.Now()
+
+	p.Start()
+
+	require.Eventually(t, func() bool {
+		return !p.Status().ReconnectAt.IsZero()
+	}, 5*time.Second, 50*time.Millisecond)
+
+	at := p.Status().ReconnectAt
+	require.True(t, at.After(start.Add(2*time.Second)))
+	require.True(t, at.Before(time.Now().Add(5*time.Second)))
+
+	p.Stop(true)
+
+	require.True(t, p.Status().ReconnectAt.IsZero())
+}
+
+func TestProcessMaxRestarts(t *testing.T) {
+	p, err := New(Config{
+		Binary:         "false",
+		Reconnect:      true,
+		ReconnectDelay: 100 * time.Millisecond,
+		MaxRestarts:    2,
+	})
+	require.NoError(t, err)
+
+	p.Start()
+
+	require.Eventually(t, func() bool {
+		return p.Status().Breaker
+	}, 5*time.Second, 50*time.Millisecond)
+
+	status := p.Status()
+	require.Equal(t, 2, status.Restarts)
+	require.True(t, status.BreakerReset.IsZero())
+	require.Equal(t, "start", status.Order)
+
+	// No more restarts while the breaker is open
+	time.Sleep(500 * time.Millisecond)
+	require.Equal(t, uint64(3), p.Status().States.Starting)
+
+	// Starting the process again closes the breaker
+	p.Start()
+
+	status = p.Status()
+	require.False(t, status.Breaker)
+	require.Equal(t, 0, status.Restarts)
+
+	require.Eventually(t, func() bool {
+		return p.Status().Breaker
+	}, 5*time.Second, 50*time.Millisecond)
+
+	require.Equal(t, uint64(6), p.Status().States.Starting)
+
+	p.Stop(false)
+
+	status = p.Status()
+	require.False(t, status.Breaker)
+	require.Equal(t, 0, status.Restarts)
+
+	// The recent restarts are kept across starts and stops
+	require.Equal(t, 4, status.RecentRestarts)
+}
+
+func TestProcessOnRestart(t *testing.T) {
+	var lock sync.Mutex
+	calls := 0
+
+	p, err := New(Config{
+		Binary:         "false",
+		Reconnect:      true,
+		ReconnectDelay: 100 * time.Millisecond,
+		MaxRestarts:    3,
+		OnRestart: func() error {
+			lock.Lock()
+			defer lock.Unlock()
+
+			calls++
+
+			return fmt.Errorf("not now")
+		},
+	})
+	require.NoError(t, err)
+
+	p.Start()
+
+	// The process isn't restarted if the callback fails, but it will be tried again
+	require.Eventually(t, func() bool {
+		return p.Status().Breaker
+	}, 5*time.Second, 50*time.Millisecond)
+
+	require.Equal(t, uint64(1), p.Status().States.Starting)
+
+	lock.Lock()
+	require.Equal(t, 3, calls)
+	lock.Unlock()
+
+	p.Stop(false)
+}
+
+func TestProcessRestartPolicy(t *testing.T) {
+	tests := []struct {
+		binary   string
+		policy   string
+		decision string
+		state    string
+	}{
+		{"false", RestartAlways, RestartDecisionRestart, "failed"},
+		{"true", RestartAlways, RestartDecisionRestart, "finished"},
+		{"false", RestartOnFailure, RestartDecisionRestart, "failed"},
+		{"true", RestartOnFailure, RestartDecisionNone, "finished"},
+		{"false", RestartNever, RestartDecisionNone, "failed"},
+	}
+
+	for _, test := range tests {
+		p, err := New(Config{
+			Binary:         test.binary,
+			Reconnect:      test.policy == RestartNever, // The policy takes precedence
+			ReconnectDelay: 100 * time.Millisecond,
+			RestartPolicy:  test.policy,
+		})
+		require.NoError(t, err)
+
+		p.Start()
+
+		require.Eventually(t, func() bool {
+			return p.Status().RestartDecision == test.decision
+		}, 5*time.Second, 50*time.Millisecond, test)
+
+		status := p.Status()
+		require.Equal(t, test.policy, status.RestartPolicy)
+
+		if test.decision == RestartDecisionNone {
+			// The process stays down until it is started again
+			time.Sleep(300 * time.Millisecond)
+
+			status = p.Status()
+			require.Equal(t, test.state, status.State, test)
+			require.Equal(t, "start", status.Order)
+			require.Equal(t, uint64(1), status.States.Starting, test)
+		} else {
+			require.Eventually(t, func() bool {
+				return p.Status().States.Starting > 1
+			}, 5*time.Second, 50*time.Millisecond, test)
+		}
+
+		p.Stop(false)
+
+		require.Equal(t, "", p.Status().RestartDecision)
+	}
+
+	// Without a policy, Reconnect decides
+	p, err := New(Config{
+		Binary:    "false",
+		Reconnect: false,
+	})
+	require.NoError(t, err)
+	require.Equal(t, RestartNever, p.Status().RestartPolicy)
+
+	p, err = New(Config{
+		Binary:    "false",
+		Reconnect: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, RestartAlways, p.Status().RestartPolicy)
+}
+
+func TestProcessRestartWindow(t *testing.T) {
+	p, err := New(Config{
+		Binary:         "false",
+		Reconnect:      true,
+		ReconnectDelay: 100 * time.Millisecond,
+		MaxRestarts:    1,
+		RestartWindow:  2 * time.Second,
+	})
+	require.NoError(t, err)
+
+	p.Start()
+
+	require.Eventually(t, func() bool {
+		return p.Status().Breaker
+	}, 5*time.Second, 50*time.Millisecond)
+
+	status := p.Status()
+	require.Equal(t, 1, status.Restarts)
+	require.False(t, status.BreakerReset.IsZero())
+
+	// The restarts resume after the window elapsed
+	require.Eventually(t, func() bool {
+		return p.Status().States.Starting == 3
+	}, 5*time.Second, 50*time.Millisecond)
+
+	p.Stop(false)
+}
+
+func TestProcessFailureReason(t *testing.T) {
+	p, err := New(Config{
+		Binary: "sloop",
+	})
+	require.NoError(t, err)
+
+	p.Start()
+
+	require.Eventually(t, func() bool {
+		return p.Status().State == "failed"
+	}, 5*time.Second, 50*time.Millisecond)
+
+	status := p.Status()
+	require.Equal(t, ReasonBinaryNotFound, status.Reason)
+	require.NotEmpty(t, status.Detail)
+
+	p.Stop(false)
+
+	p, err = New(Config{
+		Binary:       "sleep",
+		Args:         []string{"10"},
+		StaleTimeout: time.Second,
+	})
+	require.NoError(t, err)
+
+	p.Start()
+
+	require.Eventually(t, func() bool {
+		return p.Status().State == "killed"
+	}, 5*time.Second, 50*time.Millisecond)
+
+	require.Equal(t, ReasonStale, p.Status().Reason)
+
+	p.Stop(false)
+
+	// Starting again resets the reason
+	p.Start()
+	require.Empty(t, p.Status().Reason)
+
+	p.Stop(false)
+}
+
+func TestEnvironment(t *testing.T) {
+	t.Setenv("PATH", "/usr/bin")
+	t.Setenv("TZ", "UTC")
+	t.Setenv("CORE_API_AUTH_PASSWORD", "secret")
+
+	env := Environment(map[string]string{
+		"TZ":  "Europe/Zurich",
+		"FOO": "bar",
+	})
+
+	require.Contains(t, env, "PATH=/usr/bin")
+	require.Contains(t, env, "TZ=Europe/Zurich")
+	require.Contains(t, env, "FOO=bar")
+	require.NotContains(t, env, "TZ=UTC")
+	require.NotContains(t, env, "CORE_API_AUTH_PASSWORD=secret")
+}
@@ -0,0 +1,60 @@
+//go:build linux
+
+package process
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// startCommand starts the command restricted to the given CPUs and with the given niceness. Both
+// are applied to a dedicated thread before the command is started, such that the new process
+// inherits them from the very first instruction. The thread is never used again, because the
+// goroutine exits without unlocking it. If the scheduling can't be applied, the command is
+// started anyways and the error is returned as schedErr.
+func startCommand(cmd *exec.Cmd, affinity []int, nice int) (schedErr error, err error) {
+	if len(affinity) == 0 && nice == 0 {
+		return nil, cmd.Start()
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		runtime.LockOSThread()
+
+		schedErr = setScheduling(affinity, nice)
+		err = cmd.Start()
+	}()
+
+	<-done
+
+	return schedErr, err
+}
+
+// setScheduling restricts the calling thread to the given CPUs and sets its niceness.
+func setScheduling(affinity []int, nice int) error {
+	if len(affinity) != 0 {
+		set := unix.CPUSet{}
+		for _, cpu := range affinity {
+			set.Set(cpu)
+		}
+
+		if err := unix.SchedSetaffinity(0, &set); err != nil {
+			return fmt.Errorf("setting CPU affinity: %w", err)
+		}
+	}
+
+	if nice != 0 {
+		// The niceness of PRIO_PROCESS applies to the calling thread only on Linux
+		if err := unix.Setpriority(unix.PRIO_PROCESS, 0, nice); err != nil {
+			return fmt.Errorf("setting niceness: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessScheduling(t *testing.T) {
+	p, err := New(Config{
+		Binary:      "sleep",
+		Args:        []string{"5"},
+		CPUAffinity: []int{0},
+		Nice:        5,
+	})
+	require.NoError(t, err)
+
+	err = p.Start()
+	require.NoError(t, err)
+
+	defer p.Stop(true)
+
+	require.Eventually(t, func() bool {
+		return p.Status().State == "running"
+	}, 5*time.Second, 50*time.Millisecond)
+
+	pid := p.(*process).pid
+
+	status, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	require.NoError(t, err)
+	require.Contains(t, string(status), "Cpus_allowed_list:\t0\n")
+
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	require.NoError(t, err)
+
+	// The fields after the command name, which is enclosed in parentheses. The
+	// niceness is the 19th field of the whole line.
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	require.Equal(t, "5", fields[16])
+}
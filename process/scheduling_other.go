@@ -0,0 +1,11 @@
+//go:build !linux
+
+package process
+
+import "os/exec"
+
+// startCommand starts the command. CPU affinity and niceness are ignored, because
+// they are only supported on Linux.
+func startCommand(cmd *exec.Cmd, affinity []int, nice int) (schedErr error, err error) {
+	return nil, cmd.Start()
+}
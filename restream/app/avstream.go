@@ -1,5 +1,7 @@
 package app
 
+import "time"
+
 type AVstreamIO struct {
 	State  string
 	Packet uint64
@@ -18,4 +20,38 @@ type AVstream struct {
 	Looping     bool
 	Duplicating bool
 	GOP         string
+	Derived     AVstreamMetrics
+}
+
+// AVstreamMetrics are metrics derived from snapshots of an AVstream
+type AVstreamMetrics struct {
+	Latency    float64 // Duration of the queued frames in seconds
+	DropRate   float64 // Dropped frames per second
+	DupRate    float64 // Duplicated frames per second
+	BufferFill float64 // Latency relative to the input buffer of the process, 0 if no buffer is configured
+}
+
+// Metrics derives the metrics from this snapshot and the previous snapshot that has been taken
+// the elapsed duration before. The latency is the duration of the queue at the given framerate.
+// It is 0 if the framerate is not known. The rates are 0 if there's no previous snapshot.
+func (a *AVstream) Metrics(prev *AVstream, elapsed time.Duration, fps float64) AVstreamMetrics {
+	m := AVstreamMetrics{}
+
+	if fps > 0 {
+		m.Latency = float64(a.Queue) / fps
+	}
+
+	if prev == nil || elapsed <= 0 {
+		return m
+	}
+
+	// The counters have been reset, e.g. because the input has been reopened
+	if a.Drop < prev.Drop || a.Dup < prev.Dup {
+		return m
+	}
+
+	m.DropRate = float64(a.Drop-prev.Drop) / elapsed.Seconds()
+	m.DupRate = float64(a.Dup-prev.Dup) / elapsed.Seconds()
+
+	return m
 }
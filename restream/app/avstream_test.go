@@ -0,0 +1,35 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAVstreamMetrics(t *testing.T) {
+	prev := &AVstream{
+		Queue: 10,
+		Drop:  5,
+		Dup:   2,
+	}
+
+	av := &AVstream{
+		Queue: 50,
+		Drop:  15,
+		Dup:   2,
+	}
+
+	m := av.Metrics(prev, 2*time.Second, 25)
+	require.Equal(t, AVstreamMetrics{
+		Latency:  2,
+		DropRate: 5,
+		DupRate:  0,
+	}, m)
+
+	m = av.Metrics(nil, 0, 0)
+	require.Equal(t, AVstreamMetrics{}, m)
+
+	m = prev.Metrics(av, time.Second, 25)
+	require.Equal(t, AVstreamMetrics{Latency: 0.4}, m)
+}
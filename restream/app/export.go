@@ -0,0 +1,26 @@
+package app
+
+import "encoding/json"
+
+// Export is the portable representation of all processes together with
+// their metadata and the general metadata.
+type Export struct {
+	Version  uint64                   `json:"version"`
+	Process  map[string]ExportProcess `json:"process"`
+	Metadata struct {
+		System  map[string]ExportMetadata            `json:"system"`
+		Process map[string]map[string]ExportMetadata `json:"process"`
+	} `json:"metadata"`
+}
+
+type ExportProcess struct {
+	Config *Config `json:"config"`
+	Order  string  `json:"order"`
+}
+
+// ExportMetadata is the JSON encoded metadata together with the name of its
+// Go type, e.g. "*app.Config" or "map[string]interface {}".
+type ExportMetadata struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
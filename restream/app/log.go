@@ -7,6 +7,8 @@ import (
 type LogEntry struct {
 	Timestamp time.Time
 	Data      string
+	Level     string // Severity of the line, e.g. "info", "warning", "error"
+	Stream    string // Output the line has been written to, "stdout" or "stderr"
 }
 
 type LogHistoryEntry struct {
@@ -19,3 +21,27 @@ type Log struct {
 	LogHistoryEntry
 	History []LogHistoryEntry
 }
+
+// LogRun is the log of a completed run of a process.
+type LogRun struct {
+	StartedAt  time.Time
+	StoppedAt  time.Time
+	ExitCode   int    // -1 if the process has been terminated by a signal
+	ExitSignal string // Name of the signal the process has been terminated by
+	Prelude    []string
+	Log        []LogEntry
+}
+
+// LogParts selects the parts of the logs of a process. The lines can be restricted
+// to the ones written to stdout or stderr. If none of them is selected, the lines of
+// both are selected.
+type LogParts int
+
+const (
+	LogPrelude LogParts = 1 << iota // The lines ffmpeg writes before it starts processing
+	LogLines                        // The lines ffmpeg writes while processing
+	LogStdout                       // The lines written to stdout
+	LogStderr                       // The lines written to stderr
+
+	LogAll = LogPrelude | LogLines
+)
@@ -0,0 +1,34 @@
+package app
+
+import (
+	"net"
+	"strconv"
+)
+
+// PlayoutInfo describes how to connect to the playout API of an input of a process
+type PlayoutInfo struct {
+	Scheme   string // Scheme of the playout API, e.g. "http"
+	Host     string // Host the playout API is listening on
+	Port     int    // Port the playout API is listening on
+	Protocol string // Protocol of the input address, e.g. "rtmp", "srt", "file", or "lavfi" for generated streams
+	Socket   string // Path of the unix socket the playout API is listening on instead of Host and Port
+}
+
+// Address returns the address of the playout API in the form host:port. IPv6
+// hosts are enclosed in square brackets, e.g. [::1]:3000. If the playout API is
+// listening on a unix socket, the address is in the form unix:path.
+func (p PlayoutInfo) Address() string {
+	if len(p.Socket) != 0 {
+		return "unix:" + p.Socket
+	}
+
+	return net.JoinHostPort(p.Host, strconv.Itoa(p.Port))
+}
+
+// PlayoutPortInfo describes a port that is assigned to the playout API of an input of a process
+type PlayoutPortInfo struct {
+	Port       int    // Port from the port range
+	ProcessID  string // ID of the process the port is assigned to
+	InputID    string // ID of the input of the process the port is assigned to
+	AssignedAt int64  // Unix timestamp of the assignment
+}
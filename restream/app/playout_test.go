@@ -0,0 +1,29 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlayoutInfoAddress(t *testing.T) {
+	info := PlayoutInfo{
+		Scheme: "http",
+		Host:   "127.0.0.1",
+		Port:   3000,
+	}
+
+	require.Equal(t, "127.0.0.1:3000", info.Address())
+
+	info.Host = "::1"
+
+	require.Equal(t, "[::1]:3000", info.Address())
+
+	info.Host = "localhost"
+
+	require.Equal(t, "localhost:3000", info.Address())
+
+	info.Socket = "/run/core/playout.sock"
+
+	require.Equal(t, "unix:/run/core/playout.sock", info.Address())
+}
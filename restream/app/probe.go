@@ -1,5 +1,7 @@
 package app
 
+import "time"
+
 type ProbeIO struct {
 	Address string
 
@@ -13,6 +15,7 @@ type ProbeIO struct {
 	Coder    string
 	Bitrate  float64 // kbit/s
 	Duration float64
+	Program  uint64 // ID of the program the stream belongs to, 0 if none
 
 	// Video
 	Pixfmt string
@@ -30,3 +33,11 @@ type Probe struct {
 	Streams []ProbeIO
 	Log     []string
 }
+
+// ProbeOptions are the options for probing the inputs of a process
+type ProbeOptions struct {
+	Timeout         time.Duration // Max. duration of the probe, the default timeout if 0
+	AnalyzeDuration time.Duration // Duration of each input that is analyzed, the default of ffmpeg if 0
+	ProbeSize       uint64        // Max. number of bytes of each input that are read, the default of ffmpeg if 0
+	Program         uint64        // ID of the program whose streams are returned, all streams if 0
+}
@@ -1,6 +1,8 @@
 package app
 
 import (
+	"time"
+
 	"github.com/datarhei/core/v16/process"
 )
 
@@ -12,10 +14,11 @@ type ConfigIOCleanup struct {
 }
 
 type ConfigIO struct {
-	ID      string            `json:"id"`
-	Address string            `json:"address"`
-	Options []string          `json:"options"`
-	Cleanup []ConfigIOCleanup `json:"cleanup"`
+	ID      string              `json:"id"`
+	Address string              `json:"address"`
+	Options []string            `json:"options"`
+	Cleanup []ConfigIOCleanup   `json:"cleanup"`
+	Each    []map[string]string `json:"each,omitempty"` // Only for outputs, the output is expanded once per element
 }
 
 func (io ConfigIO) Clone() ConfigIO {
@@ -30,37 +33,110 @@ func (io ConfigIO) Clone() ConfigIO {
 	clone.Cleanup = make([]ConfigIOCleanup, len(io.Cleanup))
 	copy(clone.Cleanup, io.Cleanup)
 
+	if io.Each != nil {
+		clone.Each = make([]map[string]string, len(io.Each))
+		for i, element := range io.Each {
+			clone.Each[i] = make(map[string]string, len(element))
+			for key, value := range element {
+				clone.Each[i][key] = value
+			}
+		}
+	}
+
+	return clone
+}
+
+// ConfigHook is a command that will be executed before the start or after the stop of a process.
+// The hooks run only if the process is started or stopped by the restreamer, not if ffmpeg is
+// restarted automatically, e.g. on reconnect. The binary must be allowed by the restreamer.
+type ConfigHook struct {
+	Binary  string   `json:"binary"`
+	Args    []string `json:"args"`
+	Timeout uint64   `json:"timeout_seconds"` // seconds
+}
+
+func (hook *ConfigHook) Clone() *ConfigHook {
+	if hook == nil {
+		return nil
+	}
+
+	clone := &ConfigHook{
+		Binary:  hook.Binary,
+		Timeout: hook.Timeout,
+	}
+
+	clone.Args = make([]string, len(hook.Args))
+	copy(clone.Args, hook.Args)
+
 	return clone
 }
 
 type Config struct {
-	ID             string     `json:"id"`
-	Reference      string     `json:"reference"`
-	FFVersion      string     `json:"ffversion"`
-	Input          []ConfigIO `json:"input"`
-	Output         []ConfigIO `json:"output"`
-	Options        []string   `json:"options"`
-	Reconnect      bool       `json:"reconnect"`
-	ReconnectDelay uint64     `json:"reconnect_delay_seconds"` // seconds
-	Autostart      bool       `json:"autostart"`
-	StaleTimeout   uint64     `json:"stale_timeout_seconds"` // seconds
-	LimitCPU       float64    `json:"limit_cpu_usage"`       // percent
-	LimitMemory    uint64     `json:"limit_memory_bytes"`    // bytes
-	LimitWaitFor   uint64     `json:"limit_waitfor_seconds"` // seconds
+	ID              string            `json:"id"`
+	Reference       string            `json:"reference"`
+	Tenant          string            `json:"tenant,omitempty"` // Tenant the process belongs to, selects the partition of a partitioned store
+	FFVersion       string            `json:"ffversion"`
+	Input           []ConfigIO        `json:"input"`
+	Output          []ConfigIO        `json:"output"`
+	Options         []string          `json:"options"`
+	Environment     map[string]string `json:"environment,omitempty"`
+	WorkingDir      string            `json:"working_dir,omitempty"`
+	PreStart        *ConfigHook       `json:"pre_start,omitempty"`
+	PostStop        *ConfigHook       `json:"post_stop,omitempty"`
+	Priority        int               `json:"priority"`
+	OnDemand        bool              `json:"on_demand,omitempty"`
+	ReloadConsumers bool              `json:"reload_consumers,omitempty"`
+	LogStdout       bool              `json:"log_stdout,omitempty"`
+	Reconnect       bool              `json:"reconnect"`
+	ReconnectDelay  uint64            `json:"reconnect_delay_seconds"`            // seconds
+	ReconnectJitter uint64            `json:"reconnect_jitter_seconds,omitempty"` // seconds, a random duration up to this is added to ReconnectDelay
+	RestartPolicy   string            `json:"restart_policy,omitempty"`           // "always", "on-failure", or "never", Reconnect decides if empty
+	MaxRestarts     int               `json:"max_restarts,omitempty"`
+	RestartWindow   uint64            `json:"restart_window_seconds,omitempty"` // seconds
+	Autostart       bool              `json:"autostart"`
+	AutostartDelay  uint64            `json:"autostart_delay_seconds"`   // seconds
+	AutostartJitter uint64            `json:"autostart_jitter_seconds"`  // seconds
+	AutostartOrder  int               `json:"autostart_order,omitempty"` // Position in the autostart sequence at boot, lower first, 0 after all others
+	StaleTimeout    uint64            `json:"stale_timeout_seconds"`     // seconds
+	LimitCPU        float64           `json:"limit_cpu_usage"`           // percent
+	LimitMemory     uint64            `json:"limit_memory_bytes"`        // bytes
+	LimitWaitFor    uint64            `json:"limit_waitfor_seconds"`     // seconds
+	CPUAffinity     []int             `json:"cpu_affinity,omitempty"`    // CPUs the process is allowed to run on, all if empty
+	Nice            int               `json:"nice,omitempty"`            // Niceness of the process, 0 keeps the default
+	Device          string            `json:"device,omitempty"`          // ID of the hardware acceleration device, none if empty
+	InputBuffer     uint64            `json:"input_buffer_ms,omitempty"` // milliseconds of each input to buffer, more buffer adds latency
 }
 
 func (config *Config) Clone() *Config {
 	clone := &Config{
-		ID:             config.ID,
-		Reference:      config.Reference,
-		FFVersion:      config.FFVersion,
-		Reconnect:      config.Reconnect,
-		ReconnectDelay: config.ReconnectDelay,
-		Autostart:      config.Autostart,
-		StaleTimeout:   config.StaleTimeout,
-		LimitCPU:       config.LimitCPU,
-		LimitMemory:    config.LimitMemory,
-		LimitWaitFor:   config.LimitWaitFor,
+		ID:              config.ID,
+		Reference:       config.Reference,
+		Tenant:          config.Tenant,
+		FFVersion:       config.FFVersion,
+		WorkingDir:      config.WorkingDir,
+		PreStart:        config.PreStart.Clone(),
+		PostStop:        config.PostStop.Clone(),
+		Priority:        config.Priority,
+		OnDemand:        config.OnDemand,
+		ReloadConsumers: config.ReloadConsumers,
+		LogStdout:       config.LogStdout,
+		Reconnect:       config.Reconnect,
+		ReconnectDelay:  config.ReconnectDelay,
+		ReconnectJitter: config.ReconnectJitter,
+		RestartPolicy:   config.RestartPolicy,
+		MaxRestarts:     config.MaxRestarts,
+		RestartWindow:   config.RestartWindow,
+		Autostart:       config.Autostart,
+		AutostartDelay:  config.AutostartDelay,
+		AutostartJitter: config.AutostartJitter,
+		AutostartOrder:  config.AutostartOrder,
+		StaleTimeout:    config.StaleTimeout,
+		LimitCPU:        config.LimitCPU,
+		LimitMemory:     config.LimitMemory,
+		LimitWaitFor:    config.LimitWaitFor,
+		Nice:            config.Nice,
+		Device:          config.Device,
+		InputBuffer:     config.InputBuffer,
 	}
 
 	clone.Input = make([]ConfigIO, len(config.Input))
@@ -76,6 +152,18 @@ func (config *Config) Clone() *Config {
 	clone.Options = make([]string, len(config.Options))
 	copy(clone.Options, config.Options)
 
+	if config.Environment != nil {
+		clone.Environment = make(map[string]string, len(config.Environment))
+		for k, v := range config.Environment {
+			clone.Environment[k] = v
+		}
+	}
+
+	if config.CPUAffinity != nil {
+		clone.CPUAffinity = make([]int, len(config.CPUAffinity))
+		copy(clone.CPUAffinity, config.CPUAffinity)
+	}
+
 	return clone
 }
 
@@ -107,6 +195,8 @@ type Process struct {
 	Config    *Config `json:"config"`
 	CreatedAt int64   `json:"created_at"`
 	Order     string  `json:"order"`
+	Version   uint64  `json:"version"`           // Increased with each change of the config or order
+	Drained   bool    `json:"drained,omitempty"` // Whether the process has been stopped by draining, it will be started again by undraining
 }
 
 func (process *Process) Clone() *Process {
@@ -116,6 +206,8 @@ func (process *Process) Clone() *Process {
 		Config:    process.Config.Clone(),
 		CreatedAt: process.CreatedAt,
 		Order:     process.Order,
+		Version:   process.Version,
+		Drained:   process.Drained,
 	}
 
 	return clone
@@ -139,16 +231,69 @@ func (p *ProcessStates) Marshal(s process.States) {
 	p.Killed = s.Killed
 }
 
+// TeeBranchState represents the state of a branch of an output using the tee muxer
+type TeeBranchState struct {
+	OutputID string // ID of the output
+	Index    int    // Index of the branch in the output address
+	Address  string // Address of the branch without the slave options
+	OnFail   string // Policy if the branch fails, "ignore", "abort", or "restart_branch"
+	State    string // Current state of the branch, e.g. "ok", "failed"
+	Error    string // Reason for the last failure
+	Failures uint64 // Number of failures since the process has been created
+}
+
+// ProcessSummary is the essential information about a process for a list of processes
+type ProcessSummary struct {
+	ID        string
+	Reference string
+	Order     string // Order of the process, "start" or "stop"
+	State     string // Current state of the process, empty if the process is invalid
+	ExitCode  int    // Exit code of the last run, -1 if the process didn't exit yet or it has been terminated by a signal
+}
+
 type State struct {
-	Order     string        // Current order, e.g. "start", "stop"
-	State     string        // Current state, e.g. "running"
-	States    ProcessStates // Cumulated process states
-	Time      int64         // Unix timestamp of last status change
-	Duration  float64       // Runtime in seconds since last status change
-	Reconnect float64       // Seconds until next reconnect, negative if not reconnecting
-	LastLog   string        // Last recorded line from the process
-	Progress  Progress      // Progress data of the process
-	Memory    uint64        // Current memory consumption in bytes
-	CPU       float64       // Current CPU consumption in percent
-	Command   []string      // ffmpeg command line parameters
+	Order         string           // Current order, e.g. "start", "stop"
+	Version       uint64           // Current version of the process, see Process.Version
+	State         string           // Current state, e.g. "running"
+	States        ProcessStates    // Cumulated process states
+	Time          int64            // Unix timestamp of last status change
+	Duration      float64          // Runtime in seconds since last status change
+	Reconnect     float64          // Seconds until next reconnect, negative if not reconnecting
+	StartIn       float64          // Seconds until the delayed autostart, negative if no start is pending
+	LastLog       string           // Last recorded line from the process
+	Progress      Progress         // Progress data of the process
+	Memory        uint64           // Current memory consumption in bytes
+	CPU           float64          // Current CPU consumption in percent
+	Command       []string         // ffmpeg command line parameters
+	Tee           []TeeBranchState // State of the branches of outputs using the tee muxer
+	ExitCode      int              // Exit code of the last run, -1 if not exited yet or terminated by a signal
+	ExitSignal    string           // Signal that terminated the last run, empty if it exited by itself
+	QueuePosition int              // Position in the queue of processes waiting for a free slot, 0 if not queued
+	Priority      int              // Priority of the process for the queue, higher values first
+	Consumers     int              // Number of processes referencing an on-demand process that should be running
+	Restarts      int              // Number of automatic restarts within the restart window
+	Breaker       string           // State of the circuit breaker for automatic restarts, "closed" or "open"
+	RestartPolicy string           // Policy for restarting the process after it exited, "always", "on-failure", or "never"
+	Restart       string           // Decision whether to restart after the process exited, "restart", "breaker", or "none", empty if it didn't exit since the last start or stop
+	RotatedAt     int64            // Unix timestamp of the last rotation of the credentials, 0 if never
+	Paused        bool             // Whether forwarding the inputs to the outputs is paused
+	Reason        string           // Reason why a process that should be running isn't running, e.g. "queued" or "binary_not_found", empty if it is running
+	Detail        string           // Human readable description of the reason
+	FFmpeg        struct {
+		Binary  string // Path to the ffmpeg binary the process is using
+		Version string // Version of the ffmpeg binary
+	}
+}
+
+// TestResult is the result of running a process for a limited time
+type TestResult struct {
+	Success     bool          // Whether the process has been running for the whole duration and produced output
+	Duration    time.Duration // How long the process has been running
+	Exited      bool          // Whether the process exited by itself before the end of the test
+	ExitCode    int           // Exit code if the process exited by itself, -1 if it has been terminated by a signal
+	ExitSignal  string        // Signal that terminated the process if it exited by itself
+	Progress    Progress      // Last progress while the process has been running, including the AVstream of the inputs
+	Prelude     []string      // Lines ffmpeg wrote before it started processing
+	Log         []LogEntry    // Lines ffmpeg wrote while processing
+	Diagnostics []string      // Reasons for a failure and the errors from the log
 }
@@ -47,4 +47,8 @@ type Progress struct {
 	Speed     float64
 	Drop      uint64
 	Dup       uint64
+
+	Duration   float64 // Duration of the inputs in seconds, 0 if unknown, e.g. for live inputs
+	Completion float64 // Percentage of the inputs that has been processed, -1 if unknown
+	ETA        float64 // Seconds until all inputs are processed, -1 if unknown
 }
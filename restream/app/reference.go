@@ -0,0 +1,11 @@
+package app
+
+// Reference describes a reference from an input of a process to an
+// output of another process, e.g. "#process:output=out".
+type Reference struct {
+	ProcessID string // ID of the process with the referencing input
+	InputID   string // ID of the referencing input
+	TargetID  string // ID of the referenced process
+	OutputID  string // ID of the referenced output
+	Exists    bool   // Whether the referenced process and output currently exist
+}
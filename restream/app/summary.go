@@ -0,0 +1,19 @@
+package app
+
+// RestreamSummary is an aggregate over all processes
+type RestreamSummary struct {
+	Processes     int                   // Number of processes
+	States        map[string]int        // Number of processes per state
+	InputBitrate  float64               // Sum of the bitrates of all inputs in bit/s
+	OutputBitrate float64               // Sum of the bitrates of all outputs in bit/s
+	Restarts      int                   // Number of automatic restarts within the last hour
+	CPU           float64               // Used CPU of all processes in percent
+	Memory        uint64                // Used memory of all processes in bytes
+	Devices       map[string]DeviceLoad // Load per hardware acceleration device
+}
+
+// DeviceLoad is the load of a hardware acceleration device
+type DeviceLoad struct {
+	Processes []string // IDs of the processes that should be running on the device
+	Capacity  int      // Maximum number of processes on the device, unlimited if 0
+}
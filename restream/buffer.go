@@ -0,0 +1,72 @@
+package restream
+
+import (
+	"strconv"
+
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// maxInputBuffer is the max. duration of the input buffer in milliseconds
+const maxInputBuffer = 30000
+
+// inputBufferBitrate is the bitrate in bits per second the size of the buffer for real-time
+// inputs, e.g. capture devices, is calculated for. Inputs with a higher bitrate get a buffer of
+// a shorter duration.
+const inputBufferBitrate = 20 * 1000 * 1000
+
+// setBufferOptions adds the options for the input buffer of the process in front of the options
+// of each input. The inputs are analyzed for the duration of the buffer before the processing
+// starts, and real-time inputs get a buffer that can hold the duration at inputBufferBitrate.
+// Options that are already given for an input are not overridden.
+func setBufferOptions(config *app.Config) {
+	if config.InputBuffer == 0 {
+		return
+	}
+
+	buffer := map[string]string{
+		"-analyzeduration": strconv.FormatUint(config.InputBuffer*1000, 10), // microseconds
+		"-rtbufsize":       strconv.FormatUint(config.InputBuffer*inputBufferBitrate/8/1000, 10),
+	}
+
+	for i, input := range config.Input {
+		options := []string{}
+
+		for _, name := range []string{"-analyzeduration", "-rtbufsize"} {
+			if hasOption(input.Options, name) {
+				continue
+			}
+
+			options = append(options, name, buffer[name])
+		}
+
+		input.Options = append(options, input.Options...)
+		config.Input[i] = input
+	}
+}
+
+// hasOption returns whether the option is in the list of options
+func hasOption(options []string, name string) bool {
+	for _, option := range options {
+		if option == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setBufferFill sets the fill level of the input buffer of the process for each input
+// with an avstream, i.e. the latency relative to the duration of the buffer.
+func setBufferFill(progress *app.Progress, buffer uint64) {
+	if buffer == 0 {
+		return
+	}
+
+	for _, input := range progress.Input {
+		if input.AVstream == nil {
+			continue
+		}
+
+		input.AVstream.Derived.BufferFill = input.AVstream.Derived.Latency / (float64(buffer) / 1000)
+	}
+}
@@ -0,0 +1,237 @@
+package restream
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/datarhei/core/v16/ffmpeg"
+	"github.com/datarhei/core/v16/ffmpeg/skills"
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+var (
+	reCodecOption  = regexp.MustCompile(`^-(?:c|codec|vcodec|acodec|scodec)(?::.*)?$`)
+	reFilterOption = regexp.MustCompile(`^-(?:vf|af|filter(?::.*)?|filter_complex|lavfi)$`)
+	reFilterLabels = regexp.MustCompile(`^(?:\s*\[[^\]]*\])*\s*`)
+)
+
+// capabilities are the decoders, encoders, muxers, and filters an ffmpeg binary supports.
+// A nil set means that the binary didn't report anything and it will not be checked.
+type capabilities struct {
+	decoders map[string]struct{}
+	encoders map[string]struct{}
+	muxers   map[string]struct{}
+	filters  map[string]struct{}
+}
+
+func newCapabilities(s skills.Skills) *capabilities {
+	c := &capabilities{}
+
+	for _, list := range [][]skills.Codec{s.Codecs.Audio, s.Codecs.Video, s.Codecs.Subtitle} {
+		for _, codec := range list {
+			for _, decoder := range codec.Decoders {
+				if c.decoders == nil {
+					c.decoders = map[string]struct{}{}
+				}
+
+				c.decoders[decoder] = struct{}{}
+			}
+
+			for _, encoder := range codec.Encoders {
+				if c.encoders == nil {
+					c.encoders = map[string]struct{}{}
+				}
+
+				c.encoders[encoder] = struct{}{}
+			}
+		}
+	}
+
+	for _, format := range s.Formats.Muxers {
+		if c.muxers == nil {
+			c.muxers = map[string]struct{}{}
+		}
+
+		c.muxers[format.Id] = struct{}{}
+	}
+
+	for _, device := range s.Devices.Muxers {
+		if c.muxers == nil {
+			c.muxers = map[string]struct{}{}
+		}
+
+		c.muxers[device.Id] = struct{}{}
+	}
+
+	for _, filter := range s.Filters {
+		if c.filters == nil {
+			c.filters = map[string]struct{}{}
+		}
+
+		c.filters[filter.Id] = struct{}{}
+	}
+
+	return c
+}
+
+// validateCapabilities checks whether the ffmpeg binary supports the decoders, encoders,
+// muxers, and filters the config is referring to, based on the skills that have been detected
+// when the binary has been loaded. Demuxers are not checked because ffmpeg doesn't list all
+// of their aliases.
+func validateCapabilities(binary ffmpeg.FFmpeg, config *app.Config) error {
+	s := binary.Skills()
+
+	if err := newCapabilities(s).validate(config); err != nil {
+		return fmt.Errorf("the process '%s' is not supported by ffmpeg %s: %w", config.ID, s.FFmpeg.Version, err)
+	}
+
+	return nil
+}
+
+func (c *capabilities) validate(config *app.Config) error {
+	if err := c.validateOptions(config.Options, false); err != nil {
+		return fmt.Errorf("global options: %w", err)
+	}
+
+	for _, input := range config.Input {
+		if err := c.validateOptions(input.Options, false); err != nil {
+			return fmt.Errorf("options of '%s': %w", input.ID, err)
+		}
+	}
+
+	for _, output := range config.Output {
+		if err := c.validateOptions(output.Options, true); err != nil {
+			return fmt.Errorf("options of '%s': %w", output.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *capabilities) validateOptions(options []string, output bool) error {
+	for i := 0; i < len(options)-1; i++ {
+		option, value := options[i], options[i+1]
+
+		switch {
+		case reCodecOption.MatchString(option) && output:
+			if !c.hasEncoder(value) {
+				return fmt.Errorf("unknown encoder '%s', it is not supported by this ffmpeg", value)
+			}
+		case reCodecOption.MatchString(option):
+			if !c.hasDecoder(value) {
+				return fmt.Errorf("unknown decoder '%s', it is not supported by this ffmpeg", value)
+			}
+		case option == "-f" && output:
+			if !c.hasMuxer(value) {
+				return fmt.Errorf("unknown muxer '%s', it is not supported by this ffmpeg", value)
+			}
+		case reFilterOption.MatchString(option):
+			for _, name := range filterNames(value) {
+				if !c.hasFilter(name) {
+					return fmt.Errorf("unknown filter '%s', it is not supported by this ffmpeg", name)
+				}
+			}
+		default:
+			continue
+		}
+
+		i++
+	}
+
+	return nil
+}
+
+func (c *capabilities) hasDecoder(name string) bool {
+	if c.decoders == nil || name == "copy" {
+		return true
+	}
+
+	_, ok := c.decoders[name]
+
+	return ok
+}
+
+func (c *capabilities) hasEncoder(name string) bool {
+	if c.encoders == nil || name == "copy" {
+		return true
+	}
+
+	_, ok := c.encoders[name]
+
+	return ok
+}
+
+func (c *capabilities) hasMuxer(name string) bool {
+	if c.muxers == nil {
+		return true
+	}
+
+	_, ok := c.muxers[name]
+
+	return ok
+}
+
+func (c *capabilities) hasFilter(name string) bool {
+	if c.filters == nil {
+		return true
+	}
+
+	_, ok := c.filters[name]
+
+	return ok
+}
+
+// filterNames returns the names of the filters in a filtergraph, e.g.
+// "[0:v]scale=1280:-1,fps=25[out]" results in "scale" and "fps".
+func filterNames(graph string) []string {
+	names := []string{}
+
+	for _, f := range splitFiltergraph(graph) {
+		f = reFilterLabels.ReplaceAllString(f, "")
+
+		name := f
+		if i := strings.IndexAny(f, "=@[ \t"); i != -1 {
+			name = f[:i]
+		}
+
+		if len(name) == 0 {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// splitFiltergraph splits a filtergraph into its filters. Separators that are
+// quoted or escaped are part of the arguments of a filter.
+func splitFiltergraph(graph string) []string {
+	filters := []string{}
+
+	var b strings.Builder
+	quoted := false
+	escaped := false
+
+	for _, r := range graph {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '\'':
+			quoted = !quoted
+		case !quoted && (r == ',' || r == ';'):
+			filters = append(filters, b.String())
+			b.Reset()
+			continue
+		}
+
+		b.WriteRune(r)
+	}
+
+	filters = append(filters, b.String())
+
+	return filters
+}
@@ -0,0 +1,489 @@
+package restream
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// configFile is a process config that has been read from a file
+type configFile struct {
+	name   string
+	path   string
+	config *app.Config
+}
+
+// LoadConfigDir adds the processes from the files with the extension .json or .jsonc in the directory.
+// Each file contains one process config. The ID of a process defaults to the name of the file without
+// the extension. See readConfigFile for comments and layering of files. Existing processes are updated
+// if their config differs. If reconcile is true, the
+// processes that don't have a file in the directory are removed. The errors are returned per file
+// name. A file with an error doesn't prevent the other files from being loaded.
+func (r *restream) LoadConfigDir(dir string, reconcile bool) map[string]error {
+	files, errs := readConfigDir(dir)
+	if files == nil {
+		return errs
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.dirty = true
+
+	r.applyConfigDir(dir, files, errs, reconcile, true)
+
+	return errs
+}
+
+// readConfigDir reads the process configs from the directory. The files are nil if the
+// directory can't be read.
+func readConfigDir(dir string) ([]configFile, map[string]error) {
+	errs := map[string]error{}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		errs[dir] = err
+		return nil, errs
+	}
+
+	files := []configFile{}
+
+	for _, e := range entries {
+		name := e.Name()
+
+		if e.IsDir() || !isConfigFile(name) || isBaseConfigFile(name) {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+
+		config, err := readConfigFile(dir, name)
+		if err != nil {
+			errs[name] = err
+			files = append(files, configFile{name: name, path: path})
+			continue
+		}
+
+		if len(config.ID) == 0 {
+			config.ID = configFileID(name)
+		}
+
+		files = append(files, configFile{
+			name:   name,
+			path:   path,
+			config: config,
+		})
+	}
+
+	return files, errs
+}
+
+// configBaseKey is the key in a config file that refers to the file the config is layered on
+const configBaseKey = "$base"
+
+func isConfigFile(name string) bool {
+	ext := filepath.Ext(name)
+
+	return ext == ".json" || ext == ".jsonc"
+}
+
+// isBaseConfigFile returns whether the file is only a base for other files, i.e. the name
+// ends with .base.json or .base.jsonc.
+func isBaseConfigFile(name string) bool {
+	return filepath.Ext(strings.TrimSuffix(name, filepath.Ext(name))) == ".base"
+}
+
+// configFileID returns the default ID of the process of a config file, i.e. the name
+// without the extension.
+func configFileID(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// readConfigFile reads the process config from the file with the name in the directory. The
+// file may contain comments like in JavaScript, i.e. "// ..." and "/* ... */". A config can
+// be layered on a base config with the key "$base", that contains the path of the base file
+// relative to the directory. The config is merged into the base config, see mergeConfigJSON.
+// Base files can be layered on other base files. Files with the extension .base.json or
+// .base.jsonc in the directory are not loaded as processes.
+func readConfigFile(dir, name string) (*app.Config, error) {
+	layers, err := readConfigLayers(dir, name, map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(layers)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &app.Config{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return config, nil
+}
+
+// readConfigLayers reads the file and the base files it is layered on, and returns the
+// merged JSON. The names of the already visited files are used to detect cycles.
+func readConfigLayers(dir, name string, visited map[string]bool) (map[string]interface{}, error) {
+	path := filepath.Clean(filepath.Join(dir, name))
+
+	if visited[path] {
+		return nil, fmt.Errorf("cyclic base config '%s'", name)
+	}
+
+	visited[path] = true
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	layer := map[string]interface{}{}
+
+	if err := json.Unmarshal(stripJSONComments(data), &layer); err != nil {
+		if len(visited) > 1 {
+			return nil, fmt.Errorf("invalid JSON in base config '%s': %w", name, err)
+		}
+
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	base, ok := layer[configBaseKey]
+	if !ok {
+		return layer, nil
+	}
+
+	delete(layer, configBaseKey)
+
+	basename, ok := base.(string)
+	if !ok || len(basename) == 0 {
+		return nil, fmt.Errorf("the value of '%s' must be the name of a file", configBaseKey)
+	}
+
+	baselayer, err := readConfigLayers(dir, basename, visited)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeConfigJSON(baselayer, layer), nil
+}
+
+// mergeConfigJSON merges the override into the base config, both decoded from JSON. Objects
+// are merged recursively and a null value in the override removes the key. Arrays of objects
+// that all have an "id", e.g. the inputs and outputs, are merged by the ID, where the elements
+// with a new ID are appended. All other values, including other arrays like the options,
+// are replaced. The base is modified.
+func mergeConfigJSON(base, override map[string]interface{}) map[string]interface{} {
+	for key, value := range override {
+		if value == nil {
+			delete(base, key)
+			continue
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if b, ok := base[key].(map[string]interface{}); ok {
+				base[key] = mergeConfigJSON(b, v)
+				continue
+			}
+		case []interface{}:
+			if b, ok := base[key].([]interface{}); ok {
+				if merged, ok := mergeConfigJSONByID(b, v); ok {
+					base[key] = merged
+					continue
+				}
+			}
+		}
+
+		base[key] = value
+	}
+
+	return base
+}
+
+// mergeConfigJSONByID merges the elements of the override into the elements of the base with
+// the same ID. It returns false if not all elements are objects with an ID.
+func mergeConfigJSONByID(base, override []interface{}) ([]interface{}, bool) {
+	index := map[string]int{}
+
+	for i, e := range base {
+		id, ok := configJSONElementID(e)
+		if !ok {
+			return nil, false
+		}
+
+		index[id] = i
+	}
+
+	for _, e := range override {
+		if _, ok := configJSONElementID(e); !ok {
+			return nil, false
+		}
+	}
+
+	for _, e := range override {
+		id, _ := configJSONElementID(e)
+
+		if i, ok := index[id]; ok {
+			base[i] = mergeConfigJSON(base[i].(map[string]interface{}), e.(map[string]interface{}))
+			continue
+		}
+
+		index[id] = len(base)
+		base = append(base, e)
+	}
+
+	return base, true
+}
+
+func configJSONElementID(e interface{}) (string, bool) {
+	m, ok := e.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+
+	id, ok := m["id"].(string)
+
+	return id, ok
+}
+
+// stripJSONComments replaces the comments "// ..." and "/* ... */" outside of strings with
+// spaces, such that the positions in error messages stay the same.
+func stripJSONComments(data []byte) []byte {
+	stripped := make([]byte, len(data))
+	copy(stripped, data)
+
+	inString := false
+
+	for i := 0; i < len(stripped); i++ {
+		c := stripped[i]
+
+		if inString {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+
+			continue
+		}
+
+		if c == '"' {
+			inString = true
+			continue
+		}
+
+		if c != '/' || i+1 >= len(stripped) {
+			continue
+		}
+
+		switch stripped[i+1] {
+		case '/':
+			for ; i < len(stripped) && stripped[i] != '\n'; i++ {
+				stripped[i] = ' '
+			}
+		case '*':
+			stripped[i], stripped[i+1] = ' ', ' '
+			i += 2
+
+			for ; i < len(stripped); i++ {
+				if stripped[i] == '*' && i+1 < len(stripped) && stripped[i+1] == '/' {
+					stripped[i], stripped[i+1] = ' ', ' '
+					i++
+					break
+				}
+
+				if stripped[i] != '\n' {
+					stripped[i] = ' '
+				}
+			}
+		}
+	}
+
+	return stripped
+}
+
+// applyConfigDir adds or updates the processes from the files in the directory and records
+// the errors per file name. All files are read first, such that the processes are added or
+// updated after the processes they reference, regardless of the order of the files. Processes of files that can't be read are never removed, neither the process
+// the file has been loaded as before nor the process with the default ID of the file. If
+// autostart is false, added processes will be started only with Start. The lock must be
+// held by the caller.
+func (r *restream) applyConfigDir(dir string, files []configFile, errs map[string]error, reconcile, autostart bool) {
+	keep := map[string]string{}
+	loaded := map[string]string{}
+	pending := map[string]*app.Process{}
+	names := map[string]configFile{}
+
+	for _, f := range files {
+		if f.config == nil {
+			keep[configFileID(f.name)] = f.name
+
+			if id, ok := r.configDir.files[f.path]; ok {
+				keep[id] = f.name
+				loaded[f.path] = id
+			}
+
+			continue
+		}
+
+		id := f.config.ID
+
+		if name, ok := keep[id]; ok {
+			errs[f.name] = fmt.Errorf("the ID '%s' is already used by '%s'", id, name)
+			continue
+		}
+
+		keep[id] = f.name
+		loaded[f.path] = id
+
+		r.setFFVersion(f.config)
+
+		if t, ok := r.tasks[id]; ok {
+			// The stored config is a clone, compare it to a clone as well
+			current, _ := json.Marshal(t.process.Config)
+			changed, _ := json.Marshal(f.config.Clone())
+
+			if bytes.Equal(current, changed) {
+				continue
+			}
+		}
+
+		pending[id] = &app.Process{Config: f.config}
+		names[id] = f
+	}
+
+	// A process waits for the processes it references, unless none of the processes
+	// can be added or updated this way, e.g. because of a circular reference.
+	wait := true
+	failed := map[string]error{}
+
+	for len(pending) != 0 {
+		applied := false
+
+		for _, id := range sortedIDs(pending) {
+			config := pending[id].Config
+
+			if wait && referencesAny(config, pending) {
+				continue
+			}
+
+			if err := r.applyConfigFile(config, names[id].name, autostart); err != nil {
+				failed[id] = err
+				continue
+			}
+
+			delete(pending, id)
+			applied = true
+		}
+
+		if applied {
+			wait = true
+			continue
+		}
+
+		if wait {
+			wait = false
+			continue
+		}
+
+		// None of the remaining processes can be added or updated
+		for id := range pending {
+			f := names[id]
+
+			errs[f.name] = failed[id]
+
+			if _, ok := r.tasks[id]; !ok {
+				delete(loaded, f.path)
+			}
+		}
+
+		break
+	}
+
+	if reconcile {
+		for id, t := range r.tasks {
+			if _, ok := keep[id]; ok {
+				continue
+			}
+
+			r.stopProcess(id)
+			t.process.Order = "stop"
+
+			if err := r.deleteProcess(id); err != nil {
+				r.logger.Warn().WithField("id", id).WithError(err).Log("Removing process failed")
+				continue
+			}
+
+			r.logger.Info().WithField("id", id).Log("Removed process")
+		}
+	}
+
+	for path := range r.configDir.files {
+		if filepath.Dir(path) == filepath.Clean(dir) {
+			delete(r.configDir.files, path)
+		}
+	}
+
+	for path, id := range loaded {
+		r.configDir.files[path] = id
+	}
+
+	r.syncOnDemand()
+	r.startQueued()
+
+	r.save()
+}
+
+// applyConfigFile updates the process with the ID of the config or adds it, if it doesn't
+// exist. If autostart is false, an added process will be started only with Start. The lock
+// must be held by the caller.
+func (r *restream) applyConfigFile(config *app.Config, name string, autostart bool) error {
+	id := config.ID
+
+	logger := r.logger.WithField("id", id).WithField("file", name)
+
+	if _, ok := r.tasks[id]; ok {
+		if err := r.updateProcess(id, config); err != nil {
+			return err
+		}
+
+		logger.Info().Log("Updated process")
+
+		return nil
+	}
+
+	if r.idInUse(id) {
+		return ErrProcessExists
+	}
+
+	t, err := r.createTask(config)
+	if err != nil {
+		return err
+	}
+
+	r.tasks[t.id] = t
+
+	r.setCleanup(t.id, t.config)
+
+	if autostart && t.process.Order == "start" {
+		if err := r.autostartProcess(t, 0); err != nil {
+			logger.Warn().WithError(err).Log("Starting process failed")
+		}
+	}
+
+	logger.Info().Log("Added process")
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package restream
+
+import (
+	"sort"
+
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// Device is a device for hardware acceleration, e.g. a GPU. Processes are assigned to
+// a device with the Device field of their config.
+type Device struct {
+	ID       string // ID of the device the processes refer to, e.g. "gpu0"
+	HWAccel  string // Hardware acceleration method, the value for -hwaccel, e.g. "cuda"
+	Device   string // Device for the method, the value for -hwaccel_device, e.g. "0". Optional
+	Capacity int    // Maximum number of processes running on the device at the same time, unlimited if 0
+}
+
+// setDeviceOptions adds the options for the hardware acceleration device of the process
+// in front of the options of each input, such that the inputs are decoded on the device.
+func (r *restream) setDeviceOptions(config *app.Config) {
+	device, ok := r.devices[config.Device]
+	if !ok {
+		return
+	}
+
+	options := []string{"-hwaccel", device.HWAccel}
+
+	if len(device.Device) != 0 {
+		options = append(options, "-hwaccel_device", device.Device)
+	}
+
+	for i, input := range config.Input {
+		input.Options = append(append([]string{}, options...), input.Options...)
+		config.Input[i] = input
+	}
+}
+
+// deviceFull returns whether the device of the task doesn't have a free slot for the
+// task. The caller must hold the lock.
+func (r *restream) deviceFull(t *task) bool {
+	device, ok := r.devices[t.config.Device]
+	if !ok || device.Capacity <= 0 {
+		return false
+	}
+
+	return len(r.deviceProcesses(device.ID, t)) >= device.Capacity
+}
+
+// deviceProcesses returns the sorted IDs of the processes that occupy a slot on the device,
+// except the one of the given task, with the same rules as for the limit of concurrently
+// running processes, and the processes that are tested on the device. The caller must hold the lock.
+func (r *restream) deviceProcesses(id string, except *task) []string {
+	ids := []string{}
+
+	for _, t := range r.tasks {
+		if t == except || t.config.Device != id || !occupiesSlot(t) {
+			continue
+		}
+
+		ids = append(ids, t.id)
+	}
+
+	// The processes that are currently tested are running as well
+	for _, t := range r.tests {
+		if t == except || t.config.Device != id {
+			continue
+		}
+
+		ids = append(ids, t.id)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
@@ -0,0 +1,103 @@
+package restream
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/datarhei/core/v16/restream/app"
+	"github.com/datarhei/core/v16/restream/replace"
+)
+
+// maxEachElements is the max. number of elements an output can be expanded for
+const maxEachElements = 16
+
+var eachKeyRe = regexp.MustCompile(`^[a-z]+$`)
+
+// expandOutputs replaces each output that has a list of elements by one output per element,
+// e.g. for the renditions of an ABR ladder. The placeholders {each:key} in the ID, the address,
+// the options, and the patterns of the cleanup rules are replaced by the values of the element,
+// see replace.Replacer.ReplaceEach. The placeholder {each:index} is the index of the element,
+// unless the element has a value for "index". The ID of each expanded output must be unique.
+// The config will be modified in place, but only if all outputs can be expanded.
+func expandOutputs(config *app.Config, r replace.Replacer) error {
+	outputs := make([]app.ConfigIO, 0, len(config.Output))
+	ids := map[string]bool{}
+
+	for _, output := range config.Output {
+		if len(output.Each) == 0 {
+			ids[output.ID] = true
+			outputs = append(outputs, output)
+			continue
+		}
+
+		if len(output.Each) > maxEachElements {
+			return fmt.Errorf("the output '%s' can be expanded for max. %d elements", output.ID, maxEachElements)
+		}
+
+		for i, e := range output.Each {
+			element := map[string]string{
+				"index": strconv.Itoa(i),
+			}
+
+			for key, value := range e {
+				if !eachKeyRe.MatchString(key) {
+					return fmt.Errorf("the output '%s' has an invalid key '%s', only the letters a-z are allowed", output.ID, key)
+				}
+
+				element[key] = value
+			}
+
+			expanded, err := expandOutput(output, element, r)
+			if err != nil {
+				return fmt.Errorf("the output '%s' can't be expanded for element %d: %w", output.ID, i, err)
+			}
+
+			if expanded.ID == output.ID {
+				return fmt.Errorf("the ID of the output '%s' must contain an {each:...} placeholder", output.ID)
+			}
+
+			if ids[expanded.ID] {
+				return fmt.Errorf("the output '%s' expands to the duplicate ID '%s'", output.ID, expanded.ID)
+			}
+
+			ids[expanded.ID] = true
+			outputs = append(outputs, expanded)
+		}
+	}
+
+	config.Output = outputs
+
+	return nil
+}
+
+func expandOutput(output app.ConfigIO, element map[string]string, r replace.Replacer) (app.ConfigIO, error) {
+	var err error
+
+	expanded := output.Clone()
+	expanded.Each = nil
+
+	if expanded.ID, err = r.ReplaceEach(expanded.ID, element); err != nil {
+		return expanded, fmt.Errorf("ID: %w", err)
+	}
+
+	if expanded.Address, err = r.ReplaceEach(expanded.Address, element); err != nil {
+		return expanded, fmt.Errorf("address: %w", err)
+	}
+
+	for i, option := range expanded.Options {
+		if expanded.Options[i], err = r.ReplaceEach(option, element); err != nil {
+			return expanded, fmt.Errorf("options: %w", err)
+		}
+	}
+
+	for i, cleanup := range expanded.Cleanup {
+		if cleanup.Pattern, err = r.ReplaceEach(cleanup.Pattern, element); err != nil {
+			return expanded, fmt.Errorf("cleanup: %w", err)
+		}
+
+		expanded.Cleanup[i] = cleanup
+	}
+
+	return expanded, nil
+}
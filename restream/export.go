@@ -0,0 +1,352 @@
+package restream
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// exportVersion is the version of the format of app.Export
+const exportVersion = 1
+
+// metadataBuiltinTypes are the types of metadata that can always be restored by an
+// import. Other types have to be registered with RegisterMetadataType.
+var metadataBuiltinTypes = []interface{}{
+	"",
+	false,
+	float64(0),
+	float32(0),
+	int(0),
+	int8(0),
+	int16(0),
+	int32(0),
+	int64(0),
+	uint(0),
+	uint8(0),
+	uint16(0),
+	uint32(0),
+	uint64(0),
+	[]byte{},
+	[]string{},
+	[]interface{}{},
+	map[string]interface{}{},
+	map[string]string{},
+	json.RawMessage{},
+	app.Config{},
+}
+
+// registerMetadataGoType remembers the type of v and the pointer to it, such that metadata of
+// these types can be restored by an import. The caller must hold the lock.
+func (r *restream) registerMetadataGoType(v interface{}) {
+	if r.metadataTypes == nil {
+		r.metadataTypes = make(map[string]reflect.Type)
+	}
+
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return
+	}
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	r.metadataTypes[t.String()] = t
+	r.metadataTypes[reflect.PtrTo(t).String()] = reflect.PtrTo(t)
+}
+
+// Export returns the configuration and the order of all processes, the metadata of
+// the processes, and the general metadata. The metadata is stored JSON encoded with
+// the name of its type.
+func (r *restream) Export() (app.Export, error) {
+	data := app.Export{
+		Version: exportVersion,
+		Process: map[string]app.ExportProcess{},
+	}
+
+	data.Metadata.System = map[string]app.ExportMetadata{}
+	data.Metadata.Process = map[string]map[string]app.ExportMetadata{}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for id, t := range r.tasks {
+		data.Process[id] = app.ExportProcess{
+			Config: t.process.Config.Clone(),
+			Order:  t.process.Order,
+		}
+
+		if len(t.metadata) == 0 {
+			continue
+		}
+
+		metadata, err := exportMetadata(t.metadata)
+		if err != nil {
+			return app.Export{}, fmt.Errorf("process '%s': %w", id, err)
+		}
+
+		data.Metadata.Process[id] = metadata
+	}
+
+	metadata, err := exportMetadata(r.metadata)
+	if err != nil {
+		return app.Export{}, err
+	}
+
+	data.Metadata.System = metadata
+
+	return data, nil
+}
+
+func exportMetadata(metadata map[string]interface{}) (map[string]app.ExportMetadata, error) {
+	export := map[string]app.ExportMetadata{}
+
+	for key, data := range metadata {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode the metadata for the key '%s': %w", key, err)
+		}
+
+		export[key] = app.ExportMetadata{
+			Type: reflect.TypeOf(data).String(),
+			Data: raw,
+		}
+	}
+
+	return export, nil
+}
+
+// Import adds the processes and sets the metadata from a previous export. None of the processes
+// must exist already. The processes are added without starting them, regardless of their autostart
+// setting, and afterwards the processes with the exported order "start" will be started. If any of
+// this fails, all changes are rolled back. The metadata is restored with its original type if it
+// is a builtin type, an *app.Config, or has been registered with RegisterMetadataType. Otherwise,
+// e.g. for unregistered structs, the metadata is restored as generic JSON values, i.e.
+// map[string]interface{}, []interface{}, float64, and so on, that can be converted with
+// GetMetadataInto or GetProcessMetadataInto.
+func (r *restream) Import(data app.Export) error {
+	if data.Version != exportVersion {
+		return fmt.Errorf("unsupported version of the export: %d", data.Version)
+	}
+
+	if !r.allowChange() {
+		return ErrRateLimited
+	}
+
+	ids := make([]string, 0, len(data.Process))
+
+	for id, p := range data.Process {
+		if p.Config == nil {
+			return fmt.Errorf("process '%s': the config is missing", id)
+		}
+
+		if p.Config.ID != id {
+			return fmt.Errorf("process '%s': the ID of the config doesn't match (%s)", id, p.Config.ID)
+		}
+
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	r.lock.RLock()
+	tasks, system, processMetadata, err := r.prepareImport(ids, data)
+	r.lock.RUnlock()
+
+	if err != nil {
+		r.lock.Lock()
+		r.rollbackImport(tasks, nil)
+		r.unlock()
+
+		return err
+	}
+
+	if r.mapcheck != 0 {
+		for _, id := range ids {
+			probe := r.probeTask(tasks[id], app.ProbeOptions{Timeout: r.mapcheck})
+			if err := validateStreamMapping(tasks[id].config, probe); err != nil {
+				r.lock.Lock()
+				r.rollbackImport(tasks, nil)
+				r.unlock()
+
+				return fmt.Errorf("process '%s': %w", id, err)
+			}
+		}
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.dirty = true
+
+	for _, id := range ids {
+		if r.idInUse(id) {
+			r.rollbackImport(tasks, nil)
+			return fmt.Errorf("process '%s': %w", id, ErrProcessExists)
+		}
+	}
+
+	for _, id := range ids {
+		t := tasks[id]
+
+		t.process.Order = "stop"
+
+		for key, value := range processMetadata[id] {
+			setTaskMetadata(t, key, value)
+		}
+
+		r.tasks[id] = t
+		r.setCleanup(id, t.config)
+	}
+
+	// Remember the previous general metadata in order to restore it on rollback
+	previous := map[string]interface{}{}
+
+	for key, value := range system {
+		previous[key] = r.metadata[key]
+
+		if r.metadata == nil {
+			r.metadata = make(map[string]interface{})
+		}
+
+		r.metadata[key] = value
+	}
+
+	for _, id := range ids {
+		// On-demand processes are started by the processes referencing them
+		if data.Process[id].Order != "start" || tasks[id].config.OnDemand {
+			continue
+		}
+
+		if err := r.startProcess(id); err != nil {
+			r.rollbackImport(tasks, previous)
+			return fmt.Errorf("process '%s': %w", id, err)
+		}
+	}
+
+	r.syncOnDemand()
+	r.startQueued()
+
+	r.save()
+
+	return nil
+}
+
+// prepareImport creates the tasks for the processes with the given IDs and decodes the
+// exported metadata, without adding anything. The created tasks are returned even in case
+// of an error, such that they can be rolled back. The caller must hold the lock.
+func (r *restream) prepareImport(ids []string, data app.Export) (map[string]*task, map[string]interface{}, map[string]map[string]interface{}, error) {
+	tasks := map[string]*task{}
+
+	for _, id := range ids {
+		if _, ok := r.tasks[id]; ok {
+			return tasks, nil, nil, fmt.Errorf("process '%s': %w", id, ErrProcessExists)
+		}
+
+		t, err := r.createTask(data.Process[id].Config.Clone())
+		if err != nil {
+			return tasks, nil, nil, fmt.Errorf("process '%s': %w", id, err)
+		}
+
+		tasks[id] = t
+	}
+
+	system, err := r.importMetadata(data.Metadata.System)
+	if err != nil {
+		return tasks, nil, nil, err
+	}
+
+	processMetadata := map[string]map[string]interface{}{}
+	for id, m := range data.Metadata.Process {
+		if _, ok := tasks[id]; !ok {
+			return tasks, nil, nil, fmt.Errorf("process '%s': %w", id, ErrUnknownProcess)
+		}
+
+		metadata, err := r.importMetadata(m)
+		if err != nil {
+			return tasks, nil, nil, fmt.Errorf("process '%s': %w", id, err)
+		}
+
+		processMetadata[id] = metadata
+	}
+
+	return tasks, system, processMetadata, nil
+}
+
+// rollbackImport removes the imported tasks and restores the previous general metadata,
+// where a nil value means that the key didn't exist. The caller must hold the lock.
+func (r *restream) rollbackImport(tasks map[string]*task, previous map[string]interface{}) {
+	for id, t := range tasks {
+		if r.tasks[id] == t {
+			r.stopProcess(id)
+			r.unsetCleanup(id)
+			delete(r.tasks, id)
+		}
+
+		r.unsetPlayoutPorts(t)
+	}
+
+	for key, value := range previous {
+		if value == nil {
+			delete(r.metadata, key)
+		} else {
+			r.metadata[key] = value
+		}
+	}
+
+	if len(r.metadata) == 0 {
+		r.metadata = nil
+	}
+}
+
+// importMetadata decodes the exported metadata into its original type, if the type
+// is known, and validates it. Empty values are dropped. The caller must hold the lock.
+func (r *restream) importMetadata(export map[string]app.ExportMetadata) (map[string]interface{}, error) {
+	metadata := map[string]interface{}{}
+
+	for key, m := range export {
+		t, ok := r.metadataTypes[m.Type]
+		if !ok {
+			var data interface{}
+			if err := json.Unmarshal(m.Data, &data); err != nil {
+				return nil, fmt.Errorf("failed to decode the metadata for the key '%s': %w", key, err)
+			}
+
+			metadata[key] = data
+			continue
+		}
+
+		var v reflect.Value
+		if t.Kind() == reflect.Ptr {
+			v = reflect.New(t.Elem())
+		} else {
+			v = reflect.New(t)
+		}
+
+		if err := json.Unmarshal(m.Data, v.Interface()); err != nil {
+			return nil, fmt.Errorf("failed to decode the metadata for the key '%s' as %s: %w", key, m.Type, err)
+		}
+
+		if t.Kind() != reflect.Ptr {
+			v = v.Elem()
+		}
+
+		metadata[key] = v.Interface()
+	}
+
+	for key, data := range metadata {
+		if data == nil {
+			delete(metadata, key)
+			continue
+		}
+
+		if err := r.validateMetadata(key, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return metadata, nil
+}
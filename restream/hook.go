@@ -0,0 +1,280 @@
+package restream
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/datarhei/core/v16/ffmpeg/parse"
+	"github.com/datarhei/core/v16/process"
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// Timeout for a hook if none is given in its config.
+const hookDefaultTimeout = 30 * time.Second
+
+// hookParser wraps a parser in order to write the output of the hooks to the
+// log of a process. The output of the pre-start hook is kept until the log
+// has been reset for the new run of the process.
+type hookParser struct {
+	parse.Parser
+
+	pending []string
+	lock    sync.Mutex
+}
+
+// newHookParser wraps the parser of the task if it has any hooks defined.
+func newHookParser(t *task, parser parse.Parser) parse.Parser {
+	t.hooks = nil
+
+	if t.config.PreStart == nil && t.config.PostStop == nil {
+		return parser
+	}
+
+	t.hooks = &hookParser{
+		Parser: parser,
+	}
+
+	return t.hooks
+}
+
+// deferLines adds the lines to the log after the next reset of the log.
+func (p *hookParser) deferLines(lines []string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.pending = append(p.pending, lines...)
+}
+
+// addLines adds the lines to the current log.
+func (p *hookParser) addLines(lines []string) {
+	for _, line := range lines {
+		p.Parser.Parse(line)
+	}
+}
+
+func (p *hookParser) ResetLog() {
+	p.Parser.ResetLog()
+
+	p.lock.Lock()
+	lines := p.pending
+	p.pending = nil
+	p.lock.Unlock()
+
+	p.addLines(lines)
+}
+
+// runHook executes the command of the hook with the environment and working directory of
+// the process. It returns the output of the command, each line prefixed with the name of the
+// hook. The command will be killed if it doesn't finish within its timeout.
+func runHook(name string, hook *app.ConfigHook, config *app.Config) ([]string, error) {
+	timeout := hookDefaultTimeout
+	if hook.Timeout != 0 {
+		timeout = time.Duration(hook.Timeout) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, hook.Binary, hook.Args...)
+	cmd.Dir = config.WorkingDir
+
+	// The same environment as for the process
+	cmd.Env = process.Environment(config.Environment)
+
+	// The output is written to a file instead of a pipe. Otherwise waiting for the
+	// command would block until all of its children closed the pipe, even after
+	// the command itself has been killed because of the timeout.
+	output, err := os.CreateTemp("", "core-hook-")
+	if err != nil {
+		return nil, fmt.Errorf("%s hook failed: %w", name, err)
+	}
+
+	defer func() {
+		output.Close()
+		os.Remove(output.Name())
+	}()
+
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err = cmd.Run()
+
+	lines := []string{}
+
+	if _, serr := output.Seek(0, io.SeekStart); serr == nil {
+		scanner := bufio.NewScanner(output)
+		for scanner.Scan() {
+			lines = append(lines, "["+name+"] "+scanner.Text())
+		}
+	}
+
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+
+		err = fmt.Errorf("%s hook failed: %w", name, err)
+		lines = append(lines, "["+name+"] "+err.Error())
+	}
+
+	return lines, err
+}
+
+// hookRun is a pending run of the pre-start hook of a task.
+type hookRun struct {
+	done bool // Whether the hook succeeded and the process can be started
+}
+
+// runPreStart runs the pre-start hook of the task in the background, if it has one that hasn't
+// succeeded for this start yet. It returns whether the start has to wait for the hook. After
+// the hook succeeded, the process will be started again, unless it has been stopped or replaced
+// in the meantime. If the hook fails, the process isn't started. The output of the hook will be
+// part of the log of the next run of the process, or of the current log if the hook failed. The
+// caller must hold the lock.
+func (r *restream) runPreStart(t *task) bool {
+	if t.config.PreStart == nil {
+		return false
+	}
+
+	if run := t.prestart; run != nil {
+		if !run.done {
+			return true
+		}
+
+		t.prestart = nil
+
+		return false
+	}
+
+	run := &hookRun{}
+
+	t.prestart = run
+	t.hookErr = nil
+
+	if t.process.Order != "start" {
+		t.process.Version++
+	}
+
+	t.process.Order = "start"
+
+	// A waiting process doesn't hold a place in the queue, it will be queued
+	// again after the hook succeeded if there's no free slot.
+	r.dequeue(t)
+
+	id, hook, config, hooks := t.id, t.config.PreStart, t.config, t.hooks
+
+	go func() {
+		lines, err := runHook("prestart", hook, config)
+
+		r.lock.Lock()
+		defer r.unlock()
+
+		// The start has been cancelled or the process has been replaced in the meantime
+		if t.prestart != run || r.tasks[id] != t {
+			return
+		}
+
+		r.dirty = true
+
+		if err != nil {
+			t.prestart = nil
+			t.hookErr = err
+
+			if hooks != nil {
+				hooks.addLines(lines)
+			}
+
+			r.logger.Warn().WithField("id", id).WithError(err).Log("Not starting process")
+
+			return
+		}
+
+		if hooks != nil {
+			hooks.deferLines(lines)
+		}
+
+		run.done = true
+
+		if err := r.startProcess(id); err != nil {
+			r.logger.Warn().WithField("id", id).WithError(err).Log("Starting process after the pre-start hook failed")
+		}
+
+		r.syncOnDemand()
+		r.startQueued()
+
+		r.save()
+	}()
+
+	return true
+}
+
+// cancelPreStart cancels a pending pre-start hook, such that the process will not be
+// started after the hook finished. The caller must hold the lock.
+func (r *restream) cancelPreStart(t *task) {
+	t.prestart = nil
+	t.hookErr = nil
+}
+
+// hookCallbacks returns the callbacks for the process of the task. The pre-start hook runs
+// before each automatic restart of the process, such that it runs before every start, and
+// the post-stop hook runs after every exit of the process, i.e. also if the process exited
+// by itself. The output of the post-stop hook will be added to the log of the last run of
+// the process. The caller must hold the lock.
+func (r *restream) hookCallbacks(t *task) (func() error, func()) {
+	id, config, hooks := t.id, t.config, t.hooks
+
+	var onRestart func() error
+
+	if config.PreStart != nil {
+		onRestart = func() error {
+			lines, err := runHook("prestart", config.PreStart, config)
+
+			if hooks != nil {
+				if err != nil {
+					hooks.addLines(lines)
+				} else {
+					hooks.deferLines(lines)
+				}
+			}
+
+			r.lock.Lock()
+			defer r.unlock()
+
+			// The process might have been replaced in the meantime
+			if r.tasks[id] == t {
+				r.dirty = true
+				t.hookErr = err
+			}
+
+			if err != nil {
+				r.logger.Warn().WithField("id", id).WithError(err).Log("Not restarting process")
+			}
+
+			return err
+		}
+	}
+
+	onExit := func() {
+		if config.PostStop != nil {
+			lines, err := runHook("poststop", config.PostStop, config)
+			if hooks != nil {
+				hooks.addLines(lines)
+			}
+
+			if err != nil {
+				r.logger.Warn().WithField("id", id).WithError(err).Log("Running hook")
+			}
+		}
+
+		r.onExit()
+	}
+
+	return onRestart, onExit
+}
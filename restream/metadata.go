@@ -0,0 +1,107 @@
+package restream
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/invopop/jsonschema"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+func (r *restream) RegisterMetadataSchema(key string, schema []byte) error {
+	if len(key) == 0 {
+		return fmt.Errorf("a key for the schema has to be provided")
+	}
+
+	s, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(schema))
+	if err != nil {
+		return fmt.Errorf("invalid schema for the key '%s': %w", key, err)
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	if r.metadataSchemas == nil {
+		r.metadataSchemas = make(map[string]*gojsonschema.Schema)
+	}
+
+	r.metadataSchemas[key] = s
+
+	return nil
+}
+
+func (r *restream) RegisterMetadataType(key string, v interface{}) error {
+	schema, err := jsonschema.Reflect(v).MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to create schema for the key '%s': %w", key, err)
+	}
+
+	if err := r.RegisterMetadataSchema(key, schema); err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	r.registerMetadataGoType(v)
+	r.unlock()
+
+	return nil
+}
+
+// validateMetadata checks the data against the schema that has been registered
+// for the key. Data for keys without a schema is always valid.
+func (r *restream) validateMetadata(key string, data interface{}) error {
+	schema, ok := r.metadataSchemas[key]
+	if !ok {
+		return nil
+	}
+
+	result, err := schema.Validate(gojsonschema.NewGoLoader(data))
+	if err != nil {
+		return fmt.Errorf("failed to validate the metadata for the key '%s': %w", key, err)
+	}
+
+	if !result.Valid() {
+		errors := []string{}
+		for _, e := range result.Errors() {
+			errors = append(errors, e.String())
+		}
+
+		return fmt.Errorf("the metadata for the key '%s' doesn't conform to the schema: %s", key, strings.Join(errors, "; "))
+	}
+
+	return nil
+}
+
+func (r *restream) GetProcessMetadataInto(id, key string, v interface{}) error {
+	data, err := r.GetProcessMetadata(id, key)
+	if err != nil {
+		return err
+	}
+
+	return convertMetadata(key, data, v)
+}
+
+func (r *restream) GetMetadataInto(key string, v interface{}) error {
+	data, err := r.GetMetadata(key)
+	if err != nil {
+		return err
+	}
+
+	return convertMetadata(key, data, v)
+}
+
+// convertMetadata converts the stored data into v via its JSON representation, such that
+// it doesn't matter whether the data has been set directly or has been loaded from the store.
+func convertMetadata(key string, data, v interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode the metadata for the key '%s': %w", key, err)
+	}
+
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("failed to decode the metadata for the key '%s': %w", key, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package restream
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/datarhei/core/v16/process"
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// Reasons why a process that should be running isn't running, in addition
+// to the reasons of the process package, e.g. process.ReasonBinaryNotFound.
+const (
+	ReasonQueued             = "queued"              // The process is waiting for a free slot
+	ReasonBackoff            = "backoff"             // The process exited and will be restarted after the reconnect delay
+	ReasonFailedMaxRestarts  = "failed_max_restarts" // The process has been restarted too often and the restarts are paused
+	ReasonAddressUnreachable = "address_unreachable" // An input or output couldn't be connected to
+	ReasonDrained            = "drained"             // The process has been stopped by draining and waits for undraining
+	ReasonPreStartFailed     = "prestart_failed"     // The pre-start hook of the process failed
+)
+
+// reUnreachable matches the log lines of ffmpeg about addresses that can't be connected to.
+var reUnreachable = regexp.MustCompile(`(?i)(connection refused|connection timed out|no route to host|network is unreachable|name or service not known|failed to resolve hostname|server returned [45][0-9][0-9])`)
+
+// taskReason returns the reason and a description why a task whose order is "start"
+// isn't running. The reason is empty if the task is running or shouldn't be running.
+func taskReason(t *task, status process.Status, state *app.State) (string, string) {
+	if state.Order != "start" {
+		return "", ""
+	}
+
+	if t.process.Drained {
+		return ReasonDrained, "the process has been stopped by draining"
+	}
+
+	if t.queued {
+		return ReasonQueued, fmt.Sprintf("waiting for a free slot at position %d of the queue", state.QueuePosition)
+	}
+
+	// A pending delayed start or pre-start hook is not a failure
+	if t.start != nil || t.prestart != nil || t.ffmpeg.IsRunning() {
+		return "", ""
+	}
+
+	if t.hookErr != nil {
+		return ReasonPreStartFailed, t.hookErr.Error()
+	}
+
+	reason, detail := status.Reason, status.Detail
+
+	if len(reason) == 0 && reUnreachable.MatchString(state.LastLog) {
+		reason, detail = ReasonAddressUnreachable, state.LastLog
+	}
+
+	if status.Breaker {
+		d := fmt.Sprintf("%d restarts within the restart window", state.Restarts)
+		if len(detail) != 0 {
+			d += ", last failure: " + detail
+		}
+
+		return ReasonFailedMaxRestarts, d
+	}
+
+	if state.Reconnect >= 0 {
+		d := fmt.Sprintf("restarting in %.0f seconds", state.Reconnect)
+
+		if len(reason) == 0 {
+			return ReasonBackoff, d
+		}
+
+		detail += ", " + d
+	}
+
+	return reason, detail
+}
@@ -1,9 +1,12 @@
 package replace
 
 import (
+	"fmt"
 	"net/url"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/datarhei/core/v16/glob"
 	"github.com/datarhei/core/v16/restream/app"
@@ -19,8 +22,11 @@ type Replacer interface {
 	RegisterTemplate(placeholder, template string, defaults map[string]string)
 
 	// RegisterTemplateFunc does the same as RegisterTemplate, but the template
-	// is returned by the template function.
-	RegisterTemplateFunc(placeholder string, template TemplateFn, defaults map[string]string)
+	// is returned by the template function. The template can be restricted to the
+	// given sections, e.g. "output". In other sections the placeholder will not be
+	// replaced and CheckSection returns an error. Without sections, the template
+	// is allowed in all sections.
+	RegisterTemplateFunc(placeholder string, template TemplateFn, defaults map[string]string, sections ...string)
 
 	// Replace replaces all occurences of placeholder in str with value. The placeholder is of the
 	// form {placeholder}. It is possible to escape a characters in value with \\ by appending a ^
@@ -33,18 +39,84 @@ type Replacer interface {
 	// A placeholder name may consist on of the letters a-z and ':'. The placeholder may contain
 	// a glob pattern to find the appropriate template.
 	Replace(str, placeholder, value string, vars map[string]string, config *app.Config, section string) string
+
+	// SetEnvironment enables the substitution of variables of the form ${VAR} by ReplaceEnvironment
+	// with the values from env. In strict mode, variables that are not in env are an error. Otherwise
+	// they are left untouched. A nil env disables the substitution.
+	SetEnvironment(env map[string]string, strict bool)
+
+	// ReplaceEnvironment replaces all variables of the form ${VAR} in str with the values of the
+	// environment as provided by SetEnvironment. If the substitution is disabled, str is returned as is.
+	ReplaceEnvironment(str string) (string, error)
+
+	// Preview returns str with all placeholders expanded as they would be in the given section
+	// ("global", "input", or "output") of the config, i.e. the environment variables, the placeholders
+	// {processid} and {reference}, and the placeholders with a registered template, including
+	// their parameters, the variables $processid and $reference, and the defaults. It is an error
+	// if a placeholder is unknown or a parameter of a template has neither a value nor a default.
+	// Placeholders that depend on an input or output, e.g. {inputid}, are unknown.
+	Preview(str string, config *app.Config, section string) (string, error)
+
+	// SetGlobals sets the values of the placeholders of the form {global:key}, e.g. {global:region},
+	// that are the same for all processes. The keys may only consist of the letters a-z. The
+	// placeholders are replaced by Replace with the value of the key, unless a template with the
+	// same name is registered. As for other placeholders without a template, unknown keys are
+	// replaced by the empty string. A nil globals removes all values. Processes have to be
+	// reloaded in order to use the changed values, see Restreamer.ReloadAll.
+	SetGlobals(globals map[string]string)
+
+	// ReplaceEach replaces all placeholders of the form {each:key} in str with the value of the key
+	// in the element, e.g. of the list of elements an output is expanded for. The key may only consist
+	// of the letters a-z. A character can be escaped as with Replace, e.g. {each:key^:}. It is an
+	// error if the element doesn't have the key.
+	ReplaceEach(str string, element map[string]string) (string, error)
+
+	// CheckSection returns an error if str contains a placeholder whose template is not
+	// allowed in the section, see RegisterTemplateFunc.
+	CheckSection(str, section string) error
 }
 
 type template struct {
 	fn       TemplateFn
 	defaults map[string]string
+	sections map[string]bool // Sections the template is allowed in, all sections if nil
+}
+
+// allowed returns whether the template is allowed in the section
+func (t template) allowed(section string) bool {
+	if t.sections == nil {
+		return true
+	}
+
+	return t.sections[section]
+}
+
+// allowedSections returns the sorted sections the template is allowed in
+func (t template) allowedSections() []string {
+	sections := []string{}
+	for section := range t.sections {
+		sections = append(sections, section)
+	}
+
+	sort.Strings(sections)
+
+	return sections
 }
 
 type replacer struct {
-	templates map[string]template
+	templates     map[string]template
+	templatesLock sync.RWMutex
+
+	globals     map[string]string
+	globalsLock sync.RWMutex
+
+	env       map[string]string
+	envStrict bool
+	envLock   sync.RWMutex
 
 	re         *regexp.Regexp
 	templateRe *regexp.Regexp
+	envRe      *regexp.Regexp
 }
 
 // New returns a Replacer
@@ -53,6 +125,7 @@ func New() Replacer {
 		templates:  make(map[string]template),
 		re:         regexp.MustCompile(`{([a-z:]+)(?:\^(.))?(?:,(.*?))?}`),
 		templateRe: regexp.MustCompile(`{([a-z:]+)}`),
+		envRe:      regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`),
 	}
 
 	return r
@@ -62,11 +135,32 @@ func (r *replacer) RegisterTemplate(placeholder, tmpl string, defaults map[strin
 	r.RegisterTemplateFunc(placeholder, func(*app.Config, string) string { return tmpl }, defaults)
 }
 
-func (r *replacer) RegisterTemplateFunc(placeholder string, templateFn TemplateFn, defaults map[string]string) {
-	r.templates[placeholder] = template{
+func (r *replacer) RegisterTemplateFunc(placeholder string, templateFn TemplateFn, defaults map[string]string, sections ...string) {
+	t := template{
 		fn:       templateFn,
 		defaults: defaults,
 	}
+
+	if len(sections) != 0 {
+		t.sections = make(map[string]bool, len(sections))
+		for _, section := range sections {
+			t.sections[section] = true
+		}
+	}
+
+	r.templatesLock.Lock()
+	defer r.templatesLock.Unlock()
+
+	r.templates[placeholder] = t
+}
+
+func (r *replacer) template(placeholder string) (template, bool) {
+	r.templatesLock.RLock()
+	defer r.templatesLock.RUnlock()
+
+	t, ok := r.templates[placeholder]
+
+	return t, ok
 }
 
 func (r *replacer) Replace(str, placeholder, value string, vars map[string]string, config *app.Config, section string) string {
@@ -87,29 +181,220 @@ func (r *replacer) Replace(str, placeholder, value string, vars map[string]strin
 
 		// Check for a registered template
 		if len(v) == 0 {
-			t, ok := r.templates[placeholder]
+			t, ok := r.template(placeholder)
 			if ok {
+				if !t.allowed(section) {
+					return match
+				}
+
 				tmpl = t
+			} else if global, ok := r.global(placeholder); ok {
+				return escape(global, matches[2])
 			}
 		}
 
 		v = tmpl.fn(config, section)
 		v = r.compileTemplate(v, matches[3], vars, tmpl.defaults)
 
-		if len(matches[2]) != 0 {
-			// If there's a character to escape, we also have to escape the
-			// escape character, but only if it is different from the character
-			// to escape.
-			if matches[2] != "\\" {
-				v = strings.ReplaceAll(v, "\\", "\\\\\\")
+		return escape(v, matches[2])
+	})
+
+	return str
+}
+
+func (r *replacer) Preview(str string, config *app.Config, section string) (string, error) {
+	if config == nil {
+		config = &app.Config{}
+	}
+
+	str, err := r.ReplaceEnvironment(str)
+	if err != nil {
+		return "", err
+	}
+
+	vars := map[string]string{
+		"processid": config.ID,
+		"reference": config.Reference,
+	}
+
+	str = r.re.ReplaceAllStringFunc(str, func(match string) string {
+		if err != nil {
+			return match
+		}
+
+		matches := r.re.FindStringSubmatch(match)
+		placeholder := matches[1]
+
+		if value, ok := vars[placeholder]; ok {
+			return escape(value, matches[2])
+		}
+
+		tmpl, ok := r.template(placeholder)
+		if !ok {
+			if global, ok := r.global(placeholder); ok {
+				return escape(global, matches[2])
 			}
-			v = strings.ReplaceAll(v, matches[2], "\\\\"+matches[2])
+
+			err = fmt.Errorf("unknown placeholder '{%s}'", placeholder)
+			return match
 		}
 
-		return strings.Replace(match, match, v, 1)
+		if !tmpl.allowed(section) {
+			err = sectionError(placeholder, section, tmpl)
+			return match
+		}
+
+		v := r.compileTemplate(tmpl.fn(config, section), matches[3], vars, tmpl.defaults)
+
+		if missing := r.templateRe.FindStringSubmatch(v); missing != nil {
+			err = fmt.Errorf("missing value for the parameter '%s' of the placeholder '{%s}'", missing[1], placeholder)
+			return match
+		}
+
+		return escape(v, matches[2])
 	})
 
-	return str
+	if err != nil {
+		return "", err
+	}
+
+	return str, nil
+}
+
+func (r *replacer) ReplaceEach(str string, element map[string]string) (string, error) {
+	var err error
+
+	str = r.re.ReplaceAllStringFunc(str, func(match string) string {
+		matches := r.re.FindStringSubmatch(match)
+
+		if !strings.HasPrefix(matches[1], "each:") || err != nil {
+			return match
+		}
+
+		key := strings.TrimPrefix(matches[1], "each:")
+
+		value, ok := element[key]
+		if !ok {
+			err = fmt.Errorf("unknown key '%s' in '{%s}'", key, matches[1])
+			return match
+		}
+
+		return escape(value, matches[2])
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return str, nil
+}
+
+func (r *replacer) CheckSection(str, section string) error {
+	for _, matches := range r.re.FindAllStringSubmatch(str, -1) {
+		placeholder := matches[1]
+
+		tmpl, ok := r.template(placeholder)
+		if !ok || tmpl.allowed(section) {
+			continue
+		}
+
+		return sectionError(placeholder, section, tmpl)
+	}
+
+	return nil
+}
+
+func sectionError(placeholder, section string, tmpl template) error {
+	return fmt.Errorf("the placeholder '{%s}' is not allowed in the %s section, only in: %s", placeholder, section, strings.Join(tmpl.allowedSections(), ", "))
+}
+
+// escape escapes the character c in the value of a placeholder with \\. Nothing
+// is escaped if c is empty.
+func escape(v, c string) string {
+	if len(c) == 0 {
+		return v
+	}
+
+	// If there's a character to escape, we also have to escape the
+	// escape character, but only if it is different from the character
+	// to escape.
+	if c != "\\" {
+		v = strings.ReplaceAll(v, "\\", "\\\\\\")
+	}
+
+	return strings.ReplaceAll(v, c, "\\\\"+c)
+}
+
+func (r *replacer) SetGlobals(globals map[string]string) {
+	r.globalsLock.Lock()
+	defer r.globalsLock.Unlock()
+
+	r.globals = nil
+
+	if globals != nil {
+		r.globals = make(map[string]string, len(globals))
+		for key, value := range globals {
+			r.globals[key] = value
+		}
+	}
+}
+
+// global returns the value of a placeholder of the form global:key
+func (r *replacer) global(placeholder string) (string, bool) {
+	if !strings.HasPrefix(placeholder, "global:") {
+		return "", false
+	}
+
+	r.globalsLock.RLock()
+	defer r.globalsLock.RUnlock()
+
+	value, ok := r.globals[strings.TrimPrefix(placeholder, "global:")]
+
+	return value, ok
+}
+
+func (r *replacer) SetEnvironment(env map[string]string, strict bool) {
+	r.envLock.Lock()
+	defer r.envLock.Unlock()
+
+	r.env = nil
+
+	if env != nil {
+		r.env = make(map[string]string, len(env))
+		for key, value := range env {
+			r.env[key] = value
+		}
+	}
+
+	r.envStrict = strict
+}
+
+func (r *replacer) ReplaceEnvironment(str string) (string, error) {
+	r.envLock.RLock()
+	defer r.envLock.RUnlock()
+
+	if r.env == nil {
+		return str, nil
+	}
+
+	var err error
+
+	str = r.envRe.ReplaceAllStringFunc(str, func(match string) string {
+		name := r.envRe.FindStringSubmatch(match)[1]
+
+		value, ok := r.env[name]
+		if !ok {
+			if r.envStrict && err == nil {
+				err = fmt.Errorf("unknown environment variable '%s'", name)
+			}
+
+			return match
+		}
+
+		return value
+	})
+
+	return str, err
 }
 
 // compileTemplate fills in the placeholder in the template with the values from the params
@@ -1,6 +1,7 @@
 package replace
 
 import (
+	"sync"
 	"testing"
 
 	"github.com/datarhei/core/v16/restream/app"
@@ -61,6 +62,31 @@ func TestReplaceTemplateFunc(t *testing.T) {
 	require.Equal(t, "Hello World! E=mc\\\\:2?", replaced)
 }
 
+func TestReplaceTemplateRegisterAgain(t *testing.T) {
+	r := New()
+	r.RegisterTemplate("foo:bar", "Hello {who}!", nil)
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 100; i++ {
+			r.Replace("{foo:bar,who=World}", "foo:bar", "", nil, nil, "")
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		r.RegisterTemplate("foo:bar", "Hallo {who}!", nil)
+	}
+
+	wg.Wait()
+
+	replaced := r.Replace("{foo:bar,who=World}", "foo:bar", "", nil, nil, "")
+	require.Equal(t, "Hallo World!", replaced)
+}
+
 func TestReplaceTemplateDefaults(t *testing.T) {
 	r := New()
 	r.RegisterTemplate("foobar", "Hello {who}! {what}?", map[string]string{
@@ -142,3 +168,141 @@ func TestReplaceGlob(t *testing.T) {
 	replaced := r.Replace("{foo:baz}, {foo:bar}", "foo:*", "", nil, nil, "")
 	require.Equal(t, "Hello foobaz, Hello foobar", replaced)
 }
+
+func TestReplaceEnvironment(t *testing.T) {
+	r := New()
+
+	replaced, err := r.ReplaceEnvironment("rtmp://${RTMP_HOST}/live")
+	require.NoError(t, err)
+	require.Equal(t, "rtmp://${RTMP_HOST}/live", replaced, "substitution should be disabled by default")
+
+	r.SetEnvironment(map[string]string{
+		"RTMP_HOST": "example.com",
+		"TOKEN":     "secret",
+	}, false)
+
+	replaced, err = r.ReplaceEnvironment("rtmp://${RTMP_HOST}/live?token=${TOKEN}&foo=${FOO}&bar=$TOKEN")
+	require.NoError(t, err)
+	require.Equal(t, "rtmp://example.com/live?token=secret&foo=${FOO}&bar=$TOKEN", replaced)
+
+	r.SetEnvironment(map[string]string{
+		"RTMP_HOST": "example.com",
+	}, true)
+
+	_, err = r.ReplaceEnvironment("rtmp://${RTMP_HOST}/live?token=${TOKEN}")
+	require.Error(t, err)
+
+	r.SetEnvironment(nil, true)
+
+	replaced, err = r.ReplaceEnvironment("rtmp://${RTMP_HOST}/live")
+	require.NoError(t, err)
+	require.Equal(t, "rtmp://${RTMP_HOST}/live", replaced)
+}
+
+func TestReplacePreview(t *testing.T) {
+	r := New()
+	r.RegisterTemplate("rtmp", "rtmp://localhost/live/{name}.stream?token={token}", map[string]string{
+		"token": "foobar",
+	})
+	r.RegisterTemplateFunc("diskfs", func(config *app.Config, section string) string {
+		return "/mnt/data/" + section
+	}, nil)
+	r.SetEnvironment(map[string]string{"HOST": "example.com"}, true)
+
+	config := &app.Config{
+		ID:        "314159265359",
+		Reference: "ref",
+	}
+
+	preview, err := r.Preview("{rtmp,name=$processid}", config, "output")
+	require.NoError(t, err)
+	require.Equal(t, "rtmp://localhost/live/314159265359.stream?token=foobar", preview)
+
+	preview, err = r.Preview("{rtmp^:,name=foo,token=$reference}", config, "output")
+	require.NoError(t, err)
+	require.Equal(t, `rtmp\\://localhost/live/foo.stream?token=ref`, preview)
+
+	preview, err = r.Preview("{diskfs}/{processid}.m3u8 ${HOST}", config, "input")
+	require.NoError(t, err)
+	require.Equal(t, "/mnt/data/input/314159265359.m3u8 example.com", preview)
+
+	_, err = r.Preview("{rtmp}", config, "output")
+	require.ErrorContains(t, err, "'name'")
+
+	_, err = r.Preview("{foobar}", config, "output")
+	require.ErrorContains(t, err, "{foobar}")
+
+	_, err = r.Preview("${FOOBAR}", config, "output")
+	require.Error(t, err)
+}
+
+func TestReplaceTemplateSections(t *testing.T) {
+	r := New()
+	r.RegisterTemplateFunc("foo:bar", func(config *app.Config, section string) string {
+		return "Hello " + section
+	}, nil, "output", "global")
+
+	replaced := r.Replace("{foo:bar}", "foo:bar", "", nil, nil, "output")
+	require.Equal(t, "Hello output", replaced)
+
+	replaced = r.Replace("{foo:bar}", "foo:bar", "", nil, nil, "input")
+	require.Equal(t, "{foo:bar}", replaced, "the placeholder should not be replaced in other sections")
+
+	require.NoError(t, r.CheckSection("{foo:bar}", "global"))
+	require.ErrorContains(t, r.CheckSection("foo {foo:bar}", "input"), "only in: global, output")
+
+	_, err := r.Preview("{foo:bar}", nil, "input")
+	require.Error(t, err)
+
+	r.RegisterTemplateFunc("foo:baz", func(config *app.Config, section string) string {
+		return "Hello " + section
+	}, nil)
+
+	replaced = r.Replace("{foo:baz}", "foo:baz", "", nil, nil, "input")
+	require.Equal(t, "Hello input", replaced, "the placeholder should be allowed in all sections")
+
+	require.NoError(t, r.CheckSection("{foo:baz} {unknown}", "input"))
+}
+
+func TestReplaceEach(t *testing.T) {
+	r := New()
+	r.RegisterTemplate("rtmp", "rtmp://localhost/{name}", nil)
+
+	element := map[string]string{
+		"name":    "720p",
+		"bitrate": "2500k",
+		"size":    "1280:720",
+	}
+
+	replaced, err := r.ReplaceEach("{rtmp} -b:v {each:bitrate} scale={each:size^:} {each:name}", element)
+	require.NoError(t, err)
+	require.Equal(t, `{rtmp} -b:v 2500k scale=1280\\:720 720p`, replaced)
+
+	_, err = r.ReplaceEach("{each:height}", element)
+	require.ErrorContains(t, err, "'height'")
+}
+
+func TestReplaceGlobals(t *testing.T) {
+	r := New()
+	r.RegisterTemplate("global:host", "template", nil)
+
+	r.SetGlobals(map[string]string{
+		"region": "eu:west",
+		"host":   "global",
+	})
+
+	replaced := r.Replace("{global:region}/{global:region^:}/{global:host}/{global:foobar}", "global:*", "", nil, nil, "output")
+	require.Equal(t, `eu:west/eu\\:west/template/`, replaced)
+
+	preview, err := r.Preview("{global:region}", nil, "input")
+	require.NoError(t, err)
+	require.Equal(t, "eu:west", preview)
+
+	_, err = r.Preview("{global:foobar}", nil, "input")
+	require.Error(t, err)
+
+	r.SetGlobals(nil)
+
+	replaced = r.Replace("{global:region}", "global:*", "", nil, nil, "output")
+	require.Equal(t, "", replaced)
+}
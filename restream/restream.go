@@ -1,14 +1,28 @@
 package restream
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	gonet "net"
+	"net/http"
+	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/datarhei/core/v16/ffmpeg"
@@ -19,6 +33,7 @@ import (
 	"github.com/datarhei/core/v16/log"
 	"github.com/datarhei/core/v16/net"
 	"github.com/datarhei/core/v16/net/url"
+	"github.com/datarhei/core/v16/playout"
 	"github.com/datarhei/core/v16/process"
 	"github.com/datarhei/core/v16/restream/app"
 	rfs "github.com/datarhei/core/v16/restream/fs"
@@ -26,35 +41,82 @@ import (
 	"github.com/datarhei/core/v16/restream/store"
 
 	"github.com/Masterminds/semver/v3"
+	"github.com/xeipuuv/gojsonschema"
+	"golang.org/x/time/rate"
 )
 
 // The Restreamer interface
 type Restreamer interface {
-	ID() string                                                  // ID of this instance
-	Name() string                                                // Arbitrary name of this instance
-	CreatedAt() time.Time                                        // Time of when this instance has been created
-	Start()                                                      // Start all processes that have a "start" order
-	Stop()                                                       // Stop all running process but keep their "start" order
-	AddProcess(config *app.Config) error                         // Add a new process
-	GetProcessIDs(idpattern, refpattern string) []string         // Get a list of process IDs based on patterns for ID and reference
-	DeleteProcess(id string) error                               // Delete a process
-	UpdateProcess(id string, config *app.Config) error           // Update a process
-	StartProcess(id string) error                                // Start a process
-	StopProcess(id string) error                                 // Stop a process
-	RestartProcess(id string) error                              // Restart a process
-	ReloadProcess(id string) error                               // Reload a process
-	GetProcess(id string) (*app.Process, error)                  // Get a process
-	GetProcessState(id string) (*app.State, error)               // Get the state of a process
-	GetProcessLog(id string) (*app.Log, error)                   // Get the logs of a process
-	GetPlayout(id, inputid string) (string, error)               // Get the URL of the playout API for a process
-	Probe(id string) app.Probe                                   // Probe a process
-	ProbeWithTimeout(id string, timeout time.Duration) app.Probe // Probe a process with specific timeout
-	Skills() skills.Skills                                       // Get the ffmpeg skills
-	ReloadSkills() error                                         // Reload the ffmpeg skills
-	SetProcessMetadata(id, key string, data interface{}) error   // Set metatdata to a process
-	GetProcessMetadata(id, key string) (interface{}, error)      // Get previously set metadata from a process
-	SetMetadata(key string, data interface{}) error              // Set general metadata
-	GetMetadata(key string) (interface{}, error)                 // Get previously set general metadata
+	ID() string                                                                           // ID of this instance
+	Name() string                                                                         // Arbitrary name of this instance
+	CreatedAt() time.Time                                                                 // Time of when this instance has been created
+	Start()                                                                               // Start all processes that have a "start" order
+	Stop()                                                                                // Stop all running process but keep their "start" order
+	Close(ctx context.Context) error                                                      // Stop everything for a shutdown and write the processes to the store
+	AddProcess(config *app.Config) error                                                  // Add a new process
+	CloneProcess(srcID, newID string, overrides map[string]string) (*app.Config, error)   // Add a copy of a process with a new ID and optionally overridden fields
+	LoadConfigDir(dir string, reconcile bool) map[string]error                            // Add or update the processes from the config files in a directory
+	GetProcessIDs(idpattern, refpattern string) []string                                  // Get a list of process IDs based on patterns for ID and reference
+	GetProcessIDsByState(order, state, idpattern, refpattern string) []string             // Get a list of process IDs based on the order and state, and optionally on patterns for ID and reference
+	GetProcessIDsByFilter(filter ProcessFilter) []string                                  // Get a list of process IDs matching all criteria of the filter
+	ListProcesses(filter ProcessFilter) []app.ProcessSummary                              // Get a summary of each process matching all criteria of the filter
+	DeleteProcess(id string) error                                                        // Stop and delete a process
+	DeleteUnreferencedProcess(id string) error                                            // Stop and delete a process only if no other process references it
+	DeleteProcesses(idpattern, refpattern string, opts DeleteOptions) ([]string, []error) // Delete all processes matching the patterns for ID and reference
+	GetReferences(id string) ([]app.Reference, []app.Reference, error)                    // Get the inbound and outbound references of a process
+	UpdateProcess(id string, config *app.Config) error                                    // Update a process
+	UpdateProcessIf(id string, version uint64, config *app.Config) error                  // Update a process only if it has the given version
+	ReorderOutputs(id string, order []string) error                                       // Rearrange the outputs of a process by their IDs
+	RenameProcess(id, newid string) error                                                 // Change the ID of a process and update the references of other processes to it
+	Validate(config *app.Config) (*app.Config, error)                                     // Validate a config without adding it, returns the resolved config
+	TestProcess(config *app.Config, duration time.Duration) (app.TestResult, error)       // Run a config without adding it for a limited time and report the result
+	StartProcess(id string) error                                                         // Start a process
+	StopProcess(id string) error                                                          // Stop a process
+	Drain() ([]string, error)                                                             // Stop all running processes without changing their order, such that Undrain starts them again
+	Undrain() ([]string, error)                                                           // Start the processes that have been stopped by Drain
+	PauseProcess(id string) error                                                         // Stop forwarding the inputs of a process to its outputs without stopping it
+	ResumeProcess(id string) error                                                        // Resume forwarding the inputs of a paused process
+	RestartProcess(id string) error                                                       // Restart a process
+	ReloadProcess(id string) error                                                        // Reload a process
+	ReloadAll() ([]string, []error)                                                       // Reload all processes whose command changed because of the placeholders
+	RotateCredentials(id string) ([]string, error)                                        // Resolve the addresses of a process again and reload it if they changed
+	GetProcess(id string) (*app.Process, error)                                           // Get a process
+	GetProcessState(id string) (*app.State, error)                                        // Get the state of a process, including the progress from the progress pipe
+	GetProcessCommand(id string) ([]string, error)                                        // Get the complete command line ffmpeg is called with for a process
+	GetProcessStates(ids []string) map[string]app.State                                   // Get a consistent snapshot of the states of the processes, of all processes if no IDs are given
+	Summary() app.RestreamSummary                                                         // Get totals over all processes
+	GetProcessLog(id string) (*app.Log, error)                                            // Get the logs of a process
+	GetProcessLogParts(id string, parts app.LogParts) (*app.Log, error)                   // Get only the selected parts of the logs of a process
+	GetProcessLogHistory(id string) ([]app.LogRun, error)                                 // Get the logs of the last completed runs of a process, the latest run last
+	GetProcessLogSince(id, cursor string) ([]app.LogEntry, string, error)                 // Get the log lines of a process that have been added since the cursor, and the cursor for the next call
+	GetProcessErrors(id string) ([]app.LogEntry, error)                                   // Get the log lines of the current run of a process with the level warning or above
+	GetPlayout(id, inputid string) (string, error)                                        // Get the URL of the playout API for a process
+	GetPlayoutInfo(id, inputid string) (app.PlayoutInfo, error)                           // Get the connection details of the playout API for a process
+	ListPlayoutPorts() []app.PlayoutPortInfo                                              // List the ports from the port range that are assigned to the playout API of processes
+	Probe(id string) app.Probe                                                            // Probe a process
+	Thumbnail(id, ioid string, timeout time.Duration) ([]byte, error)                     // Extract a single frame as JPEG from an output or input of a running process
+	ProbeWithTimeout(id string, timeout time.Duration) app.Probe                          // Probe a process with specific timeout
+	ProbeWithOptions(id string, options app.ProbeOptions) app.Probe                       // Probe a process with specific options for ffmpeg
+	ProbeAddress(address string, options []string, timeout time.Duration) app.Probe       // Probe an address that is not an input of a process
+	Skills() skills.Skills                                                                // Get the ffmpeg skills
+	ReloadSkills() error                                                                  // Reload the ffmpeg skills
+	SetProcessMetadata(id, key string, data interface{}) error                            // Set metatdata to a process
+	GetProcessMetadata(id, key string) (interface{}, error)                               // Get previously set metadata from a process
+	SetAutostartOrder(ids []string) error                                                 // Set the order in which the processes are started when the restreamer starts
+	SetProcessMetadataBulk(idpattern, key string, data interface{}) ([]string, error)     // Set metadata to all processes whose ID matches the pattern
+	GetProcessMetadataBulk(idpattern, key string) (map[string]interface{}, error)         // Get previously set metadata from all processes whose ID matches the pattern
+	DeleteProcessMetadata(id, key string) error                                           // Delete metadata from a process, a no-op if the key doesn't exist
+	ListProcessMetadataKeys(id string) ([]string, error)                                  // Get the sorted keys of the metadata of a process
+	SetMetadata(key string, data interface{}) error                                       // Set general metadata
+	GetMetadata(key string) (interface{}, error)                                          // Get previously set general metadata
+	DeleteMetadata(key string) error                                                      // Delete general metadata, a no-op if the key doesn't exist
+	ListMetadataKeys() []string                                                           // Get the sorted keys of the general metadata
+	GetProcessMetadataInto(id, key string, v interface{}) error                           // Get previously set metadata from a process decoded into v, e.g. a *json.RawMessage
+	GetMetadataInto(key string, v interface{}) error                                      // Get previously set general metadata decoded into v, e.g. a *json.RawMessage
+	RegisterMetadataSchema(key string, schema []byte) error                               // Register a JSON schema the metadata with the key must conform to, for general and process metadata
+	RegisterMetadataType(key string, v interface{}) error                                 // Register the type the metadata with the key must conform to, for general and process metadata
+	Export() (app.Export, error)                                                          // Export all processes with their config, order, and metadata, and the general metadata
+	Import(data app.Export) error                                                         // Import processes and metadata from an export, the processes must not exist
 }
 
 // Config is the required configuration for a new restreamer instance.
@@ -65,8 +127,93 @@ type Config struct {
 	Filesystems  []fs.Filesystem
 	Replace      replace.Replacer
 	FFmpeg       ffmpeg.FFmpeg
+	FFmpegAlt    []ffmpeg.FFmpeg // Alternative ffmpeg binaries, selected by the FFVersion constraint of a process
 	MaxProcesses int64
 	Logger       log.Logger
+
+	// Host the playout API of the processes will bind to, e.g. "0.0.0.0". Optional.
+	// If not provided, the default of ffmpeg applies, i.e. the loopback interface.
+	PlayoutBindHost string
+
+	// Host the playout API is reachable at for the API. It must be a routable
+	// address. Optional. Default value "127.0.0.1".
+	PlayoutAdvertiseHost string
+
+	// Directory for the unix sockets of the playout API of the processes. ffmpeg itself
+	// can't listen on a unix socket, the playout API has to be provided by a sidecar
+	// listening on the socket of each playout input, see GetPlayoutInfo. If set, no ports
+	// from the port range will be used for the playout API, and PlayoutBindHost and
+	// PlayoutAdvertiseHost are ignored. The directory will be created. Optional.
+	PlayoutSocketDir string
+
+	// Interval for checking the store for changes by other writers. Changes will be
+	// applied to the processes. Only supported by stores that implement store.Watcher.
+	// Optional. Default value 0, i.e. disabled.
+	StoreWatchInterval time.Duration
+
+	// Directory with JSON files that contain one process config each. The processes
+	// will be added or updated on startup, in addition to the processes from the store.
+	// Errors are logged per file. Optional.
+	ConfigDir string
+
+	// Whether to remove the processes on startup that don't have a file in ConfigDir.
+	// Optional. Default value false.
+	ConfigDirReconcile bool
+
+	// Max. number of processes running at the same time. Processes that are started
+	// beyond this limit will be queued and launched as soon as a running process
+	// gets stopped. Optional. Default value 0, i.e. unlimited.
+	MaxConcurrent int64
+
+	// Default timeout for probing the inputs of a process or an address, if the probe
+	// doesn't give a timeout. At most ProbeMaxTimeout. Optional. Default value 20 seconds.
+	ProbeTimeout time.Duration
+
+	// Time between the starts of the processes that are started when the restreamer
+	// starts, in the order of their AutostartOrder. It is added to the autostart delay
+	// of the processes. Optional. Default value 0, i.e. all start at once.
+	AutostartInterval time.Duration
+
+	// Whether a process may preempt a running process with a lower priority if no
+	// slot is available. The preempted process will be queued. Otherwise the priority
+	// only affects the order of the queue. Optional. Default value false.
+	Preempt bool
+
+	// Option flags that are allowed in the global, input, and output options of a process,
+	// e.g. "-f" or "-codec". A flag with a stream specifier like "-codec:v" is allowed if
+	// the flag without the specifier is allowed. Optional. If empty, all flags are allowed.
+	OptionsAllowlist []string
+
+	// URL schemes that are allowed for the outputs of a process, e.g. "rtmp" or "srt". This
+	// applies to each branch of a tee output and in addition to the output validator of ffmpeg.
+	// Local files are covered by the checks of the paths. Optional. If empty, all schemes are
+	// allowed.
+	OutputSchemes []string
+
+	// Whether to probe the inputs of a process when it is added and check that the
+	// streams referenced by the -map options of the outputs exist. Probing may take a
+	// while and not all inputs can be probed. Optional. Default value false.
+	ValidateMapping bool
+
+	// Devices for hardware acceleration, e.g. GPUs, that processes can be assigned to. A process
+	// that would exceed the capacity of its device is queued until a slot is free. Optional.
+	Devices []Device
+
+	// Max. number of changes of processes per second, i.e. adding, updating, and deleting
+	// processes. Changes beyond this rate are rejected with ErrRateLimited. Optional. Default
+	// value 0, i.e. unlimited.
+	ChangeRate float64
+
+	// Max. number of changes of processes that may happen at once before ChangeRate applies.
+	// Optional. Default value 1 if ChangeRate is set.
+	ChangeBurst int
+
+	// Notifications about process events to an external URL. Optional.
+	Webhook WebhookConfig
+
+	// Absolute paths of the binaries that may be used for the PreStart and PostStop hooks
+	// of a process. Optional. If empty, no hooks are allowed.
+	HookBinaries []string
 }
 
 type task struct {
@@ -76,11 +223,23 @@ type task struct {
 	process   *app.Process
 	config    *app.Config
 	command   []string // The actual command parameter for ffmpeg
+	binary    ffmpeg.FFmpeg
 	ffmpeg    process.Process
 	parser    parse.Parser
-	playout   map[string]int
+	hooks     *hookParser       // Parser for the output of the hooks, nil if there are no hooks
+	playout   map[string]int    // Playout port per input ID
+	playoutAt time.Time         // Time the playout ports have been assigned
+	sockets   map[string]string // Playout socket per input ID, if the playout API listens on unix sockets
+	tee       *teeState
 	logger    log.Logger
-	usesDisk  bool // Whether this task uses the disk
+	usesDisk  bool        // Whether this task uses the disk
+	queued    bool        // Whether this task is waiting for a free slot
+	start     *time.Timer // Timer for a delayed autostart
+	startAt   time.Time   // Time of the delayed autostart
+	rotatedAt time.Time   // Time of the last rotation of the credentials
+	paused    time.Time   // Time the process has been paused, zero if it is not paused
+	prestart  *hookRun    // The pending pre-start hook, nil if none is running
+	hookErr   error       // Error of the last pre-start hook, nil if it succeeded
 	metadata  map[string]interface{}
 }
 
@@ -90,22 +249,52 @@ type restream struct {
 	createdAt time.Time
 	store     store.Store
 	ffmpeg    ffmpeg.FFmpeg
+	ffmpegAlt []ffmpeg.FFmpeg
 	maxProc   int64
 	nProc     int64
+	maxConc   int64
+	preempt   bool
+	interval  time.Duration   // Time between the starts of the processes in Start
+	mapcheck  time.Duration   // Probe timeout for validating the stream mapping of new processes, 0 if disabled
+	allowlist map[string]bool // Allowed option flags without the leading "-", all flags are allowed if nil
+	schemes   map[string]bool // Allowed lower-cased URL schemes for outputs, all schemes are allowed if nil
+	devices   map[string]Device
+	hookBins  map[string]bool  // Binaries that are allowed for the hooks
+	changes   *rate.Limiter    // Limits the rate of changes of processes, unlimited if nil
+	queue     []*task          // Tasks waiting for a free slot, in the order they will be launched
+	tests     map[string]*task // Tasks of the processes that are currently tested, see TestProcess
+	thumbs    chan struct{}    // Semaphore for the thumbnails that are extracted at the same time
+	webhook   *webhook
 	fs        struct {
 		list         []rfs.Filesystem
 		diskfs       []rfs.Filesystem
 		stopObserver context.CancelFunc
 	}
-	replace  replace.Replacer
-	tasks    map[string]*task
-	logger   log.Logger
-	metadata map[string]interface{}
+	replace            replace.Replacer
+	tasks              map[string]*task
+	logger             log.Logger
+	metadata           map[string]interface{}
+	metadataSchemas    map[string]*gojsonschema.Schema
+	metadataTypes      map[string]reflect.Type
+	storeWatchInterval time.Duration
+	configDir          struct {
+		files map[string]string // Path of a config file to the ID of the process it has been loaded as
+	}
+	probeTimeout time.Duration // Default timeout for probing
+	playout      struct {
+		bindHost      string
+		advertiseHost string
+		socketDir     string
+	}
 
-	lock sync.RWMutex
+	lock     sync.RWMutex
+	snapshot atomic.Value // *snapshot of the tasks for the read path, see publish
+	dirty    bool         // Whether the tasks changed since the last snapshot, see unlock
 
 	startOnce sync.Once
 	stopOnce  sync.Once
+	closeOnce sync.Once
+	closeErr  error
 }
 
 // New returns a new instance that implements the Restreamer interface
@@ -117,6 +306,10 @@ func New(config Config) (Restreamer, error) {
 		store:     config.Store,
 		replace:   config.Replace,
 		logger:    config.Logger,
+
+		thumbs: make(chan struct{}, thumbnailMaxConcurrent),
+
+		storeWatchInterval: config.StoreWatchInterval,
 	}
 
 	if r.logger == nil {
@@ -157,13 +350,128 @@ func New(config Config) (Restreamer, error) {
 		return nil, fmt.Errorf("ffmpeg must be provided")
 	}
 
+	r.playout.bindHost = config.PlayoutBindHost
+	r.playout.advertiseHost = config.PlayoutAdvertiseHost
+	r.playout.socketDir = config.PlayoutSocketDir
+
+	if len(r.playout.socketDir) != 0 {
+		if err := os.MkdirAll(r.playout.socketDir, 0700); err != nil {
+			return nil, fmt.Errorf("unable to create the directory for the playout sockets: %w", err)
+		}
+	}
+
+	if len(r.playout.advertiseHost) == 0 {
+		r.playout.advertiseHost = "127.0.0.1"
+	}
+
+	if err := validateAdvertiseHost(r.playout.advertiseHost); err != nil {
+		return nil, fmt.Errorf("invalid playout advertise host: %w", err)
+	}
+
+	for _, f := range config.FFmpegAlt {
+		if f == nil {
+			continue
+		}
+
+		r.ffmpegAlt = append(r.ffmpegAlt, f)
+	}
+
 	r.maxProc = config.MaxProcesses
+	r.maxConc = config.MaxConcurrent
+	r.preempt = config.Preempt
+	r.interval = config.AutostartInterval
+
+	r.probeTimeout = config.ProbeTimeout
+	if r.probeTimeout <= 0 {
+		r.probeTimeout = 20 * time.Second
+	} else if r.probeTimeout > ProbeMaxTimeout {
+		r.probeTimeout = ProbeMaxTimeout
+	}
+
+	if config.ValidateMapping {
+		r.mapcheck = 20 * time.Second
+	}
+
+	if len(config.OptionsAllowlist) != 0 {
+		r.allowlist = map[string]bool{}
+
+		for _, flag := range config.OptionsAllowlist {
+			r.allowlist[strings.TrimPrefix(flag, "-")] = true
+		}
+	}
+
+	if len(config.OutputSchemes) != 0 {
+		r.schemes = map[string]bool{}
+
+		for _, scheme := range config.OutputSchemes {
+			r.schemes[strings.ToLower(strings.TrimSuffix(scheme, "://"))] = true
+		}
+	}
+
+	if config.ChangeRate > 0 {
+		burst := config.ChangeBurst
+		if burst <= 0 {
+			burst = 1
+		}
+
+		r.changes = rate.NewLimiter(rate.Limit(config.ChangeRate), burst)
+	}
+
+	r.tests = map[string]*task{}
+	r.devices = map[string]Device{}
+
+	for _, device := range config.Devices {
+		if len(device.ID) == 0 || len(device.HWAccel) == 0 {
+			return nil, fmt.Errorf("invalid device: the ID and the hardware acceleration method are required")
+		}
+
+		if _, ok := r.devices[device.ID]; ok {
+			return nil, fmt.Errorf("invalid device: the ID '%s' is already in use", device.ID)
+		}
+
+		r.devices[device.ID] = device
+	}
+
+	webhook, err := newWebhook(config.Webhook, r.logger.WithComponent("Webhook"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook: %w", err)
+	}
+
+	r.webhook = webhook
+
+	r.hookBins = map[string]bool{}
+
+	for _, binary := range config.HookBinaries {
+		if !filepath.IsAbs(binary) {
+			return nil, fmt.Errorf("invalid hook binary '%s': the path must be absolute", binary)
+		}
+
+		r.hookBins[filepath.Clean(binary)] = true
+	}
+
+	for _, v := range metadataBuiltinTypes {
+		r.registerMetadataGoType(v)
+	}
 
 	if err := r.load(); err != nil {
 		return nil, fmt.Errorf("failed to load data from DB (%w)", err)
 	}
 
+	r.configDir.files = map[string]string{}
+
+	if len(config.ConfigDir) != 0 {
+		files, errs := readConfigDir(config.ConfigDir)
+		if files != nil {
+			r.applyConfigDir(config.ConfigDir, files, errs, config.ConfigDirReconcile, false)
+		}
+
+		for name, err := range errs {
+			r.logger.Warn().WithField("file", name).WithError(err).Log("Ignoring process config")
+		}
+	}
+
 	r.save()
+	r.publish()
 
 	r.stopOnce.Do(func() {})
 
@@ -173,17 +481,27 @@ func New(config Config) (Restreamer, error) {
 func (r *restream) Start() {
 	r.startOnce.Do(func() {
 		r.lock.Lock()
-		defer r.lock.Unlock()
+		defer r.unlock()
 
-		for id, t := range r.tasks {
-			if t.process.Order == "start" {
-				r.startProcess(id)
+		r.dirty = true
+
+		// Start the processes in a deterministic order, such that the
+		// same processes will be queued in case of a limit.
+		offset := time.Duration(0)
+
+		for _, t := range r.autostartTasks() {
+			// Drained processes keep their order, but are started only by Undrain
+			if t.process.Order == "start" && !t.process.Drained {
+				r.autostartProcess(t, offset)
+				offset += r.interval
 			}
 
 			// The filesystem cleanup rules can be set
-			r.setCleanup(id, t.config)
+			r.setCleanup(t.id, t.config)
 		}
 
+		r.syncOnDemand()
+
 		ctx, cancel := context.WithCancel(context.Background())
 		r.fs.stopObserver = cancel
 
@@ -195,6 +513,10 @@ func (r *restream) Start() {
 			}
 		}
 
+		if watcher, ok := r.store.(store.Watcher); ok && r.storeWatchInterval > 0 {
+			go r.watchStore(ctx, watcher, r.storeWatchInterval)
+		}
+
 		r.stopOnce = sync.Once{}
 	})
 }
@@ -202,16 +524,23 @@ func (r *restream) Start() {
 func (r *restream) Stop() {
 	r.stopOnce.Do(func() {
 		r.lock.Lock()
-		defer r.lock.Unlock()
+		defer r.unlock()
+
+		r.dirty = true
 
 		// Stop the currently running processes without
 		// altering their order such that on a subsequent
 		// Start() they will get restarted.
 		for id, t := range r.tasks {
+			r.unscheduleStart(t)
+			r.cancelPreStart(t)
+
 			if t.ffmpeg != nil {
 				t.ffmpeg.Stop(true)
 			}
 
+			t.tee.stopRelays()
+
 			r.unsetCleanup(id)
 		}
 
@@ -226,6 +555,91 @@ func (r *restream) Stop() {
 	})
 }
 
+// Close stops all processes and the background jobs, releases the playout ports, and
+// writes the processes to the store. The processes keep their order, such that they will
+// be started again by the next instance. The processes are stopped in parallel and Close
+// waits for them to exit until the context is done, in which case the error of the context
+// is returned. The restreamer must not be used anymore afterwards. Calling Close again
+// returns the result of the first call.
+func (r *restream) Close(ctx context.Context) error {
+	r.closeOnce.Do(func() {
+		// Neither Start nor Stop will do anything anymore
+		r.startOnce.Do(func() {})
+		r.stopOnce.Do(func() {})
+
+		r.closeErr = r.close(ctx)
+	})
+
+	return r.closeErr
+}
+
+func (r *restream) close(ctx context.Context) error {
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.dirty = true
+
+	wg := sync.WaitGroup{}
+
+	for id, t := range r.tasks {
+		r.unscheduleStart(t)
+		r.cancelPreStart(t)
+		r.dequeue(t)
+
+		if t.ffmpeg != nil {
+			wg.Add(1)
+			go func(t *task) {
+				defer wg.Done()
+
+				t.ffmpeg.Stop(true)
+				t.tee.stopRelays()
+			}(t)
+		}
+
+		r.unsetCleanup(id)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	var err error
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// The processes that didn't exit yet will be killed by themselves
+		err = fmt.Errorf("waiting for the processes to exit: %w", ctx.Err())
+	}
+
+	if r.fs.stopObserver != nil {
+		r.fs.stopObserver()
+	}
+
+	for _, fs := range r.fs.list {
+		fs.Stop()
+	}
+
+	r.webhook.stop()
+
+	for _, t := range r.tasks {
+		r.unsetPlayoutPorts(t)
+	}
+
+	r.save()
+
+	// Release the store, e.g. the lock on a database file
+	if closer, ok := r.store.(io.Closer); ok {
+		if cerr := closer.Close(); cerr != nil {
+			r.logger.Warn().WithError(cerr).Log("Closing the store")
+		}
+	}
+
+	return err
+}
+
 func (r *restream) observe(ctx context.Context, fs fs.Filesystem, interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
@@ -253,17 +667,255 @@ func (r *restream) observe(ctx context.Context, fs fs.Filesystem, interval time.
 						continue
 					}
 
-					if t.process.Order != "start" {
+					if t.process.Order != "start" || t.process.Drained {
 						continue
 					}
 
 					r.logger.Warn().Log("Shutting down because filesystem is full")
 					r.stopProcess(id)
 				}
-				r.lock.Unlock()
+
+				r.syncOnDemand()
+				r.startQueued()
+				r.unlock()
+			}
+		}
+	}
+}
+
+// watchStore periodically checks the store for changes by other writers
+// and applies them to the processes.
+func (r *restream) watchStore(ctx context.Context, watcher store.Watcher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := watcher.Changed()
+			if err != nil {
+				r.logger.Warn().WithError(err).Log("Checking the store for changes failed")
+				continue
+			}
+
+			if !changed {
+				continue
+			}
+
+			data, err := r.store.Load()
+			if err != nil {
+				r.logger.Warn().WithError(err).Log("Loading the changed store failed")
+				continue
+			}
+
+			r.logger.Info().Log("Store has been changed, reloading")
+
+			r.lock.Lock()
+			r.reconcile(data)
+			r.unlock()
+		}
+	}
+}
+
+// reconcile adds, removes, and updates the processes such that they match the given data.
+// The processes are created in the order of their references, such that a process can
+// reference a process that is added or updated as well. A process that can't be created
+// is kept as an invalid process, in order not to remove it from the store.
+func (r *restream) reconcile(data store.StoreData) {
+	r.dirty = true
+
+	for id, t := range r.tasks {
+		if _, ok := data.Process[id]; ok {
+			continue
+		}
+
+		r.stopProcess(id)
+		t.process.Order = "stop"
+
+		if err := r.deleteProcess(id); err != nil {
+			r.logger.Warn().WithField("id", id).WithError(err).Log("Removing process failed")
+			continue
+		}
+
+		r.notifyChange(EventRemove, t)
+
+		r.logger.Info().WithField("id", id).Log("Removed process")
+	}
+
+	pending := map[string]*app.Process{}
+
+	for id, process := range data.Process {
+		if process.Config == nil {
+			continue
+		}
+
+		t, ok := r.tasks[id]
+		if ok {
+			current, _ := json.Marshal(t.process.Config)
+			changed, _ := json.Marshal(process.Config)
+
+			if bytes.Equal(current, changed) {
+				if t.process.Order != process.Order || t.process.Drained != process.Drained {
+					switch {
+					case process.Order == "start" && process.Drained:
+						r.drainProcess(t)
+					case process.Order == "start":
+						t.process.Drained = false
+						r.startProcess(id)
+					default:
+						t.process.Drained = false
+						r.stopProcess(id)
+					}
+
+					r.logger.Info().WithFields(log.Fields{
+						"id":      id,
+						"order":   process.Order,
+						"drained": process.Drained,
+					}).Log("Changed order of process")
+				}
+
+				continue
+			}
+		}
+
+		pending[id] = process
+	}
+
+	// A process waits for the processes it references, unless none of the processes
+	// can be created this way, e.g. because of a circular reference.
+	wait := true
+	errs := map[string]error{}
+
+	for len(pending) != 0 {
+		created := false
+
+		for _, id := range sortedIDs(pending) {
+			process := pending[id]
+
+			if wait && referencesAny(process.Config, pending) {
+				continue
+			}
+
+			nt, err := r.createTask(process.Config.Clone())
+			if err != nil {
+				errs[id] = err
+				continue
 			}
+
+			nt.process.Order = process.Order
+			nt.process.CreatedAt = process.CreatedAt
+			nt.process.Drained = process.Drained
+
+			delete(pending, id)
+			created = true
+
+			r.replaceTask(id, nt)
+		}
+
+		if created {
+			wait = true
+			continue
+		}
+
+		if wait {
+			wait = false
+			continue
+		}
+
+		// None of the remaining processes can be created
+		for _, id := range sortedIDs(pending) {
+			process := pending[id]
+
+			r.logger.Warn().WithField("id", id).WithError(errs[id]).Log("Keeping invalid process")
+
+			r.replaceTask(id, &task{
+				id:        id,
+				reference: process.Reference,
+				process:   process,
+				config:    process.Config.Clone(),
+				logger:    r.logger.WithField("id", id),
+			})
+		}
+
+		break
+	}
+
+	r.syncOnDemand()
+	r.startQueued()
+
+	for id, t := range r.tasks {
+		t.metadata = data.Metadata.Process[id]
+	}
+
+	r.metadata = data.Metadata.System
+}
+
+// replaceTask adds the task of a process from the store or replaces the task with the
+// same ID, and starts it if it should be running.
+func (r *restream) replaceTask(id string, nt *task) {
+	event := EventAdd
+
+	if t, ok := r.tasks[id]; ok {
+		r.stopProcess(id)
+		t.process.Order = "stop"
+
+		if err := r.deleteProcess(id); err != nil {
+			r.unsetPlayoutPorts(nt)
+			r.logger.Warn().WithField("id", id).WithError(err).Log("Updating process failed")
+			return
+		}
+
+		event = EventUpdate
+	}
+
+	r.tasks[id] = nt
+
+	if nt.valid {
+		r.setCleanup(id, nt.config)
+
+		if nt.process.Order == "start" && !nt.process.Drained {
+			r.startProcess(id)
+		}
+	}
+
+	r.notifyChange(event, nt)
+
+	if event == EventUpdate {
+		r.logger.Info().WithField("id", id).Log("Updated process")
+	} else {
+		r.logger.Info().WithField("id", id).Log("Added process")
+	}
+}
+
+// sortedIDs returns the IDs of the processes in ascending order.
+func sortedIDs(processes map[string]*app.Process) []string {
+	ids := make([]string, 0, len(processes))
+
+	for id := range processes {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+// referencesAny returns whether an input of the config references one of the other processes.
+func referencesAny(config *app.Config, processes map[string]*app.Process) bool {
+	for _, input := range config.Input {
+		matches := reReference.FindStringSubmatch(input.Address)
+		if matches == nil || matches[1] == config.ID {
+			continue
+		}
+
+		if _, ok := processes[matches[1]]; ok {
+			return true
 		}
 	}
+
+	return false
 }
 
 func (r *restream) load() error {
@@ -294,6 +946,11 @@ func (r *restream) load() error {
 			logger:    r.logger.WithField("id", id),
 		}
 
+		// An output that can't be expanded is rejected by validateConfig
+		if err := expandOutputs(t.config, r.replace); err != nil {
+			r.logger.Warn().WithField("id", id).WithError(err).Log("Expanding outputs")
+		}
+
 		// Replace all placeholders in the config
 		resolvePlaceholders(t.config, r.replace)
 
@@ -330,34 +987,69 @@ func (r *restream) load() error {
 			r.logger.Warn().WithField("id", t.id).WithError(err).Log("")
 		}
 
-		err := r.resolveAddresses(tasks, t.config)
+		// Fall back to the default ffmpeg binary if none of the available binaries fits
+		binary, err := r.selectFFmpeg(t.config.FFVersion)
+		if err != nil {
+			binary = r.ffmpeg
+		}
+
+		t.binary = binary
+
+		err = resolveEnvironment(t.config, r.replace)
 		if err != nil {
 			r.logger.Warn().WithField("id", t.id).WithError(err).Log("Ignoring")
 			continue
 		}
 
-		t.usesDisk, err = r.validateConfig(t.config)
+		err = r.resolveAddresses(tasks, t.config)
 		if err != nil {
 			r.logger.Warn().WithField("id", t.id).WithError(err).Log("Ignoring")
 			continue
 		}
 
-		err = r.setPlayoutPorts(t)
+		t.usesDisk, err = r.validateConfig(t.config)
 		if err != nil {
 			r.logger.Warn().WithField("id", t.id).WithError(err).Log("Ignoring")
 			continue
 		}
 
+		setBufferOptions(t.config)
+		r.setDeviceOptions(t.config)
+		stripPlayoutOptions(t.config)
+
+		if err := r.setTeeBranches(t); err != nil {
+			r.logger.Warn().WithField("id", t.id).WithError(err).Log("Ignoring")
+			continue
+		}
+
 		t.command = t.config.CreateCommand()
-		t.parser = r.ffmpeg.NewProcessParser(t.logger, t.id, t.reference)
-
-		ffmpeg, err := r.ffmpeg.New(ffmpeg.ProcessConfig{
-			Reconnect:      t.config.Reconnect,
-			ReconnectDelay: time.Duration(t.config.ReconnectDelay) * time.Second,
-			StaleTimeout:   time.Duration(t.config.StaleTimeout) * time.Second,
-			Command:        t.command,
-			Parser:         t.parser,
-			Logger:         t.logger,
+		t.parser = newHookParser(t, newTeeParser(t.binary.NewProcessParser(t.logger, t.id, t.reference), t.tee, r.onTeeBranchFailed(t)))
+
+		onStateChange, onStale := r.onStateChange(t)
+		onRestart, onExit := r.hookCallbacks(t)
+
+		ffmpeg, err := t.binary.New(ffmpeg.ProcessConfig{
+			Reconnect:       t.config.Reconnect,
+			ReconnectDelay:  time.Duration(t.config.ReconnectDelay) * time.Second,
+			ReconnectJitter: time.Duration(t.config.ReconnectJitter) * time.Second,
+			RestartPolicy:   t.config.RestartPolicy,
+			MaxRestarts:     t.config.MaxRestarts,
+			RestartWindow:   time.Duration(t.config.RestartWindow) * time.Second,
+			StaleTimeout:    time.Duration(t.config.StaleTimeout) * time.Second,
+			Command:         t.command,
+			Environment:     t.config.Environment,
+			WorkingDir:      t.config.WorkingDir,
+			Parser:          t.parser,
+			LogStdout:       t.config.LogStdout,
+			ProgressPipe:    true,
+			LevelPrefix:     true,
+			CPUAffinity:     t.config.CPUAffinity,
+			Nice:            t.config.Nice,
+			Logger:          t.logger,
+			OnStateChange:   onStateChange,
+			OnStale:         onStale,
+			OnRestart:       onRestart,
+			OnExit:          onExit,
 		})
 		if err != nil {
 			return err
@@ -399,8 +1091,26 @@ func (r *restream) CreatedAt() time.Time {
 
 var ErrUnknownProcess = errors.New("unknown process")
 var ErrProcessExists = errors.New("process already exists")
+var ErrRateLimited = errors.New("too many changes of processes, try again later")
+
+// allowChange returns whether a change of a process is allowed by the rate limit of changes.
+func (r *restream) allowChange() bool {
+	if r.changes == nil {
+		return true
+	}
+
+	return r.changes.Allow()
+}
 
 func (r *restream) AddProcess(config *app.Config) error {
+	if !r.allowChange() {
+		return ErrRateLimited
+	}
+
+	return r.addProcess(config)
+}
+
+func (r *restream) addProcess(config *app.Config) error {
 	r.lock.RLock()
 	t, err := r.createTask(config)
 	r.lock.RUnlock()
@@ -409,11 +1119,23 @@ func (r *restream) AddProcess(config *app.Config) error {
 		return err
 	}
 
+	if r.mapcheck != 0 {
+		probe := r.probeTask(t, app.ProbeOptions{Timeout: r.mapcheck})
+		if err := validateStreamMapping(t.config, probe); err != nil {
+			r.lock.Lock()
+			r.unsetPlayoutPorts(t)
+			r.unlock()
+			return err
+		}
+	}
+
 	r.lock.Lock()
-	defer r.lock.Unlock()
+	defer r.unlock()
+
+	r.dirty = true
 
-	_, ok := r.tasks[t.id]
-	if ok {
+	if r.idInUse(t.id) {
+		r.unsetPlayoutPorts(t)
 		return ErrProcessExists
 	}
 
@@ -423,29 +1145,122 @@ func (r *restream) AddProcess(config *app.Config) error {
 	r.setCleanup(t.id, t.config)
 
 	if t.process.Order == "start" {
-		err := r.startProcess(t.id)
+		err := r.autostartProcess(t, 0)
 		if err != nil {
+			r.unsetPlayoutPorts(t)
+			r.unsetCleanup(t.id)
 			delete(r.tasks, t.id)
 			return err
 		}
 	}
 
+	r.syncOnDemand()
+
 	r.save()
 
 	return nil
 }
 
-func (r *restream) createTask(config *app.Config) (*task, error) {
-	id := strings.TrimSpace(config.ID)
-
-	if len(id) == 0 {
-		return nil, fmt.Errorf("an empty ID is not allowed")
+// CloneProcess adds a new process with the ID newID and a copy of the original config of the
+// process with the ID srcID. The overrides are applied to the copy, where the keys are the
+// JSON names of the fields of the config, e.g. "reference" or "autostart". Only fields with a
+// string, bool, or numeric value can be overridden. Placeholders in the copy are resolved for
+// the new ID. It returns the config of the new process.
+func (r *restream) CloneProcess(srcID, newID string, overrides map[string]string) (*app.Config, error) {
+	r.lock.RLock()
+	src, ok := r.tasks[srcID]
+	if !ok {
+		r.lock.RUnlock()
+		return nil, ErrUnknownProcess
 	}
 
-	config.FFVersion = "^" + r.ffmpeg.Skills().FFmpeg.Version
-	if v, err := semver.NewVersion(config.FFVersion); err == nil {
-		// Remove the patch level for the constraint
-		config.FFVersion = fmt.Sprintf("^%d.%d.0", v.Major(), v.Minor())
+	config := src.process.Config.Clone()
+	r.lock.RUnlock()
+
+	config.ID = newID
+
+	if err := applyOverrides(config, overrides); err != nil {
+		return nil, err
+	}
+
+	if err := r.AddProcess(config); err != nil {
+		return nil, err
+	}
+
+	return config.Clone(), nil
+}
+
+// applyOverrides sets the fields of the config, identified by their JSON names, to the
+// values parsed according to the type of the field. The ID can't be overridden.
+func applyOverrides(config *app.Config, overrides map[string]string) error {
+	v := reflect.ValueOf(config).Elem()
+
+	for key, value := range overrides {
+		if key == "id" {
+			return fmt.Errorf("the field 'id' can't be overridden")
+		}
+
+		var field reflect.Value
+
+		for i := 0; i < v.NumField(); i++ {
+			name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+			if name == key {
+				field = v.Field(i)
+				break
+			}
+		}
+
+		if !field.IsValid() {
+			return fmt.Errorf("unknown field '%s'", key)
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for field '%s': %w", key, err)
+			}
+			field.SetBool(b)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("invalid value for field '%s': %w", key, err)
+			}
+			field.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("invalid value for field '%s': %w", key, err)
+			}
+			field.SetUint(n)
+		case reflect.Float32, reflect.Float64:
+			f, err := strconv.ParseFloat(value, field.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("invalid value for field '%s': %w", key, err)
+			}
+			field.SetFloat(f)
+		default:
+			return fmt.Errorf("the field '%s' can't be overridden", key)
+		}
+	}
+
+	return nil
+}
+
+func (r *restream) createTask(config *app.Config) (*task, error) {
+	id := strings.TrimSpace(config.ID)
+
+	if len(id) == 0 {
+		return nil, fmt.Errorf("an empty ID is not allowed")
+	}
+
+	r.setFFVersion(config)
+
+	binary, err := r.selectFFmpeg(config.FFVersion)
+	if err != nil {
+		return nil, err
 	}
 
 	process := &app.Process{
@@ -456,7 +1271,8 @@ func (r *restream) createTask(config *app.Config) (*task, error) {
 		CreatedAt: time.Now().Unix(),
 	}
 
-	if config.Autostart {
+	// On-demand processes are started by the processes referencing them
+	if config.Autostart && !config.OnDemand {
 		process.Order = "start"
 	}
 
@@ -465,12 +1281,22 @@ func (r *restream) createTask(config *app.Config) (*task, error) {
 		reference: process.Reference,
 		process:   process,
 		config:    process.Config.Clone(),
+		binary:    binary,
 		logger:    r.logger.WithField("id", process.ID),
 	}
 
+	if err := expandOutputs(t.config, r.replace); err != nil {
+		return nil, err
+	}
+
 	resolvePlaceholders(t.config, r.replace)
 
-	err := r.resolveAddresses(r.tasks, t.config)
+	err = resolveEnvironment(t.config, r.replace)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.resolveAddresses(r.tasks, t.config)
 	if err != nil {
 		return nil, err
 	}
@@ -480,23 +1306,50 @@ func (r *restream) createTask(config *app.Config) (*task, error) {
 		return nil, err
 	}
 
-	err = r.setPlayoutPorts(t)
+	err = validateCapabilities(t.binary, t.config)
 	if err != nil {
 		return nil, err
 	}
 
-	t.command = t.config.CreateCommand()
-	t.parser = r.ffmpeg.NewProcessParser(t.logger, t.id, t.reference)
+	setBufferOptions(t.config)
+	r.setDeviceOptions(t.config)
+	stripPlayoutOptions(t.config)
 
-	ffmpeg, err := r.ffmpeg.New(ffmpeg.ProcessConfig{
-		Reconnect:      t.config.Reconnect,
-		ReconnectDelay: time.Duration(t.config.ReconnectDelay) * time.Second,
-		StaleTimeout:   time.Duration(t.config.StaleTimeout) * time.Second,
-		Command:        t.command,
-		Parser:         t.parser,
-		Logger:         t.logger,
+	if err := r.setTeeBranches(t); err != nil {
+		return nil, err
+	}
+
+	t.command = t.config.CreateCommand()
+	t.parser = newHookParser(t, newTeeParser(t.binary.NewProcessParser(t.logger, t.id, t.reference), t.tee, r.onTeeBranchFailed(t)))
+
+	onStateChange, onStale := r.onStateChange(t)
+	onRestart, onExit := r.hookCallbacks(t)
+
+	ffmpeg, err := t.binary.New(ffmpeg.ProcessConfig{
+		Reconnect:       t.config.Reconnect,
+		ReconnectDelay:  time.Duration(t.config.ReconnectDelay) * time.Second,
+		ReconnectJitter: time.Duration(t.config.ReconnectJitter) * time.Second,
+		RestartPolicy:   t.config.RestartPolicy,
+		MaxRestarts:     t.config.MaxRestarts,
+		RestartWindow:   time.Duration(t.config.RestartWindow) * time.Second,
+		StaleTimeout:    time.Duration(t.config.StaleTimeout) * time.Second,
+		Command:         t.command,
+		Environment:     t.config.Environment,
+		WorkingDir:      t.config.WorkingDir,
+		Parser:          t.parser,
+		LogStdout:       t.config.LogStdout,
+		ProgressPipe:    true,
+		LevelPrefix:     true,
+		CPUAffinity:     t.config.CPUAffinity,
+		Nice:            t.config.Nice,
+		Logger:          t.logger,
+		OnStateChange:   onStateChange,
+		OnStale:         onStale,
+		OnRestart:       onRestart,
+		OnExit:          onExit,
 	})
 	if err != nil {
+		r.unsetPlayoutPorts(t)
 		return nil, err
 	}
 
@@ -506,6 +1359,260 @@ func (r *restream) createTask(config *app.Config) (*task, error) {
 	return t, nil
 }
 
+// setFFVersion sets the version constraint for ffmpeg to the minor version
+// of the default binary if none is given.
+func (r *restream) setFFVersion(config *app.Config) {
+	if len(config.FFVersion) != 0 {
+		return
+	}
+
+	ffversion := r.ffmpeg.Skills().FFmpeg.Version
+	if v, err := semver.NewVersion(ffversion); err == nil {
+		// Remove the patch level for the constraint
+		ffversion = fmt.Sprintf("%d.%d.0", v.Major(), v.Minor())
+	}
+
+	config.FFVersion = "^" + ffversion
+}
+
+// TestProcess runs the process of the config for the duration without adding it, e.g. in order to check
+// a config before putting it into production. The ID must not be used by an existing process, such that
+// they don't interfere, and no other process can take the ID during the test. The test counts against the
+// max. number of processes and the capacity of the device of the process, but it is never queued. The process
+// is not restarted if it exits and hooks are not run. The test ends early if the process exits. The process
+// is always stopped and its resources are released.
+func (r *restream) TestProcess(config *app.Config, duration time.Duration) (app.TestResult, error) {
+	result := app.TestResult{}
+
+	config = config.Clone()
+	config.Reconnect = false
+	config.RestartPolicy = process.RestartNever
+	config.PreStart = nil
+	config.PostStop = nil
+
+	t, err := r.reserveTest(config)
+	if err != nil {
+		return result, err
+	}
+
+	defer func() {
+		r.lock.Lock()
+		defer r.unlock()
+
+		delete(r.tests, t.id)
+		r.nProc--
+
+		t.tee.stopRelays()
+		r.unsetPlayoutPorts(t)
+
+		// The test may have blocked a slot on a device
+		r.startQueued()
+	}()
+
+	t.tee.startRelays()
+
+	if err := t.ffmpeg.Start(); err != nil {
+		return result, err
+	}
+
+	// The errors are collected while the process is running, because the log
+	// may hold only the most recent lines. This includes the errors from the
+	// prelude, e.g. if an input can't be opened.
+	failures := []string{}
+	seen := map[string]bool{}
+
+	collectErrors := func() {
+		for _, line := range t.parser.Log() {
+			key := line.Timestamp.String() + line.Data
+			if seen[key] || !parse.LevelAtLeast(line.Level, "error") {
+				continue
+			}
+
+			seen[key] = true
+			failures = append(failures, line.Data)
+		}
+	}
+
+	start := time.Now()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for time.Since(start) < duration {
+		<-ticker.C
+
+		collectErrors()
+
+		status := t.ffmpeg.Status()
+
+		if status.State == "finished" || status.State == "failed" || status.State == "killed" {
+			result.Exited = true
+			break
+		}
+
+		// The progress is reset when the process exits
+		if status.State == "running" {
+			result.Progress = t.parser.Progress()
+		}
+	}
+
+	result.Duration = time.Since(start)
+
+	t.ffmpeg.Stop(true)
+
+	collectErrors()
+
+	status := t.ffmpeg.Status()
+	entry := logHistoryEntry(t.parser.Report(), app.LogAll)
+
+	result.Prelude = entry.Prelude
+	result.Log = entry.Log
+
+	if result.Exited {
+		result.ExitCode = status.ExitCode
+		result.ExitSignal = status.ExitSignal
+
+		if len(result.ExitSignal) != 0 {
+			result.Diagnostics = append(result.Diagnostics, fmt.Sprintf("the process has been terminated by %s after %s", result.ExitSignal, result.Duration.Round(time.Millisecond)))
+		} else {
+			result.Diagnostics = append(result.Diagnostics, fmt.Sprintf("the process exited with code %d after %s", result.ExitCode, result.Duration.Round(time.Millisecond)))
+		}
+	}
+
+	produced := result.Progress.Frame != 0 || result.Progress.Packet != 0
+	for _, io := range result.Progress.Output {
+		if io.Packet != 0 {
+			produced = true
+		}
+	}
+
+	if !produced {
+		result.Diagnostics = append(result.Diagnostics, "no output has been produced")
+	}
+
+	result.Diagnostics = append(result.Diagnostics, failures...)
+
+	result.Success = !result.Exited && produced
+
+	return result, nil
+}
+
+// reserveTest creates the task for testing the process of the config and reserves its ID, a
+// process slot, and a slot on its device, until the test is done. The reservation must be
+// released by the caller.
+func (r *restream) reserveTest(config *app.Config) (*task, error) {
+	r.lock.Lock()
+	defer r.unlock()
+
+	if r.idInUse(config.ID) {
+		return nil, ErrProcessExists
+	}
+
+	if r.maxProc > 0 && r.nProc >= r.maxProc {
+		return nil, fmt.Errorf("max. number of running processes (%d) reached", r.maxProc)
+	}
+
+	t, err := r.createTask(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.deviceFull(t) {
+		return nil, fmt.Errorf("no free slot on the device '%s'", t.config.Device)
+	}
+
+	if err := r.renewPlayoutPorts(t); err != nil {
+		return nil, err
+	}
+
+	r.tests[t.id] = t
+	r.nProc++
+
+	return t, nil
+}
+
+// idInUse returns whether the ID is used by a process or a process that is currently
+// tested. The caller must hold the lock.
+func (r *restream) idInUse(id string) bool {
+	if _, ok := r.tasks[id]; ok {
+		return true
+	}
+
+	_, ok := r.tests[id]
+
+	return ok
+}
+
+// Validate checks the config in the same way as AddProcess, without adding the process. It
+// returns a copy of the config with the placeholders and references to other processes
+// resolved, as it would be used for ffmpeg. It is not checked whether a process with the
+// same ID already exists, such that a config for an update can be validated as well.
+func (r *restream) Validate(config *app.Config) (*app.Config, error) {
+	if len(strings.TrimSpace(config.ID)) == 0 {
+		return nil, fmt.Errorf("an empty ID is not allowed")
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	config = config.Clone()
+
+	r.setFFVersion(config)
+
+	binary, err := r.selectFFmpeg(config.FFVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := expandOutputs(config, r.replace); err != nil {
+		return nil, err
+	}
+
+	resolvePlaceholders(config, r.replace)
+
+	if err := resolveEnvironment(config, r.replace); err != nil {
+		return nil, err
+	}
+
+	if err := r.resolveAddresses(r.tasks, config); err != nil {
+		return nil, err
+	}
+
+	if _, err := r.validateConfig(config); err != nil {
+		return nil, err
+	}
+
+	if err := validateCapabilities(binary, config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// selectFFmpeg returns the first available ffmpeg binary whose version satisfies the
+// given constraint. The default binary is considered first.
+func (r *restream) selectFFmpeg(constraint string) (ffmpeg.FFmpeg, error) {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ffmpeg version constraint '%s': %w", constraint, err)
+	}
+
+	binaries := append([]ffmpeg.FFmpeg{r.ffmpeg}, r.ffmpegAlt...)
+
+	for _, binary := range binaries {
+		v, err := semver.NewVersion(binary.Skills().FFmpeg.Version)
+		if err != nil {
+			continue
+		}
+
+		if c.Check(v) {
+			return binary, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no available ffmpeg binary satisfies the version constraint '%s'", constraint)
+}
+
 func (r *restream) setCleanup(id string, config *app.Config) {
 	rePrefix := regexp.MustCompile(`^([a-z]+):`)
 
@@ -557,31 +1664,36 @@ func (r *restream) setPlayoutPorts(t *task) error {
 	r.unsetPlayoutPorts(t)
 
 	t.playout = make(map[string]int)
+	t.playoutAt = time.Now()
+
+	if len(r.playout.socketDir) != 0 {
+		t.sockets = make(map[string]string)
+	}
 
 	for i, input := range t.config.Input {
 		if !strings.HasPrefix(input.Address, "avstream:") && !strings.HasPrefix(input.Address, "playout:") {
 			continue
 		}
 
-		options := []string{}
-		skip := false
-
-		for _, o := range input.Options {
-			if skip {
-				continue
-			}
+		options := withoutPlayoutOptions(input.Options)
 
-			if o == "-playout_httpport" {
-				skip = true
-				continue
-			}
+		if t.sockets != nil {
+			// The playout API on the socket is provided by a sidecar, no options for ffmpeg required
+			socket := playoutSocket(r.playout.socketDir, t.id, input.ID)
 
-			options = append(options, o)
-		}
+			t.logger.WithFields(log.Fields{
+				"socket": socket,
+				"input":  input.ID,
+			}).Debug().Log("Assigning playout socket")
 
-		if port, err := r.ffmpeg.GetPort(); err == nil {
+			t.sockets[input.ID] = socket
+		} else if port, err := r.ffmpeg.GetPort(); err == nil {
 			options = append(options, "-playout_httpport", strconv.Itoa(port))
 
+			if len(r.playout.bindHost) != 0 {
+				options = append(options, "-playout_httphost", r.playout.bindHost)
+			}
+
 			t.logger.WithFields(log.Fields{
 				"port":  port,
 				"input": input.ID,
@@ -599,44 +1711,396 @@ func (r *restream) setPlayoutPorts(t *task) error {
 	return nil
 }
 
-func (r *restream) unsetPlayoutPorts(t *task) {
-	if t.playout == nil {
-		return
+// onTeeBranchFailed returns the callback for when a branch of the tee muxer
+// of the task failed.
+func (r *restream) onTeeBranchFailed(t *task) func(branch app.TeeBranchState) {
+	return func(branch app.TeeBranchState) {
+		t.logger.WithFields(log.Fields{
+			"output": branch.OutputID,
+			"branch": branch.Index,
+			"onfail": branch.OnFail,
+		}).WithError(fmt.Errorf("%s", branch.Error)).Warn().Log("Tee branch failed")
 	}
+}
 
-	for _, port := range t.playout {
-		r.ffmpeg.PutPort(port)
+// validateAdvertiseHost checks whether the host can be used to connect to,
+// i.e. it must not be an unspecified or multicast address.
+func validateAdvertiseHost(host string) error {
+	ip := gonet.ParseIP(host)
+	if ip == nil {
+		if strings.ContainsAny(host, ":/ ") {
+			return fmt.Errorf("'%s' is neither an IP address nor a hostname", host)
+		}
+
+		return nil
 	}
 
-	t.playout = nil
+	if ip.IsUnspecified() {
+		return fmt.Errorf("the unspecified address '%s' is not routable", host)
+	}
+
+	if ip.IsMulticast() {
+		return fmt.Errorf("the multicast address '%s' is not routable", host)
+	}
+
+	return nil
 }
 
-func (r *restream) validateConfig(config *app.Config) (bool, error) {
-	if len(config.Input) == 0 {
-		return false, fmt.Errorf("at least one input must be defined for the process '%s'", config.ID)
+// stripPlayoutOptions removes the options for the playout server from the playout inputs.
+// They will be set when the playout ports are assigned, i.e. when the process starts.
+func stripPlayoutOptions(config *app.Config) {
+	for i, input := range config.Input {
+		if !strings.HasPrefix(input.Address, "avstream:") && !strings.HasPrefix(input.Address, "playout:") {
+			continue
+		}
+
+		input.Options = withoutPlayoutOptions(input.Options)
+		config.Input[i] = input
 	}
+}
 
-	var err error
+// renewPlayoutPorts assigns new playout ports to a task whose ports have been
+// released. The ffmpeg process will be re-created with the new ports.
+func (r *restream) renewPlayoutPorts(t *task) error {
+	if err := r.setPlayoutPorts(t); err != nil {
+		r.unsetPlayoutPorts(t)
+		return err
+	}
 
-	ids := map[string]bool{}
+	t.command = t.config.CreateCommand()
 
-	for _, io := range config.Input {
-		io.ID = strings.TrimSpace(io.ID)
+	onStateChange, onStale := r.onStateChange(t)
+	onRestart, onExit := r.hookCallbacks(t)
+
+	ffmpeg, err := t.binary.New(ffmpeg.ProcessConfig{
+		Reconnect:       t.config.Reconnect,
+		ReconnectDelay:  time.Duration(t.config.ReconnectDelay) * time.Second,
+		ReconnectJitter: time.Duration(t.config.ReconnectJitter) * time.Second,
+		RestartPolicy:   t.config.RestartPolicy,
+		MaxRestarts:     t.config.MaxRestarts,
+		RestartWindow:   time.Duration(t.config.RestartWindow) * time.Second,
+		StaleTimeout:    time.Duration(t.config.StaleTimeout) * time.Second,
+		Command:         t.command,
+		Environment:     t.config.Environment,
+		WorkingDir:      t.config.WorkingDir,
+		Parser:          t.parser,
+		LogStdout:       t.config.LogStdout,
+		ProgressPipe:    true,
+		LevelPrefix:     true,
+		CPUAffinity:     t.config.CPUAffinity,
+		Nice:            t.config.Nice,
+		Logger:          t.logger,
+		OnStateChange:   onStateChange,
+		OnStale:         onStale,
+		OnRestart:       onRestart,
+		OnExit:          onExit,
+	})
+	if err != nil {
+		r.unsetPlayoutPorts(t)
+		return err
+	}
 
-		if len(io.ID) == 0 {
-			return false, fmt.Errorf("empty input IDs are not allowed (process '%s')", config.ID)
-		}
+	t.ffmpeg = ffmpeg
 
-		if _, found := ids[io.ID]; found {
-			return false, fmt.Errorf("the input ID '%s' is already in use for the process `%s`", io.ID, config.ID)
-		}
+	return nil
+}
 
-		ids[io.ID] = true
+// hasPlayoutInput returns whether the config has an input with the given ID that
+// provides a playout API.
+// inputFormat returns the value of the last -f option of an input, i.e. the forced demuxer,
+// or an empty string if the demuxer is not forced.
+func inputFormat(options []string) string {
+	format := ""
 
-		io.Address = strings.TrimSpace(io.Address)
+	for i := 0; i < len(options)-1; i++ {
+		if options[i] == "-f" {
+			format = options[i+1]
+		}
+	}
 
-		if len(io.Address) == 0 {
-			return false, fmt.Errorf("the address for input '#%s:%s' must not be empty", config.ID, io.ID)
+	return format
+}
+
+func hasPlayoutInput(config *app.Config, inputid string) bool {
+	for _, input := range config.Input {
+		if input.ID != inputid {
+			continue
+		}
+
+		return strings.HasPrefix(input.Address, "avstream:") || strings.HasPrefix(input.Address, "playout:")
+	}
+
+	return false
+}
+
+func (r *restream) unsetPlayoutPorts(t *task) {
+	if t.playout == nil {
+		return
+	}
+
+	r.dirty = true
+
+	for _, port := range t.playout {
+		r.ffmpeg.PutPort(port)
+	}
+
+	for _, socket := range t.sockets {
+		playout.CloseClient(socket)
+
+		if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+			t.logger.WithField("socket", socket).WithError(err).Warn().Log("Removing playout socket")
+		}
+	}
+
+	t.playout = nil
+	t.playoutAt = time.Time{}
+	t.sockets = nil
+}
+
+// playoutSocket returns the path of the unix socket for the playout API of an input of a
+// process. The IDs are hashed in order to get a valid filename that fits into the length
+// limit of socket paths.
+func playoutSocket(dir, id, inputid string) string {
+	sum := sha256.Sum256([]byte(id + "\x00" + inputid))
+
+	return filepath.Join(dir, "playout_"+hex.EncodeToString(sum[:8])+".sock")
+}
+
+// validateEnvironment checks that the environment variables can be passed to a process.
+func validateEnvironment(env map[string]string) error {
+	for k, v := range env {
+		if len(k) == 0 {
+			return fmt.Errorf("empty variable names are not allowed")
+		}
+
+		if strings.ContainsAny(k, "=\n\r\x00") {
+			return fmt.Errorf("the variable name '%s' contains invalid characters", k)
+		}
+
+		if strings.ContainsAny(v, "\n\r\x00") {
+			return fmt.Errorf("the value of the variable '%s' contains invalid characters", k)
+		}
+	}
+
+	return nil
+}
+
+// streamTypes maps the stream type of a stream specifier to the type as reported by a probe
+var streamTypes = map[string]string{
+	"v": "video",
+	"V": "video",
+	"a": "audio",
+	"s": "subtitle",
+	"d": "data",
+	"t": "attachment",
+}
+
+// validateStreamMapping checks whether the -map options of the outputs refer to streams that
+// exist in the probed inputs.
+func validateStreamMapping(config *app.Config, probe app.Probe) error {
+	if len(probe.Streams) == 0 {
+		return fmt.Errorf("the inputs of the process '%s' can't be probed in order to validate the stream mapping", config.ID)
+	}
+
+	for _, io := range config.Output {
+		for i := 0; i < len(io.Options)-1; i++ {
+			if io.Options[i] != "-map" {
+				continue
+			}
+
+			i++
+
+			if !matchesStream(io.Options[i], probe.Streams) {
+				return fmt.Errorf("the mapping '%s' of output '#%s:%s' doesn't refer to any stream of the inputs", io.Options[i], config.ID, io.ID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// matchesStream returns whether the -map specifier refers to at least one of the streams. Labels of
+// filter graph outputs, negative and optional mappings, and stream specifiers that can't be checked
+// against a probe (e.g. programs or metadata) are considered to match.
+func matchesStream(spec string, streams []app.ProbeIO) bool {
+	if strings.HasPrefix(spec, "[") || strings.HasPrefix(spec, "-") || strings.HasSuffix(spec, "?") {
+		return true
+	}
+
+	parts := strings.Split(spec, ":")
+
+	index, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return true
+	}
+
+	kind := ""
+	stream := -1
+
+	switch len(parts) {
+	case 1:
+	case 2:
+		if n, err := strconv.Atoi(parts[1]); err == nil {
+			stream = n
+		} else if t, ok := streamTypes[parts[1]]; ok {
+			kind = t
+		} else {
+			return true
+		}
+	case 3:
+		t, ok := streamTypes[parts[1]]
+		if !ok {
+			return true
+		}
+
+		n, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return true
+		}
+
+		kind, stream = t, n
+	default:
+		return true
+	}
+
+	// Index of the stream among the streams of the same type
+	n := 0
+
+	for _, s := range streams {
+		if s.Index != index {
+			continue
+		}
+
+		if len(kind) != 0 && s.Type != kind {
+			continue
+		}
+
+		if stream < 0 {
+			return true
+		}
+
+		if len(kind) == 0 {
+			if s.Stream == uint64(stream) {
+				return true
+			}
+
+			continue
+		}
+
+		if n == stream {
+			return true
+		}
+
+		n++
+	}
+
+	return false
+}
+
+func (r *restream) validateConfig(config *app.Config) (bool, error) {
+	if len(config.Input) == 0 {
+		return false, fmt.Errorf("at least one input must be defined for the process '%s'", config.ID)
+	}
+
+	if !store.IsValidTenant(config.Tenant) {
+		return false, fmt.Errorf("the tenant '%s' of the process '%s' may only contain letters, digits, '-', and '_'", config.Tenant, config.ID)
+	}
+
+	if config.InputBuffer > maxInputBuffer {
+		return false, fmt.Errorf("the input buffer of the process '%s' must not be longer than %d milliseconds", config.ID, maxInputBuffer)
+	}
+
+	for _, input := range config.Input {
+		if len(input.Each) != 0 {
+			return false, fmt.Errorf("the input '%s' of the process '%s' can't be expanded, only outputs can", input.ID, config.ID)
+		}
+	}
+
+	for _, output := range config.Output {
+		if len(output.Each) != 0 {
+			return false, fmt.Errorf("the output '%s' of the process '%s' hasn't been expanded", output.ID, config.ID)
+		}
+	}
+
+	if err := checkPlaceholderSections(config, r.replace); err != nil {
+		return false, fmt.Errorf("invalid placeholder in the process '%s': %w", config.ID, err)
+	}
+
+	if err := validateEnvironment(config.Environment); err != nil {
+		return false, fmt.Errorf("invalid environment for the process '%s': %w", config.ID, err)
+	}
+
+	if len(config.WorkingDir) != 0 && !filepath.IsAbs(config.WorkingDir) {
+		return false, fmt.Errorf("the working directory for the process '%s' must be an absolute path", config.ID)
+	}
+
+	for name, hook := range map[string]*app.ConfigHook{"pre_start": config.PreStart, "post_stop": config.PostStop} {
+		if hook == nil {
+			continue
+		}
+
+		if len(hook.Binary) == 0 {
+			return false, fmt.Errorf("the %s hook for the process '%s' requires a binary", name, config.ID)
+		}
+
+		if !r.hookBins[filepath.Clean(hook.Binary)] {
+			return false, fmt.Errorf("the binary '%s' of the %s hook for the process '%s' is not allowed", hook.Binary, name, config.ID)
+		}
+	}
+
+	if _, ok := r.devices[config.Device]; len(config.Device) != 0 && !ok {
+		return false, fmt.Errorf("the device '%s' for the process '%s' doesn't exist", config.Device, config.ID)
+	}
+
+	if config.MaxRestarts < 0 {
+		return false, fmt.Errorf("the maximum number of restarts for the process '%s' must not be negative", config.ID)
+	}
+
+	for _, cpu := range config.CPUAffinity {
+		if cpu < 0 || cpu >= runtime.NumCPU() {
+			return false, fmt.Errorf("the CPU %d for the affinity of the process '%s' doesn't exist, valid CPUs are 0 to %d", cpu, config.ID, runtime.NumCPU()-1)
+		}
+	}
+
+	if config.Nice < -20 || config.Nice > 19 {
+		return false, fmt.Errorf("the niceness of the process '%s' must be between -20 and 19", config.ID)
+	}
+
+	switch config.RestartPolicy {
+	case "", process.RestartAlways, process.RestartOnFailure, process.RestartNever:
+	default:
+		return false, fmt.Errorf("unknown restart policy '%s' for the process '%s', known policies are: %s, %s, %s", config.RestartPolicy, config.ID, process.RestartAlways, process.RestartOnFailure, process.RestartNever)
+	}
+
+	if err := r.validateOptions(config.Options); err != nil {
+		return false, fmt.Errorf("invalid global options for the process '%s': %w", config.ID, err)
+	}
+
+	var err error
+
+	ids := map[string]bool{}
+
+	for _, io := range config.Input {
+		io.ID = strings.TrimSpace(io.ID)
+
+		if len(io.ID) == 0 {
+			return false, fmt.Errorf("empty input IDs are not allowed (process '%s')", config.ID)
+		}
+
+		if err := r.validateOptions(io.Options); err != nil {
+			return false, fmt.Errorf("invalid options for input '#%s:%s': %w", config.ID, io.ID, err)
+		}
+
+		if _, found := ids[io.ID]; found {
+			return false, fmt.Errorf("the input ID '%s' is already in use for the process `%s`", io.ID, config.ID)
+		}
+
+		ids[io.ID] = true
+
+		io.Address = strings.TrimSpace(io.Address)
+
+		if len(io.Address) == 0 {
+			return false, fmt.Errorf("the address for input '#%s:%s' must not be empty", config.ID, io.ID)
 		}
 
 		if len(r.fs.diskfs) != 0 {
@@ -673,6 +2137,10 @@ func (r *restream) validateConfig(config *app.Config) (bool, error) {
 			return false, fmt.Errorf("empty output IDs are not allowed (process '%s')", config.ID)
 		}
 
+		if err := r.validateOptions(io.Options); err != nil {
+			return false, fmt.Errorf("invalid options for output '#%s:%s': %w", config.ID, io.ID, err)
+		}
+
 		if _, found := ids[io.ID]; found {
 			return false, fmt.Errorf("the output ID '%s' is already in use for the process `%s`", io.ID, config.ID)
 		}
@@ -689,7 +2157,7 @@ func (r *restream) validateConfig(config *app.Config) (bool, error) {
 			maxFails := 0
 			for _, fs := range r.fs.diskfs {
 				isFile := false
-				io.Address, isFile, err = r.validateOutputAddress(io.Address, fs.Metadata("base"))
+				io.Address, isFile, err = r.validateOutputAddress(io.Address, fs.Metadata("base"), config.WorkingDir)
 				if err != nil {
 					maxFails++
 				}
@@ -704,7 +2172,7 @@ func (r *restream) validateConfig(config *app.Config) (bool, error) {
 			}
 		} else {
 			isFile := false
-			io.Address, isFile, err = r.validateOutputAddress(io.Address, "/")
+			io.Address, isFile, err = r.validateOutputAddress(io.Address, "/", config.WorkingDir)
 			if err != nil {
 				return false, fmt.Errorf("the address for output '#%s:%s' is invalid: %w", config.ID, io.ID, err)
 			}
@@ -718,6 +2186,39 @@ func (r *restream) validateConfig(config *app.Config) (bool, error) {
 	return hasFiles, nil
 }
 
+// validateOptions rejects options with null bytes and, if an allowlist is configured, flags
+// that are not allowed. Options that start with a "-" followed by a letter are considered flags,
+// such that values like negative numbers or "-" are not.
+func (r *restream) validateOptions(options []string) error {
+	for _, option := range options {
+		if strings.ContainsRune(option, 0) {
+			return fmt.Errorf("the option %q contains a null byte", option)
+		}
+
+		if r.allowlist == nil || !isOptionFlag(option) {
+			continue
+		}
+
+		flag, _, _ := strings.Cut(strings.TrimPrefix(option, "-"), ":")
+
+		if !r.allowlist[flag] {
+			return fmt.Errorf("the option '%s' is not allowed", option)
+		}
+	}
+
+	return nil
+}
+
+func isOptionFlag(option string) bool {
+	if len(option) < 2 || option[0] != '-' {
+		return false
+	}
+
+	c := option[1]
+
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
 func (r *restream) validateInputAddress(address, basedir string) (string, error) {
 	if ok := url.HasScheme(address); ok {
 		if err := url.Validate(address); err != nil {
@@ -732,25 +2233,29 @@ func (r *restream) validateInputAddress(address, basedir string) (string, error)
 	return address, nil
 }
 
-func (r *restream) validateOutputAddress(address, basedir string) (string, bool, error) {
+// validateOutputAddress validates the output address of a process. Relative paths
+// are resolved against the working directory, or the current directory if none is given.
+func (r *restream) validateOutputAddress(address, basedir, workdir string) (string, bool, error) {
 	// If the address contains a "|" or it starts with a "[", then assume that it
 	// is an address for the tee muxer.
-	if strings.Contains(address, "|") || strings.HasPrefix(address, "[") {
+	if isTeeAddress(address) {
 		addresses := strings.Split(address, "|")
 
 		isFile := false
 
-		teeOptions := regexp.MustCompile(`^\[[^\]]*\]`)
-
 		for i, a := range addresses {
-			options := teeOptions.FindString(a)
-			a = teeOptions.ReplaceAllString(a, "")
+			options := reTeeOptions.FindString(a)
+			a = reTeeOptions.ReplaceAllString(a, "")
 
-			va, file, err := r.validateOutputAddress(a, basedir)
-			if err != nil {
+			if err := validateTeeOnFail(options); err != nil {
 				return address, false, err
 			}
 
+			va, file, err := r.validateOutputAddress(a, basedir, workdir)
+			if err != nil {
+				return address, false, fmt.Errorf("tee branch %d: %w", i, err)
+			}
+
 			if file {
 				isFile = true
 			}
@@ -768,6 +2273,10 @@ func (r *restream) validateOutputAddress(address, basedir string) (string, bool,
 			return address, false, err
 		}
 
+		if scheme := url.Scheme(address); r.schemes != nil && !r.schemes[scheme] {
+			return address, false, fmt.Errorf("the scheme '%s' is not allowed for outputs", scheme)
+		}
+
 		if !r.ffmpeg.ValidateOutputAddress(address) {
 			return address, false, fmt.Errorf("address is not allowed")
 		}
@@ -779,6 +2288,10 @@ func (r *restream) validateOutputAddress(address, basedir string) (string, bool,
 		return "pipe:", false, nil
 	}
 
+	if len(workdir) != 0 && !filepath.IsAbs(address) {
+		address = filepath.Join(workdir, address)
+	}
+
 	address, err := filepath.Abs(address)
 	if err != nil {
 		return address, false, fmt.Errorf("not a valid path (%w)", err)
@@ -819,9 +2332,9 @@ func (r *restream) resolveAddresses(tasks map[string]*task, config *app.Config)
 	return nil
 }
 
-func (r *restream) resolveAddress(tasks map[string]*task, id, address string) (string, error) {
-	re := regexp.MustCompile(`^#(.+):output=(.+)`)
+var reReference = regexp.MustCompile(`^#(.+):output=(.+)`)
 
+func (r *restream) resolveAddress(tasks map[string]*task, id, address string) (string, error) {
 	if len(address) == 0 {
 		return address, fmt.Errorf("empty address")
 	}
@@ -830,7 +2343,7 @@ func (r *restream) resolveAddress(tasks map[string]*task, id, address string) (s
 		return address, nil
 	}
 
-	matches := re.FindStringSubmatch(address)
+	matches := reReference.FindStringSubmatch(address)
 	if matches == nil {
 		return address, fmt.Errorf("invalid format (%s)", address)
 	}
@@ -854,9 +2367,43 @@ func (r *restream) resolveAddress(tasks map[string]*task, id, address string) (s
 }
 
 func (r *restream) UpdateProcess(id string, config *app.Config) error {
+	if !r.allowChange() {
+		return ErrRateLimited
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.dirty = true
+
+	return r.updateProcess(id, config)
+}
+
+var ErrVersionConflict = errors.New("the process has been changed in the meantime")
+
+func (r *restream) UpdateProcessIf(id string, version uint64, config *app.Config) error {
+	if !r.allowChange() {
+		return ErrRateLimited
+	}
+
 	r.lock.Lock()
-	defer r.lock.Unlock()
+	defer r.unlock()
+
+	r.dirty = true
+
+	task, ok := r.tasks[id]
+	if !ok {
+		return ErrUnknownProcess
+	}
+
+	if task.process.Version != version {
+		return ErrVersionConflict
+	}
 
+	return r.updateProcess(id, config)
+}
+
+func (r *restream) updateProcess(id string, config *app.Config) error {
 	t, err := r.createTask(config)
 	if err != nil {
 		return err
@@ -864,23 +2411,28 @@ func (r *restream) UpdateProcess(id string, config *app.Config) error {
 
 	task, ok := r.tasks[id]
 	if !ok {
+		r.unsetPlayoutPorts(t)
 		return ErrUnknownProcess
 	}
 
 	t.process.Order = task.process.Order
+	t.process.Version = task.process.Version + 1
+	t.process.Drained = task.process.Drained
 
 	if id != t.id {
-		_, ok := r.tasks[t.id]
-		if ok {
+		if r.idInUse(t.id) {
+			r.unsetPlayoutPorts(t)
 			return ErrProcessExists
 		}
 	}
 
 	if err := r.stopProcess(id); err != nil {
+		r.unsetPlayoutPorts(t)
 		return err
 	}
 
 	if err := r.deleteProcess(id); err != nil {
+		r.unsetPlayoutPorts(t)
 		return err
 	}
 
@@ -889,519 +2441,2702 @@ func (r *restream) UpdateProcess(id string, config *app.Config) error {
 	// set filesystem cleanup rules
 	r.setCleanup(t.id, t.config)
 
-	if t.process.Order == "start" {
+	if t.process.Order == "start" && !t.process.Drained {
 		r.startProcess(t.id)
 	}
 
+	r.syncOnDemand()
+	r.startQueued()
+
 	r.save()
 
 	return nil
 }
 
-func (r *restream) GetProcessIDs(idpattern, refpattern string) []string {
-	r.lock.RLock()
-	defer r.lock.RUnlock()
+// RenameProcess changes the ID of a process to newid. In contrast to an update with a new ID, the
+// metadata and the time of creation are kept, and the inputs of other processes that reference the
+// process are changed to the new ID. The renamed process is restarted if it is running. A referencing
+// process is reloaded, i.e. restarted if it is running, only if its command changed, e.g. because the
+// addresses of the outputs of the renamed process contain its ID.
+func (r *restream) RenameProcess(id, newid string) error {
+	if len(strings.TrimSpace(newid)) == 0 {
+		return fmt.Errorf("an empty ID is not allowed")
+	}
 
-	if len(idpattern) == 0 && len(refpattern) == 0 {
-		ids := make([]string, len(r.tasks))
-		i := 0
+	if !r.allowChange() {
+		return ErrRateLimited
+	}
 
-		for id := range r.tasks {
-			ids[i] = id
-			i++
-		}
+	r.lock.Lock()
+	defer r.unlock()
 
-		return ids
-	}
+	r.dirty = true
 
-	idmap := map[string]int{}
-	count := 0
+	task, ok := r.tasks[id]
+	if !ok {
+		return ErrUnknownProcess
+	}
 
-	if len(idpattern) != 0 {
-		for id := range r.tasks {
-			match, err := glob.Match(idpattern, id)
-			if err != nil {
-				return nil
-			}
+	if id == newid {
+		return nil
+	}
 
-			if !match {
-				continue
-			}
+	if r.idInUse(newid) {
+		return ErrProcessExists
+	}
 
-			idmap[id]++
-		}
+	config := task.process.Config.Clone()
+	config.ID = newid
 
-		count++
+	if err := r.updateProcess(id, config); err != nil {
+		return err
 	}
 
-	if len(refpattern) != 0 {
-		for _, t := range r.tasks {
-			match, err := glob.Match(refpattern, t.reference)
-			if err != nil {
-				return nil
-			}
+	t := r.tasks[newid]
+	t.process.CreatedAt = task.process.CreatedAt
+	t.metadata = task.metadata
 
-			if !match {
+	errs := []string{}
+
+	for _, d := range r.tasks {
+		config := d.process.Config.Clone()
+		changed := false
+
+		for i, input := range config.Input {
+			matches := reReference.FindStringSubmatch(input.Address)
+			if matches == nil || matches[1] != id {
 				continue
 			}
 
-			idmap[t.id]++
+			input.Address = "#" + newid + ":output=" + matches[2]
+			config.Input[i] = input
+			changed = true
 		}
 
-		count++
-	}
-
-	ids := []string{}
+		if !changed {
+			continue
+		}
 
-	for id, n := range idmap {
-		if n != count {
+		d.process.Config = config
+		d.process.Version++
+
+		if reload, err := r.commandChanged(d); err == nil && !reload {
 			continue
 		}
 
-		ids = append(ids, id)
+		if err := r.reloadProcess(d.id); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", d.id, err))
+		}
 	}
 
-	return ids
-}
+	r.syncOnDemand()
+	r.startQueued()
 
-func (r *restream) GetProcess(id string) (*app.Process, error) {
-	r.lock.RLock()
-	defer r.lock.RUnlock()
+	r.save()
 
-	task, ok := r.tasks[id]
-	if !ok {
-		return &app.Process{}, ErrUnknownProcess
+	if len(errs) != 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("the process has been renamed, but reloading the referencing processes failed: %s", strings.Join(errs, "; "))
 	}
 
-	process := task.process.Clone()
-
-	return process, nil
+	return nil
 }
 
-func (r *restream) DeleteProcess(id string) error {
-	r.lock.Lock()
-	defer r.lock.Unlock()
-
-	err := r.deleteProcess(id)
-	if err != nil {
-		return err
+// ReorderOutputs rearranges the outputs of a process such that they are in the same order as
+// the given output IDs. The order must contain each ID of the outputs exactly once. The process
+// is updated, i.e. restarted if it is running, only if the order changed.
+func (r *restream) ReorderOutputs(id string, order []string) error {
+	if !r.allowChange() {
+		return ErrRateLimited
 	}
 
-	r.save()
+	r.lock.Lock()
+	defer r.unlock()
 
-	return nil
-}
+	r.dirty = true
 
-func (r *restream) deleteProcess(id string) error {
 	task, ok := r.tasks[id]
 	if !ok {
 		return ErrUnknownProcess
 	}
 
-	if task.process.Order != "stop" {
-		return fmt.Errorf("the process with the ID '%s' is still running", id)
+	config := task.process.Config.Clone()
+
+	if len(order) != len(config.Output) {
+		return fmt.Errorf("the order must contain all %d output IDs exactly once", len(config.Output))
 	}
 
-	r.unsetPlayoutPorts(task)
-	r.unsetCleanup(id)
+	outputs := map[string]app.ConfigIO{}
+	for _, output := range config.Output {
+		outputs[output.ID] = output
+	}
 
-	delete(r.tasks, id)
+	reordered := make([]app.ConfigIO, 0, len(order))
+	changed := false
 
-	return nil
-}
+	for i, outputid := range order {
+		output, ok := outputs[outputid]
+		if !ok {
+			return fmt.Errorf("unknown or duplicate output ID '%s'", outputid)
+		}
 
-func (r *restream) StartProcess(id string) error {
-	r.lock.Lock()
-	defer r.lock.Unlock()
+		delete(outputs, outputid)
 
-	err := r.startProcess(id)
-	if err != nil {
-		return err
+		if config.Output[i].ID != outputid {
+			changed = true
+		}
+
+		reordered = append(reordered, output)
 	}
 
-	r.save()
+	if !changed {
+		return nil
+	}
 
-	return nil
+	config.Output = reordered
+
+	return r.updateProcess(id, config)
 }
 
-func (r *restream) startProcess(id string) error {
-	task, ok := r.tasks[id]
-	if !ok {
-		return ErrUnknownProcess
-	}
+func (r *restream) GetProcessIDs(idpattern, refpattern string) []string {
+	return processIDs(r.view().tasks, idpattern, refpattern)
+}
 
-	if !task.valid {
-		return fmt.Errorf("invalid process definition")
-	}
+func (r *restream) GetProcessIDsByState(order, state, idpattern, refpattern string) []string {
+	return r.GetProcessIDsByFilter(ProcessFilter{
+		ID:        idpattern,
+		Reference: refpattern,
+		Order:     order,
+		State:     state,
+	})
+}
 
-	status := task.ffmpeg.Status()
+// ProcessFilter selects processes. Empty criteria are ignored, all others must match.
+type ProcessFilter struct {
+	ID        string // Glob pattern for the ID
+	Reference string // Glob pattern for the reference
+	Order     string // Order of the process, "start" or "stop"
+	State     string // State of the process, e.g. "running" or "queued"
+}
 
-	if task.process.Order == "start" && status.Order == "start" {
-		return nil
-	}
+func (r *restream) GetProcessIDsByFilter(filter ProcessFilter) []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
 
-	if r.maxProc > 0 && r.nProc >= r.maxProc {
-		return fmt.Errorf("max. number of running processes (%d) reached", r.maxProc)
+	ids := []string{}
+
+	for _, task := range r.filterTasks(filter) {
+		ids = append(ids, task.id)
 	}
 
-	task.process.Order = "start"
+	return ids
+}
 
-	task.ffmpeg.Start()
+// ListProcesses returns a summary of each process matching the filter, sorted by the ID. The
+// summaries are collected while holding the lock once, such that they are consistent.
+func (r *restream) ListProcesses(filter ProcessFilter) []app.ProcessSummary {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
 
-	r.nProc++
+	summaries := []app.ProcessSummary{}
 
-	return nil
-}
+	for _, task := range r.filterTasks(filter) {
+		summary := app.ProcessSummary{
+			ID:        task.id,
+			Reference: task.reference,
+			Order:     task.process.Order,
+			ExitCode:  -1,
+		}
 
-func (r *restream) StopProcess(id string) error {
-	r.lock.Lock()
-	defer r.lock.Unlock()
+		if task.valid {
+			status := task.ffmpeg.Status()
 
-	err := r.stopProcess(id)
-	if err != nil {
-		return err
+			summary.State = taskState(task, status)
+			summary.ExitCode = status.ExitCode
+		}
+
+		summaries = append(summaries, summary)
 	}
 
-	r.save()
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].ID < summaries[j].ID
+	})
 
-	return nil
+	return summaries
 }
 
-func (r *restream) stopProcess(id string) error {
-	task, ok := r.tasks[id]
-	if !ok {
-		return ErrUnknownProcess
-	}
+// filterTasks returns the tasks that match the filter. The state of invalid tasks
+// is unknown, such that they don't match any state. The caller must hold the lock.
+func (r *restream) filterTasks(filter ProcessFilter) []*task {
+	tasks := []*task{}
 
-	if task.ffmpeg == nil {
-		return nil
+	for _, id := range processIDs(r.tasks, filter.ID, filter.Reference) {
+		task := r.tasks[id]
+
+		if len(filter.Order) != 0 && task.process.Order != filter.Order {
+			continue
+		}
+
+		if len(filter.State) != 0 {
+			if !task.valid {
+				continue
+			}
+
+			if taskState(task, task.ffmpeg.Status()) != filter.State {
+				continue
+			}
+		}
+
+		tasks = append(tasks, task)
 	}
 
-	status := task.ffmpeg.Status()
+	return tasks
+}
 
-	if task.process.Order == "stop" && status.Order == "stop" {
-		return nil
+// processIDs returns the IDs of the tasks that match the glob patterns for the ID and the
+// reference. Empty patterns are ignored.
+func processIDs(tasks map[string]*task, idpattern, refpattern string) []string {
+	if len(idpattern) == 0 && len(refpattern) == 0 {
+		ids := make([]string, len(tasks))
+		i := 0
+
+		for id := range tasks {
+			ids[i] = id
+			i++
+		}
+
+		return ids
 	}
 
-	task.process.Order = "stop"
+	idmap := map[string]int{}
+	count := 0
 
-	task.ffmpeg.Stop(true)
+	if len(idpattern) != 0 {
+		for id := range tasks {
+			match, err := glob.Match(idpattern, id)
+			if err != nil {
+				return nil
+			}
 
-	r.nProc--
+			if !match {
+				continue
+			}
 
-	return nil
-}
+			idmap[id]++
+		}
 
-func (r *restream) RestartProcess(id string) error {
-	r.lock.RLock()
-	defer r.lock.RUnlock()
+		count++
+	}
 
-	return r.restartProcess(id)
-}
+	if len(refpattern) != 0 {
+		for _, t := range tasks {
+			match, err := glob.Match(refpattern, t.reference)
+			if err != nil {
+				return nil
+			}
 
-func (r *restream) restartProcess(id string) error {
-	task, ok := r.tasks[id]
-	if !ok {
-		return ErrUnknownProcess
+			if !match {
+				continue
+			}
+
+			idmap[t.id]++
+		}
+
+		count++
 	}
 
-	if !task.valid {
-		return fmt.Errorf("invalid process definition")
+	ids := []string{}
+
+	for id, n := range idmap {
+		if n != count {
+			continue
+		}
+
+		ids = append(ids, id)
 	}
 
-	if task.process.Order == "stop" {
-		return nil
+	return ids
+}
+
+func (r *restream) GetProcess(id string) (*app.Process, error) {
+	task, ok := r.view().tasks[id]
+	if !ok {
+		return &app.Process{}, ErrUnknownProcess
 	}
 
-	task.ffmpeg.Kill(true)
+	process := task.process.Clone()
 
-	return nil
+	return process, nil
 }
 
-func (r *restream) ReloadProcess(id string) error {
+func (r *restream) DeleteProcess(id string) error {
+	if !r.allowChange() {
+		return ErrRateLimited
+	}
+
 	r.lock.Lock()
-	defer r.lock.Unlock()
+	defer r.unlock()
 
-	err := r.reloadProcess(id)
+	r.dirty = true
+
+	err := r.stopProcess(id)
+	if err != nil {
+		return err
+	}
+
+	err = r.deleteProcess(id)
 	if err != nil {
 		return err
 	}
 
+	r.startQueued()
+
 	r.save()
 
 	return nil
 }
 
-func (r *restream) reloadProcess(id string) error {
-	t, ok := r.tasks[id]
-	if !ok {
-		return ErrUnknownProcess
+var ErrProcessReferenced = errors.New("the process is referenced by other processes")
+
+func (r *restream) DeleteUnreferencedProcess(id string) error {
+	if !r.allowChange() {
+		return ErrRateLimited
 	}
 
-	t.valid = false
+	r.lock.Lock()
+	defer r.unlock()
 
-	t.config = t.process.Config.Clone()
+	r.dirty = true
 
-	resolvePlaceholders(t.config, r.replace)
+	if _, ok := r.tasks[id]; !ok {
+		return ErrUnknownProcess
+	}
 
-	err := r.resolveAddresses(r.tasks, t.config)
-	if err != nil {
-		return err
+	// The process is only stopped after it is known that it can be deleted
+	if inbound, _ := r.getReferences(id); len(inbound) != 0 {
+		return ErrProcessReferenced
 	}
 
-	t.usesDisk, err = r.validateConfig(t.config)
+	err := r.stopProcess(id)
 	if err != nil {
 		return err
 	}
 
-	err = r.setPlayoutPorts(t)
+	err = r.deleteProcess(id)
 	if err != nil {
 		return err
 	}
 
-	t.command = t.config.CreateCommand()
-
-	order := "stop"
-	if t.process.Order == "start" {
-		order = "start"
-		r.stopProcess(id)
-	}
+	r.startQueued()
 
-	t.parser = r.ffmpeg.NewProcessParser(t.logger, t.id, t.reference)
+	r.save()
 
-	ffmpeg, err := r.ffmpeg.New(ffmpeg.ProcessConfig{
-		Reconnect:      t.config.Reconnect,
-		ReconnectDelay: time.Duration(t.config.ReconnectDelay) * time.Second,
-		StaleTimeout:   time.Duration(t.config.StaleTimeout) * time.Second,
-		Command:        t.command,
-		Parser:         t.parser,
-		Logger:         t.logger,
-	})
-	if err != nil {
-		return err
-	}
+	return nil
+}
 
-	t.ffmpeg = ffmpeg
-	t.valid = true
+// DeleteOptions are the options for deleting multiple processes at once.
+type DeleteOptions struct {
+	// All allows to delete all processes if no patterns are given.
+	All bool
 
-	if order == "start" {
-		r.startProcess(id)
-	}
+	// PurgeOnDelete removes the files of all cleanup rules of a process, regardless
+	// of their own PurgeOnDelete setting.
+	PurgeOnDelete bool
 
-	return nil
+	// Unreferenced refuses to delete processes that are referenced by other processes
+	// that are not deleted as well.
+	Unreferenced bool
 }
 
-func (r *restream) GetProcessState(id string) (*app.State, error) {
-	state := &app.State{}
+var ErrEmptyPattern = errors.New("no pattern given, set All in order to delete all processes")
 
-	r.lock.RLock()
-	defer r.lock.RUnlock()
+// DeleteProcesses deletes the processes matching the patterns for the ID and the reference,
+// with the same semantics as GetProcessIDs. It returns the IDs of the deleted processes, sorted,
+// and an error for each process that couldn't be deleted.
+func (r *restream) DeleteProcesses(idpattern, refpattern string, opts DeleteOptions) ([]string, []error) {
+	if len(idpattern) == 0 && len(refpattern) == 0 && !opts.All {
+		return []string{}, []error{ErrEmptyPattern}
+	}
 
-	task, ok := r.tasks[id]
-	if !ok {
-		return state, ErrUnknownProcess
+	if !r.allowChange() {
+		return []string{}, []error{ErrRateLimited}
 	}
 
-	if !task.valid {
-		return state, nil
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.dirty = true
+
+	ids := processIDs(r.tasks, idpattern, refpattern)
+	if ids == nil {
+		return []string{}, []error{fmt.Errorf("invalid pattern")}
 	}
 
-	status := task.ffmpeg.Status()
+	sort.Strings(ids)
 
-	state.Order = task.process.Order
-	state.State = status.State
-	state.States.Marshal(status.States)
-	state.Time = status.Time.Unix()
-	state.Memory = status.Memory
-	state.CPU = status.CPU
-	state.Duration = status.Duration.Round(10 * time.Millisecond).Seconds()
+	deleted := []string{}
+	errs := []error{}
+
+	// Processes referencing each other will be deleted in the order of their references,
+	// such that a process referenced only by deleted processes can be deleted as well.
+	pending := ids
+	for len(pending) != 0 {
+		remaining := []string{}
+
+		for _, id := range pending {
+			if opts.Unreferenced {
+				if inbound, _ := r.getReferences(id); len(inbound) != 0 {
+					remaining = append(remaining, id)
+					continue
+				}
+			}
+
+			task := r.tasks[id]
+
+			if opts.PurgeOnDelete && task.process.Order == "stop" {
+				r.setPurgeOnDelete(task)
+			}
+
+			if err := r.deleteProcess(id); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", id, err))
+				continue
+			}
+
+			deleted = append(deleted, id)
+		}
+
+		if len(remaining) == len(pending) {
+			for _, id := range remaining {
+				errs = append(errs, fmt.Errorf("%s: %w", id, ErrProcessReferenced))
+			}
+
+			break
+		}
+
+		pending = remaining
+	}
+
+	sort.Strings(deleted)
+
+	if len(deleted) != 0 {
+		r.save()
+	}
+
+	return deleted, errs
+}
+
+// setPurgeOnDelete sets the cleanup rules of the task such that all their
+// files will be removed when the cleanup rules are unset.
+func (r *restream) setPurgeOnDelete(t *task) {
+	config := t.config.Clone()
+
+	for i, output := range config.Output {
+		for j := range output.Cleanup {
+			output.Cleanup[j].PurgeOnDelete = true
+		}
+
+		config.Output[i] = output
+	}
+
+	r.setCleanup(t.id, config)
+}
+
+func (r *restream) GetReferences(id string) ([]app.Reference, []app.Reference, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if _, ok := r.tasks[id]; !ok {
+		return nil, nil, ErrUnknownProcess
+	}
+
+	inbound, outbound := r.getReferences(id)
+
+	return inbound, outbound, nil
+}
+
+// getReferences returns the references of other processes to this process (inbound)
+// and the references of this process to other processes (outbound).
+func (r *restream) getReferences(id string) ([]app.Reference, []app.Reference) {
+	inbound := []app.Reference{}
+	outbound := []app.Reference{}
+
+	for _, t := range r.tasks {
+		for _, input := range t.process.Config.Input {
+			matches := reReference.FindStringSubmatch(input.Address)
+			if matches == nil {
+				continue
+			}
+
+			ref := app.Reference{
+				ProcessID: t.id,
+				InputID:   input.ID,
+				TargetID:  matches[1],
+				OutputID:  matches[2],
+			}
+
+			if target, ok := r.tasks[ref.TargetID]; ok {
+				for _, output := range target.config.Output {
+					if output.ID == ref.OutputID {
+						ref.Exists = true
+						break
+					}
+				}
+			}
+
+			if ref.TargetID == id {
+				inbound = append(inbound, ref)
+			}
+
+			if ref.ProcessID == id {
+				outbound = append(outbound, ref)
+			}
+		}
+	}
+
+	return inbound, outbound
+}
+
+func (r *restream) deleteProcess(id string) error {
+	task, ok := r.tasks[id]
+	if !ok {
+		return ErrUnknownProcess
+	}
+
+	if task.process.Order != "stop" {
+		return fmt.Errorf("the process with the ID '%s' is still running", id)
+	}
+
+	task.tee.stopRelays()
+	r.unsetPlayoutPorts(task)
+	r.unsetCleanup(id)
+
+	delete(r.tasks, id)
+
+	return nil
+}
+
+var ErrOnDemand = errors.New("the process is started and stopped on demand")
+
+func (r *restream) StartProcess(id string) error {
+	if !r.allowChange() {
+		return ErrRateLimited
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.dirty = true
+
+	if task, ok := r.tasks[id]; ok && task.config.OnDemand {
+		return ErrOnDemand
+	}
+
+	err := r.startProcess(id)
+	if err != nil {
+		return err
+	}
+
+	// A started process is not drained anymore
+	r.tasks[id].process.Drained = false
+
+	r.syncOnDemand()
+
+	r.save()
+
+	return nil
+}
+
+func (r *restream) startProcess(id string) error {
+	task, ok := r.tasks[id]
+	if !ok {
+		return ErrUnknownProcess
+	}
+
+	r.dirty = true
+
+	if !task.valid {
+		return fmt.Errorf("invalid process definition")
+	}
+
+	r.unscheduleStart(task)
+
+	status := task.ffmpeg.Status()
+
+	if task.process.Order == "start" && status.Order == "start" {
+		// Starting the process again resumes the automatic restarts
+		if status.Breaker {
+			// The playout ports have been released if the process exited for good
+			if task.playout == nil {
+				if err := r.renewPlayoutPorts(task); err != nil {
+					return err
+				}
+			}
+
+			task.tee.startRelays()
+
+			return task.ffmpeg.Start()
+		}
+
+		return nil
+	}
+
+	if r.maxProc > 0 && r.nProc >= r.maxProc {
+		return fmt.Errorf("max. number of running processes (%d) reached", r.maxProc)
+	}
+
+	full := r.maxConc > 0 && r.running(task) >= r.maxConc
+
+	// A process can't preempt another one in order to get a slot on its device
+	if (full && r.preemptible(task) == nil) || r.deviceFull(task) {
+		if task.process.Order != "start" {
+			task.process.Version++
+		}
+
+		task.process.Order = "start"
+
+		r.enqueue(task)
+
+		return nil
+	}
+
+	// The pre-start hook runs in the background, the process will be
+	// started again after the hook succeeded.
+	if r.runPreStart(task) {
+		return nil
+	}
+
+	if full {
+		victim := r.preemptible(task)
+
+		r.logger.Info().WithFields(log.Fields{
+			"id": victim.id,
+			"by": task.id,
+		}).Log("Preempting process")
+
+		victim.ffmpeg.Stop(true)
+		r.nProc--
+
+		r.unsetPlayoutPorts(victim)
+
+		r.enqueue(victim)
+	}
+
+	if task.playout == nil {
+		if err := r.renewPlayoutPorts(task); err != nil {
+			return err
+		}
+	}
+
+	if task.process.Order != "start" {
+		task.process.Version++
+	}
+
+	task.process.Order = "start"
+
+	r.dequeue(task)
+
+	task.tee.startRelays()
+	task.ffmpeg.Start()
+
+	r.nProc++
+
+	return nil
+}
+
+// running returns the number of processes that occupy a slot, not counting the given task.
+func (r *restream) running(except *task) int64 {
+	n := int64(0)
+
+	for _, t := range r.tasks {
+		if t == except || !occupiesSlot(t) {
+			continue
+		}
+
+		n++
+	}
+
+	return n
+}
+
+// occupiesSlot returns whether the process of the task occupies a slot. A launched process
+// occupies a slot as long as it is running or it will be restarted. A process that exited for
+// good doesn't occupy a slot anymore, even if its order is still "start". The caller must hold
+// the lock.
+func occupiesSlot(t *task) bool {
+	if !t.valid || t.queued || t.process.Order != "start" {
+		return false
+	}
+
+	status := t.ffmpeg.Status()
+	if status.Order != "start" {
+		return false
+	}
+
+	if t.ffmpeg.IsRunning() {
+		return true
+	}
+
+	switch status.RestartDecision {
+	case process.RestartDecisionNone:
+		return false
+	case process.RestartDecisionBreaker:
+		// Restarts are paused until the next start, unless they resume after a while
+		return !status.ReconnectAt.IsZero()
+	}
+
+	return true
+}
+
+// onExit is called after the process of a task exited. If it will not be restarted, its
+// playout ports are given back to the pool, the relays of its tee branches are stopped,
+// and it may have freed a slot for a queued process.
+func (r *restream) onExit() {
+	r.lock.Lock()
+	defer r.unlock()
+
+	for _, t := range r.tasks {
+		// The relays of the tee branches are not needed anymore if the process will not be restarted
+		if t.tee.relaysRunning() && (!t.valid || t.ffmpeg.Status().Order != "start") {
+			t.tee.stopRelays()
+		}
+
+		if len(t.playout) == 0 && len(t.sockets) == 0 {
+			continue
+		}
+
+		if !t.valid || t.queued || t.process.Order != "start" || occupiesSlot(t) {
+			continue
+		}
+
+		r.unsetPlayoutPorts(t)
+	}
+
+	if len(r.queue) == 0 {
+		return
+	}
+
+	r.startQueued()
+}
+
+// preemptible returns the running task with the lowest priority that can be preempted by
+// the given task, nil if there is none or preemption is disabled.
+func (r *restream) preemptible(by *task) *task {
+	if !r.preempt {
+		return nil
+	}
+
+	var victim *task
+
+	for _, t := range r.tasks {
+		if t == by || !occupiesSlot(t) {
+			continue
+		}
+
+		if t.config.Priority >= by.config.Priority {
+			continue
+		}
+
+		// The least important task comes last in the queue order
+		if victim == nil || lessTask(victim, t) {
+			victim = t
+		}
+	}
+
+	return victim
+}
+
+// enqueue adds the task to the queue of tasks waiting for a free slot. The
+// queue is ordered by the priority and the creation time of the processes.
+func (r *restream) enqueue(t *task) {
+	if t.queued {
+		return
+	}
+
+	t.queued = true
+
+	r.queue = append(r.queue, t)
+
+	sort.SliceStable(r.queue, func(i, j int) bool {
+		return lessTask(r.queue[i], r.queue[j])
+	})
+}
+
+// dequeue removes the task from the queue.
+func (r *restream) dequeue(t *task) {
+	if !t.queued {
+		return
+	}
+
+	t.queued = false
+
+	for i, q := range r.queue {
+		if q == t {
+			r.queue = append(r.queue[:i], r.queue[i+1:]...)
+			break
+		}
+	}
+}
+
+// queuePosition returns the 1-based position of the task in the queue, 0 if it is not queued.
+func (r *restream) queuePosition(t *task) int {
+	for i, q := range r.queue {
+		if q == t {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+// startQueued launches the queued processes as long as there are free slots.
+func (r *restream) startQueued() {
+	queue := make([]*task, len(r.queue))
+	copy(queue, r.queue)
+
+	for _, t := range queue {
+		if r.maxConc > 0 && r.running(nil) >= r.maxConc {
+			return
+		}
+
+		if err := r.startProcess(t.id); err != nil {
+			r.logger.Warn().WithField("id", t.id).WithError(err).Log("Starting queued process failed")
+			continue
+		}
+
+		if !t.queued {
+			r.logger.Info().WithField("id", t.id).Log("Started queued process")
+		}
+	}
+}
+
+// consumers returns the number of processes referencing the process with the given ID
+// that should be running.
+func (r *restream) consumers(id string) int {
+	inbound, _ := r.getReferences(id)
+
+	consumers := map[string]struct{}{}
+
+	for _, ref := range inbound {
+		if ref.ProcessID == id {
+			continue
+		}
+
+		t, ok := r.tasks[ref.ProcessID]
+		if !ok || !t.valid || t.process.Order != "start" || t.process.Drained {
+			continue
+		}
+
+		consumers[ref.ProcessID] = struct{}{}
+	}
+
+	return len(consumers)
+}
+
+// syncOnDemand starts the on-demand processes that are referenced by at least one
+// process that should be running and stops those that are not referenced anymore.
+// Starting or stopping an on-demand process may change the consumers of others, so
+// this is repeated until nothing changes anymore.
+func (r *restream) syncOnDemand() {
+	for i := 0; i <= len(r.tasks); i++ {
+		changed := false
+
+		for _, t := range r.sortedTasks() {
+			if !t.config.OnDemand || !t.valid {
+				continue
+			}
+
+			consumers := r.consumers(t.id)
+
+			if consumers != 0 && t.process.Order != "start" {
+				if err := r.startProcess(t.id); err != nil {
+					r.logger.Warn().WithField("id", t.id).WithError(err).Log("Starting on-demand process failed")
+					continue
+				}
+
+				r.logger.Info().WithField("id", t.id).WithField("consumers", consumers).Log("Started on-demand process")
+				changed = true
+			} else if consumers == 0 && t.process.Order == "start" {
+				if err := r.stopProcess(t.id); err != nil {
+					r.logger.Warn().WithField("id", t.id).WithError(err).Log("Stopping on-demand process failed")
+					continue
+				}
+
+				r.logger.Info().WithField("id", t.id).Log("Stopped on-demand process")
+				changed = true
+			}
+		}
+
+		if !changed {
+			return
+		}
+	}
+}
+
+// sortedTasks returns the tasks ordered by the priority and the creation time of their processes.
+func (r *restream) sortedTasks() []*task {
+	tasks := make([]*task, 0, len(r.tasks))
+
+	for _, t := range r.tasks {
+		tasks = append(tasks, t)
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return lessTask(tasks[i], tasks[j])
+	})
+
+	return tasks
+}
+
+// lessTask orders tasks by their priority, higher first, then by the creation
+// time of their processes, then by their ID.
+func lessTask(a, b *task) bool {
+	if a.config.Priority != b.config.Priority {
+		return a.config.Priority > b.config.Priority
+	}
+
+	if a.process.CreatedAt != b.process.CreatedAt {
+		return a.process.CreatedAt < b.process.CreatedAt
+	}
+
+	return a.id < b.id
+}
+
+// autostartTasks returns the tasks in the order they are started when the restreamer
+// starts. Tasks with an autostart order come first, lower values first, and tasks with
+// the same order are sorted as in sortedTasks.
+func (r *restream) autostartTasks() []*task {
+	tasks := r.sortedTasks()
+
+	sort.SliceStable(tasks, func(i, j int) bool {
+		a, b := tasks[i].config.AutostartOrder, tasks[j].config.AutostartOrder
+
+		if a == 0 || b == 0 {
+			return b == 0 && a != 0
+		}
+
+		return a < b
+	})
+
+	return tasks
+}
+
+// autostartProcess starts the process after the autostart delay of its config plus
+// the offset, or immediately if there's no delay.
+func (r *restream) autostartProcess(t *task, offset time.Duration) error {
+	delay := time.Duration(t.config.AutostartDelay)*time.Second + offset
+
+	if t.config.AutostartJitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(time.Duration(t.config.AutostartJitter) * time.Second)))
+	}
+
+	if delay == 0 || !t.valid {
+		return r.startProcess(t.id)
+	}
+
+	r.unscheduleStart(t)
+
+	if t.process.Order != "start" {
+		t.process.Version++
+	}
+
+	t.process.Order = "start"
+	t.startAt = time.Now().Add(delay)
+
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		r.lock.Lock()
+		defer r.unlock()
+
+		// The start has been cancelled or the process has been replaced in the meantime
+		if t.start != timer || r.tasks[t.id] != t {
+			return
+		}
+
+		if err := r.startProcess(t.id); err != nil {
+			r.logger.Warn().WithField("id", t.id).WithError(err).Log("Delayed start failed")
+		}
+
+		r.syncOnDemand()
+	})
+
+	t.start = timer
+
+	r.logger.Info().WithFields(log.Fields{
+		"id":    t.id,
+		"delay": delay.String(),
+	}).Log("Delaying start")
+
+	return nil
+}
+
+// unscheduleStart cancels a pending delayed start.
+func (r *restream) unscheduleStart(t *task) {
+	if t.start == nil {
+		return
+	}
+
+	t.start.Stop()
+	t.start = nil
+}
+
+// SetAutostartOrder sets the autostart order of the processes with the given IDs to
+// their position in the list, starting at 1. The autostart order of all other processes
+// is reset, i.e. they will be started after the listed ones.
+func (r *restream) SetAutostartOrder(ids []string) error {
+	if !r.allowChange() {
+		return ErrRateLimited
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.dirty = true
+
+	order := map[string]int{}
+
+	for i, id := range ids {
+		if _, ok := r.tasks[id]; !ok {
+			return ErrUnknownProcess
+		}
+
+		if _, ok := order[id]; ok {
+			return fmt.Errorf("the process %s is listed more than once", id)
+		}
+
+		order[id] = i + 1
+	}
+
+	changed := false
+
+	for id, t := range r.tasks {
+		if t.process.Config.AutostartOrder == order[id] {
+			continue
+		}
+
+		t.process.Config.AutostartOrder = order[id]
+		t.config.AutostartOrder = order[id]
+		t.process.Version++
+
+		changed = true
+	}
+
+	if changed {
+		r.save()
+	}
+
+	return nil
+}
+
+func (r *restream) StopProcess(id string) error {
+	if !r.allowChange() {
+		return ErrRateLimited
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.dirty = true
+
+	if task, ok := r.tasks[id]; ok && task.config.OnDemand {
+		return ErrOnDemand
+	}
+
+	err := r.stopProcess(id)
+	if err != nil {
+		return err
+	}
+
+	// A stopped process will not be started by Undrain
+	r.tasks[id].process.Drained = false
+
+	r.syncOnDemand()
+	r.startQueued()
+
+	r.save()
+
+	return nil
+}
+
+func (r *restream) stopProcess(id string) error {
+	task, ok := r.tasks[id]
+	if !ok {
+		return ErrUnknownProcess
+	}
+
+	r.dirty = true
+
+	r.unscheduleStart(task)
+	r.cancelPreStart(task)
+
+	if task.queued {
+		r.dequeue(task)
+
+		task.process.Version++
+		task.process.Order = "stop"
+
+		return nil
+	}
+
+	if task.ffmpeg == nil {
+		return nil
+	}
+
+	status := task.ffmpeg.Status()
+
+	if task.process.Order == "stop" && status.Order == "stop" {
+		return nil
+	}
+
+	if task.process.Order != "stop" {
+		task.process.Version++
+	}
+
+	task.process.Order = "stop"
+
+	task.ffmpeg.Stop(true)
+
+	r.nProc--
+
+	// Give the playout ports back to the pool such that other processes can use them
+	// while this process is stopped. They will be re-assigned when it is started again.
+	// The same applies to the sockets, such that the playout of a stopped process is
+	// reported as not ready.
+	r.unsetPlayoutPorts(task)
+
+	return nil
+}
+
+// Drain stops all processes whose order is "start" without changing their order, e.g. for
+// maintenance. The stopped processes are marked as drained, such that they will not be
+// started when the restreamer starts, until Undrain starts them again. On-demand processes
+// are not marked, they are stopped and started along with their consumers. It returns the
+// IDs of the processes that were running and have been stopped, also if stopping some of
+// them failed. Queued processes are marked as well, but they are not listed.
+func (r *restream) Drain() ([]string, error) {
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.dirty = true
+
+	stopped := []string{}
+	errs := []string{}
+	drained := false
+
+	for _, t := range r.sortedTasks() {
+		if !t.valid || t.config.OnDemand || t.process.Order != "start" || t.process.Drained {
+			continue
+		}
+
+		drained = true
+
+		running, err := r.drainProcess(t)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", t.id, err))
+			continue
+		}
+
+		if running {
+			stopped = append(stopped, t.id)
+		}
+	}
+
+	r.syncOnDemand()
+
+	if drained {
+		r.save()
+	}
+
+	sort.Strings(stopped)
+
+	if len(errs) != 0 {
+		sort.Strings(errs)
+		return stopped, fmt.Errorf("stopping some processes failed: %s", strings.Join(errs, "; "))
+	}
+
+	return stopped, nil
+}
+
+// drainProcess stops the process of the task without changing its order and marks it
+// as drained. The playout ports are given back to the pool. It returns whether the process
+// was running. The caller must hold the lock.
+func (r *restream) drainProcess(t *task) (bool, error) {
+	r.unscheduleStart(t)
+	r.cancelPreStart(t)
+
+	t.process.Drained = true
+
+	running := false
+
+	var err error
+
+	if t.queued {
+		r.dequeue(t)
+	} else if t.ffmpeg != nil && t.ffmpeg.Status().Order == "start" {
+		// A process with a pending delayed start hasn't been launched yet
+		running = t.ffmpeg.IsRunning()
+		err = t.ffmpeg.Stop(true)
+
+		r.nProc--
+	}
+
+	if len(t.playout) != 0 || len(t.sockets) != 0 {
+		r.unsetPlayoutPorts(t)
+	}
+
+	return running, err
+}
+
+// Undrain starts the processes that have been stopped by Drain, and only those, in the order
+// they are started when the restreamer starts. A process that can't be started stays drained.
+// It returns the IDs of the started processes.
+func (r *restream) Undrain() ([]string, error) {
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.dirty = true
+
+	started := []string{}
+	errs := []string{}
+
+	for _, t := range r.autostartTasks() {
+		if !t.process.Drained {
+			continue
+		}
+
+		if err := r.startProcess(t.id); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", t.id, err))
+			continue
+		}
+
+		t.process.Drained = false
+
+		started = append(started, t.id)
+	}
+
+	r.syncOnDemand()
+	r.startQueued()
+
+	if len(started) != 0 {
+		r.save()
+	}
+
+	sort.Strings(started)
+
+	if len(errs) != 0 {
+		sort.Strings(errs)
+		return started, fmt.Errorf("starting some processes failed: %s", strings.Join(errs, "; "))
+	}
+
+	return started, nil
+}
+
+func (r *restream) RestartProcess(id string) error {
+	if !r.allowChange() {
+		return ErrRateLimited
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.restartProcess(id)
+}
+
+func (r *restream) restartProcess(id string) error {
+	task, ok := r.tasks[id]
+	if !ok {
+		return ErrUnknownProcess
+	}
+
+	if !task.valid {
+		return fmt.Errorf("invalid process definition")
+	}
+
+	if task.process.Order == "stop" {
+		return nil
+	}
+
+	task.ffmpeg.Kill(true)
+
+	return nil
+}
+
+func (r *restream) ReloadProcess(id string) error {
+	if !r.allowChange() {
+		return ErrRateLimited
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.dirty = true
+
+	err := r.reloadProcess(id)
+
+	r.syncOnDemand()
+	r.startQueued()
+
+	if err != nil {
+		return err
+	}
+
+	if t := r.tasks[id]; t.config.ReloadConsumers {
+		r.reloadConsumers(t)
+	}
+
+	r.save()
+
+	return nil
+}
+
+// ReloadAll resolves the placeholders in the config of each process again, e.g. after the
+// template functions of the replacer changed, and reloads the processes whose command
+// changed. Running processes will be restarted. It returns the IDs of the reloaded processes,
+// sorted, and an error for each process that couldn't be resolved or reloaded.
+func (r *restream) ReloadAll() ([]string, []error) {
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.dirty = true
+
+	reloaded := []string{}
+	errs := []error{}
+
+	for _, t := range r.sortedTasks() {
+		changed, err := r.commandChanged(t)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", t.id, err))
+			continue
+		}
+
+		if !changed {
+			continue
+		}
+
+		if err := r.reloadProcess(t.id); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", t.id, err))
+			continue
+		}
+
+		reloaded = append(reloaded, t.id)
+	}
+
+	sort.Strings(reloaded)
+
+	r.syncOnDemand()
+	r.startQueued()
+
+	if len(reloaded) != 0 {
+		r.save()
+	}
+
+	return reloaded, errs
+}
+
+// commandChanged returns whether the command of the process of the task would be different
+// if its config is resolved again. The playout ports are not considered because they are
+// assigned anew with each reload.
+func (r *restream) commandChanged(t *task) (bool, error) {
+	if !t.valid {
+		return true, nil
+	}
+
+	config, err := r.resolveConfig(t)
+	if err != nil {
+		return false, err
+	}
+
+	current := t.config.Clone()
+
+	for _, c := range []*app.Config{config, current} {
+		for i, input := range c.Input {
+			input.Options = withoutPlayoutOptions(input.Options)
+			c.Input[i] = input
+		}
+	}
+
+	return !reflect.DeepEqual(config.CreateCommand(), current.CreateCommand()), nil
+}
+
+// resolveConfig returns the config of the process of the task with the placeholders,
+// environment variables, and references resolved as they would be with a reload.
+func (r *restream) resolveConfig(t *task) (*app.Config, error) {
+	config := t.process.Config.Clone()
+
+	if err := expandOutputs(config, r.replace); err != nil {
+		return nil, err
+	}
+
+	resolvePlaceholders(config, r.replace)
+
+	if err := resolveEnvironment(config, r.replace); err != nil {
+		return nil, err
+	}
+
+	if err := r.resolveAddresses(r.tasks, config); err != nil {
+		return nil, err
+	}
+
+	setBufferOptions(config)
+	r.setDeviceOptions(config)
+
+	return config, nil
+}
+
+// RotateCredentials resolves the addresses of the inputs and outputs of a process again in
+// order to pick up new credentials, e.g. a new token provided by the template functions of
+// the replacer. ffmpeg can't change the address of an input or output while it is running,
+// so the process will be reloaded and restarted if any address changed. It returns the IDs
+// of the inputs and outputs with a changed address.
+func (r *restream) RotateCredentials(id string) ([]string, error) {
+	if !r.allowChange() {
+		return nil, ErrRateLimited
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.dirty = true
+
+	t, ok := r.tasks[id]
+	if !ok {
+		return nil, ErrUnknownProcess
+	}
+
+	if !t.valid {
+		return nil, fmt.Errorf("invalid process definition")
+	}
+
+	config, err := r.resolveConfig(t)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := []string{}
+
+	for _, ios := range [][2][]app.ConfigIO{{config.Input, t.config.Input}, {config.Output, t.config.Output}} {
+		for i, io := range ios[0] {
+			if i >= len(ios[1]) || io.Address != ios[1][i].Address {
+				changed = append(changed, io.ID)
+			}
+		}
+	}
+
+	if len(changed) == 0 {
+		return changed, nil
+	}
+
+	t.logger.Info().WithField("changed", changed).Log("Rotating credentials, reloading process")
+
+	err = r.reloadProcess(id)
+
+	r.syncOnDemand()
+	r.startQueued()
+
+	if err != nil {
+		return changed, err
+	}
+
+	t.rotatedAt = time.Now()
+
+	r.save()
+
+	return changed, nil
+}
+
+// withoutPlayoutOptions returns the options without the ones for the playout server.
+func withoutPlayoutOptions(options []string) []string {
+	filtered := []string{}
+
+	for i := 0; i < len(options); i++ {
+		if options[i] == "-playout_httpport" || options[i] == "-playout_httphost" {
+			i++
+			continue
+		}
+
+		filtered = append(filtered, options[i])
+	}
+
+	return filtered
+}
+
+// Time to wait for a producer to run again before its consumers will be reloaded.
+const consumersReloadTimeout = 30 * time.Second
+
+// reloadConsumers reloads the processes referencing the process of the task whose
+// inputs changed because of a reload of the process. If the process should be running,
+// this will happen as soon as it is running again, such that the consumers don't fail
+// in the meantime. The caller must hold the lock.
+func (r *restream) reloadConsumers(t *task) {
+	if len(r.changedConsumers(t.id)) == 0 {
+		return
+	}
+
+	if t.process.Order != "start" {
+		r.reloadChangedConsumers(t.id)
+		return
+	}
+
+	id, ffmpeg := t.id, t.ffmpeg
+
+	go func() {
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+
+		timeout := time.NewTimer(consumersReloadTimeout)
+		defer timeout.Stop()
+
+		for ffmpeg.Status().State != "running" {
+			select {
+			case <-ticker.C:
+			case <-timeout.C:
+				r.logger.Warn().WithField("id", id).Log("Not reloading consumers, the process is not running")
+				return
+			}
+		}
+
+		r.lock.Lock()
+		defer r.unlock()
+
+		// The process might have been changed or removed in the meantime
+		if t, ok := r.tasks[id]; !ok || t.ffmpeg != ffmpeg {
+			return
+		}
+
+		if r.reloadChangedConsumers(id) {
+			r.save()
+		}
+	}()
+}
+
+// reloadChangedConsumers reloads the processes referencing the process with the given ID
+// whose inputs changed. It returns whether any process has been reloaded. The caller must
+// hold the lock.
+func (r *restream) reloadChangedConsumers(id string) bool {
+	consumers := r.changedConsumers(id)
+	if len(consumers) == 0 {
+		return false
+	}
+
+	for _, cid := range consumers {
+		if err := r.reloadProcess(cid); err != nil {
+			r.logger.Warn().WithFields(log.Fields{
+				"id":       cid,
+				"producer": id,
+			}).WithError(err).Log("Reloading consumer failed")
+			continue
+		}
+
+		r.logger.Info().WithFields(log.Fields{
+			"id":       cid,
+			"producer": id,
+		}).Log("Reloaded consumer")
+	}
+
+	r.syncOnDemand()
+	r.startQueued()
+
+	return true
+}
+
+// changedConsumers returns the IDs of the processes referencing the process with the
+// given ID whose inputs would resolve to a different address than they are using now.
+func (r *restream) changedConsumers(id string) []string {
+	inbound, _ := r.getReferences(id)
+
+	ids := []string{}
+	seen := map[string]struct{}{}
+
+	for _, ref := range inbound {
+		if _, ok := seen[ref.ProcessID]; ok || ref.ProcessID == id {
+			continue
+		}
+
+		seen[ref.ProcessID] = struct{}{}
+
+		t, ok := r.tasks[ref.ProcessID]
+		if !ok || !t.valid {
+			continue
+		}
+
+		config, err := r.resolveConfig(t)
+		if err != nil {
+			ids = append(ids, t.id)
+			continue
+		}
+
+		for i, input := range config.Input {
+			if i >= len(t.config.Input) || input.Address != t.config.Input[i].Address {
+				ids = append(ids, t.id)
+				break
+			}
+		}
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+func (r *restream) reloadProcess(id string) error {
+	t, ok := r.tasks[id]
+	if !ok {
+		return ErrUnknownProcess
+	}
+
+	r.dirty = true
+
+	t.valid = false
+
+	t.config = t.process.Config.Clone()
+
+	if err := expandOutputs(t.config, r.replace); err != nil {
+		return err
+	}
+
+	resolvePlaceholders(t.config, r.replace)
+
+	err := resolveEnvironment(t.config, r.replace)
+	if err != nil {
+		return err
+	}
+
+	err = r.resolveAddresses(r.tasks, t.config)
+	if err != nil {
+		return err
+	}
+
+	t.usesDisk, err = r.validateConfig(t.config)
+	if err != nil {
+		return err
+	}
+
+	setBufferOptions(t.config)
+	r.setDeviceOptions(t.config)
+	stripPlayoutOptions(t.config)
+
+	if err := r.setTeeBranches(t); err != nil {
+		return err
+	}
+
+	t.command = t.config.CreateCommand()
+
+	order := "stop"
+	if t.process.Order == "start" && !t.process.Drained {
+		order = "start"
+		r.stopProcess(id)
+	}
+
+	// The playout ports will be assigned again when the process starts
+	r.unsetPlayoutPorts(t)
+
+	t.parser = newHookParser(t, newTeeParser(t.binary.NewProcessParser(t.logger, t.id, t.reference), t.tee, r.onTeeBranchFailed(t)))
+
+	onStateChange, onStale := r.onStateChange(t)
+	onRestart, onExit := r.hookCallbacks(t)
+
+	ffmpeg, err := t.binary.New(ffmpeg.ProcessConfig{
+		Reconnect:       t.config.Reconnect,
+		ReconnectDelay:  time.Duration(t.config.ReconnectDelay) * time.Second,
+		ReconnectJitter: time.Duration(t.config.ReconnectJitter) * time.Second,
+		RestartPolicy:   t.config.RestartPolicy,
+		MaxRestarts:     t.config.MaxRestarts,
+		RestartWindow:   time.Duration(t.config.RestartWindow) * time.Second,
+		StaleTimeout:    time.Duration(t.config.StaleTimeout) * time.Second,
+		Command:         t.command,
+		Environment:     t.config.Environment,
+		WorkingDir:      t.config.WorkingDir,
+		Parser:          t.parser,
+		LogStdout:       t.config.LogStdout,
+		ProgressPipe:    true,
+		LevelPrefix:     true,
+		CPUAffinity:     t.config.CPUAffinity,
+		Nice:            t.config.Nice,
+		Logger:          t.logger,
+		OnStateChange:   onStateChange,
+		OnStale:         onStale,
+		OnRestart:       onRestart,
+		OnExit:          onExit,
+	})
+	if err != nil {
+		return err
+	}
+
+	t.ffmpeg = ffmpeg
+	t.valid = true
+
+	if order == "start" {
+		r.startProcess(id)
+	}
+
+	return nil
+}
+
+func (r *restream) GetProcessState(id string) (*app.State, error) {
+	s := r.view()
+
+	task, ok := s.tasks[id]
+	if !ok {
+		return &app.State{}, ErrUnknownProcess
+	}
+
+	return taskProcessState(task, s.positions[id], s.consumers[id]), nil
+}
+
+func (r *restream) GetProcessCommand(id string) ([]string, error) {
+	s := r.view()
+
+	task, ok := s.tasks[id]
+	if !ok {
+		return nil, ErrUnknownProcess
+	}
+
+	if !task.valid {
+		return nil, fmt.Errorf("invalid process definition")
+	}
+
+	// The arguments of the live process include the options that are added internally
+	return append([]string{task.binary.Binary()}, task.ffmpeg.Args()...), nil
+}
+
+func (r *restream) GetProcessStates(ids []string) map[string]app.State {
+	states := map[string]app.State{}
+
+	// All states are collected while holding the lock once, such that
+	// no process can be changed in between.
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if len(ids) == 0 {
+		for id, task := range r.tasks {
+			states[id] = *r.processState(task)
+		}
+
+		return states
+	}
+
+	for _, id := range ids {
+		task, ok := r.tasks[id]
+		if !ok {
+			continue
+		}
+
+		states[id] = *r.processState(task)
+	}
+
+	return states
+}
+
+// Summary returns totals over all processes, e.g. for a dashboard.
+func (r *restream) Summary() app.RestreamSummary {
+	summary := app.RestreamSummary{
+		States:  map[string]int{},
+		Devices: map[string]app.DeviceLoad{},
+	}
+
+	// All processes are collected while holding the lock once, such that
+	// the totals are consistent.
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, task := range r.tasks {
+		summary.Processes++
+
+		if !task.valid {
+			summary.States["failed"]++
+			continue
+		}
+
+		status := task.ffmpeg.Status()
+
+		summary.States[taskState(task, status)]++
+		summary.Restarts += status.RecentRestarts
+		summary.CPU += status.CPU
+		summary.Memory += status.Memory
+
+		progress := task.parser.Progress()
+
+		for _, io := range progress.Input {
+			summary.InputBitrate += io.Bitrate
+		}
+
+		for _, io := range progress.Output {
+			summary.OutputBitrate += io.Bitrate
+		}
+	}
+
+	for id, device := range r.devices {
+		summary.Devices[id] = app.DeviceLoad{
+			Processes: r.deviceProcesses(id, nil),
+			Capacity:  device.Capacity,
+		}
+	}
+
+	return summary
+}
+
+// taskState returns the state of the task, considering that it may wait for a slot or for a delayed start.
+func taskState(task *task, status process.Status) string {
+	if task.queued {
+		return "queued"
+	}
+
+	if task.start != nil {
+		return "starting"
+	}
+
+	// Automatic restarts are paused because of too many of them
+	if status.Breaker {
+		return "failed"
+	}
+
+	return status.State
+}
+
+// processState returns the current state of the task. The lock must be held by the caller.
+func (r *restream) processState(task *task) *app.State {
+	position, consumers := 0, 0
+
+	if task.queued {
+		position = r.queuePosition(task)
+	}
+
+	if task.valid && task.config.OnDemand {
+		consumers = r.consumers(task.id)
+	}
+
+	return taskProcessState(task, position, consumers)
+}
+
+// taskProcessState returns the state of the task with the given position in the
+// queue and number of consumers, such that it doesn't depend on the other tasks.
+func taskProcessState(task *task, position, consumers int) *app.State {
+	state := &app.State{
+		Version: task.process.Version,
+	}
+
+	if !task.valid {
+		return state
+	}
+
+	status := task.ffmpeg.Status()
+
+	state.Order = task.process.Order
+	state.State = taskState(task, status)
+	state.StartIn = -1
+
+	if task.queued {
+		state.QueuePosition = position
+	}
+
+	if task.start != nil {
+		state.StartIn = time.Until(task.startAt).Round(10 * time.Millisecond).Seconds()
+
+		if state.StartIn < 0 {
+			state.StartIn = 0
+		}
+	}
+
+	state.Priority = task.config.Priority
+
+	if task.config.OnDemand {
+		state.Consumers = consumers
+	}
+
+	if !task.rotatedAt.IsZero() {
+		state.RotatedAt = task.rotatedAt.Unix()
+	}
+
+	// A restart since pausing the process resumes it
+	state.Paused = !task.paused.IsZero() && status.State == "running" && !status.Time.After(task.paused)
+
+	state.Restarts = status.Restarts
+	state.RestartPolicy = status.RestartPolicy
+	state.Restart = status.RestartDecision
+	state.Breaker = "closed"
+
+	if status.Breaker {
+		state.Breaker = "open"
+	}
+
+	state.States.Marshal(status.States)
+	state.Time = status.Time.Unix()
+	state.Memory = status.Memory
+	state.CPU = status.CPU
+	state.Duration = status.Duration.Round(10 * time.Millisecond).Seconds()
 	state.Reconnect = -1
+	state.ExitCode = status.ExitCode
+	state.ExitSignal = status.ExitSignal
 	state.Command = make([]string, len(task.command))
 	copy(state.Command, task.command)
+	state.FFmpeg.Binary = task.binary.Binary()
+	state.FFmpeg.Version = task.binary.Skills().FFmpeg.Version
+
+	if state.Order == "start" && !task.queued && task.start == nil && !task.ffmpeg.IsRunning() && status.RestartDecision != process.RestartDecisionNone && status.RestartPolicy != process.RestartNever {
+		state.Reconnect = float64(task.config.ReconnectDelay) - state.Duration
+
+		if !status.ReconnectAt.IsZero() {
+			state.Reconnect = time.Until(status.ReconnectAt).Round(10 * time.Millisecond).Seconds()
+		}
+
+		if status.Breaker {
+			// The restart is delayed until the breaker resets, if at all
+			state.Reconnect = -1
+
+			if !status.BreakerReset.IsZero() {
+				state.Reconnect = math.Max(time.Until(status.BreakerReset).Round(10*time.Millisecond).Seconds(), 0)
+			}
+		} else if state.Reconnect < 0 {
+			state.Reconnect = 0
+		}
+	}
+
+	state.Progress = task.parser.Progress()
+
+	for i, p := range state.Progress.Input {
+		if int(p.Index) >= len(task.process.Config.Input) {
+			continue
+		}
+
+		state.Progress.Input[i].ID = task.process.Config.Input[p.Index].ID
+	}
+
+	// The outputs of the command are the ones of the resolved config, including
+	// the expanded outputs
+	outputs := task.config.Output
+
+	for i, p := range state.Progress.Output {
+		if int(p.Index) >= len(outputs) {
+			continue
+		}
+
+		state.Progress.Output[i].ID = outputs[p.Index].ID
+	}
+
+	setBufferFill(&state.Progress, task.config.InputBuffer)
+
+	state.Tee = task.tee.Branches()
+
+	report := task.parser.Report()
+
+	if len(report.Log) != 0 {
+		state.LastLog = report.Log[len(report.Log)-1].Data
+	}
+
+	state.Reason, state.Detail = taskReason(task, status, state)
+
+	return state
+}
+
+func (r *restream) GetProcessLog(id string) (*app.Log, error) {
+	return r.GetProcessLogParts(id, app.LogAll)
+}
+
+// GetProcessLogParts returns the logs of the current or last run of a process and of the
+// previous runs. The logs of a run are kept after the process stopped. With the next start
+// they are moved to the history, which holds as many runs as configured for ffmpeg. Runs
+// without a prelude, e.g. because ffmpeg couldn't be started, are not kept in the history.
+// Updating or reloading a process discards all of its logs.
+func (r *restream) GetProcessLogParts(id string, parts app.LogParts) (*app.Log, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	task, ok := r.tasks[id]
+	if !ok {
+		return &app.Log{}, ErrUnknownProcess
+	}
+
+	if !task.valid {
+		return &app.Log{}, nil
+	}
+
+	log := &app.Log{}
+
+	current := task.parser.Report()
+
+	log.LogHistoryEntry = logHistoryEntry(current, parts)
+
+	history := task.parser.ReportHistory()
+
+	for _, h := range history {
+		log.History = append(log.History, logHistoryEntry(h, parts))
+	}
+
+	return log, nil
+}
+
+// GetProcessLogHistory returns the logs of the completed runs of a process, including
+// the last run if the process is not running anymore. The number of runs is bounded by
+// the log history length of ffmpeg, the size of each run by the number of log lines and
+// prelude lines of ffmpeg.
+func (r *restream) GetProcessLogHistory(id string) ([]app.LogRun, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	task, ok := r.tasks[id]
+	if !ok {
+		return nil, ErrUnknownProcess
+	}
+
+	runs := []app.LogRun{}
+
+	if !task.valid {
+		return runs, nil
+	}
 
-	if state.Order == "start" && !task.ffmpeg.IsRunning() && task.config.Reconnect {
-		state.Reconnect = float64(task.config.ReconnectDelay) - state.Duration
+	reports := task.parser.ReportHistory()
+	reports = append(reports, task.parser.Report())
 
-		if state.Reconnect < 0 {
-			state.Reconnect = 0
+	for _, report := range reports {
+		if report.StoppedAt.IsZero() {
+			continue
 		}
+
+		e := logHistoryEntry(report, app.LogAll)
+
+		runs = append(runs, app.LogRun{
+			StartedAt:  report.CreatedAt,
+			StoppedAt:  report.StoppedAt,
+			ExitCode:   report.ExitCode,
+			ExitSignal: report.ExitSignal,
+			Prelude:    e.Prelude,
+			Log:        e.Log,
+		})
 	}
 
-	state.Progress = task.parser.Progress()
+	return runs, nil
+}
 
-	for i, p := range state.Progress.Input {
-		if int(p.Index) >= len(task.process.Config.Input) {
+// logHistoryEntry converts the report of a run to the selected parts of its log.
+func logHistoryEntry(report parse.Report, parts app.LogParts) app.LogHistoryEntry {
+	e := app.LogHistoryEntry{
+		CreatedAt: report.CreatedAt,
+		Prelude:   []string{},
+		Log:       []app.LogEntry{},
+	}
+
+	if parts&app.LogPrelude != 0 {
+		e.Prelude = report.Prelude
+	}
+
+	if parts&app.LogLines != 0 {
+		streams := parts & (app.LogStdout | app.LogStderr)
+		if streams == 0 {
+			streams = app.LogStdout | app.LogStderr
+		}
+
+		for _, line := range report.Log {
+			if line.Stream == "stdout" && streams&app.LogStdout == 0 {
+				continue
+			}
+
+			if line.Stream != "stdout" && streams&app.LogStderr == 0 {
+				continue
+			}
+
+			e.Log = append(e.Log, app.LogEntry{
+				Timestamp: line.Timestamp,
+				Data:      line.Data,
+				Level:     line.Level,
+				Stream:    line.Stream,
+			})
+		}
+	}
+
+	return e
+}
+
+func (r *restream) GetProcessLogSince(id, cursor string) ([]app.LogEntry, string, error) {
+	since, sinceLines, err := parseLogCursor(cursor)
+	if err != nil {
+		return nil, cursor, err
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	task, ok := r.tasks[id]
+	if !ok {
+		return nil, cursor, ErrUnknownProcess
+	}
+
+	if !task.valid {
+		return []app.LogEntry{}, cursor, nil
+	}
+
+	current := task.parser.Report()
+
+	// The lines of a different run or from before a reset of the
+	// process are all new lines.
+	skip := uint64(0)
+	if current.CreatedAt.UnixNano() == since {
+		// Number of lines that have been dropped from the log
+		dropped := current.Lines - uint64(len(current.Log))
+
+		if sinceLines > dropped {
+			skip = sinceLines - dropped
+		}
+
+		if skip > uint64(len(current.Log)) {
+			skip = uint64(len(current.Log))
+		}
+	}
+
+	lines := make([]app.LogEntry, 0, uint64(len(current.Log))-skip)
+	for _, line := range current.Log[skip:] {
+		lines = append(lines, app.LogEntry{
+			Timestamp: line.Timestamp,
+			Data:      line.Data,
+			Level:     line.Level,
+			Stream:    line.Stream,
+		})
+	}
+
+	return lines, formatLogCursor(current.CreatedAt.UnixNano(), current.Lines), nil
+}
+
+func (r *restream) GetProcessErrors(id string) ([]app.LogEntry, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	task, ok := r.tasks[id]
+	if !ok {
+		return nil, ErrUnknownProcess
+	}
+
+	lines := []app.LogEntry{}
+
+	if !task.valid {
+		return lines, nil
+	}
+
+	for _, line := range task.parser.Log() {
+		if !parse.LevelAtLeast(line.Level, "warning") {
 			continue
 		}
 
-		state.Progress.Input[i].ID = task.process.Config.Input[p.Index].ID
+		lines = append(lines, app.LogEntry{
+			Timestamp: line.Timestamp,
+			Data:      line.Data,
+			Level:     line.Level,
+			Stream:    line.Stream,
+		})
 	}
 
-	for i, p := range state.Progress.Output {
-		if int(p.Index) >= len(task.process.Config.Output) {
+	return lines, nil
+}
+
+// formatLogCursor returns a cursor for the position in the log of a process. The cursor
+// consists of the creation time of the log and the number of lines logged so far.
+func formatLogCursor(createdAt int64, lines uint64) string {
+	return strconv.FormatInt(createdAt, 10) + "." + strconv.FormatUint(lines, 10)
+}
+
+// parseLogCursor parses a cursor created by formatLogCursor. An empty cursor refers
+// to the beginning of the log.
+func parseLogCursor(cursor string) (int64, uint64, error) {
+	if len(cursor) == 0 {
+		return 0, 0, nil
+	}
+
+	before, after, found := strings.Cut(cursor, ".")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid log cursor")
+	}
+
+	createdAt, err := strconv.ParseInt(before, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid log cursor")
+	}
+
+	lines, err := strconv.ParseUint(after, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid log cursor")
+	}
+
+	return createdAt, lines, nil
+}
+
+func (r *restream) Probe(id string) app.Probe {
+	return r.ProbeWithOptions(id, app.ProbeOptions{})
+}
+
+func (r *restream) ProbeWithTimeout(id string, timeout time.Duration) app.Probe {
+	return r.ProbeWithOptions(id, app.ProbeOptions{Timeout: timeout})
+}
+
+func (r *restream) ProbeWithOptions(id string, options app.ProbeOptions) app.Probe {
+	r.lock.RLock()
+
+	appprobe := app.Probe{}
+
+	task, ok := r.tasks[id]
+	if !ok {
+		appprobe.Log = append(appprobe.Log, fmt.Sprintf("Unknown process ID (%s)", id))
+		r.lock.RUnlock()
+		return appprobe
+	}
+
+	r.lock.RUnlock()
+
+	if !task.valid {
+		return appprobe
+	}
+
+	return r.probeTask(task, options)
+}
+
+// ProbeMaxTimeout is the max. timeout and analyze duration for probing. Longer durations are clamped.
+const ProbeMaxTimeout = 5 * time.Minute
+
+// probeTimeoutOf returns the timeout for probing, the default timeout if none is given.
+func (r *restream) probeTimeoutOf(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return r.probeTimeout
+	}
+
+	if timeout > ProbeMaxTimeout {
+		return ProbeMaxTimeout
+	}
+
+	return timeout
+}
+
+// probeTask probes the resolved inputs of the task. The task doesn't need to be registered.
+func (r *restream) probeTask(task *task, options app.ProbeOptions) app.Probe {
+	appprobe := app.Probe{}
+
+	timeout := r.probeTimeoutOf(options.Timeout)
+
+	if options.AnalyzeDuration > ProbeMaxTimeout {
+		options.AnalyzeDuration = ProbeMaxTimeout
+	}
+
+	var command []string
+
+	// Copy global options
+	command = append(command, task.config.Options...)
+
+	for _, input := range task.config.Input {
+		// Add the resolved input to the process command. The options for probing
+		// come last, such that they take precedence over the options of the input.
+		command = append(command, input.Options...)
+		command = append(command, probeOptions(options)...)
+		command = append(command, "-i", input.Address)
+	}
+
+	prober := task.binary.NewProbeParser(task.logger)
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	ffmpeg, err := task.binary.New(ffmpeg.ProcessConfig{
+		Reconnect:      false,
+		ReconnectDelay: 0,
+		StaleTimeout:   timeout,
+		Command:        command,
+		Environment:    task.config.Environment,
+		WorkingDir:     task.config.WorkingDir,
+		Parser:         prober,
+		Logger:         task.logger,
+		OnExit: func() {
+			wg.Done()
+		},
+	})
+
+	if err != nil {
+		appprobe.Log = append(appprobe.Log, err.Error())
+		return appprobe
+	}
+
+	ffmpeg.Start()
+
+	wg.Wait()
+
+	appprobe = prober.Probe()
+
+	if options.Program != 0 {
+		streams := []app.ProbeIO{}
+
+		for _, s := range appprobe.Streams {
+			if s.Program == options.Program {
+				streams = append(streams, s)
+			}
+		}
+
+		appprobe.Streams = streams
+	}
+
+	return appprobe
+}
+
+// probeOptions returns the input options for ffmpeg for probing with the given options.
+func probeOptions(options app.ProbeOptions) []string {
+	command := []string{}
+
+	if options.AnalyzeDuration > 0 {
+		command = append(command, "-analyzeduration", strconv.FormatInt(options.AnalyzeDuration.Microseconds(), 10))
+	}
+
+	if options.ProbeSize > 0 {
+		command = append(command, "-probesize", strconv.FormatUint(options.ProbeSize, 10))
+	}
+
+	return command
+}
+
+// ProbeAddress probes the address with the given input options, e.g. in order to test a source
+// before creating a process for it. The address must be an allowed input address. If the timeout
+// is 0, the default timeout of Probe is used.
+func (r *restream) ProbeAddress(address string, options []string, timeout time.Duration) app.Probe {
+	appprobe := app.Probe{}
+
+	timeout = r.probeTimeoutOf(timeout)
+
+	address, err := r.validateInputAddress(address, "")
+	if err != nil {
+		appprobe.Log = append(appprobe.Log, fmt.Sprintf("Invalid address (%s): %s", address, err))
+		return appprobe
+	}
+
+	if err := r.validateProbeOptions(options); err != nil {
+		appprobe.Log = append(appprobe.Log, fmt.Sprintf("Invalid options: %s", err))
+		return appprobe
+	}
+
+	command := []string{}
+	command = append(command, options...)
+	command = append(command, "-i", address)
+
+	logger := r.logger.WithField("address", address)
+
+	prober := r.ffmpeg.NewProbeParser(logger)
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	ffmpeg, err := r.ffmpeg.New(ffmpeg.ProcessConfig{
+		Reconnect:      false,
+		ReconnectDelay: 0,
+		StaleTimeout:   timeout,
+		Command:        command,
+		Parser:         prober,
+		Logger:         logger,
+		OnExit: func() {
+			wg.Done()
+		},
+	})
+
+	if err != nil {
+		appprobe.Log = append(appprobe.Log, err.Error())
+		return appprobe
+	}
+
+	ffmpeg.Start()
+
+	wg.Wait()
+
+	appprobe = prober.Probe()
+
+	return appprobe
+}
+
+// probeInputOptions are the options that read from other inputs or files than the
+// address, such that the address validation would be bypassed.
+var probeInputOptions = map[string]bool{
+	"i":                     true,
+	"f":                     true, // Only for the formats in probeInputFormats
+	"filter_script":         true,
+	"filter_complex":        true,
+	"filter_complex_script": true,
+	"lavfi":                 true,
+	"attach":                true,
+	"dump_attachment":       true,
+}
+
+// probeInputFormats are the formats that interpret the address as something else than
+// the address of an input, e.g. as a filter graph or as a list of files.
+var probeInputFormats = map[string]bool{
+	"lavfi":  true,
+	"concat": true,
+}
+
+// validateProbeOptions checks the options for probing an address. In addition to the
+// validation of the options of a process, options that select other inputs are rejected.
+func (r *restream) validateProbeOptions(options []string) error {
+	if err := r.validateOptions(options); err != nil {
+		return err
+	}
+
+	for i, option := range options {
+		if !isOptionFlag(option) {
+			continue
+		}
+
+		flag, _, _ := strings.Cut(strings.TrimPrefix(option, "-"), ":")
+
+		if !probeInputOptions[flag] {
 			continue
 		}
 
-		state.Progress.Output[i].ID = task.process.Config.Output[p.Index].ID
-	}
+		if flag == "f" {
+			if i+1 < len(options) && !probeInputFormats[options[i+1]] {
+				continue
+			}
+		}
+
+		return fmt.Errorf("the option '%s' is not allowed for probing an address", option)
+	}
+
+	return nil
+}
+
+func (r *restream) Skills() skills.Skills {
+	return r.ffmpeg.Skills()
+}
+
+func (r *restream) ReloadSkills() error {
+	return r.ffmpeg.ReloadSkills()
+}
+
+func (r *restream) GetPlayout(id, inputid string) (string, error) {
+	info, err := r.GetPlayoutInfo(id, inputid)
+	if err != nil {
+		return "", err
+	}
+
+	return info.Address(), nil
+}
+
+// ErrPlayoutNotReady is returned if the playout ports of a process are currently not assigned,
+// e.g. because the process is stopped or its ports are being reassigned.
+var ErrPlayoutNotReady = errors.New("playout not ready")
 
-	report := task.parser.Report()
+func (r *restream) GetPlayoutInfo(id, inputid string) (app.PlayoutInfo, error) {
+	info := app.PlayoutInfo{}
 
-	if len(report.Log) != 0 {
-		state.LastLog = report.Log[len(report.Log)-1].Data
+	// The ports are reassigned while holding the write lock, i.e. the port and the
+	// config read here are always consistent.
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	task, ok := r.tasks[id]
+	if !ok {
+		return info, ErrUnknownProcess
 	}
 
-	return state, nil
+	return r.playoutInfo(task, inputid)
 }
 
-func (r *restream) GetProcessLog(id string) (*app.Log, error) {
+// ListPlayoutPorts returns the ports that are currently assigned to the playout API of
+// the inputs of the processes, ordered by the port.
+func (r *restream) ListPlayoutPorts() []app.PlayoutPortInfo {
 	r.lock.RLock()
 	defer r.lock.RUnlock()
 
-	task, ok := r.tasks[id]
-	if !ok {
-		return &app.Log{}, ErrUnknownProcess
+	ports := []app.PlayoutPortInfo{}
+
+	for id, t := range r.tasks {
+		for inputid, port := range t.playout {
+			ports = append(ports, app.PlayoutPortInfo{
+				Port:       port,
+				ProcessID:  id,
+				InputID:    inputid,
+				AssignedAt: t.playoutAt.Unix(),
+			})
+		}
 	}
 
+	sort.Slice(ports, func(i, j int) bool {
+		return ports[i].Port < ports[j].Port
+	})
+
+	return ports
+}
+
+// playoutInfo returns how to connect to the playout API of the input of the task. The lock
+// must be held by the caller.
+func (r *restream) playoutInfo(task *task, inputid string) (app.PlayoutInfo, error) {
+	info := app.PlayoutInfo{}
+	id := task.id
+
 	if !task.valid {
-		return &app.Log{}, nil
+		return info, fmt.Errorf("invalid process definition")
 	}
 
-	log := &app.Log{}
+	port, ok := task.playout[inputid]
+	socket, hasSocket := task.sockets[inputid]
+	if !ok && !hasSocket {
+		if task.playout == nil && hasPlayoutInput(task.config, inputid) {
+			return info, fmt.Errorf("input ID '%s' of process '%s': %w", inputid, id, ErrPlayoutNotReady)
+		}
 
-	current := task.parser.Report()
+		return info, fmt.Errorf("no playout for input ID '%s' and process '%s'", inputid, id)
+	}
 
-	log.CreatedAt = current.CreatedAt
-	log.Prelude = current.Prelude
-	log.Log = make([]app.LogEntry, len(current.Log))
-	for i, line := range current.Log {
-		log.Log[i] = app.LogEntry{
-			Timestamp: line.Timestamp,
-			Data:      line.Data,
-		}
+	info.Scheme = "http"
+
+	if hasSocket {
+		info.Socket = socket
+	} else {
+		info.Host = r.playout.advertiseHost
+		info.Port = port
 	}
 
-	history := task.parser.ReportHistory()
+	for _, input := range task.config.Input {
+		if input.ID != inputid {
+			continue
+		}
 
-	for _, h := range history {
-		e := app.LogHistoryEntry{
-			CreatedAt: h.CreatedAt,
-			Prelude:   h.Prelude,
+		address := strings.TrimPrefix(strings.TrimPrefix(input.Address, "avstream:"), "playout:")
+
+		info.Protocol = url.Scheme(address)
+		if len(info.Protocol) == 0 {
+			info.Protocol = "file"
 		}
 
-		e.Log = make([]app.LogEntry, len(h.Log))
-		for i, line := range h.Log {
-			e.Log[i] = app.LogEntry{
-				Timestamp: line.Timestamp,
-				Data:      line.Data,
-			}
+		// The address of the lavfi demuxer is a filter graph that generates the streams
+		if inputFormat(input.Options) == "lavfi" {
+			info.Protocol = "lavfi"
 		}
 
-		log.History = append(log.History, e)
+		break
 	}
 
-	return log, nil
+	return info, nil
 }
 
-func (r *restream) Probe(id string) app.Probe {
-	return r.ProbeWithTimeout(id, 20*time.Second)
+var ErrPauseNotSupported = errors.New("pausing requires that all inputs are playout inputs of files")
+
+// PauseProcess stops forwarding the inputs of a running process to its outputs without stopping
+// ffmpeg. The connections to the inputs are kept alive, such that the process can be resumed
+// instantly. Pausing is done via the playout API, i.e. all inputs must be playout inputs of files,
+// the same as for the playback control of the playout API, otherwise ErrPauseNotSupported is returned. The process is not paused anymore after it has been restarted.
+func (r *restream) PauseProcess(id string) error {
+	return r.setPaused(id, true)
 }
 
-func (r *restream) ProbeWithTimeout(id string, timeout time.Duration) app.Probe {
-	r.lock.RLock()
+// ResumeProcess resumes forwarding the inputs of a paused process to its outputs.
+func (r *restream) ResumeProcess(id string) error {
+	return r.setPaused(id, false)
+}
 
-	appprobe := app.Probe{}
+func (r *restream) setPaused(id string, paused bool) error {
+	if !r.allowChange() {
+		return ErrRateLimited
+	}
+
+	r.lock.RLock()
 
 	task, ok := r.tasks[id]
 	if !ok {
-		appprobe.Log = append(appprobe.Log, fmt.Sprintf("Unknown process ID (%s)", id))
 		r.lock.RUnlock()
-		return appprobe
+		return ErrUnknownProcess
 	}
 
-	r.lock.RUnlock()
-
-	if !task.valid {
-		return appprobe
+	if !task.valid || !task.ffmpeg.IsRunning() {
+		r.lock.RUnlock()
+		return fmt.Errorf("the process '%s' is not running", id)
 	}
 
-	var command []string
-
-	// Copy global options
-	command = append(command, task.config.Options...)
+	infos := []app.PlayoutInfo{}
 
 	for _, input := range task.config.Input {
-		// Add the resolved input to the process command
-		command = append(command, input.Options...)
-		command = append(command, "-i", input.Address)
-	}
+		if !hasPlayoutInput(task.config, input.ID) {
+			r.lock.RUnlock()
+			return fmt.Errorf("input '%s' of process '%s': %w", input.ID, id, ErrPauseNotSupported)
+		}
 
-	prober := r.ffmpeg.NewProbeParser(task.logger)
+		info, err := r.playoutInfo(task, input.ID)
+		if err != nil {
+			r.lock.RUnlock()
+			return err
+		}
 
-	var wg sync.WaitGroup
+		if info.Protocol != "file" {
+			r.lock.RUnlock()
+			return fmt.Errorf("input '%s' of process '%s' is a live source (%s): %w", input.ID, id, info.Protocol, ErrPauseNotSupported)
+		}
 
-	wg.Add(1)
+		infos = append(infos, info)
+	}
 
-	ffmpeg, err := r.ffmpeg.New(ffmpeg.ProcessConfig{
-		Reconnect:      false,
-		ReconnectDelay: 0,
-		StaleTimeout:   timeout,
-		Command:        command,
-		Parser:         prober,
-		Logger:         task.logger,
-		OnExit: func() {
-			wg.Done()
-		},
-	})
+	r.lock.RUnlock()
 
-	if err != nil {
-		appprobe.Log = append(appprobe.Log, err.Error())
-		return appprobe
+	action := "resume"
+	if paused {
+		action = "pause"
 	}
 
-	ffmpeg.Start()
+	// Not holding the lock while talking to the playout API
+	for _, info := range infos {
+		if err := playoutControl(info, action); err != nil {
+			return fmt.Errorf("failed to %s process '%s': %w", action, id, err)
+		}
+	}
 
-	wg.Wait()
+	r.lock.Lock()
+	defer r.unlock()
 
-	appprobe = prober.Probe()
+	r.dirty = true
 
-	return appprobe
-}
+	// The process may have been replaced in the meantime
+	if r.tasks[id] != task {
+		return nil
+	}
 
-func (r *restream) Skills() skills.Skills {
-	return r.ffmpeg.Skills()
-}
+	if paused {
+		task.paused = time.Now()
+	} else {
+		task.paused = time.Time{}
+	}
 
-func (r *restream) ReloadSkills() error {
-	return r.ffmpeg.ReloadSkills()
+	return nil
 }
 
-func (r *restream) GetPlayout(id, inputid string) (string, error) {
-	r.lock.RLock()
-	defer r.lock.RUnlock()
+// playoutControl sends the action to the control endpoint of the playout API.
+func playoutControl(info app.PlayoutInfo, action string) error {
+	endpoint := info.Scheme + "://" + info.Address() + "/v1/control"
+	if len(info.Socket) != 0 {
+		// The host is irrelevant, the connection is made to the socket
+		endpoint = info.Scheme + "://playout/v1/control"
+	}
 
-	task, ok := r.tasks[id]
-	if !ok {
-		return "", ErrUnknownProcess
+	data, _ := json.Marshal(map[string]string{"action": action})
+
+	request, err := http.NewRequest(http.MethodPut, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
 	}
 
-	if !task.valid {
-		return "", fmt.Errorf("invalid process definition")
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := playout.Client(info.Socket).Do(request)
+	if err != nil {
+		return err
 	}
 
-	port, ok := task.playout[inputid]
-	if !ok {
-		return "", fmt.Errorf("no playout for input ID '%s' and process '%s'", inputid, id)
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("playout API responded with %s", response.Status)
 	}
 
-	return "127.0.0.1:" + strconv.Itoa(port), nil
+	return nil
 }
 
 var ErrMetadataKeyNotFound = errors.New("unknown key")
 
 func (r *restream) SetProcessMetadata(id, key string, data interface{}) error {
+	if !r.allowChange() {
+		return ErrRateLimited
+	}
+
 	r.lock.Lock()
-	defer r.lock.Unlock()
+	defer r.unlock()
+
+	r.dirty = true
 
 	if len(key) == 0 {
 		return fmt.Errorf("a key for storing the data has to be provided")
@@ -1412,6 +5147,25 @@ func (r *restream) SetProcessMetadata(id, key string, data interface{}) error {
 		return ErrUnknownProcess
 	}
 
+	if data != nil {
+		if err := r.validateMetadata(key, data); err != nil {
+			return err
+		}
+	}
+
+	setTaskMetadata(task, key, data)
+
+	// The metadata is part of the process for read-modify-write
+	task.process.Version++
+
+	r.save()
+
+	return nil
+}
+
+// setTaskMetadata stores the data under the key with the task. A nil data removes the key.
+// The caller must hold the lock.
+func setTaskMetadata(task *task, key string, data interface{}) {
 	if task.metadata == nil {
 		task.metadata = make(map[string]interface{})
 	}
@@ -1425,10 +5179,71 @@ func (r *restream) SetProcessMetadata(id, key string, data interface{}) error {
 	if len(task.metadata) == 0 {
 		task.metadata = nil
 	}
+}
 
-	r.save()
+// SetProcessMetadataBulk stores the data under the key with all processes whose ID matches the
+// glob pattern, or removes the key if data is nil. The data is validated only once and the store
+// is written only once. It returns the sorted IDs of the updated processes. An empty pattern is
+// not allowed, use "*" in order to update all processes.
+func (r *restream) SetProcessMetadataBulk(idpattern, key string, data interface{}) ([]string, error) {
+	if !r.allowChange() {
+		return nil, ErrRateLimited
+	}
 
-	return nil
+	if len(idpattern) == 0 {
+		return nil, fmt.Errorf("a pattern for the process IDs has to be provided")
+	}
+
+	if len(key) == 0 {
+		return nil, fmt.Errorf("a key for storing the data has to be provided")
+	}
+
+	r.lock.Lock()
+	defer r.unlock()
+
+	r.dirty = true
+
+	if data != nil {
+		if err := r.validateMetadata(key, data); err != nil {
+			return nil, err
+		}
+	}
+
+	ids := processIDs(r.tasks, idpattern, "")
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		setTaskMetadata(r.tasks[id], key, data)
+		r.tasks[id].process.Version++
+	}
+
+	if len(ids) != 0 {
+		r.save()
+	}
+
+	return ids, nil
+}
+
+// GetProcessMetadataBulk returns the data stored under the key with all processes whose ID
+// matches the glob pattern, by the ID. Processes without data for the key are not included.
+// An empty pattern matches all processes.
+func (r *restream) GetProcessMetadataBulk(idpattern, key string) (map[string]interface{}, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("a key for the data has to be provided")
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	data := map[string]interface{}{}
+
+	for _, id := range processIDs(r.tasks, idpattern, "") {
+		if value, ok := r.tasks[id].metadata[key]; ok {
+			data[id] = value
+		}
+	}
+
+	return data, nil
 }
 
 func (r *restream) GetProcessMetadata(id, key string) (interface{}, error) {
@@ -1452,14 +5267,40 @@ func (r *restream) GetProcessMetadata(id, key string) (interface{}, error) {
 	return data, nil
 }
 
+func (r *restream) DeleteProcessMetadata(id, key string) error {
+	return r.SetProcessMetadata(id, key, nil)
+}
+
+func (r *restream) ListProcessMetadataKeys(id string) ([]string, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	task, ok := r.tasks[id]
+	if !ok {
+		return nil, ErrUnknownProcess
+	}
+
+	return metadataKeys(task.metadata), nil
+}
+
 func (r *restream) SetMetadata(key string, data interface{}) error {
+	if !r.allowChange() {
+		return ErrRateLimited
+	}
+
 	r.lock.Lock()
-	defer r.lock.Unlock()
+	defer r.unlock()
 
 	if len(key) == 0 {
 		return fmt.Errorf("a key for storing the data has to be provided")
 	}
 
+	if data != nil {
+		if err := r.validateMetadata(key, data); err != nil {
+			return err
+		}
+	}
+
 	if r.metadata == nil {
 		r.metadata = make(map[string]interface{})
 	}
@@ -1495,6 +5336,110 @@ func (r *restream) GetMetadata(key string) (interface{}, error) {
 	return data, nil
 }
 
+func (r *restream) DeleteMetadata(key string) error {
+	return r.SetMetadata(key, nil)
+}
+
+func (r *restream) ListMetadataKeys() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return metadataKeys(r.metadata)
+}
+
+// metadataKeys returns the sorted keys of the metadata.
+func metadataKeys(metadata map[string]interface{}) []string {
+	keys := make([]string, 0, len(metadata))
+
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// resolveEnvironment replaces all environment variables of the form ${VAR} in
+// the options and addresses of the config. The config will be modified in place.
+func resolveEnvironment(config *app.Config, r replace.Replacer) error {
+	var err error
+
+	for i, option := range config.Options {
+		if config.Options[i], err = r.ReplaceEnvironment(option); err != nil {
+			return fmt.Errorf("global options: %w", err)
+		}
+	}
+
+	for _, list := range [][]app.ConfigIO{config.Input, config.Output} {
+		for i, io := range list {
+			if io.Address, err = r.ReplaceEnvironment(io.Address); err != nil {
+				return fmt.Errorf("address of '%s': %w", io.ID, err)
+			}
+
+			for j, option := range io.Options {
+				if io.Options[j], err = r.ReplaceEnvironment(option); err != nil {
+					return fmt.Errorf("options of '%s': %w", io.ID, err)
+				}
+			}
+
+			list[i] = io
+		}
+	}
+
+	for name, hook := range map[string]*app.ConfigHook{"pre_start": config.PreStart, "post_stop": config.PostStop} {
+		if hook == nil {
+			continue
+		}
+
+		for j, arg := range hook.Args {
+			if hook.Args[j], err = r.ReplaceEnvironment(arg); err != nil {
+				return fmt.Errorf("arguments of %s hook: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkPlaceholderSections returns an error if the resolved config contains a placeholder
+// that has been left unresolved because its template is not allowed in the section.
+func checkPlaceholderSections(config *app.Config, r replace.Replacer) error {
+	for _, option := range config.Options {
+		if err := r.CheckSection(option, "global"); err != nil {
+			return fmt.Errorf("global options: %w", err)
+		}
+	}
+
+	for section, list := range map[string][]app.ConfigIO{"input": config.Input, "output": config.Output} {
+		for _, io := range list {
+			if err := r.CheckSection(io.Address, section); err != nil {
+				return fmt.Errorf("address of '%s': %w", io.ID, err)
+			}
+
+			for _, option := range io.Options {
+				if err := r.CheckSection(option, section); err != nil {
+					return fmt.Errorf("options of '%s': %w", io.ID, err)
+				}
+			}
+		}
+	}
+
+	for name, hook := range map[string]*app.ConfigHook{"pre_start": config.PreStart, "post_stop": config.PostStop} {
+		if hook == nil {
+			continue
+		}
+
+		for _, arg := range hook.Args {
+			if err := r.CheckSection(arg, "global"); err != nil {
+				return fmt.Errorf("arguments of %s hook: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
 // resolvePlaceholders replaces all placeholders in the config. The config
 // will be modified in place.
 func resolvePlaceholders(config *app.Config, r replace.Replacer) {
@@ -1507,6 +5452,7 @@ func resolvePlaceholders(config *app.Config, r replace.Replacer) {
 		// Replace any known placeholders
 		option = r.Replace(option, "diskfs", "", vars, config, "global")
 		option = r.Replace(option, "fs:*", "", vars, config, "global")
+		option = r.Replace(option, "global:*", "", vars, config, "global")
 
 		config.Options[i] = option
 	}
@@ -1525,6 +5471,7 @@ func resolvePlaceholders(config *app.Config, r replace.Replacer) {
 		input.Address = r.Replace(input.Address, "diskfs", "", vars, config, "input")
 		input.Address = r.Replace(input.Address, "memfs", "", vars, config, "input")
 		input.Address = r.Replace(input.Address, "fs:*", "", vars, config, "input")
+		input.Address = r.Replace(input.Address, "global:*", "", vars, config, "input")
 		input.Address = r.Replace(input.Address, "rtmp", "", vars, config, "input")
 		input.Address = r.Replace(input.Address, "srt", "", vars, config, "input")
 
@@ -1536,6 +5483,7 @@ func resolvePlaceholders(config *app.Config, r replace.Replacer) {
 			option = r.Replace(option, "diskfs", "", vars, config, "input")
 			option = r.Replace(option, "memfs", "", vars, config, "input")
 			option = r.Replace(option, "fs:*", "", vars, config, "input")
+			option = r.Replace(option, "global:*", "", vars, config, "input")
 
 			input.Options[j] = option
 		}
@@ -1559,6 +5507,7 @@ func resolvePlaceholders(config *app.Config, r replace.Replacer) {
 		output.Address = r.Replace(output.Address, "diskfs", "", vars, config, "output")
 		output.Address = r.Replace(output.Address, "memfs", "", vars, config, "output")
 		output.Address = r.Replace(output.Address, "fs:*", "", vars, config, "output")
+		output.Address = r.Replace(output.Address, "global:*", "", vars, config, "output")
 		output.Address = r.Replace(output.Address, "rtmp", "", vars, config, "output")
 		output.Address = r.Replace(output.Address, "srt", "", vars, config, "output")
 
@@ -1570,6 +5519,7 @@ func resolvePlaceholders(config *app.Config, r replace.Replacer) {
 			option = r.Replace(option, "diskfs", "", vars, config, "output")
 			option = r.Replace(option, "memfs", "", vars, config, "output")
 			option = r.Replace(option, "fs:*", "", vars, config, "output")
+			option = r.Replace(option, "global:*", "", vars, config, "output")
 
 			output.Options[j] = option
 		}
@@ -1587,4 +5537,26 @@ func resolvePlaceholders(config *app.Config, r replace.Replacer) {
 
 		config.Output[i] = output
 	}
+
+	// Resolving the hooks
+	for _, hook := range []*app.ConfigHook{config.PreStart, config.PostStop} {
+		if hook == nil {
+			continue
+		}
+
+		hook.Binary = r.Replace(hook.Binary, "processid", config.ID, nil, nil, "global")
+		hook.Binary = r.Replace(hook.Binary, "reference", config.Reference, nil, nil, "global")
+
+		for j, arg := range hook.Args {
+			// Replace any known placeholders
+			arg = r.Replace(arg, "processid", config.ID, nil, nil, "global")
+			arg = r.Replace(arg, "reference", config.Reference, nil, nil, "global")
+			arg = r.Replace(arg, "diskfs", "", vars, config, "global")
+			arg = r.Replace(arg, "memfs", "", vars, config, "global")
+			arg = r.Replace(arg, "fs:*", "", vars, config, "global")
+			arg = r.Replace(arg, "global:*", "", vars, config, "global")
+
+			hook.Args[j] = arg
+		}
+	}
 }
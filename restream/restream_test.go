@@ -1,17 +1,35 @@
 package restream
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	gonet "net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/datarhei/core/v16/ffmpeg"
+	"github.com/datarhei/core/v16/ffmpeg/parse"
+	"github.com/datarhei/core/v16/ffmpeg/skills"
 	"github.com/datarhei/core/v16/internal/testhelper"
+	"github.com/datarhei/core/v16/io/fs"
 	"github.com/datarhei/core/v16/net"
+	"github.com/datarhei/core/v16/process"
 	"github.com/datarhei/core/v16/restream/app"
 	"github.com/datarhei/core/v16/restream/replace"
+	"github.com/datarhei/core/v16/restream/store"
 
 	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
 )
 
 func getDummyRestreamer(portrange net.Portranger, validatorIn, validatorOut ffmpeg.Validator, replacer replace.Replacer) (Restreamer, error) {
@@ -113,6 +131,61 @@ func TestAutostartProcess(t *testing.T) {
 	rs.StopProcess(process.ID)
 }
 
+func TestAutostartDelay(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Autostart = true
+	process.AutostartDelay = 1
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+	require.Equal(t, "starting", state.State)
+	require.InDelta(t, 1, state.StartIn, 0.1)
+
+	require.Eventually(t, func() bool {
+		state, _ := rs.GetProcessState(process.ID)
+		return state.State == "running"
+	}, 3*time.Second, 100*time.Millisecond)
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, float64(-1), state.StartIn)
+
+	rs.StopProcess(process.ID)
+
+	// Stopping cancels the pending start
+	process = getDummyProcess()
+	process.ID = "process2"
+	process.Autostart = true
+	process.AutostartDelay = 1
+	process.AutostartJitter = 1
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "starting", state.State)
+	require.GreaterOrEqual(t, state.StartIn, 0.9)
+	require.LessOrEqual(t, state.StartIn, 2.0)
+
+	err = rs.StopProcess(process.ID)
+	require.NoError(t, err)
+
+	time.Sleep(2500 * time.Millisecond)
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "stop", state.Order)
+	require.Equal(t, "finished", state.State)
+}
+
 func TestAddInvalidProcess(t *testing.T) {
 	rs, err := getDummyRestreamer(nil, nil, nil, nil)
 	require.NoError(t, err)
@@ -233,6 +306,69 @@ func TestUpdateProcess(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestUpdateProcessIf(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), state.Version)
+
+	update := getDummyProcess()
+	update.Reference = "first"
+
+	err = rs.UpdateProcessIf(process.ID, 0, update)
+	require.NoError(t, err)
+
+	state, _ = rs.GetProcessState(process.ID)
+	require.Equal(t, uint64(1), state.Version)
+
+	// An update based on the outdated version must fail
+	update = getDummyProcess()
+	update.Reference = "second"
+
+	err = rs.UpdateProcessIf(process.ID, 0, update)
+	require.Equal(t, ErrVersionConflict, err)
+
+	p, _ := rs.GetProcess(process.ID)
+	require.Equal(t, "first", p.Reference)
+
+	err = rs.UpdateProcessIf("foobar", 1, update)
+	require.Equal(t, ErrUnknownProcess, err)
+
+	// Changing the order is a change as well
+	rs.StartProcess(process.ID)
+	rs.StopProcess(process.ID)
+
+	state, _ = rs.GetProcessState(process.ID)
+	require.Equal(t, uint64(3), state.Version)
+
+	err = rs.UpdateProcessIf(process.ID, 3, update)
+	require.NoError(t, err)
+
+	p, _ = rs.GetProcess(process.ID)
+	require.Equal(t, "second", p.Reference)
+	require.Equal(t, uint64(4), p.Version)
+
+	// Changing the metadata is a change as well
+	err = rs.SetProcessMetadata(process.ID, "foo", "bar")
+	require.NoError(t, err)
+
+	_, err = rs.SetProcessMetadataBulk("*", "foo", "baz")
+	require.NoError(t, err)
+
+	err = rs.UpdateProcessIf(process.ID, 4, update)
+	require.Equal(t, ErrVersionConflict, err)
+
+	state, _ = rs.GetProcessState(process.ID)
+	require.Equal(t, uint64(6), state.Version)
+}
+
 func TestGetProcess(t *testing.T) {
 	rs, err := getDummyRestreamer(nil, nil, nil, nil)
 	require.NoError(t, err)
@@ -338,6 +474,93 @@ func TestStopProcess(t *testing.T) {
 	require.Equal(t, "stop", state.Order, "Process should be stopped")
 }
 
+func TestGetProcessStates(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process1 := getDummyProcess()
+	process2 := getDummyProcess()
+	process2.ID = "process2"
+
+	rs.AddProcess(process1)
+	rs.AddProcess(process2)
+	rs.StartProcess(process1.ID)
+
+	states := rs.GetProcessStates(nil)
+	require.Equal(t, 2, len(states))
+	require.Equal(t, "start", states[process1.ID].Order)
+	require.Equal(t, "stop", states[process2.ID].Order)
+
+	states = rs.GetProcessStates([]string{process2.ID, "foobar"})
+	require.Equal(t, 1, len(states), "unknown processes should be skipped")
+	require.Equal(t, "stop", states[process2.ID].Order)
+
+	rs.StopProcess(process1.ID)
+}
+
+func TestGetProcessIDsByState(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process1 := getDummyProcess()
+	process2 := getDummyProcess()
+	process2.ID = "process2"
+	process2.Reference = "ref"
+
+	rs.AddProcess(process1)
+	rs.AddProcess(process2)
+	rs.StartProcess(process1.ID)
+
+	require.ElementsMatch(t, []string{process1.ID, process2.ID}, rs.GetProcessIDsByState("", "", "", ""))
+	require.ElementsMatch(t, []string{process1.ID}, rs.GetProcessIDsByState("start", "", "", ""))
+	require.ElementsMatch(t, []string{process2.ID}, rs.GetProcessIDsByState("stop", "finished", "", ""))
+	require.ElementsMatch(t, []string{process2.ID}, rs.GetProcessIDsByState("stop", "", "", "ref"))
+	require.ElementsMatch(t, []string{}, rs.GetProcessIDsByState("start", "", "", "ref"))
+	require.ElementsMatch(t, []string{}, rs.GetProcessIDsByState("", "failed", "", ""))
+
+	require.Eventually(t, func() bool {
+		ids := rs.GetProcessIDsByState("start", "running", "proc*", "")
+		return len(ids) == 1 && ids[0] == process1.ID
+	}, 5*time.Second, 100*time.Millisecond)
+
+	rs.StopProcess(process1.ID)
+}
+
+func TestGetProcessIDsByFilter(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	for _, id := range []string{"ingest_1", "ingest_2", "egress_1"} {
+		process := getDummyProcess()
+		process.ID = id
+		process.Reference = "prod"
+
+		if id == "ingest_2" {
+			process.Reference = "dev"
+		}
+
+		require.NoError(t, rs.AddProcess(process))
+	}
+
+	require.NoError(t, rs.StartProcess("ingest_1"))
+	require.NoError(t, rs.StartProcess("egress_1"))
+
+	require.ElementsMatch(t, []string{"ingest_1", "ingest_2", "egress_1"}, rs.GetProcessIDsByFilter(ProcessFilter{}))
+	require.ElementsMatch(t, []string{"ingest_1", "ingest_2"}, rs.GetProcessIDsByFilter(ProcessFilter{ID: "ingest_*"}))
+	require.ElementsMatch(t, []string{"ingest_1"}, rs.GetProcessIDsByFilter(ProcessFilter{ID: "ingest_*", Reference: "prod"}))
+	require.ElementsMatch(t, []string{"ingest_2"}, rs.GetProcessIDsByFilter(ProcessFilter{ID: "ingest_*", Order: "stop"}))
+	require.ElementsMatch(t, []string{}, rs.GetProcessIDsByFilter(ProcessFilter{Reference: "dev", Order: "start"}))
+	require.ElementsMatch(t, []string{}, rs.GetProcessIDsByFilter(ProcessFilter{ID: "["}))
+
+	require.Eventually(t, func() bool {
+		ids := rs.GetProcessIDsByFilter(ProcessFilter{Reference: "prod", State: "running"})
+		return len(ids) == 2
+	}, 5*time.Second, 100*time.Millisecond)
+
+	rs.StopProcess("ingest_1")
+	rs.StopProcess("egress_1")
+}
+
 func TestRestartProcess(t *testing.T) {
 	rs, err := getDummyRestreamer(nil, nil, nil, nil)
 	require.NoError(t, err)
@@ -407,6 +630,39 @@ func TestProbeProcess(t *testing.T) {
 	require.Equal(t, 3, len(probe.Streams))
 }
 
+func TestProbeAddress(t *testing.T) {
+	valIn, err := ffmpeg.NewValidator([]string{"^https?://"}, nil)
+	require.NoError(t, err)
+
+	rs, err := getDummyRestreamer(nil, valIn, nil, nil)
+	require.NoError(t, err)
+
+	probe := rs.ProbeAddress("http://example.com/live.m3u8", []string{"-re"}, 5*time.Second)
+	require.Equal(t, 3, len(probe.Streams))
+
+	probe = rs.ProbeAddress("rtmp://example.com/live", nil, 5*time.Second)
+	require.Equal(t, 0, len(probe.Streams))
+	require.Equal(t, 1, len(probe.Log))
+	require.Contains(t, probe.Log[0], "address is not allowed")
+
+	// Options that select other inputs would bypass the validation of the address
+	for _, options := range [][]string{
+		{"-i", "/etc/passwd"},
+		{"-f", "lavfi"},
+		{"-f", "concat", "-safe", "0"},
+		{"-filter_complex", "movie=/etc/passwd"},
+		{"-re\x00"},
+	} {
+		probe = rs.ProbeAddress("http://example.com/live.m3u8", options, 5*time.Second)
+		require.Equal(t, 0, len(probe.Streams), options)
+		require.Equal(t, 1, len(probe.Log), options)
+		require.Contains(t, probe.Log[0], "Invalid options", options)
+	}
+
+	probe = rs.ProbeAddress("http://example.com/live.m3u8", []string{"-f", "hls"}, 5*time.Second)
+	require.Equal(t, 3, len(probe.Streams))
+}
+
 func TestProcessMetadata(t *testing.T) {
 	rs, err := getDummyRestreamer(nil, nil, nil, nil)
 	require.NoError(t, err)
@@ -428,6 +684,128 @@ func TestProcessMetadata(t *testing.T) {
 	require.Equal(t, process.ID, p.ID, "failed to retrieve stored data")
 }
 
+func TestMetadataKeys(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{}, rs.ListMetadataKeys())
+
+	rs.SetMetadata("foo", "bar")
+	rs.SetMetadata("bar", "foo")
+
+	require.Equal(t, []string{"bar", "foo"}, rs.ListMetadataKeys())
+
+	err = rs.DeleteMetadata("foo")
+	require.NoError(t, err)
+
+	err = rs.DeleteMetadata("foobar")
+	require.NoError(t, err, "deleting a non-existing key is not an error")
+
+	require.Equal(t, []string{"bar"}, rs.ListMetadataKeys())
+
+	_, err = rs.GetMetadata("foo")
+	require.Equal(t, ErrMetadataKeyNotFound, err)
+
+	_, err = rs.ListProcessMetadataKeys("foobar")
+	require.Equal(t, ErrUnknownProcess, err)
+
+	err = rs.DeleteProcessMetadata("foobar", "foo")
+	require.Equal(t, ErrUnknownProcess, err)
+
+	rs.SetProcessMetadata(process.ID, "foo", "bar")
+	rs.SetProcessMetadata(process.ID, "bar", "foo")
+
+	keys, err := rs.ListProcessMetadataKeys(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"bar", "foo"}, keys)
+
+	err = rs.DeleteProcessMetadata(process.ID, "bar")
+	require.NoError(t, err)
+
+	err = rs.DeleteProcessMetadata(process.ID, "foobar")
+	require.NoError(t, err, "deleting a non-existing key is not an error")
+
+	keys, err = rs.ListProcessMetadataKeys(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo"}, keys)
+}
+
+func TestMetadataSchema(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.RegisterMetadataSchema("foo", []byte("{"))
+	require.Error(t, err, "invalid schemas must be rejected")
+
+	err = rs.RegisterMetadataSchema("foo", []byte(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string"},
+			"count": {"type": "integer", "minimum": 0}
+		},
+		"required": ["name"]
+	}`))
+	require.NoError(t, err)
+
+	err = rs.SetMetadata("foo", map[string]interface{}{"count": 1})
+	require.ErrorContains(t, err, "name is required")
+
+	err = rs.SetMetadata("foo", map[string]interface{}{"name": "bar", "count": -1})
+	require.ErrorContains(t, err, "count")
+
+	err = rs.SetProcessMetadata(process.ID, "foo", "bar")
+	require.Error(t, err)
+
+	err = rs.SetMetadata("foo", map[string]interface{}{"name": "bar", "count": 1})
+	require.NoError(t, err)
+
+	err = rs.SetMetadata("bar", "no schema")
+	require.NoError(t, err)
+
+	var raw json.RawMessage
+
+	err = rs.GetMetadataInto("foo", &raw)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"name":"bar","count":1}`, string(raw))
+
+	type data struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+
+	err = rs.RegisterMetadataType("typed", data{})
+	require.NoError(t, err)
+
+	err = rs.SetProcessMetadata(process.ID, "typed", data{Name: "foobar", Count: 42})
+	require.NoError(t, err)
+
+	err = rs.SetProcessMetadata(process.ID, "typed", "foobar")
+	require.Error(t, err)
+
+	var d data
+
+	err = rs.GetProcessMetadataInto(process.ID, "typed", &d)
+	require.NoError(t, err)
+	require.Equal(t, data{Name: "foobar", Count: 42}, d)
+
+	err = rs.GetProcessMetadataInto(process.ID, "unknown", &d)
+	require.Equal(t, ErrMetadataKeyNotFound, err)
+
+	// Removing is always allowed
+	err = rs.DeleteMetadata("foo")
+	require.NoError(t, err)
+}
+
 func TestLog(t *testing.T) {
 	rs, err := getDummyRestreamer(nil, nil, nil, nil)
 	require.NoError(t, err)
@@ -461,326 +839,1157 @@ func TestLog(t *testing.T) {
 	require.NotEqual(t, 0, len(log.Log))
 }
 
-func TestPlayoutNoRange(t *testing.T) {
+func TestCloneProcess(t *testing.T) {
 	rs, err := getDummyRestreamer(nil, nil, nil, nil)
 	require.NoError(t, err)
 
 	process := getDummyProcess()
+	process.Reference = "ref"
+	process.Output[0].Address = "{processid}.m3u8"
 
-	process.Input[0].Address = "playout:" + process.Input[0].Address
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
 
-	rs.AddProcess(process)
+	config, err := rs.CloneProcess(process.ID, "clone", map[string]string{
+		"reference":               "variant",
+		"reconnect_delay_seconds": "5",
+		"autostart":               "false",
+		"limit_cpu_usage":         "50.5",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "clone", config.ID)
+	require.Equal(t, "variant", config.Reference)
+	require.Equal(t, uint64(5), config.ReconnectDelay)
+	require.Equal(t, 50.5, config.LimitCPU)
+	require.Equal(t, "{processid}.m3u8", config.Output[0].Address, "the original config is returned")
 
-	_, err = rs.GetPlayout("foobar", process.Input[0].ID)
-	require.NotEqual(t, nil, err, "playout of non-existing process should error")
+	state, err := rs.GetProcessState("clone")
+	require.NoError(t, err)
+	require.Contains(t, state.Command, "clone.m3u8")
 
-	_, err = rs.GetPlayout(process.ID, "foobar")
-	require.NotEqual(t, nil, err, "playout of non-existing input should error")
+	src, err := rs.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "ref", src.Reference)
+	require.Equal(t, uint64(10), src.Config.ReconnectDelay)
+
+	_, err = rs.CloneProcess("foobar", "clone2", nil)
+	require.ErrorIs(t, err, ErrUnknownProcess)
+
+	_, err = rs.CloneProcess(process.ID, "clone", nil)
+	require.ErrorIs(t, err, ErrProcessExists)
+
+	for _, overrides := range []map[string]string{
+		{"id": "foobar"},
+		{"foobar": "1"},
+		{"input": "foobar"},
+		{"reconnect_delay_seconds": "-1"},
+		{"autostart": "maybe"},
+	} {
+		_, err = rs.CloneProcess(process.ID, "clone2", overrides)
+		require.Error(t, err, overrides)
+	}
 
-	addr, _ := rs.GetPlayout(process.ID, process.Input[0].ID)
-	require.Equal(t, 0, len(addr), "the playout address should be empty if no port range is given")
+	_, err = rs.CloneProcess(process.ID, " ", nil)
+	require.Error(t, err, "the new ID is validated")
+
+	_, err = rs.GetProcess("clone2")
+	require.Error(t, err)
 }
 
-func TestPlayoutRange(t *testing.T) {
-	portrange, err := net.NewPortrange(3000, 3001)
+func TestReorderOutputs(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
 	require.NoError(t, err)
 
-	rs, err := getDummyRestreamer(portrange, nil, nil, nil)
+	process := getDummyProcess()
+	process.Output = append(process.Output, app.ConfigIO{
+		ID:      "out2",
+		Address: "-",
+		Options: []string{"-codec", "copy", "-f", "mpegts"},
+	})
+
+	err = rs.AddProcess(process)
 	require.NoError(t, err)
 
-	process := getDummyProcess()
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
 
-	process.Input[0].Address = "playout:" + process.Input[0].Address
+	p, err := rs.GetProcess(process.ID)
+	require.NoError(t, err)
 
-	rs.AddProcess(process)
+	version := p.Version
 
-	_, err = rs.GetPlayout("foobar", process.Input[0].ID)
-	require.NotEqual(t, nil, err, "playout of non-existing process should error")
+	for _, order := range [][]string{
+		{"out"},
+		{"out", "out2", "out3"},
+		{"out", "out"},
+		{"out", "foobar"},
+	} {
+		err = rs.ReorderOutputs(process.ID, order)
+		require.Error(t, err, order)
+	}
 
-	_, err = rs.GetPlayout(process.ID, "foobar")
-	require.NotEqual(t, nil, err, "playout of non-existing input should error")
+	err = rs.ReorderOutputs("foobar", []string{"out", "out2"})
+	require.ErrorIs(t, err, ErrUnknownProcess)
 
-	addr, _ := rs.GetPlayout(process.ID, process.Input[0].ID)
-	require.NotEqual(t, 0, len(addr), "the playout address should not be empty if a port range is given")
-	require.Equal(t, "127.0.0.1:3000", addr, "the playout address should be 127.0.0.1:3000")
-}
+	err = rs.ReorderOutputs(process.ID, []string{"out", "out2"})
+	require.NoError(t, err)
 
-func TestAddressReference(t *testing.T) {
-	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	p, err = rs.GetProcess(process.ID)
 	require.NoError(t, err)
+	require.Equal(t, version, p.Version, "the process shouldn't be updated if the order didn't change")
 
-	process1 := getDummyProcess()
-	process2 := getDummyProcess()
+	err = rs.ReorderOutputs(process.ID, []string{"out2", "out"})
+	require.NoError(t, err)
 
-	process2.ID = "process2"
+	p, err = rs.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "out2", p.Config.Output[0].ID)
+	require.Equal(t, "out", p.Config.Output[1].ID)
+	require.Equal(t, "start", p.Order)
+	require.NotEqual(t, version, p.Version)
 
-	rs.AddProcess(process1)
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Contains(t, strings.Join(state.Command, " "), "-f mpegts - -codec copy -f null -")
 
-	process2.Input[0].Address = "#process:foobar=out"
+	err = rs.StopProcess(process.ID)
+	require.NoError(t, err)
+}
 
-	err = rs.AddProcess(process2)
-	require.NotEqual(t, nil, err, "shouldn't resolve invalid reference")
+func TestValidate(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
 
-	process2.Input[0].Address = "#process2:output=out"
+	process := getDummyProcess()
+	process.Output[0].Address = "{processid}.m3u8"
 
-	err = rs.AddProcess(process2)
-	require.NotEqual(t, nil, err, "shouldn't resolve invalid reference")
+	config, err := rs.Validate(process)
+	require.NoError(t, err)
+	require.Equal(t, "process.m3u8", config.Output[0].Address)
+	require.NotEqual(t, 0, len(config.FFVersion))
 
-	process2.Input[0].Address = "#process:output=foobar"
+	// The given config is not modified and the process is not added
+	require.Equal(t, "{processid}.m3u8", process.Output[0].Address)
+	require.Equal(t, 0, len(process.FFVersion))
 
-	err = rs.AddProcess(process2)
-	require.NotEqual(t, nil, err, "shouldn't resolve invalid reference")
+	_, err = rs.GetProcess(process.ID)
+	require.Error(t, err)
 
-	process2.Input[0].Address = "#process:output=out"
+	process.ID = " "
 
-	err = rs.AddProcess(process2)
-	require.Equal(t, nil, err, "should resolve reference")
+	_, err = rs.Validate(process)
+	require.Error(t, err)
+
+	process = getDummyProcess()
+	process.Output = nil
+
+	_, err = rs.Validate(process)
+	require.Error(t, err)
+
+	process = getDummyProcess()
+	process.FFVersion = "^99.0.0"
+
+	_, err = rs.Validate(process)
+	require.Error(t, err)
 }
 
-func TestConfigValidation(t *testing.T) {
-	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+func TestFFVersion(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
 	require.NoError(t, err)
 
-	rs := rsi.(*restream)
+	// The constraint is the version of the FFmpeg binary (4.0.2) without the patch level
+	process := getDummyProcess()
+	require.NoError(t, rs.AddProcess(process))
 
-	config := getDummyProcess()
+	p, err := rs.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "^4.0.0", p.Config.FFVersion)
 
-	_, err = rs.validateConfig(config)
+	// A given constraint is kept
+	process = getDummyProcess()
+	process.ID = "process2"
+	process.FFVersion = "^4.0"
+	require.NoError(t, rs.AddProcess(process))
+
+	p, err = rs.GetProcess(process.ID)
 	require.NoError(t, err)
+	require.Equal(t, "^4.0", p.Config.FFVersion)
+}
 
-	config.Input = []app.ConfigIO{}
-	_, err = rs.validateConfig(config)
-	require.Error(t, err)
+func TestLogParts(t *testing.T) {
+	binary, err := testhelper.BuildBinary("ffmpeg", "../internal/testhelper")
+	require.NoError(t, err, "Failed to build helper program")
 
-	config = getDummyProcess()
-	config.Input[0].ID = ""
-	_, err = rs.validateConfig(config)
-	require.Error(t, err)
+	ffmpeg, err := ffmpeg.New(ffmpeg.Config{
+		Binary:           binary,
+		LogHistoryLength: 3,
+	})
+	require.NoError(t, err)
 
-	config = getDummyProcess()
-	config.Input[0].Address = ""
-	_, err = rs.validateConfig(config)
-	require.Error(t, err)
+	rs, err := New(Config{
+		FFmpeg: ffmpeg,
+	})
+	require.NoError(t, err)
 
-	config = getDummyProcess()
-	config.Output = []app.ConfigIO{}
-	_, err = rs.validateConfig(config)
-	require.Error(t, err)
+	process := getDummyProcess()
 
-	config = getDummyProcess()
-	config.Output[0].ID = ""
-	_, err = rs.validateConfig(config)
-	require.Error(t, err)
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
 
-	config = getDummyProcess()
-	config.Output[0].Address = ""
-	_, err = rs.validateConfig(config)
+	_, err = rs.GetProcessLogParts("foobar", app.LogAll)
 	require.Error(t, err)
-}
 
-func TestConfigValidationFFmpeg(t *testing.T) {
-	valIn, err := ffmpeg.NewValidator([]string{"^https?://"}, nil)
+	err = rs.StartProcess(process.ID)
 	require.NoError(t, err)
 
-	valOut, err := ffmpeg.NewValidator([]string{"^https?://", "^rtmp://"}, nil)
+	require.Eventually(t, func() bool {
+		log, _ := rs.GetProcessLog(process.ID)
+		return len(log.Prelude) != 0 && len(log.Log) != 0
+	}, 5*time.Second, 100*time.Millisecond)
+
+	err = rs.StopProcess(process.ID)
 	require.NoError(t, err)
 
-	rsi, err := getDummyRestreamer(nil, valIn, valOut, nil)
+	// The prelude of the last run is kept after the process stopped
+	log, err := rs.GetProcessLogParts(process.ID, app.LogPrelude)
 	require.NoError(t, err)
+	require.NotEqual(t, 0, len(log.Prelude))
+	require.Equal(t, 0, len(log.Log))
+	require.Equal(t, 0, len(log.History))
 
-	rs := rsi.(*restream)
+	log, err = rs.GetProcessLogParts(process.ID, app.LogLines)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(log.Prelude))
+	require.NotEqual(t, 0, len(log.Log))
 
-	config := getDummyProcess()
+	// With the next start the last run is moved to the history
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
 
-	_, err = rs.validateConfig(config)
-	require.Error(t, err)
+	require.Eventually(t, func() bool {
+		log, _ := rs.GetProcessLogParts(process.ID, app.LogPrelude)
+		return len(log.History) == 1 && len(log.History[0].Prelude) != 0 && len(log.History[0].Log) == 0
+	}, 5*time.Second, 100*time.Millisecond)
 
-	config.Input[0].Address = "http://stream.example.com/master.m3u8"
-	config.Output[0].Address = "http://stream.example.com/master2.m3u8"
+	rs.StopProcess(process.ID)
+}
 
-	_, err = rs.validateConfig(config)
+func TestLogHistory(t *testing.T) {
+	binary, err := testhelper.BuildBinary("ffmpeg", "../internal/testhelper")
+	require.NoError(t, err, "Failed to build helper program")
+
+	ffmpeg, err := ffmpeg.New(ffmpeg.Config{
+		Binary:           binary,
+		LogHistoryLength: 1,
+	})
 	require.NoError(t, err)
 
-	config.Output[0].Address = "[f=flv]http://stream.example.com/master2.m3u8"
-	_, err = rs.validateConfig(config)
+	rs, err := New(Config{
+		FFmpeg: ffmpeg,
+	})
 	require.NoError(t, err)
 
-	config.Output[0].Address = "[f=hls]http://stream.example.com/master2.m3u8|[f=flv]rtmp://stream.example.com/stream"
-	_, err = rs.validateConfig(config)
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
 	require.NoError(t, err)
-}
 
-func TestOutputAddressValidation(t *testing.T) {
-	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	_, err = rs.GetProcessLogHistory("foobar")
+	require.Error(t, err)
+
+	runs, err := rs.GetProcessLogHistory(process.ID)
 	require.NoError(t, err)
+	require.Equal(t, 0, len(runs))
 
-	rs := rsi.(*restream)
+	for i := 0; i < 2; i++ {
+		err = rs.StartProcess(process.ID)
+		require.NoError(t, err)
 
-	type res struct {
-		path string
-		err  bool
+		require.Eventually(t, func() bool {
+			state, _ := rs.GetProcessState(process.ID)
+			return state.State == "running"
+		}, 5*time.Second, 100*time.Millisecond)
+
+		// The current run is not completed yet
+		runs, err = rs.GetProcessLogHistory(process.ID)
+		require.NoError(t, err)
+		require.Equal(t, i, len(runs))
+
+		err = rs.StopProcess(process.ID)
+		require.NoError(t, err)
+
+		runs, err = rs.GetProcessLogHistory(process.ID)
+		require.NoError(t, err)
+		require.Equal(t, i+1, len(runs))
 	}
 
-	paths := map[string]res{
-		"/dev/null":                            {"file:/dev/null", false},
-		"/dev/../etc/passwd":                   {"/etc/passwd", true},
-		"/dev/fb0":                             {"file:/dev/fb0", false},
-		"/etc/passwd":                          {"/etc/passwd", true},
-		"/core/data/../../etc/passwd":          {"/etc/passwd", true},
-		"/core/data/./etc/passwd":              {"file:/core/data/etc/passwd", false},
-		"file:/core/data/foobar":               {"file:/core/data/foobar", false},
-		"http://example.com":                   {"http://example.com", false},
-		"-":                                    {"pipe:", false},
-		"/core/data/foobar|http://example.com": {"file:/core/data/foobar|http://example.com", false},
-		"/core/data/foobar|/etc/passwd":        {"/core/data/foobar|/etc/passwd", true},
-		"[f=mpegts]udp://10.0.1.255:1234/":     {"[f=mpegts]udp://10.0.1.255:1234/", false},
-		"[f=null]-|[f=null]-":                  {"[f=null]pipe:|[f=null]pipe:", false},
-		"[onfail=ignore]/core/data/archive-20121107.mkv|[f=mpegts]udp://10.0.1.255:1234/": {"[onfail=ignore]file:/core/data/archive-20121107.mkv|[f=mpegts]udp://10.0.1.255:1234/", false},
+	require.Equal(t, 2, len(runs))
+
+	for _, run := range runs {
+		require.Equal(t, 255, run.ExitCode)
+		require.Equal(t, "", run.ExitSignal)
+		require.NotEqual(t, 0, len(run.Prelude))
+		require.True(t, run.StoppedAt.After(run.StartedAt))
 	}
 
-	for path, r := range paths {
-		path, _, err := rs.validateOutputAddress(path, "/core/data")
+	require.True(t, runs[1].StartedAt.After(runs[0].StoppedAt))
 
-		if r.err {
-			require.Error(t, err)
-		} else {
-			require.NoError(t, err)
-		}
+	// The history holds one run in addition to the last run
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
 
-		require.Equal(t, r.path, path)
-	}
+	err = rs.StopProcess(process.ID)
+	require.NoError(t, err)
+
+	runs, err = rs.GetProcessLogHistory(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(runs))
 }
 
-func TestMetadata(t *testing.T) {
+func TestEnvironment(t *testing.T) {
+	t.Setenv("FFMPEG_TEST_ECHO", "inherited")
+
 	rs, err := getDummyRestreamer(nil, nil, nil, nil)
 	require.NoError(t, err)
 
 	process := getDummyProcess()
+	process.Environment = map[string]string{
+		"FFMPEG_TEST_ECHO": "foobar",
+	}
 
-	data, _ := rs.GetMetadata("foobar")
-	require.Equal(t, nil, data, "nothing should be stored under the key")
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
 
-	rs.SetMetadata("foobar", process)
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
 
-	data, _ = rs.GetMetadata("foobar")
-	require.NotEqual(t, nil, data, "there should be something stored under the key")
+	hasLine := func(lines []string, line string) bool {
+		for _, l := range lines {
+			if l == line {
+				return true
+			}
+		}
 
-	p := data.(*app.Config)
+		return false
+	}
 
-	require.Equal(t, process.ID, p.ID, "failed to retrieve stored data")
-}
+	require.Eventually(t, func() bool {
+		log, _ := rs.GetProcessLog(process.ID)
+		return hasLine(log.Prelude, "FFMPEG_TEST_ECHO=foobar")
+	}, 5*time.Second, 100*time.Millisecond)
 
-func TestReplacer(t *testing.T) {
-	replacer := replace.New()
+	// Changing the environment restarts the process with the new value
+	process.Environment["FFMPEG_TEST_ECHO"] = "barfoo"
 
-	replacer.RegisterTemplateFunc("diskfs", func(config *app.Config, section string) string {
-		return "/mnt/diskfs"
-	}, nil)
+	err = rs.UpdateProcess(process.ID, process)
+	require.NoError(t, err)
 
-	replacer.RegisterTemplateFunc("fs:disk", func(config *app.Config, section string) string {
-		return "/mnt/diskfs"
-	}, nil)
+	require.Eventually(t, func() bool {
+		log, _ := rs.GetProcessLog(process.ID)
+		return hasLine(log.Prelude, "FFMPEG_TEST_ECHO=barfoo")
+	}, 5*time.Second, 100*time.Millisecond)
 
-	replacer.RegisterTemplateFunc("memfs", func(config *app.Config, section string) string {
-		return "http://localhost/mnt/memfs"
-	}, nil)
+	// Without the variable in the config, the variable of the core isn't passed on
+	process.Environment = nil
 
-	replacer.RegisterTemplateFunc("fs:mem", func(config *app.Config, section string) string {
-		return "http://localhost/mnt/memfs"
-	}, nil)
+	err = rs.UpdateProcess(process.ID, process)
+	require.NoError(t, err)
 
-	replacer.RegisterTemplateFunc("rtmp", func(config *app.Config, section string) string {
-		return "rtmp://localhost/app/{name}?token=foobar"
-	}, nil)
+	require.Eventually(t, func() bool {
+		log, _ := rs.GetProcessLog(process.ID)
+		if len(log.Prelude) == 0 {
+			return false
+		}
 
-	replacer.RegisterTemplateFunc("srt", func(config *app.Config, section string) string {
-		template := "srt://localhost:6000?mode=caller&transtype=live&latency={latency}&streamid={name}"
-		if section == "output" {
-			template += ",mode:publish"
-		} else {
-			template += ",mode:request"
+		for _, l := range log.Prelude {
+			if strings.HasPrefix(l, "FFMPEG_TEST_ECHO=") {
+				return false
+			}
 		}
-		template += ",token:abcfoobar&passphrase=secret"
 
-		return template
-	}, map[string]string{
-		"latency": "20000", // 20 milliseconds, FFmpeg requires microseconds
-	})
+		return true
+	}, 5*time.Second, 100*time.Millisecond)
 
-	rsi, err := getDummyRestreamer(nil, nil, nil, replacer)
+	rs.StopProcess(process.ID)
+
+	for _, env := range []map[string]string{
+		{"": "foobar"},
+		{"FOO=BAR": "foobar"},
+		{"FOO": "foo\nbar"},
+		{"FOO": "foo\x00bar"},
+	} {
+		process := getDummyProcess()
+		process.ID = "invalid"
+		process.Environment = env
+
+		err = rs.AddProcess(process)
+		require.Error(t, err, "%+v", env)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
 	require.NoError(t, err)
 
-	process := &app.Config{
-		ID:        "314159265359",
-		Reference: "refref",
-		Input: []app.ConfigIO{
-			{
-				ID:      "in_{processid}_{reference}",
-				Address: "input:{inputid}_process:{processid}_reference:{reference}_diskfs:{diskfs}/disk.txt_memfs:{memfs}/mem.txt_fsdisk:{fs:disk}/fsdisk.txt_fsmem:{fs:mem}/fsmem.txt_rtmp:{rtmp,name=pmtr}_srt:{srt,name=trs}_rtmp:{rtmp,name=$inputid}",
-				Options: []string{
-					"-f",
-					"lavfi",
-					"-re",
-					"input:{inputid}",
-					"process:{processid}",
-					"reference:{reference}",
-					"diskfs:{diskfs}/disk.txt",
-					"memfs:{memfs}/mem.txt",
-					"fsdisk:{fs:disk}/fsdisk.txt",
-					"fsmem:{fs:mem}/$inputid.txt",
-				},
-			},
-		},
-		Output: []app.ConfigIO{
-			{
-				ID:      "out_{processid}_{reference}",
-				Address: "output:{outputid}_process:{processid}_reference:{reference}_diskfs:{diskfs}/disk.txt_memfs:{memfs}/mem.txt_fsdisk:{fs:disk}/fsdisk.txt_fsmem:{fs:mem}/fsmem.txt_rtmp:{rtmp,name=$processid}_srt:{srt,name=$reference,latency=42}_rtmp:{rtmp,name=$outputid}",
-				Options: []string{
-					"-codec",
-					"copy",
-					"-f",
-					"null",
-					"output:{outputid}",
-					"process:{processid}",
-					"reference:{reference}",
-					"diskfs:{diskfs}/disk.txt",
-					"memfs:{memfs}/mem.txt",
-					"fsdisk:{fs:disk}/fsdisk.txt",
-					"fsmem:{fs:mem}/$outputid.txt",
-				},
-				Cleanup: []app.ConfigIOCleanup{
-					{
-						Pattern:       "pattern_{outputid}_{processid}_{reference}_{rtmp,name=$outputid}",
-						MaxFiles:      0,
-						MaxFileAge:    0,
-						PurgeOnDelete: false,
-					},
-				},
-			},
-		},
-		Options: []string{
-			"-loglevel",
-			"info",
-			"{diskfs}/foobar_on_disk.txt",
-			"{memfs}/foobar_in_mem.txt",
-			"{fs:disk}/foobar_on_disk_aswell.txt",
-			"{fs:mem}/foobar_in_mem_aswell.txt",
-		},
-		Reconnect:      true,
-		ReconnectDelay: 10,
-		Autostart:      false,
-		StaleTimeout:   0,
+	process := getDummyProcess()
+	process.PreStart = &app.ConfigHook{
+		Binary: "/bin/sh",
+		Args:   []string{"-c", "echo starting {processid}"},
+	}
+	process.PostStop = &app.ConfigHook{
+		Binary: "/bin/sh",
+		Args:   []string{"-c", "echo stopped {processid}"},
 	}
 
-	err = rsi.AddProcess(process)
+	err = rs.AddProcess(process)
+	require.Error(t, err, "the binary of the hooks is not allowed")
+
+	rs.(*restream).hookBins = map[string]bool{"/bin/sh": true}
+
+	err = rs.AddProcess(process)
 	require.NoError(t, err)
 
-	rs := rsi.(*restream)
+	// The output of the pre-start hook is part of the prelude of the process
+	hasLine := func(line string) bool {
+		log, _ := rs.GetProcessLog(process.ID)
+		for _, l := range log.Prelude {
+			if l == line {
+				return true
+			}
+		}
 
-	process = &app.Config{
+		for _, l := range log.Log {
+			if l.Data == line {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return hasLine("[prestart] starting process")
+	}, 5*time.Second, 100*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		state, _ := rs.GetProcessState(process.ID)
+		return state.State == "running"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	err = rs.StopProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return hasLine("[poststop] stopped process")
+	}, 5*time.Second, 100*time.Millisecond)
+
+	// A failing pre-start hook prevents the start
+	process.PreStart.Args = []string{"-c", "echo failing; exit 1"}
+
+	err = rs.UpdateProcess(process.ID, process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		state, _ := rs.GetProcessState(process.ID)
+		return state.Reason == ReasonPreStartFailed
+	}, 5*time.Second, 100*time.Millisecond)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.NotEqual(t, "running", state.State)
+	require.True(t, hasLine("[prestart] failing"))
+
+	// A hanging pre-start hook doesn't block the restreamer and will be killed
+	process.PreStart.Args = []string{"-c", "sleep 10"}
+	process.PreStart.Timeout = 1
+
+	err = rs.UpdateProcess(process.ID, process)
+	require.NoError(t, err)
+
+	start := time.Now()
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	_, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), time.Second)
+
+	require.Eventually(t, func() bool {
+		state, _ := rs.GetProcessState(process.ID)
+		return state.Reason == ReasonPreStartFailed && strings.Contains(state.Detail, "timed out")
+	}, 5*time.Second, 100*time.Millisecond)
+
+	// Stopping the process while the hook is running cancels the start
+	process.PreStart.Args = []string{"-c", "sleep 1"}
+
+	err = rs.UpdateProcess(process.ID, process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	err = rs.StopProcess(process.ID)
+	require.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "stop", state.Order)
+	require.Equal(t, "finished", state.State)
+
+	process.PreStart.Binary = ""
+
+	err = rs.UpdateProcess(process.ID, process)
+	require.Error(t, err)
+}
+
+func TestHooksRestart(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs.(*restream).hookBins = map[string]bool{"/bin/sh": true}
+
+	file := filepath.Join(t.TempDir(), "hooks")
+
+	process := getDummyProcess()
+	process.ReconnectDelay = 1
+	process.PreStart = &app.ConfigHook{
+		Binary: "/bin/sh",
+		Args:   []string{"-c", "echo prestart >> " + file},
+	}
+	process.PostStop = &app.ConfigHook{
+		Binary: "/bin/sh",
+		Args:   []string{"-c", "echo poststop >> " + file},
+	}
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		state, _ := rs.GetProcessState(process.ID)
+		return state.State == "running"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	// The process exits by itself and will be restarted
+	task := rs.(*restream).tasks[process.ID]
+	err = task.ffmpeg.Kill(true)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		data, _ := os.ReadFile(file)
+		return string(data) == "prestart\npoststop\nprestart\n"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		state, _ := rs.GetProcessState(process.ID)
+		return state.State == "running"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	err = rs.StopProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		data, _ := os.ReadFile(file)
+		return string(data) == "prestart\npoststop\nprestart\npoststop\n"
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestLogSince(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	_, _, err = rs.GetProcessLogSince("foobar", "")
+	require.Equal(t, ErrUnknownProcess, err)
+
+	_, _, err = rs.GetProcessLogSince(process.ID, "foobar")
+	require.Error(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	// The dummy ffmpeg writes only progress lines after the prelude
+	time.Sleep(2 * time.Second)
+
+	lines, cursor, err := rs.GetProcessLogSince(process.ID, "")
+	require.NoError(t, err)
+	require.NotEqual(t, 0, len(lines))
+
+	log, err := rs.GetProcessLog(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, len(log.Log), len(lines))
+
+	// Only the lines after the cursor will be returned
+	next, nextCursor, err := rs.GetProcessLogSince(process.ID, cursor)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(next))
+	require.Equal(t, cursor, nextCursor)
+
+	// All lines of a new run are new
+	rs.StopProcess(process.ID)
+	rs.StartProcess(process.ID)
+
+	require.Eventually(t, func() bool {
+		next, nextCursor, err = rs.GetProcessLogSince(process.ID, cursor)
+		return err == nil && len(next) != 0
+	}, 5*time.Second, 100*time.Millisecond)
+
+	require.NotEqual(t, cursor, nextCursor)
+	require.True(t, next[0].Timestamp.After(lines[len(lines)-1].Timestamp))
+
+	rs.StopProcess(process.ID)
+}
+
+func TestProcessErrors(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Environment = map[string]string{
+		"FFMPEG_TEST_LINE": "[error] Something went wrong",
+	}
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	_, err = rs.GetProcessErrors("foobar")
+	require.Equal(t, ErrUnknownProcess, err)
+
+	lines, err := rs.GetProcessErrors(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(lines))
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		lines, err = rs.GetProcessErrors(process.ID)
+		return err == nil && len(lines) != 0
+	}, 5*time.Second, 100*time.Millisecond)
+
+	require.Equal(t, 1, len(lines))
+	require.Equal(t, "error", lines[0].Level)
+	require.Equal(t, "Something went wrong", lines[0].Data)
+
+	rs.StopProcess(process.ID)
+}
+
+func TestPlayoutNoRange(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+
+	process.Input[0].Address = "playout:" + process.Input[0].Address
+
+	rs.AddProcess(process)
+
+	_, err = rs.GetPlayout("foobar", process.Input[0].ID)
+	require.NotEqual(t, nil, err, "playout of non-existing process should error")
+
+	_, err = rs.GetPlayout(process.ID, "foobar")
+	require.NotEqual(t, nil, err, "playout of non-existing input should error")
+
+	addr, _ := rs.GetPlayout(process.ID, process.Input[0].ID)
+	require.Equal(t, 0, len(addr), "the playout address should be empty if no port range is given")
+}
+
+func TestPlayoutRange(t *testing.T) {
+	portrange, err := net.NewPortrange(3000, 3001)
+	require.NoError(t, err)
+
+	rs, err := getDummyRestreamer(portrange, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+
+	process.Input[0].Address = "playout:" + process.Input[0].Address
+
+	rs.AddProcess(process)
+
+	_, err = rs.GetPlayout(process.ID, process.Input[0].ID)
+	require.ErrorIs(t, err, ErrPlayoutNotReady, "the ports are assigned when the process starts")
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	_, err = rs.GetPlayout("foobar", process.Input[0].ID)
+	require.NotEqual(t, nil, err, "playout of non-existing process should error")
+
+	_, err = rs.GetPlayout(process.ID, "foobar")
+	require.NotEqual(t, nil, err, "playout of non-existing input should error")
+
+	addr, _ := rs.GetPlayout(process.ID, process.Input[0].ID)
+	require.NotEqual(t, 0, len(addr), "the playout address should not be empty if a port range is given")
+	require.Equal(t, "127.0.0.1:3000", addr, "the playout address should be 127.0.0.1:3000")
+
+	info, err := rs.GetPlayoutInfo(process.ID, process.Input[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, app.PlayoutInfo{
+		Scheme:   "http",
+		Host:     "127.0.0.1",
+		Port:     3000,
+		Protocol: "lavfi",
+	}, info)
+
+	rs.StopProcess(process.ID)
+}
+
+func TestAddressReference(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process1 := getDummyProcess()
+	process2 := getDummyProcess()
+
+	process2.ID = "process2"
+
+	rs.AddProcess(process1)
+
+	process2.Input[0].Address = "#process:foobar=out"
+
+	err = rs.AddProcess(process2)
+	require.NotEqual(t, nil, err, "shouldn't resolve invalid reference")
+
+	process2.Input[0].Address = "#process2:output=out"
+
+	err = rs.AddProcess(process2)
+	require.NotEqual(t, nil, err, "shouldn't resolve invalid reference")
+
+	process2.Input[0].Address = "#process:output=foobar"
+
+	err = rs.AddProcess(process2)
+	require.NotEqual(t, nil, err, "shouldn't resolve invalid reference")
+
+	process2.Input[0].Address = "#process:output=out"
+
+	err = rs.AddProcess(process2)
+	require.Equal(t, nil, err, "should resolve reference")
+}
+
+func TestGetReferences(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process1 := getDummyProcess()
+	process2 := getDummyProcess()
+
+	process2.ID = "process2"
+	process2.Input[0].Address = "#process:output=out"
+
+	err = rs.AddProcess(process1)
+	require.NoError(t, err)
+
+	err = rs.AddProcess(process2)
+	require.NoError(t, err)
+
+	_, _, err = rs.GetReferences("foobar")
+	require.Equal(t, ErrUnknownProcess, err)
+
+	reference := app.Reference{
+		ProcessID: "process2",
+		InputID:   "in",
+		TargetID:  "process",
+		OutputID:  "out",
+		Exists:    true,
+	}
+
+	inbound, outbound, err := rs.GetReferences("process")
+	require.NoError(t, err)
+	require.Equal(t, []app.Reference{reference}, inbound)
+	require.Equal(t, []app.Reference{}, outbound)
+
+	inbound, outbound, err = rs.GetReferences("process2")
+	require.NoError(t, err)
+	require.Equal(t, []app.Reference{}, inbound)
+	require.Equal(t, []app.Reference{reference}, outbound)
+
+	err = rs.StartProcess("process")
+	require.NoError(t, err)
+
+	err = rs.DeleteUnreferencedProcess("process")
+	require.Equal(t, ErrProcessReferenced, err)
+
+	// A referenced process is left untouched
+	state, err := rs.GetProcessState("process")
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+
+	err = rs.DeleteProcess("process")
+	require.NoError(t, err)
+
+	// The reference is broken now
+	reference.Exists = false
+
+	_, outbound, err = rs.GetReferences("process2")
+	require.NoError(t, err)
+	require.Equal(t, []app.Reference{reference}, outbound)
+
+	err = rs.DeleteUnreferencedProcess("process2")
+	require.NoError(t, err)
+}
+
+func TestConfigValidation(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	config := getDummyProcess()
+
+	_, err = rs.validateConfig(config)
+	require.NoError(t, err)
+
+	config.Input = []app.ConfigIO{}
+	_, err = rs.validateConfig(config)
+	require.Error(t, err)
+
+	config = getDummyProcess()
+	config.Input[0].ID = ""
+	_, err = rs.validateConfig(config)
+	require.Error(t, err)
+
+	config = getDummyProcess()
+	config.Input[0].Address = ""
+	_, err = rs.validateConfig(config)
+	require.Error(t, err)
+
+	config = getDummyProcess()
+	config.Output = []app.ConfigIO{}
+	_, err = rs.validateConfig(config)
+	require.Error(t, err)
+
+	config = getDummyProcess()
+	config.Output[0].ID = ""
+	_, err = rs.validateConfig(config)
+	require.Error(t, err)
+
+	config = getDummyProcess()
+	config.Output[0].Address = ""
+	_, err = rs.validateConfig(config)
+	require.Error(t, err)
+}
+
+func TestConfigValidationFFmpeg(t *testing.T) {
+	valIn, err := ffmpeg.NewValidator([]string{"^https?://"}, nil)
+	require.NoError(t, err)
+
+	valOut, err := ffmpeg.NewValidator([]string{"^https?://", "^rtmp://"}, nil)
+	require.NoError(t, err)
+
+	rsi, err := getDummyRestreamer(nil, valIn, valOut, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	config := getDummyProcess()
+
+	_, err = rs.validateConfig(config)
+	require.Error(t, err)
+
+	config.Input[0].Address = "http://stream.example.com/master.m3u8"
+	config.Output[0].Address = "http://stream.example.com/master2.m3u8"
+
+	_, err = rs.validateConfig(config)
+	require.NoError(t, err)
+
+	config.Output[0].Address = "[f=flv]http://stream.example.com/master2.m3u8"
+	_, err = rs.validateConfig(config)
+	require.NoError(t, err)
+
+	config.Output[0].Address = "[f=hls]http://stream.example.com/master2.m3u8|[f=flv]rtmp://stream.example.com/stream"
+	_, err = rs.validateConfig(config)
+	require.NoError(t, err)
+}
+
+func TestOutputAddressValidation(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	type res struct {
+		path string
+		err  bool
+	}
+
+	paths := map[string]res{
+		"/dev/null":                            {"file:/dev/null", false},
+		"/dev/../etc/passwd":                   {"/etc/passwd", true},
+		"/dev/fb0":                             {"file:/dev/fb0", false},
+		"/etc/passwd":                          {"/etc/passwd", true},
+		"/core/data/../../etc/passwd":          {"/etc/passwd", true},
+		"/core/data/./etc/passwd":              {"file:/core/data/etc/passwd", false},
+		"file:/core/data/foobar":               {"file:/core/data/foobar", false},
+		"http://example.com":                   {"http://example.com", false},
+		"-":                                    {"pipe:", false},
+		"/core/data/foobar|http://example.com": {"file:/core/data/foobar|http://example.com", false},
+		"/core/data/foobar|/etc/passwd":        {"/core/data/foobar|/etc/passwd", true},
+		"[f=mpegts]udp://10.0.1.255:1234/":     {"[f=mpegts]udp://10.0.1.255:1234/", false},
+		"[f=null]-|[f=null]-":                  {"[f=null]pipe:|[f=null]pipe:", false},
+		"[onfail=ignore]/core/data/archive-20121107.mkv|[f=mpegts]udp://10.0.1.255:1234/":      {"[onfail=ignore]file:/core/data/archive-20121107.mkv|[f=mpegts]udp://10.0.1.255:1234/", false},
+		"[onfail=abort]/core/data/foobar|[f=flv:onfail=restart_branch]rtmp://example.com/live": {"[onfail=abort]file:/core/data/foobar|[f=flv:onfail=restart_branch]rtmp://example.com/live", false},
+		"[onfail=foobar]/core/data/foobar|[f=null]-":                                           {"[onfail=foobar]/core/data/foobar|[f=null]-", true},
+	}
+
+	for path, r := range paths {
+		path, _, err := rs.validateOutputAddress(path, "/core/data", "")
+
+		if r.err {
+			require.Error(t, err)
+		} else {
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, r.path, path)
+	}
+}
+
+func TestOutputAddressSchemes(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+	rs.schemes = map[string]bool{"rtmp": true, "srt": true, "http": true, "https": true}
+
+	type res struct {
+		path string
+		err  string
+	}
+
+	paths := map[string]res{
+		"rtmp://example.com/live":      {"rtmp://example.com/live", ""},
+		"RTMP://example.com/live":      {"RTMP://example.com/live", ""},
+		"gopher://example.com":         {"gopher://example.com", "the scheme 'gopher' is not allowed for outputs"},
+		"/core/data/foobar":            {"file:/core/data/foobar", ""},
+		"file:///etc/passwd":           {"/etc/passwd", "/etc/passwd is not inside of /core/data"},
+		"-":                            {"pipe:", ""},
+		"/core/data/foobar|srt://host": {"file:/core/data/foobar|srt://host", ""},
+		"[f=flv]rtmp://example.com/live|[f=mpegts]udp://10.0.1.255:1234/": {"[f=flv]rtmp://example.com/live|[f=mpegts]udp://10.0.1.255:1234/", "tee branch 1: the scheme 'udp' is not allowed for outputs"},
+		"[f=null]-|[f=flv]gopher://example.com|https://example.com":       {"[f=null]-|[f=flv]gopher://example.com|https://example.com", "tee branch 1: the scheme 'gopher' is not allowed for outputs"},
+		"[f=mpegts]srt://example.com|[f=flv]http://example.com":           {"[f=mpegts]srt://example.com|[f=flv]http://example.com", ""},
+	}
+
+	for path, r := range paths {
+		path, _, err := rs.validateOutputAddress(path, "/core/data", "")
+
+		if len(r.err) != 0 {
+			require.EqualError(t, err, r.err, path)
+		} else {
+			require.NoError(t, err, path)
+		}
+
+		require.Equal(t, r.path, path)
+	}
+
+	process := getDummyProcess()
+	process.Output[0].Address = "[f=flv]rtmp://example.com/live|[f=flv]gopher://example.com"
+
+	err = rs.AddProcess(process)
+	require.ErrorContains(t, err, "tee branch 1: the scheme 'gopher' is not allowed for outputs")
+}
+
+func TestOutputAddressWorkingDir(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	type res struct {
+		path string
+		err  bool
+	}
+
+	paths := map[string]res{
+		"foobar.mp4":                       {"file:/core/data/recordings/foobar.mp4", false},
+		"./foobar.mp4":                     {"file:/core/data/recordings/foobar.mp4", false},
+		"../foobar.mp4":                    {"file:/core/data/foobar.mp4", false},
+		"../../../etc/passwd":              {"/etc/passwd", true},
+		"/core/data/foobar.mp4":            {"file:/core/data/foobar.mp4", false},
+		"foobar.mp4|http://example.com":    {"file:/core/data/recordings/foobar.mp4|http://example.com", false},
+		"[f=mp4]foobar.mp4|[f=null]-":      {"[f=mp4]file:/core/data/recordings/foobar.mp4|[f=null]pipe:", false},
+		"[f=mp4]../../foobar.mp4|[f=null]": {"[f=mp4]../../foobar.mp4|[f=null]", true},
+	}
+
+	for path, r := range paths {
+		path, _, err := rs.validateOutputAddress(path, "/core/data", "/core/data/recordings")
+
+		if r.err {
+			require.Error(t, err, path)
+		} else {
+			require.NoError(t, err, path)
+		}
+
+		require.Equal(t, r.path, path)
+	}
+
+	process := getDummyProcess()
+	process.WorkingDir = "relative/path"
+
+	_, err = rs.validateConfig(process)
+	require.Error(t, err, "relative working directories are not allowed")
+
+	process.WorkingDir = t.TempDir()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		state, _ := rs.GetProcessState(process.ID)
+		return state.State == "running"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	rs.StopProcess(process.ID)
+}
+
+func TestMetadata(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+
+	data, _ := rs.GetMetadata("foobar")
+	require.Equal(t, nil, data, "nothing should be stored under the key")
+
+	rs.SetMetadata("foobar", process)
+
+	data, _ = rs.GetMetadata("foobar")
+	require.NotEqual(t, nil, data, "there should be something stored under the key")
+
+	p := data.(*app.Config)
+
+	require.Equal(t, process.ID, p.ID, "failed to retrieve stored data")
+}
+
+func TestReplacer(t *testing.T) {
+	replacer := replace.New()
+
+	replacer.RegisterTemplateFunc("diskfs", func(config *app.Config, section string) string {
+		return "/mnt/diskfs"
+	}, nil)
+
+	replacer.RegisterTemplateFunc("fs:disk", func(config *app.Config, section string) string {
+		return "/mnt/diskfs"
+	}, nil)
+
+	replacer.RegisterTemplateFunc("memfs", func(config *app.Config, section string) string {
+		return "http://localhost/mnt/memfs"
+	}, nil)
+
+	replacer.RegisterTemplateFunc("fs:mem", func(config *app.Config, section string) string {
+		return "http://localhost/mnt/memfs"
+	}, nil)
+
+	replacer.RegisterTemplateFunc("rtmp", func(config *app.Config, section string) string {
+		return "rtmp://localhost/app/{name}?token=foobar"
+	}, nil)
+
+	replacer.RegisterTemplateFunc("srt", func(config *app.Config, section string) string {
+		template := "srt://localhost:6000?mode=caller&transtype=live&latency={latency}&streamid={name}"
+		if section == "output" {
+			template += ",mode:publish"
+		} else {
+			template += ",mode:request"
+		}
+		template += ",token:abcfoobar&passphrase=secret"
+
+		return template
+	}, map[string]string{
+		"latency": "20000", // 20 milliseconds, FFmpeg requires microseconds
+	})
+
+	rsi, err := getDummyRestreamer(nil, nil, nil, replacer)
+	require.NoError(t, err)
+
+	process := &app.Config{
+		ID:        "314159265359",
+		Reference: "refref",
+		Input: []app.ConfigIO{
+			{
+				ID:      "in_{processid}_{reference}",
+				Address: "input:{inputid}_process:{processid}_reference:{reference}_diskfs:{diskfs}/disk.txt_memfs:{memfs}/mem.txt_fsdisk:{fs:disk}/fsdisk.txt_fsmem:{fs:mem}/fsmem.txt_rtmp:{rtmp,name=pmtr}_srt:{srt,name=trs}_rtmp:{rtmp,name=$inputid}",
+				Options: []string{
+					"-f",
+					"lavfi",
+					"-re",
+					"input:{inputid}",
+					"process:{processid}",
+					"reference:{reference}",
+					"diskfs:{diskfs}/disk.txt",
+					"memfs:{memfs}/mem.txt",
+					"fsdisk:{fs:disk}/fsdisk.txt",
+					"fsmem:{fs:mem}/$inputid.txt",
+				},
+			},
+		},
+		Output: []app.ConfigIO{
+			{
+				ID:      "out_{processid}_{reference}",
+				Address: "output:{outputid}_process:{processid}_reference:{reference}_diskfs:{diskfs}/disk.txt_memfs:{memfs}/mem.txt_fsdisk:{fs:disk}/fsdisk.txt_fsmem:{fs:mem}/fsmem.txt_rtmp:{rtmp,name=$processid}_srt:{srt,name=$reference,latency=42}_rtmp:{rtmp,name=$outputid}",
+				Options: []string{
+					"-codec",
+					"copy",
+					"-f",
+					"null",
+					"output:{outputid}",
+					"process:{processid}",
+					"reference:{reference}",
+					"diskfs:{diskfs}/disk.txt",
+					"memfs:{memfs}/mem.txt",
+					"fsdisk:{fs:disk}/fsdisk.txt",
+					"fsmem:{fs:mem}/$outputid.txt",
+				},
+				Cleanup: []app.ConfigIOCleanup{
+					{
+						Pattern:       "pattern_{outputid}_{processid}_{reference}_{rtmp,name=$outputid}",
+						MaxFiles:      0,
+						MaxFileAge:    0,
+						PurgeOnDelete: false,
+					},
+				},
+			},
+		},
+		Options: []string{
+			"-loglevel",
+			"info",
+			"{diskfs}/foobar_on_disk.txt",
+			"{memfs}/foobar_in_mem.txt",
+			"{fs:disk}/foobar_on_disk_aswell.txt",
+			"{fs:mem}/foobar_in_mem_aswell.txt",
+		},
+		Reconnect:      true,
+		ReconnectDelay: 10,
+		Autostart:      false,
+		StaleTimeout:   0,
+	}
+
+	err = rsi.AddProcess(process)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process = &app.Config{
 		ID:        "314159265359",
 		Reference: "refref",
-		FFVersion: "^4.0.2",
+		FFVersion: "^4.0.0",
 		Input: []app.ConfigIO{
 			{
 				ID:      "in_314159265359_refref",
@@ -800,46 +2009,3257 @@ func TestReplacer(t *testing.T) {
 				Cleanup: []app.ConfigIOCleanup{},
 			},
 		},
-		Output: []app.ConfigIO{
-			{
-				ID:      "out_314159265359_refref",
-				Address: "output:out_314159265359_refref_process:314159265359_reference:refref_diskfs:/mnt/diskfs/disk.txt_memfs:http://localhost/mnt/memfs/mem.txt_fsdisk:/mnt/diskfs/fsdisk.txt_fsmem:http://localhost/mnt/memfs/fsmem.txt_rtmp:rtmp://localhost/app/314159265359?token=foobar_srt:srt://localhost:6000?mode=caller&transtype=live&latency=42&streamid=refref,mode:publish,token:abcfoobar&passphrase=secret_rtmp:rtmp://localhost/app/out_314159265359_refref?token=foobar",
-				Options: []string{
-					"-codec",
-					"copy",
-					"-f",
-					"null",
-					"output:out_314159265359_refref",
-					"process:314159265359",
-					"reference:refref",
-					"diskfs:/mnt/diskfs/disk.txt",
-					"memfs:http://localhost/mnt/memfs/mem.txt",
-					"fsdisk:/mnt/diskfs/fsdisk.txt",
-					"fsmem:http://localhost/mnt/memfs/$outputid.txt",
-				},
-				Cleanup: []app.ConfigIOCleanup{
-					{
-						Pattern:       "pattern_out_314159265359_refref_314159265359_refref_{rtmp,name=$outputid}",
-						MaxFiles:      0,
-						MaxFileAge:    0,
-						PurgeOnDelete: false,
-					},
-				},
-			},
+		Output: []app.ConfigIO{
+			{
+				ID:      "out_314159265359_refref",
+				Address: "output:out_314159265359_refref_process:314159265359_reference:refref_diskfs:/mnt/diskfs/disk.txt_memfs:http://localhost/mnt/memfs/mem.txt_fsdisk:/mnt/diskfs/fsdisk.txt_fsmem:http://localhost/mnt/memfs/fsmem.txt_rtmp:rtmp://localhost/app/314159265359?token=foobar_srt:srt://localhost:6000?mode=caller&transtype=live&latency=42&streamid=refref,mode:publish,token:abcfoobar&passphrase=secret_rtmp:rtmp://localhost/app/out_314159265359_refref?token=foobar",
+				Options: []string{
+					"-codec",
+					"copy",
+					"-f",
+					"null",
+					"output:out_314159265359_refref",
+					"process:314159265359",
+					"reference:refref",
+					"diskfs:/mnt/diskfs/disk.txt",
+					"memfs:http://localhost/mnt/memfs/mem.txt",
+					"fsdisk:/mnt/diskfs/fsdisk.txt",
+					"fsmem:http://localhost/mnt/memfs/$outputid.txt",
+				},
+				Cleanup: []app.ConfigIOCleanup{
+					{
+						Pattern:       "pattern_out_314159265359_refref_314159265359_refref_{rtmp,name=$outputid}",
+						MaxFiles:      0,
+						MaxFileAge:    0,
+						PurgeOnDelete: false,
+					},
+				},
+			},
+		},
+		Options: []string{
+			"-loglevel",
+			"info",
+			"/mnt/diskfs/foobar_on_disk.txt",
+			"{memfs}/foobar_in_mem.txt",
+			"/mnt/diskfs/foobar_on_disk_aswell.txt",
+			"http://localhost/mnt/memfs/foobar_in_mem_aswell.txt",
+		},
+		Reconnect:      true,
+		ReconnectDelay: 10,
+		Autostart:      false,
+		StaleTimeout:   0,
+	}
+
+	require.Equal(t, process, rs.tasks["314159265359"].config)
+}
+
+func TestReplacerEnvironment(t *testing.T) {
+	replacer := replace.New()
+	replacer.SetEnvironment(map[string]string{
+		"LOGLEVEL": "info",
+		"FORMAT":   "null",
+	}, true)
+
+	rs, err := getDummyRestreamer(nil, nil, nil, replacer)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Options = []string{"-loglevel", "${LOGLEVEL}"}
+	process.Output[0].Options = []string{"-codec", "copy", "-f", "${FORMAT}"}
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"-loglevel", "info", "-f", "lavfi", "-re", "-i", "testsrc=size=1280x720:rate=25", "-codec", "copy", "-f", "null", "-"}, state.Command)
+
+	// The stored config keeps the variables
+	p, err := rs.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"-loglevel", "${LOGLEVEL}"}, p.Config.Options)
+
+	process = getDummyProcess()
+	process.ID = "process2"
+	process.Output[0].Address = "${OUTPUT}"
+
+	err = rs.AddProcess(process)
+	require.Error(t, err, "unknown variables are not allowed in strict mode")
+}
+
+func TestTeeBranches(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.Output[0].Address = "[f=null:onfail=ignore]-|[f=null:onfail=abort]-|[f=null]-"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	task := rs.tasks[process.ID]
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, []app.TeeBranchState{
+		{OutputID: "out", Index: 0, Address: "-", OnFail: "ignore", State: "ok"},
+		{OutputID: "out", Index: 1, Address: "-", OnFail: "abort", State: "ok"},
+		{OutputID: "out", Index: 2, Address: "-", OnFail: "ignore", State: "ok"},
+	}, state.Tee)
+
+	task.parser.ResetLog()
+	task.parser.Parse("[tee @ 0x55d5c1f8a0c0] Slave muxer #0 failed: Connection refused, continuing with 2/3 slaves.")
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "failed", state.Tee[0].State)
+	require.Equal(t, "Connection refused", state.Tee[0].Error)
+	require.Equal(t, uint64(1), state.Tee[0].Failures)
+	require.Equal(t, "ok", state.Tee[1].State)
+
+	// With the level flag for -loglevel
+	task.parser.Parse("[tee @ 0x55d5c1f8a0c0] [error] Slave muxer #1 failed, aborting.")
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "failed", state.Tee[1].State)
+
+	task.parser.ResetLog()
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "ok", state.Tee[0].State)
+	require.Equal(t, uint64(1), state.Tee[0].Failures)
+}
+
+func TestTeeRestartBranch(t *testing.T) {
+	rsi, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs := rsi.(*restream)
+
+	process := getDummyProcess()
+	process.ReconnectDelay = 1
+	process.Output[0].Address = "[f=null]-|[f=null:select=v:onfail=restart_branch]-"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	task := rs.tasks[process.ID]
+
+	// The tee muxer sends the branch to the relay
+	branches := strings.Split(task.config.Output[0].Address, "|")
+	require.Equal(t, "[f=null]-", branches[0])
+	require.Regexp(t, `^\[select=v:f=mpegts:onfail=ignore\]udp://127\.0\.0\.1:[0-9]+\?pkt_size=1316$`, branches[1])
+
+	require.Len(t, task.tee.relays, 1)
+	relay := task.tee.relays[0].process
+
+	args := relay.Args()
+	require.Equal(t, []string{"-f", "null", "-"}, args[len(args)-3:])
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "restart_branch", state.Tee[1].OnFail)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return relay.IsRunning()
+	}, 5*time.Second, 100*time.Millisecond)
+
+	// A failed relay is restarted on its own, the process keeps on running
+	err = relay.Kill(true)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		state, _ := rs.GetProcessState(process.ID)
+		return state.Tee[1].State == "failed" && state.Tee[1].Failures == 1
+	}, 5*time.Second, 100*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		state, _ := rs.GetProcessState(process.ID)
+		return state.Tee[1].State == "ok" && relay.IsRunning()
+	}, 5*time.Second, 100*time.Millisecond)
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "running", state.State)
+	require.Equal(t, 0, state.Restarts)
+
+	// The relay stops with the process
+	err = rs.StopProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return !relay.IsRunning() && relay.Status().Order == "stop"
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestFFmpegVersionConstraint(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.FFVersion = "^6.0"
+
+	err = rs.AddProcess(process)
+	require.Error(t, err, "no ffmpeg binary should satisfy the constraint")
+
+	process.FFVersion = "^4.0"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "4.0.2", state.FFmpeg.Version)
+	require.NotEmpty(t, state.FFmpeg.Binary)
+}
+
+func TestStoreWatch(t *testing.T) {
+	binary, err := testhelper.BuildBinary("ffmpeg", "../internal/testhelper")
+	require.NoError(t, err, "Failed to build helper program")
+
+	ffmpeg, err := ffmpeg.New(ffmpeg.Config{
+		Binary: binary,
+	})
+	require.NoError(t, err)
+
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	localStore, err := store.NewJSON(store.JSONConfig{
+		Filesystem: memfs,
+	})
+	require.NoError(t, err)
+
+	rs, err := New(Config{
+		FFmpeg:             ffmpeg,
+		Store:              localStore,
+		StoreWatchInterval: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	rs.Start()
+	defer rs.Stop()
+
+	process := getDummyProcess()
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	// Our own writes must not trigger a reload
+	changed, err := localStore.(store.Watcher).Changed()
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	// Another writer adds a process and changes the existing one
+	remoteStore, err := store.NewJSON(store.JSONConfig{
+		Filesystem: memfs,
+	})
+	require.NoError(t, err)
+
+	data, err := remoteStore.Load()
+	require.NoError(t, err)
+
+	added := getDummyProcess()
+	added.ID = "added"
+	data.Process["added"] = &app.Process{
+		ID:     "added",
+		Config: added,
+		Order:  "stop",
+	}
+
+	data.Process["process"].Config.Reference = "changed"
+	data.Metadata.System["foo"] = "bar"
+
+	err = remoteStore.Store(data)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(rs.GetProcessIDs("", "")) == 2
+	}, 2*time.Second, 50*time.Millisecond)
+
+	p, err := rs.GetProcess("process")
+	require.NoError(t, err)
+	require.Equal(t, "changed", p.Config.Reference)
+
+	metadata, err := rs.GetMetadata("foo")
+	require.NoError(t, err)
+	require.Equal(t, "bar", metadata)
+
+	// Another writer removes a process
+	delete(data.Process, "process")
+
+	err = remoteStore.Store(data)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(rs.GetProcessIDs("", "")) == 1
+	}, 2*time.Second, 50*time.Millisecond)
+
+	_, err = rs.GetProcess("added")
+	require.NoError(t, err)
+}
+
+func TestStoreWatchReferences(t *testing.T) {
+	binary, err := testhelper.BuildBinary("ffmpeg", "../internal/testhelper")
+	require.NoError(t, err, "Failed to build helper program")
+
+	ffmpeg, err := ffmpeg.New(ffmpeg.Config{
+		Binary: binary,
+	})
+	require.NoError(t, err)
+
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	localStore, err := store.NewJSON(store.JSONConfig{
+		Filesystem: memfs,
+	})
+	require.NoError(t, err)
+
+	rs, err := New(Config{
+		FFmpeg:             ffmpeg,
+		Store:              localStore,
+		StoreWatchInterval: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	rs.Start()
+	defer rs.Stop()
+
+	remoteStore, err := store.NewJSON(store.JSONConfig{
+		Filesystem: memfs,
+	})
+	require.NoError(t, err)
+
+	data, err := remoteStore.Load()
+	require.NoError(t, err)
+
+	// Each process references the next one, such that a process
+	// comes before the process it references in the order of the IDs.
+	for i := 0; i < 20; i++ {
+		process := getDummyProcess()
+		process.ID = fmt.Sprintf("process%02d", i)
+
+		if i != 19 {
+			process.Input[0].Address = fmt.Sprintf("#process%02d:output=out", i+1)
+		}
+
+		data.Process[process.ID] = &app.Process{
+			ID:     process.ID,
+			Config: process,
+			Order:  "stop",
+		}
+	}
+
+	// A process with an unknown reference is kept
+	invalid := getDummyProcess()
+	invalid.ID = "invalid"
+	invalid.Input[0].Address = "#unknown:output=out"
+
+	data.Process[invalid.ID] = &app.Process{
+		ID:     invalid.ID,
+		Config: invalid,
+		Order:  "stop",
+	}
+
+	err = remoteStore.Store(data)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(rs.GetProcessIDs("", "")) == 21
+	}, 2*time.Second, 50*time.Millisecond)
+
+	for i := 0; i < 20; i++ {
+		_, err := rs.GetProcessState(fmt.Sprintf("process%02d", i))
+		require.NoError(t, err)
+	}
+
+	_, err = rs.GetProcessCommand("invalid")
+	require.Error(t, err)
+
+	// The next write must not remove the invalid process from the store
+	err = rs.AddProcess(getDummyProcess())
+	require.NoError(t, err)
+
+	data, err = remoteStore.Load()
+	require.NoError(t, err)
+	require.Len(t, data.Process, 22)
+	require.Contains(t, data.Process, "invalid")
+}
+
+func TestPlayoutPortRelease(t *testing.T) {
+	portrange, err := net.NewPortrangeFromList([]int{3000})
+	require.NoError(t, err)
+
+	rs, err := getDummyRestreamer(portrange, nil, nil, nil)
+	require.NoError(t, err)
+
+	process1 := getDummyProcess()
+	process1.Input[0].Address = "playout:" + process1.Input[0].Address
+
+	process2 := getDummyProcess()
+	process2.ID = "process2"
+	process2.Input[0].Address = "playout:" + process2.Input[0].Address
+
+	err = rs.AddProcess(process1)
+	require.NoError(t, err)
+
+	err = rs.AddProcess(process2)
+	require.NoError(t, err, "a stopped process doesn't hold a playout port")
+
+	err = rs.StartProcess(process1.ID)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process2.ID)
+	require.Error(t, err, "the only port is in use")
+
+	err = rs.StopProcess(process1.ID)
+	require.NoError(t, err)
+
+	_, err = rs.GetPlayoutInfo(process1.ID, process1.Input[0].ID)
+	require.Error(t, err, "a stopped process shouldn't hold a playout port")
+
+	err = rs.StartProcess(process2.ID)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process1.ID)
+	require.Error(t, err, "the only port is in use")
+
+	err = rs.DeleteProcess(process2.ID)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process1.ID)
+	require.NoError(t, err)
+
+	info, err := rs.GetPlayoutInfo(process1.ID, process1.Input[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, 3000, info.Port)
+
+	err = rs.StopProcess(process1.ID)
+	require.NoError(t, err)
+
+	// A process that exited for good gives its port back
+	process3 := getDummyProcess()
+	process3.ID = "process3"
+	process3.Input[0].Address = "playout:" + process3.Input[0].Address
+	process3.Output[0].Address = "-exit"
+	process3.Reconnect = false
+
+	err = rs.AddProcess(process3)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process3.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(rs.ListPlayoutPorts()) == 0
+	}, 5*time.Second, 100*time.Millisecond)
+
+	state, err := rs.GetProcessState(process3.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+
+	err = rs.StartProcess(process1.ID)
+	require.NoError(t, err)
+
+	err = rs.StopProcess(process1.ID)
+	require.NoError(t, err)
+}
+
+func TestListPlayoutPorts(t *testing.T) {
+	portrange, err := net.NewPortrange(3000, 3001)
+	require.NoError(t, err)
+
+	rs, err := getDummyRestreamer(portrange, nil, nil, nil)
+	require.NoError(t, err)
+
+	require.Empty(t, rs.ListPlayoutPorts())
+
+	process := getDummyProcess()
+	process.Input[0].Address = "playout:" + process.Input[0].Address
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	require.Empty(t, rs.ListPlayoutPorts())
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	ports := rs.ListPlayoutPorts()
+	require.Equal(t, 1, len(ports))
+	require.Equal(t, 3000, ports[0].Port)
+	require.Equal(t, process.ID, ports[0].ProcessID)
+	require.Equal(t, process.Input[0].ID, ports[0].InputID)
+	require.NotEqual(t, int64(0), ports[0].AssignedAt)
+
+	err = rs.DeleteProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Empty(t, rs.ListPlayoutPorts())
+}
+
+func TestPlayoutPortChurn(t *testing.T) {
+	portrange, err := net.NewPortrange(3000, 3001)
+	require.NoError(t, err)
+
+	rs, err := getDummyRestreamer(portrange, nil, nil, nil)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		process := getDummyProcess()
+		process.Input[0].Address = "playout:" + process.Input[0].Address
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err, "iteration %d", i)
+
+		err = rs.AddProcess(process)
+		require.Equal(t, ErrProcessExists, err)
+
+		err = rs.StartProcess(process.ID)
+		require.NoError(t, err)
+
+		err = rs.StopProcess(process.ID)
+		require.NoError(t, err)
+
+		err = rs.DeleteProcess(process.ID)
+		require.NoError(t, err)
+	}
+}
+
+func TestPlayoutHost(t *testing.T) {
+	binary, err := testhelper.BuildBinary("ffmpeg", "../internal/testhelper")
+	require.NoError(t, err, "Failed to build helper program")
+
+	portrange, err := net.NewPortrange(3000, 3001)
+	require.NoError(t, err)
+
+	ffmpeg, err := ffmpeg.New(ffmpeg.Config{
+		Binary:    binary,
+		Portrange: portrange,
+	})
+	require.NoError(t, err)
+
+	for _, host := range []string{"0.0.0.0", "::", "224.0.0.1", "foo:bar"} {
+		_, err = New(Config{
+			FFmpeg:               ffmpeg,
+			PlayoutAdvertiseHost: host,
+		})
+		require.Error(t, err, host)
+	}
+
+	rs, err := New(Config{
+		FFmpeg:               ffmpeg,
+		PlayoutBindHost:      "0.0.0.0",
+		PlayoutAdvertiseHost: "fd00::1",
+	})
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Input[0].Address = "playout:" + process.Input[0].Address
+	process.Input[0].Options = append(process.Input[0].Options, "-playout_httphost", "127.0.0.1")
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.NotContains(t, strings.Join(state.Command, " "), "-playout_httphost")
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	info, err := rs.GetPlayoutInfo(process.ID, process.Input[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, "[fd00::1]:3000", info.Address())
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Contains(t, strings.Join(state.Command, " "), "-playout_httpport 3000 -playout_httphost 0.0.0.0")
+	require.NotContains(t, strings.Join(state.Command, " "), "127.0.0.1")
+
+	rs.StopProcess(process.ID)
+}
+
+func TestPlayoutSocket(t *testing.T) {
+	binary, err := testhelper.BuildBinary("ffmpeg", "../internal/testhelper")
+	require.NoError(t, err, "Failed to build helper program")
+
+	ffmpeg, err := ffmpeg.New(ffmpeg.Config{
+		Binary: binary,
+	})
+	require.NoError(t, err)
+
+	dir := filepath.Join(t.TempDir(), "sockets")
+
+	rs, err := New(Config{
+		FFmpeg:           ffmpeg,
+		PlayoutSocketDir: dir,
+	})
+	require.NoError(t, err)
+	require.DirExists(t, dir)
+
+	process := getDummyProcess()
+	process.Input[0].Address = "playout:" + process.Input[0].Address
+	process.Input[0].Options = append(process.Input[0].Options, "-playout_httpport", "3000")
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	_, err = rs.GetPlayoutInfo(process.ID, process.Input[0].ID)
+	require.ErrorIs(t, err, ErrPlayoutNotReady)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	info, err := rs.GetPlayoutInfo(process.ID, process.Input[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, dir, filepath.Dir(info.Socket))
+	require.Equal(t, 0, info.Port)
+
+	addr, err := rs.GetPlayout(process.ID, process.Input[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, "unix:"+info.Socket, addr)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.NotContains(t, strings.Join(state.Command, " "), "-playout_httpport")
+
+	process2 := getDummyProcess()
+	process2.ID = "process2"
+	process2.Input[0].Address = "playout:" + process2.Input[0].Address
+
+	err = rs.AddProcess(process2)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process2.ID)
+	require.NoError(t, err)
+
+	info2, err := rs.GetPlayoutInfo(process2.ID, process2.Input[0].ID)
+	require.NoError(t, err)
+	require.NotEqual(t, info.Socket, info2.Socket)
+
+	err = rs.StopProcess(process2.ID)
+	require.NoError(t, err)
+
+	// A sidecar listening on the socket
+	listener, err := gonet.Listen("unix", info.Socket)
+	require.NoError(t, err)
+	listener.(*gonet.UnixListener).SetUnlinkOnClose(false)
+	defer listener.Close()
+
+	err = rs.StopProcess(process.ID)
+	require.NoError(t, err)
+
+	_, err = rs.GetPlayoutInfo(process.ID, process.Input[0].ID)
+	require.ErrorIs(t, err, ErrPlayoutNotReady)
+	require.NoFileExists(t, info.Socket, "the socket of a stopped process is removed")
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	info3, err := rs.GetPlayoutInfo(process.ID, process.Input[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, info.Socket, info3.Socket)
+
+	err = rs.StopProcess(process.ID)
+	require.NoError(t, err)
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	binary, err := testhelper.BuildBinary("ffmpeg", "../internal/testhelper")
+	require.NoError(t, err, "Failed to build helper program")
+
+	ffmpeg, err := ffmpeg.New(ffmpeg.Config{
+		Binary: binary,
+	})
+	require.NoError(t, err)
+
+	rs, err := New(Config{
+		FFmpeg:        ffmpeg,
+		MaxConcurrent: 2,
+	})
+	require.NoError(t, err)
+
+	for _, id := range []string{"process1", "process2", "process3", "process4"} {
+		process := getDummyProcess()
+		process.ID = id
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+	}
+
+	for _, id := range []string{"process4", "process3", "process2", "process1"} {
+		err = rs.StartProcess(id)
+		require.NoError(t, err)
+	}
+
+	checkState := func(id, state string, position int) {
+		s, err := rs.GetProcessState(id)
+		require.NoError(t, err)
+		require.Equal(t, "start", s.Order, id)
+		require.Equal(t, state, s.State, id)
+		require.Equal(t, position, s.QueuePosition, id)
+	}
+
+	checkState("process4", "running", 0)
+	checkState("process3", "running", 0)
+	checkState("process1", "queued", 1)
+	checkState("process2", "queued", 2)
+
+	require.ElementsMatch(t, []string{"process1", "process2"}, rs.GetProcessIDsByState("", "queued", "", ""))
+
+	err = rs.StopProcess("process4")
+	require.NoError(t, err)
+
+	checkState("process1", "running", 0)
+	checkState("process2", "queued", 1)
+
+	// Stopping a queued process removes it from the queue
+	err = rs.StopProcess("process2")
+	require.NoError(t, err)
+
+	s, err := rs.GetProcessState("process2")
+	require.NoError(t, err)
+	require.Equal(t, "stop", s.Order)
+	require.Equal(t, "finished", s.State)
+	require.Equal(t, 0, s.QueuePosition)
+
+	rs.StopProcess("process1")
+	rs.StopProcess("process3")
+}
+
+func TestMaxConcurrentExit(t *testing.T) {
+	binary, err := testhelper.BuildBinary("ffmpeg", "../internal/testhelper")
+	require.NoError(t, err, "Failed to build helper program")
+
+	ffmpeg, err := ffmpeg.New(ffmpeg.Config{
+		Binary: binary,
+	})
+	require.NoError(t, err)
+
+	rs, err := New(Config{
+		FFmpeg:        ffmpeg,
+		MaxConcurrent: 1,
+	})
+	require.NoError(t, err)
+
+	// The dummy ffmpeg exits immediately if the last argument is an option
+	process := getDummyProcess()
+	process.ID = "process1"
+	process.Output[0].Address = "-exit"
+	process.Reconnect = false
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	process = getDummyProcess()
+	process.ID = "process2"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess("process1")
+	require.NoError(t, err)
+
+	err = rs.StartProcess("process2")
+	require.NoError(t, err)
+
+	// The slot of a process that exited for good is given to the next queued process
+	require.Eventually(t, func() bool {
+		s, _ := rs.GetProcessState("process2")
+		return s.State == "running"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	s, err := rs.GetProcessState("process1")
+	require.NoError(t, err)
+	require.Equal(t, "start", s.Order)
+	require.NotEqual(t, "running", s.State)
+
+	rs.StopProcess("process2")
+}
+
+func TestMaxConcurrentStart(t *testing.T) {
+	binary, err := testhelper.BuildBinary("ffmpeg", "../internal/testhelper")
+	require.NoError(t, err, "Failed to build helper program")
+
+	ffmpeg, err := ffmpeg.New(ffmpeg.Config{
+		Binary: binary,
+	})
+	require.NoError(t, err)
+
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	jsonstore, err := store.NewJSON(store.JSONConfig{
+		Filesystem: memfs,
+	})
+	require.NoError(t, err)
+
+	data := store.NewStoreData()
+
+	for i, id := range []string{"process3", "process2", "process1"} {
+		config := getDummyProcess()
+		config.ID = id
+
+		data.Process[id] = &app.Process{
+			ID:        id,
+			Config:    config,
+			CreatedAt: int64(i + 1),
+			Order:     "start",
+		}
+	}
+
+	err = jsonstore.Store(data)
+	require.NoError(t, err)
+
+	rs, err := New(Config{
+		FFmpeg:        ffmpeg,
+		Store:         jsonstore,
+		MaxConcurrent: 2,
+	})
+	require.NoError(t, err)
+
+	rs.Start()
+	defer rs.Stop()
+
+	// The oldest processes are started first
+	require.ElementsMatch(t, []string{"process3", "process2"}, rs.GetProcessIDsByState("start", "running", "", ""))
+	require.Equal(t, []string{"process1"}, rs.GetProcessIDsByState("start", "queued", "", ""))
+}
+
+func TestMaxConcurrentPriority(t *testing.T) {
+	binary, err := testhelper.BuildBinary("ffmpeg", "../internal/testhelper")
+	require.NoError(t, err, "Failed to build helper program")
+
+	ffmpeg, err := ffmpeg.New(ffmpeg.Config{
+		Binary: binary,
+	})
+	require.NoError(t, err)
+
+	for _, preempt := range []bool{false, true} {
+		rs, err := New(Config{
+			FFmpeg:        ffmpeg,
+			MaxConcurrent: 1,
+			Preempt:       preempt,
+		})
+		require.NoError(t, err)
+
+		for i, id := range []string{"low", "mid", "high"} {
+			process := getDummyProcess()
+			process.ID = id
+			process.Priority = i
+
+			err = rs.AddProcess(process)
+			require.NoError(t, err)
+
+			err = rs.StartProcess(id)
+			require.NoError(t, err)
+		}
+
+		state, err := rs.GetProcessState("high")
+		require.NoError(t, err)
+		require.Equal(t, 2, state.Priority)
+
+		if !preempt {
+			// Higher priorities only jump the queue
+			require.Equal(t, []string{"low"}, rs.GetProcessIDsByState("", "running", "", ""))
+			require.Equal(t, 1, state.QueuePosition)
+		} else {
+			// Higher priorities take over the slot of a running process
+			require.Equal(t, []string{"high"}, rs.GetProcessIDsByState("", "running", "", ""))
+			require.Equal(t, 0, state.QueuePosition)
+		}
+
+		// The queue is ordered by priority
+		state, err = rs.GetProcessState("mid")
+		require.NoError(t, err)
+		require.Equal(t, "queued", state.State)
+
+		if !preempt {
+			require.Equal(t, 2, state.QueuePosition)
+		} else {
+			require.Equal(t, 1, state.QueuePosition)
+
+			state, err = rs.GetProcessState("low")
+			require.NoError(t, err)
+			require.Equal(t, "queued", state.State)
+			require.Equal(t, 2, state.QueuePosition)
+		}
+
+		for _, id := range []string{"low", "mid", "high"} {
+			rs.StopProcess(id)
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	binary, err := testhelper.BuildBinary("ffmpeg", "../internal/testhelper")
+	require.NoError(t, err, "Failed to build helper program")
+
+	ffmpeg, err := ffmpeg.New(ffmpeg.Config{
+		Binary: binary,
+	})
+	require.NoError(t, err)
+
+	lock := sync.Mutex{}
+	events := []WebhookEvent{}
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		requests++
+
+		// The first attempt fails, such that the event has to be retried
+		if requests == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		event := WebhookEvent{}
+		json.NewDecoder(r.Body).Decode(&event)
+
+		events = append(events, event)
+	}))
+	defer server.Close()
+
+	_, err = New(Config{
+		FFmpeg: ffmpeg,
+		Webhook: WebhookConfig{
+			URL:    server.URL,
+			Events: []string{"foobar"},
+		},
+	})
+	require.Error(t, err)
+
+	rs, err := New(Config{
+		ID:     "core",
+		FFmpeg: ffmpeg,
+		Webhook: WebhookConfig{
+			URL:        server.URL,
+			Events:     []string{EventStart, EventStop},
+			Retries:    1,
+			RetryDelay: 100 * time.Millisecond,
+		},
+	})
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Reference = "ref"
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return len(events) == 1
+	}, 5*time.Second, 100*time.Millisecond)
+
+	err = rs.StopProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return len(events) == 2
+	}, 5*time.Second, 100*time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	require.Equal(t, 3, requests)
+
+	require.Equal(t, "core", events[0].CoreID)
+	require.Equal(t, EventStart, events[0].Event)
+	require.Equal(t, process.ID, events[0].ID)
+	require.Equal(t, "ref", events[0].Reference)
+	require.Equal(t, "running", events[0].NewState)
+	require.NotZero(t, events[0].Timestamp)
+
+	require.Equal(t, EventStop, events[1].Event)
+	require.Equal(t, "finished", events[1].NewState)
+}
+
+func TestWebhookProcessEvents(t *testing.T) {
+	e := &processEvents{}
+
+	require.Equal(t, EventStart, e.event("starting", "running", "start"))
+	require.Equal(t, EventCrash, e.event("running", "failed", "start"))
+	require.Equal(t, "", e.event("failed", "starting", "start"))
+	require.Equal(t, EventRecover, e.event("starting", "running", "start"))
+
+	require.Equal(t, EventStale, e.setStale())
+	require.Equal(t, "", e.event("running", "finishing", "start"))
+	require.Equal(t, "", e.event("finishing", "killed", "start"))
+	require.Equal(t, EventRecover, e.event("starting", "running", "start"))
+
+	// A restart is neither a crash nor a stop
+	require.Equal(t, "", e.event("finishing", "finished", "start"))
+	require.Equal(t, EventStart, e.event("starting", "running", "start"))
+
+	require.Equal(t, EventStop, e.event("finishing", "killed", "stop"))
+}
+
+func TestCapabilities(t *testing.T) {
+	s := skills.Skills{
+		Filters: []skills.Filter{{Id: "scale"}, {Id: "fps"}, {Id: "drawtext"}},
+	}
+
+	s.Codecs.Video = []skills.Codec{{Id: "h264", Encoders: []string{"libx264", "h264_nvenc"}, Decoders: []string{"h264", "h264_cuvid"}}}
+	s.Codecs.Audio = []skills.Codec{{Id: "aac", Encoders: []string{"aac"}, Decoders: []string{"aac"}}}
+	s.Formats.Muxers = []skills.Format{{Id: "hls"}, {Id: "flv"}}
+
+	c := newCapabilities(s)
+
+	process := getDummyProcess()
+	process.Output[0].Options = []string{"-codec:v", "libx264", "-c:a", "aac", "-vf", "[0:v]scale=1280:-1,fps=25[out]", "-f", "hls"}
+
+	require.NoError(t, c.validate(process))
+
+	process.Output[0].Options = []string{"-c", "copy", "-vf", "drawtext=text='a, b; c'", "-f", "flv"}
+	require.NoError(t, c.validate(process))
+
+	process.Output[0].Options = []string{"-codec:v", "libx265"}
+	require.ErrorContains(t, c.validate(process), "libx265")
+
+	process.Output[0].Options = []string{"-f", "mpegts"}
+	require.ErrorContains(t, c.validate(process), "mpegts")
+
+	process.Output[0].Options = []string{"-filter_complex", "scale=1280:-1;[a]yadif@deint[b]"}
+	require.ErrorContains(t, c.validate(process), "yadif")
+
+	// Demuxers are not checked
+	process.Output[0].Options = []string{}
+	process.Input[0].Options = []string{"-f", "lavfi"}
+	require.NoError(t, c.validate(process))
+
+	// Codecs of inputs are checked against the decoders
+	process.Input[0].Options = []string{"-c:v", "h264_cuvid"}
+	require.NoError(t, c.validate(process))
+
+	process.Input[0].Options = []string{"-c:v", "libx264"}
+	require.ErrorContains(t, c.validate(process), "decoder 'libx264'")
+
+	process.Output[0].Options = []string{"-c:v", "h264_cuvid"}
+	process.Input[0].Options = []string{}
+	require.ErrorContains(t, c.validate(process), "encoder 'h264_cuvid'")
+
+	// Without any detected skills nothing is checked
+	c = newCapabilities(skills.Skills{})
+
+	process.Output[0].Options = []string{"-codec:v", "libx265", "-f", "mpegts", "-vf", "yadif"}
+	require.NoError(t, c.validate(process))
+}
+
+func TestOnDemand(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	source := getDummyProcess()
+	source.ID = "source"
+	source.OnDemand = true
+	source.Autostart = true
+
+	consumer1 := getDummyProcess()
+	consumer1.ID = "consumer1"
+	consumer1.Input[0].Address = "#source:output=out"
+
+	consumer2 := getDummyProcess()
+	consumer2.ID = "consumer2"
+	consumer2.Input[0].Address = "#source:output=out"
+
+	require.NoError(t, rs.AddProcess(source))
+	require.NoError(t, rs.AddProcess(consumer1))
+	require.NoError(t, rs.AddProcess(consumer2))
+
+	state, err := rs.GetProcessState("source")
+	require.NoError(t, err)
+	require.Equal(t, "stop", state.Order, "on-demand process shouldn't be autostarted")
+	require.Equal(t, 0, state.Consumers)
+
+	require.Equal(t, ErrOnDemand, rs.StartProcess("source"))
+
+	require.NoError(t, rs.StartProcess("consumer1"))
+
+	state, err = rs.GetProcessState("source")
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+	require.Equal(t, 1, state.Consumers)
+
+	require.NoError(t, rs.StartProcess("consumer2"))
+
+	state, err = rs.GetProcessState("source")
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+	require.Equal(t, 2, state.Consumers)
+
+	require.Equal(t, ErrOnDemand, rs.StopProcess("source"))
+
+	require.NoError(t, rs.StopProcess("consumer1"))
+
+	state, err = rs.GetProcessState("source")
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+	require.Equal(t, 1, state.Consumers)
+
+	require.NoError(t, rs.StopProcess("consumer2"))
+
+	state, err = rs.GetProcessState("source")
+	require.NoError(t, err)
+	require.Equal(t, "stop", state.Order)
+	require.Equal(t, 0, state.Consumers)
+}
+
+func TestReloadConsumers(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	producer := getDummyProcess()
+	producer.ID = "producer"
+	producer.ReloadConsumers = true
+
+	consumer := getDummyProcess()
+	consumer.ID = "consumer"
+	consumer.Input[0].Address = "#producer:output=out"
+
+	require.NoError(t, rs.AddProcess(producer))
+	require.NoError(t, rs.AddProcess(consumer))
+
+	r := rs.(*restream)
+
+	r.lock.RLock()
+	ffmpeg := r.tasks["consumer"].ffmpeg
+	r.lock.RUnlock()
+
+	// The address of the producer didn't change, the consumer stays as it is
+	require.NoError(t, rs.ReloadProcess("producer"))
+
+	r.lock.RLock()
+	require.Same(t, ffmpeg, r.tasks["consumer"].ffmpeg)
+	require.Equal(t, "-", r.tasks["consumer"].config.Input[0].Address)
+	r.lock.RUnlock()
+
+	r.lock.Lock()
+	r.tasks["producer"].process.Config.Output[0].Address = "udp://127.0.0.1:1234"
+	r.lock.Unlock()
+
+	require.NoError(t, rs.ReloadProcess("producer"))
+
+	r.lock.RLock()
+	require.NotSame(t, ffmpeg, r.tasks["consumer"].ffmpeg)
+	require.Equal(t, "udp://127.0.0.1:1234", r.tasks["consumer"].config.Input[0].Address)
+	r.lock.RUnlock()
+
+	// The consumer is reloaded as soon as the producer is running
+	require.NoError(t, rs.StartProcess("consumer"))
+	require.NoError(t, rs.StartProcess("producer"))
+
+	r.lock.Lock()
+	r.tasks["producer"].process.Config.Output[0].Address = "udp://127.0.0.1:5678"
+	ffmpeg = r.tasks["consumer"].ffmpeg
+	r.lock.Unlock()
+
+	require.NoError(t, rs.ReloadProcess("producer"))
+
+	require.Eventually(t, func() bool {
+		r.lock.RLock()
+		defer r.lock.RUnlock()
+
+		return r.tasks["consumer"].config.Input[0].Address == "udp://127.0.0.1:5678"
+	}, 10*time.Second, 100*time.Millisecond)
+
+	state, err := rs.GetProcessState("consumer")
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+
+	r.lock.RLock()
+	require.NotSame(t, ffmpeg, r.tasks["consumer"].ffmpeg)
+	r.lock.RUnlock()
+
+	rs.StopProcess("consumer")
+	rs.StopProcess("producer")
+}
+
+func TestDeleteProcesses(t *testing.T) {
+	binary, err := testhelper.BuildBinary("ffmpeg", "../internal/testhelper")
+	require.NoError(t, err)
+
+	ffmpeg, err := ffmpeg.New(ffmpeg.Config{
+		Binary: binary,
+	})
+	require.NoError(t, err)
+
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	rs, err := New(Config{
+		FFmpeg:      ffmpeg,
+		Filesystems: []fs.Filesystem{memfs},
+	})
+	require.NoError(t, err)
+
+	for _, id := range []string{"foo1", "foo2", "bar1", "bar2"} {
+		process := getDummyProcess()
+		process.ID = id
+		process.Output[0].Cleanup = []app.ConfigIOCleanup{
+			{Pattern: "mem:/" + id + "/**"},
+		}
+
+		if id == "bar2" {
+			process.Input[0].Address = "#bar1:output=out"
+		}
+
+		require.NoError(t, rs.AddProcess(process))
+
+		memfs.WriteFile("/"+id+"/file.txt", []byte("data"))
+	}
+
+	deleted, errs := rs.DeleteProcesses("", "", DeleteOptions{})
+	require.Equal(t, []string{}, deleted)
+	require.Equal(t, []error{ErrEmptyPattern}, errs)
+
+	deleted, errs = rs.DeleteProcesses("foo*", "", DeleteOptions{})
+	require.Equal(t, []string{"foo1", "foo2"}, deleted)
+	require.Empty(t, errs)
+	_, err = memfs.Stat("/foo1/file.txt")
+	require.NoError(t, err)
+
+	_, err = rs.GetProcess("foo1")
+	require.Equal(t, ErrUnknownProcess, err)
+
+	deleted, errs = rs.DeleteProcesses("bar1", "", DeleteOptions{Unreferenced: true})
+	require.Equal(t, []string{}, deleted)
+	require.Len(t, errs, 1)
+	require.ErrorIs(t, errs[0], ErrProcessReferenced)
+
+	// The referencing process is deleted as well, such that both can be deleted
+	deleted, errs = rs.DeleteProcesses("bar*", "", DeleteOptions{Unreferenced: true, PurgeOnDelete: true})
+	require.Equal(t, []string{"bar1", "bar2"}, deleted)
+	require.Empty(t, errs)
+	_, err = memfs.Stat("/bar1/file.txt")
+	require.Error(t, err)
+	_, err = memfs.Stat("/bar2/file.txt")
+	require.Error(t, err)
+
+	require.NoError(t, rs.AddProcess(getDummyProcess()))
+
+	deleted, errs = rs.DeleteProcesses("", "", DeleteOptions{All: true})
+	require.Equal(t, []string{"process"}, deleted)
+	require.Empty(t, errs)
+}
+
+func TestLogStreams(t *testing.T) {
+	report := parse.Report{
+		Prelude: []string{"prelude"},
+		Log: []process.Line{
+			{Data: "err", Level: "info", Stream: "stderr"},
+			{Data: "out", Level: "info", Stream: "stdout"},
+		},
+	}
+
+	e := logHistoryEntry(report, app.LogAll)
+	require.Equal(t, []string{"prelude"}, e.Prelude)
+	require.Equal(t, []app.LogEntry{
+		{Data: "err", Level: "info", Stream: "stderr"},
+		{Data: "out", Level: "info", Stream: "stdout"},
+	}, e.Log)
+
+	e = logHistoryEntry(report, app.LogLines|app.LogStdout)
+	require.Equal(t, []string{}, e.Prelude)
+	require.Equal(t, []app.LogEntry{{Data: "out", Level: "info", Stream: "stdout"}}, e.Log)
+
+	e = logHistoryEntry(report, app.LogAll|app.LogStderr)
+	require.Equal(t, []string{"prelude"}, e.Prelude)
+	require.Equal(t, []app.LogEntry{{Data: "err", Level: "info", Stream: "stderr"}}, e.Log)
+
+	e = logHistoryEntry(report, app.LogStdout)
+	require.Equal(t, []app.LogEntry{}, e.Log)
+}
+
+func TestMaxRestarts(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.MaxRestarts = -1
+
+	require.Error(t, rs.AddProcess(process))
+
+	process.MaxRestarts = 3
+	process.RestartWindow = 60
+
+	require.NoError(t, rs.AddProcess(process))
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, 0, state.Restarts)
+	require.Equal(t, "closed", state.Breaker)
+}
+
+func TestRestartPolicy(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.RestartPolicy = "foobar"
+
+	require.Error(t, rs.AddProcess(process))
+
+	process.RestartPolicy = ""
+
+	require.NoError(t, rs.AddProcess(process))
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "always", state.RestartPolicy, "Reconnect decides without a policy")
+	require.Equal(t, "", state.Restart)
+
+	process.RestartPolicy = "never"
+
+	require.NoError(t, rs.UpdateProcess(process.ID, process))
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "never", state.RestartPolicy)
+	require.Equal(t, float64(-1), state.Reconnect)
+}
+
+func TestScheduling(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+
+	for _, affinity := range [][]int{{-1}, {runtime.NumCPU()}} {
+		process.CPUAffinity = affinity
+		require.Error(t, rs.AddProcess(process), affinity)
+	}
+
+	process.CPUAffinity = []int{0}
+
+	for _, nice := range []int{-21, 20} {
+		process.Nice = nice
+		require.Error(t, rs.AddProcess(process), nice)
+	}
+
+	process.Nice = 5
+
+	require.NoError(t, rs.AddProcess(process))
+
+	p, err := rs.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, []int{0}, p.Config.CPUAffinity)
+	require.Equal(t, 5, p.Config.Nice)
+}
+
+func TestReloadAll(t *testing.T) {
+	host := "localhost"
+
+	replacer := replace.New()
+	replacer.RegisterTemplateFunc("rtmp", func(config *app.Config, section string) string {
+		return "rtmp://" + host + "/app/{name}"
+	}, nil)
+
+	rs, err := getDummyRestreamer(nil, nil, nil, replacer)
+	require.NoError(t, err)
+
+	process1 := getDummyProcess()
+	process1.ID = "process1"
+	process1.Output[0].Address = "{rtmp,name=$processid}"
+
+	process2 := getDummyProcess()
+	process2.ID = "process2"
+
+	require.NoError(t, rs.AddProcess(process1))
+	require.NoError(t, rs.AddProcess(process2))
+	require.NoError(t, rs.StartProcess(process1.ID))
+
+	reloaded, errs := rs.ReloadAll()
+	require.Equal(t, []string{}, reloaded)
+	require.Empty(t, errs)
+
+	host = "example.com"
+
+	reloaded, errs = rs.ReloadAll()
+	require.Equal(t, []string{"process1"}, reloaded)
+	require.Empty(t, errs)
+
+	state, err := rs.GetProcessState(process1.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+	require.Contains(t, state.Command, "rtmp://example.com/app/process1")
+
+	reloaded, errs = rs.ReloadAll()
+	require.Equal(t, []string{}, reloaded)
+	require.Empty(t, errs)
+
+	rs.StopProcess(process1.ID)
+}
+
+func TestRotateCredentials(t *testing.T) {
+	token := "foobar"
+
+	replacer := replace.New()
+	replacer.RegisterTemplateFunc("rtmp", func(config *app.Config, section string) string {
+		return "rtmp://localhost/app/{name}?token=" + token
+	}, nil)
+
+	rs, err := getDummyRestreamer(nil, nil, nil, replacer)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Output = append(process.Output, app.ConfigIO{
+		ID:      "rtmp",
+		Address: "{rtmp,name=$processid}",
+		Options: []string{"-f", "flv"},
+	})
+
+	require.NoError(t, rs.AddProcess(process))
+	require.NoError(t, rs.StartProcess(process.ID))
+
+	_, err = rs.RotateCredentials("foobar")
+	require.Equal(t, ErrUnknownProcess, err)
+
+	changed, err := rs.RotateCredentials(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{}, changed)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), state.RotatedAt)
+
+	token = "barfoo"
+
+	changed, err = rs.RotateCredentials(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"rtmp"}, changed)
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.NotEqual(t, int64(0), state.RotatedAt)
+	require.Equal(t, "start", state.Order)
+	require.Contains(t, state.Command, "rtmp://localhost/app/process?token=barfoo")
+
+	rs.StopProcess(process.ID)
+}
+
+func TestPlayoutReloadWhileResolving(t *testing.T) {
+	portrange, err := net.NewPortrange(3000, 3001)
+	require.NoError(t, err)
+
+	rs, err := getDummyRestreamer(portrange, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Input[0].Address = "playout:" + process.Input[0].Address
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	_, err = rs.GetPlayoutInfo(process.ID, "foobar")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrPlayoutNotReady, "an unknown input is never ready")
+
+	done := make(chan struct{})
+	errs := make(chan error, 4)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					errs <- nil
+					return
+				default:
+				}
+
+				info, err := rs.GetPlayoutInfo(process.ID, process.Input[0].ID)
+				if err != nil {
+					if !errors.Is(err, ErrPlayoutNotReady) {
+						errs <- err
+						return
+					}
+
+					continue
+				}
+
+				if info.Port != 3000 && info.Port != 3001 {
+					errs <- fmt.Errorf("unexpected port %d", info.Port)
+					return
+				}
+
+				if info.Protocol != "lavfi" {
+					errs <- fmt.Errorf("unexpected protocol %s", info.Protocol)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, rs.ReloadProcess(process.ID))
+		require.NoError(t, rs.StartProcess(process.ID))
+		require.NoError(t, rs.ReloadProcess(process.ID))
+		require.NoError(t, rs.StopProcess(process.ID))
+
+		_, err := rs.GetPlayoutInfo(process.ID, process.Input[0].ID)
+		require.ErrorIs(t, err, ErrPlayoutNotReady, "a stopped process has no playout port")
+	}
+
+	close(done)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func TestValidateStreamMapping(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs.(*restream).mapcheck = 2 * time.Second
+
+	process := getDummyProcess()
+	process.Input = append(process.Input, app.ConfigIO{
+		ID:      "in2",
+		Address: "testsrc2=size=1280x720:rate=25",
+		Options: []string{"-f", "lavfi"},
+	})
+	process.Output[0].Options = []string{"-map", "0:v:0", "-map", "1:a:1", "-map", "[out]", "-map", "1:s?"}
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	process = getDummyProcess()
+	process.ID = "process2"
+	process.Output[0].Options = []string{"-map", "0:a"}
+
+	err = rs.AddProcess(process)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "'0:a'")
+
+	_, err = rs.GetProcess(process.ID)
+	require.Equal(t, ErrUnknownProcess, err)
+}
+
+func TestMatchesStream(t *testing.T) {
+	streams := []app.ProbeIO{
+		{Index: 0, Stream: 0, Type: "video"},
+		{Index: 0, Stream: 1, Type: "audio"},
+		{Index: 0, Stream: 2, Type: "audio"},
+		{Index: 1, Stream: 0, Type: "audio"},
+	}
+
+	for spec, match := range map[string]bool{
+		"0":        true,
+		"1":        true,
+		"2":        false,
+		"0:2":      true,
+		"0:3":      false,
+		"0:v":      true,
+		"1:v":      false,
+		"0:a:1":    true,
+		"0:a:2":    false,
+		"1:a:0":    true,
+		"0:s":      false,
+		"0:s?":     true,
+		"-0:s":     true,
+		"[out]":    true,
+		"0:p:1":    true,
+		"0:m:lang": true,
+	} {
+		require.Equal(t, match, matchesStream(spec, streams), spec)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	summary := rs.Summary()
+	require.Equal(t, 0, summary.Processes)
+	require.Equal(t, 0, len(summary.States))
+
+	process := getDummyProcess()
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	process = getDummyProcess()
+	process.ID = "process2"
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return rs.Summary().States["running"] == 1
+	}, 5*time.Second, 100*time.Millisecond)
+
+	summary = rs.Summary()
+	require.Equal(t, 2, summary.Processes)
+	require.Equal(t, map[string]int{"finished": 1, "running": 1}, summary.States)
+	require.Equal(t, 0, summary.Restarts)
+
+	rs.StopProcess(process.ID)
+}
+
+func TestListProcesses(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, []app.ProcessSummary{}, rs.ListProcesses(ProcessFilter{}))
+
+	process := getDummyProcess()
+	process.ID = "process2"
+	process.Reference = "foobar"
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	process = getDummyProcess()
+	process.ID = "process1"
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess("process2")
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(rs.ListProcesses(ProcessFilter{State: "running"})) == 1
+	}, 5*time.Second, 100*time.Millisecond)
+
+	require.Equal(t, []app.ProcessSummary{
+		{ID: "process1", Order: "stop", State: "finished", ExitCode: -1},
+		{ID: "process2", Reference: "foobar", Order: "start", State: "running", ExitCode: -1},
+	}, rs.ListProcesses(ProcessFilter{}))
+
+	require.Equal(t, []app.ProcessSummary{
+		{ID: "process2", Reference: "foobar", Order: "start", State: "running", ExitCode: -1},
+	}, rs.ListProcesses(ProcessFilter{Reference: "foo*"}))
+
+	err = rs.StopProcess("process2")
+	require.NoError(t, err)
+
+	summaries := rs.ListProcesses(ProcessFilter{ID: "process2"})
+	require.Equal(t, 1, len(summaries))
+	require.Equal(t, "stop", summaries[0].Order)
+	require.Equal(t, "finished", summaries[0].State)
+	require.Equal(t, 255, summaries[0].ExitCode)
+}
+
+func TestDevices(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs.(*restream).devices = map[string]Device{
+		"gpu0": {ID: "gpu0", HWAccel: "cuda", Device: "0", Capacity: 1},
+	}
+
+	process := getDummyProcess()
+	process.ID = "process3"
+	process.Device = "gpu1"
+	err = rs.AddProcess(process)
+	require.Error(t, err, "unknown devices are not allowed")
+
+	for _, id := range []string{"process1", "process2"} {
+		process := getDummyProcess()
+		process.ID = id
+		process.Device = "gpu0"
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+	}
+
+	state, err := rs.GetProcessState("process1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"-hwaccel", "cuda", "-hwaccel_device", "0"}, state.Command[2:6])
+
+	reloaded, errs := rs.ReloadAll()
+	require.Empty(t, reloaded)
+	require.Empty(t, errs)
+
+	err = rs.StartProcess("process1")
+	require.NoError(t, err)
+
+	err = rs.StartProcess("process2")
+	require.NoError(t, err)
+
+	state, err = rs.GetProcessState("process2")
+	require.NoError(t, err)
+	require.Equal(t, "queued", state.State, "the device doesn't have a free slot")
+
+	summary := rs.Summary()
+	require.Equal(t, map[string]app.DeviceLoad{
+		"gpu0": {Processes: []string{"process1"}, Capacity: 1},
+	}, summary.Devices)
+
+	err = rs.StopProcess("process1")
+	require.NoError(t, err)
+
+	state, err = rs.GetProcessState("process2")
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+	require.NotEqual(t, "queued", state.State)
+
+	summary = rs.Summary()
+	require.Equal(t, []string{"process2"}, summary.Devices["gpu0"].Processes)
+
+	rs.StopProcess("process2")
+}
+
+func TestLoadConfigDir(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	err = rs.AddProcess(getDummyProcess())
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+
+	write := func(name string, config *app.Config) {
+		data, err := json.Marshal(config)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0644))
+	}
+
+	process := getDummyProcess()
+	process.ID = ""
+	write("foobar.json", process)
+
+	process = getDummyProcess()
+	process.ID = "process2"
+	process.Autostart = true
+	write("process2.json", process)
+
+	process = getDummyProcess()
+	process.ID = "process2"
+	write("process3.json", process)
+
+	process = getDummyProcess()
+	process.ID = "invalid"
+	process.Input = nil
+	write("invalid.json", process)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"id":`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "unknown.json"), []byte(`{"id":"unknown","foo":"bar"}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte(`foobar`), 0644))
+
+	errs := rs.LoadConfigDir(dir, false)
+	require.ElementsMatch(t, []string{"process3.json", "invalid.json", "broken.json", "unknown.json"}, mapKeys(errs))
+
+	require.ElementsMatch(t, []string{"process", "foobar", "process2"}, rs.GetProcessIDs("", ""))
+
+	p, err := rs.GetProcess("process2")
+	require.NoError(t, err)
+	require.Equal(t, "start", p.Order)
+
+	version := p.Version
+
+	// Loading the same files again doesn't change the processes
+	errs = rs.LoadConfigDir(dir, false)
+	require.Equal(t, 4, len(errs))
+
+	p, err = rs.GetProcess("process2")
+	require.NoError(t, err)
+	require.Equal(t, version, p.Version)
+
+	// Changed files update the processes, missing files remove them
+	process = getDummyProcess()
+	process.ID = "process2"
+	process.Autostart = true
+	process.Reference = "changed"
+	write("process2.json", process)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "foobar.json")))
+	require.NoError(t, os.Remove(filepath.Join(dir, "process3.json")))
+	require.NoError(t, os.Remove(filepath.Join(dir, "invalid.json")))
+	require.NoError(t, os.Remove(filepath.Join(dir, "unknown.json")))
+
+	errs = rs.LoadConfigDir(dir, true)
+	require.ElementsMatch(t, []string{"broken.json"}, mapKeys(errs))
+
+	// The process of a file that can't be read is kept
+	require.ElementsMatch(t, []string{"process2"}, rs.GetProcessIDs("", ""))
+
+	p, err = rs.GetProcess("process2")
+	require.NoError(t, err)
+	require.Equal(t, "changed", p.Reference)
+	require.Equal(t, version+1, p.Version)
+
+	// The process a file has been loaded as is kept if the file can't be read anymore
+	process = getDummyProcess()
+	process.ID = "custom"
+	write("other.json", process)
+
+	errs = rs.LoadConfigDir(dir, true)
+	require.ElementsMatch(t, []string{"broken.json"}, mapKeys(errs))
+	require.ElementsMatch(t, []string{"process2", "custom"}, rs.GetProcessIDs("", ""))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.json"), []byte(`{"id":`), 0644))
+
+	errs = rs.LoadConfigDir(dir, true)
+	require.ElementsMatch(t, []string{"broken.json", "other.json"}, mapKeys(errs))
+	require.ElementsMatch(t, []string{"process2", "custom"}, rs.GetProcessIDs("", ""))
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "other.json")))
+
+	errs = rs.LoadConfigDir(dir, true)
+	require.ElementsMatch(t, []string{"broken.json"}, mapKeys(errs))
+	require.ElementsMatch(t, []string{"process2"}, rs.GetProcessIDs("", ""))
+
+	rs.StopProcess("process2")
+
+	errs = rs.LoadConfigDir(filepath.Join(dir, "missing"), true)
+	require.Equal(t, 1, len(errs))
+	require.ElementsMatch(t, []string{"process2"}, rs.GetProcessIDs("", ""))
+}
+
+func TestLoadConfigDirReferences(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+
+	write := func(name string, config *app.Config) {
+		data, err := json.Marshal(config)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0644))
+	}
+
+	// The files are read in the order of their names, the consumer comes before its source
+	process := getDummyProcess()
+	process.ID = "consumer"
+	process.Input[0].Address = "#source:output=out"
+	write("a.json", process)
+
+	process = getDummyProcess()
+	process.ID = "source"
+	write("b.json", process)
+
+	// Circular references can't be resolved
+	process = getDummyProcess()
+	process.ID = "circle1"
+	process.Input[0].Address = "#circle2:output=out"
+	write("c.json", process)
+
+	process = getDummyProcess()
+	process.ID = "circle2"
+	process.Input[0].Address = "#circle1:output=out"
+	write("d.json", process)
+
+	errs := rs.LoadConfigDir(dir, false)
+	require.ElementsMatch(t, []string{"c.json", "d.json"}, mapKeys(errs))
+
+	require.ElementsMatch(t, []string{"consumer", "source"}, rs.GetProcessIDs("", ""))
+
+	// An update of the consumer waits for the added source as well
+	process = getDummyProcess()
+	process.ID = "consumer"
+	process.Input[0].Address = "#source2:output=out"
+	write("a.json", process)
+
+	process = getDummyProcess()
+	process.ID = "source2"
+	write("e.json", process)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "c.json")))
+	require.NoError(t, os.Remove(filepath.Join(dir, "d.json")))
+
+	errs = rs.LoadConfigDir(dir, false)
+	require.Empty(t, errs)
+
+	p, err := rs.GetProcess("consumer")
+	require.NoError(t, err)
+	require.Equal(t, "#source2:output=out", p.Config.Input[0].Address)
+}
+
+func mapKeys(m map[string]error) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+func TestPauseProcess(t *testing.T) {
+	binary, err := testhelper.BuildBinary("ffmpeg", "../internal/testhelper")
+	require.NoError(t, err, "Failed to build helper program")
+
+	ffmpeg, err := ffmpeg.New(ffmpeg.Config{
+		Binary: binary,
+	})
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+
+	rs, err := New(Config{
+		FFmpeg:           ffmpeg,
+		PlayoutSocketDir: dir,
+	})
+	require.NoError(t, err)
+
+	// Only files support pausing, the streams of the lavfi demuxer are generated
+	process := getDummyProcess()
+	process.Input[0].Address = "playout:/media/video.mp4"
+	process.Input[0].Options = []string{"-re"}
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	// The socket is assigned when the process starts
+	listener, err := gonet.Listen("unix", playoutSocket(dir, process.ID, process.Input[0].ID))
+	require.NoError(t, err)
+
+	actions := make(chan string, 2)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		control := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&control)
+
+		if r.Method == http.MethodPut && r.URL.Path == "/v1/control" {
+			actions <- control["action"]
+		}
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	err = rs.PauseProcess(process.ID)
+	require.Error(t, err, "a stopped process can't be paused")
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		state, _ := rs.GetProcessState(process.ID)
+		return state.State == "running"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	err = rs.PauseProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "pause", <-actions)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.True(t, state.Paused)
+
+	err = rs.ResumeProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "resume", <-actions)
+
+	state, err = rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.False(t, state.Paused)
+
+	err = rs.StopProcess(process.ID)
+	require.NoError(t, err)
+
+	process2 := getDummyProcess()
+	process2.ID = "process2"
+
+	err = rs.AddProcess(process2)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process2.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		state, _ := rs.GetProcessState(process2.ID)
+		return state.State == "running"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	err = rs.PauseProcess(process2.ID)
+	require.ErrorIs(t, err, ErrPauseNotSupported)
+
+	rs.StopProcess(process2.ID)
+
+	process3 := getDummyProcess()
+	process3.ID = "process3"
+	process3.Input[0].Address = "playout:rtmp://localhost/live/stream"
+	process3.Input[0].Options = []string{}
+
+	err = rs.AddProcess(process3)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process3.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		state, _ := rs.GetProcessState(process3.ID)
+		return state.State == "running"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	err = rs.PauseProcess(process3.ID)
+	require.ErrorIs(t, err, ErrPauseNotSupported, "live sources can't be paused")
+
+	rs.StopProcess(process3.ID)
+
+	process4 := getDummyProcess()
+	process4.ID = "process4"
+	process4.Input[0].Address = "playout:" + process4.Input[0].Address
+
+	err = rs.AddProcess(process4)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process4.ID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		state, _ := rs.GetProcessState(process4.ID)
+		return state.State == "running"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	err = rs.PauseProcess(process4.ID)
+	require.ErrorIs(t, err, ErrPauseNotSupported, "generated streams can't be paused")
+
+	rs.StopProcess(process4.ID)
+}
+
+func TestValidateOptions(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Input[0].Options = append(process.Input[0].Options, "-metadata", "title=foo\x00-y")
+
+	err = rs.AddProcess(process)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "null byte")
+	require.Contains(t, err.Error(), "#process:in")
+
+	process = getDummyProcess()
+	process.Options = append(process.Options, "-y\x00")
+
+	err = rs.AddProcess(process)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "global options")
+
+	// Allowlist
+	rs.(*restream).allowlist = map[string]bool{"f": true, "codec": true, "re": true}
+
+	process = getDummyProcess()
+	process.Options = nil
+	process.Input[0].Options = []string{"-f", "lavfi", "-re"}
+	process.Output[0].Options = []string{"-codec:v", "copy", "-f", "null", "-codec", "-1"}
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	process = getDummyProcess()
+	process.ID = "process2"
+	process.Options = nil
+	process.Input[0].Options = []string{"-f", "lavfi"}
+	process.Output[0].Options = []string{"-f", "null", "-filter_complex", "movie=/etc/passwd"}
+
+	err = rs.AddProcess(process)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "'-filter_complex' is not allowed")
+	require.Contains(t, err.Error(), "#process2:out")
+}
+
+func TestTestProcess(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Environment = map[string]string{
+		"FFMPEG_TEST_LINE": "[error] foobar",
+	}
+
+	result, err := rs.TestProcess(process, 2*time.Second)
+	require.NoError(t, err)
+	require.True(t, result.Success, result.Diagnostics)
+	require.False(t, result.Exited)
+	require.NotEqual(t, 0, len(result.Prelude))
+	require.Equal(t, []string{"foobar"}, result.Diagnostics)
+
+	_, err = rs.GetProcess(process.ID)
+	require.Equal(t, ErrUnknownProcess, err, "the process must not be added")
+
+	// The process exits immediately
+	process = getDummyProcess()
+	process.Output[0].Address = "-foobar"
+
+	result, err = rs.TestProcess(process, 2*time.Second)
+	require.NoError(t, err)
+	require.False(t, result.Success)
+	require.True(t, result.Exited)
+	require.Equal(t, 2, result.ExitCode)
+	require.Less(t, result.Duration, 2*time.Second)
+	require.Equal(t, []string{"the process exited with code 2 after " + result.Duration.Round(time.Millisecond).String(), "no output has been produced"}, result.Diagnostics)
+
+	err = rs.AddProcess(getDummyProcess())
+	require.NoError(t, err)
+
+	_, err = rs.TestProcess(getDummyProcess(), time.Second)
+	require.Equal(t, ErrProcessExists, err)
+}
+
+func TestTestProcessReservation(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	r := rs.(*restream)
+	r.maxProc = 1
+
+	process2 := getDummyProcess()
+	process2.ID = "process2"
+
+	err = rs.AddProcess(process2)
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		_, err := rs.TestProcess(getDummyProcess(), 2*time.Second)
+		done <- err
+	}()
+
+	require.Eventually(t, func() bool {
+		r.lock.RLock()
+		defer r.lock.RUnlock()
+
+		return len(r.tests) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// The ID is reserved and the test occupies the only process slot
+	err = rs.AddProcess(getDummyProcess())
+	require.Equal(t, ErrProcessExists, err)
+
+	_, err = rs.TestProcess(getDummyProcess(), time.Second)
+	require.Equal(t, ErrProcessExists, err)
+
+	err = rs.StartProcess(process2.ID)
+	require.Error(t, err)
+
+	require.NoError(t, <-done)
+
+	require.Equal(t, int64(0), r.nProc)
+
+	err = rs.AddProcess(getDummyProcess())
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process2.ID)
+	require.NoError(t, err)
+}
+
+func TestProcessSnapshot(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	r := rs.(*restream)
+
+	// The read path doesn't share the process with the task
+	require.NotSame(t, r.tasks[process.ID].process, r.view().tasks[process.ID].process)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+
+	// Releasing the lock without a change keeps the snapshot
+	s := r.view()
+
+	r.lock.Lock()
+	r.unlock()
+
+	require.Same(t, s, r.view())
+
+	// A change made while holding the lock is visible only after releasing it
+	r.lock.Lock()
+	r.tasks[process.ID].process.Order = "stop"
+	r.dirty = true
+
+	p, err := rs.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", p.Order)
+
+	r.unlock()
+
+	p, err = rs.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "stop", p.Order)
+
+	err = rs.DeleteProcess(process.ID)
+	require.NoError(t, err)
+
+	require.Empty(t, rs.GetProcessIDs("", ""))
+}
+
+// benchmarkProcessReads measures the throughput of read while other goroutines
+// keep on changing the metadata of the processes, which requires the write lock.
+func benchmarkProcessReads(b *testing.B, read func(r *restream, id string)) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(b, err)
+
+	r := rs.(*restream)
+
+	for i := 0; i < 100; i++ {
+		process := getDummyProcess()
+		process.ID = "process_" + strconv.Itoa(i)
+
+		err = rs.AddProcess(process)
+		require.NoError(b, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for w := 0; w < 4; w++ {
+		go func(w int) {
+			for i := 0; ctx.Err() == nil; i++ {
+				r.SetProcessMetadata("process_"+strconv.Itoa((w*25+i)%100), "counter", i)
+			}
+		}(w)
+	}
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			read(r, "process_"+strconv.Itoa(i%100))
+			i++
+		}
+	})
+}
+
+// BenchmarkProcessReadsLocked reads while holding the read lock, as it has been
+// done before the snapshots have been introduced.
+func BenchmarkProcessReadsLocked(b *testing.B) {
+	benchmarkProcessReads(b, func(r *restream, id string) {
+		r.lock.RLock()
+		defer r.lock.RUnlock()
+
+		processIDs(r.tasks, "", "")
+		r.tasks[id].process.Clone()
+		r.processState(r.tasks[id])
+	})
+}
+
+func BenchmarkProcessReadsSnapshot(b *testing.B) {
+	benchmarkProcessReads(b, func(r *restream, id string) {
+		r.GetProcessIDs("", "")
+		r.GetProcess(id)
+		r.GetProcessState(id)
+	})
+}
+
+func TestClose(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+
+	portrange, err := net.NewPortrange(3000, 3001)
+	require.NoError(t, err)
+
+	rs, err := getDummyRestreamer(portrange, nil, nil, nil)
+	require.NoError(t, err)
+
+	rs.Start()
+
+	for _, id := range []string{"process1", "process2"} {
+		process := getDummyProcess()
+		process.ID = id
+		process.Input[0].Address = "playout:" + process.Input[0].Address
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+
+		err = rs.StartProcess(id)
+		require.NoError(t, err)
+	}
+
+	// All ports are in use
+	_, err = portrange.Get()
+	require.Error(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(rs.GetProcessIDsByState("", "running", "", "")) == 2
+	}, 5*time.Second, 100*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = rs.Close(ctx)
+	require.NoError(t, err)
+
+	for _, id := range []string{"process1", "process2"} {
+		state, err := rs.GetProcessState(id)
+		require.NoError(t, err)
+		require.Equal(t, "start", state.Order, "the order should be kept")
+		require.Equal(t, "finished", state.State)
+	}
+
+	// The ports have been released
+	for i := 0; i < 2; i++ {
+		port, err := portrange.Get()
+		require.NoError(t, err)
+		portrange.Put(port)
+	}
+
+	// Closing again doesn't do anything
+	err = rs.Close(ctx)
+	require.NoError(t, err)
+
+	rs.Start()
+	require.Empty(t, rs.GetProcessIDsByState("", "running", "", ""))
+
+	// Not using require.Eventually, because it runs the condition in its own goroutine
+	for i := 0; i < 50 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	require.LessOrEqual(t, runtime.NumGoroutine(), goroutines, "goroutines are leaking")
+}
+
+func TestThumbnail(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	_, err = rs.Thumbnail("process", "in", 0)
+	require.ErrorIs(t, err, ErrUnknownProcess)
+
+	process := getDummyProcess()
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	_, err = rs.Thumbnail("process", "in", 0)
+	require.ErrorIs(t, err, ErrProcessNotRunning)
+
+	err = rs.StartProcess("process")
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		state, _ := rs.GetProcessState("process")
+		return state.State == "running"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	_, err = rs.Thumbnail("process", "foobar", 0)
+	require.ErrorIs(t, err, ErrUnknownIO)
+
+	_, err = rs.Thumbnail("process", "out", 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not accessible")
+
+	// The dummy ffmpeg never writes a frame
+	_, err = rs.Thumbnail("process", "in", 500*time.Millisecond)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "timed out")
+
+	// The ffmpeg for the thumbnail counts against the max. number of processes
+	rs.(*restream).maxProc = 1
+	_, err = rs.Thumbnail("process", "in", 500*time.Millisecond)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "max. number of running processes")
+	rs.(*restream).maxProc = 0
+
+	for i := 0; i < thumbnailMaxConcurrent; i++ {
+		rs.(*restream).thumbs <- struct{}{}
+	}
+
+	_, err = rs.Thumbnail("process", "in", 500*time.Millisecond)
+	require.ErrorIs(t, err, ErrTooManyThumbnails)
+
+	err = rs.StopProcess("process")
+	require.NoError(t, err)
+}
+
+func TestExportImport(t *testing.T) {
+	type registered struct {
+		Foo string `json:"foo"`
+	}
+
+	type unregistered struct {
+		Bar int `json:"bar"`
+	}
+
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	err = rs.RegisterMetadataType("registered", registered{})
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	err = rs.StartProcess(process.ID)
+	require.NoError(t, err)
+
+	stopped := getDummyProcess()
+	stopped.ID = "stopped"
+	stopped.Autostart = true
+	err = rs.AddProcess(stopped)
+	require.NoError(t, err)
+
+	err = rs.StopProcess(stopped.ID)
+	require.NoError(t, err)
+
+	err = rs.SetProcessMetadata(process.ID, "foobar", process)
+	require.NoError(t, err)
+
+	err = rs.SetProcessMetadata(process.ID, "registered", &registered{Foo: "foo"})
+	require.NoError(t, err)
+
+	err = rs.SetMetadata("string", "bar")
+	require.NoError(t, err)
+
+	err = rs.SetMetadata("int", 42)
+	require.NoError(t, err)
+
+	err = rs.SetMetadata("unregistered", unregistered{Bar: 42})
+	require.NoError(t, err)
+
+	export, err := rs.Export()
+	require.NoError(t, err)
+
+	err = rs.StopProcess(process.ID)
+	require.NoError(t, err)
+
+	raw, err := json.Marshal(export)
+	require.NoError(t, err)
+
+	export = app.Export{}
+	err = json.Unmarshal(raw, &export)
+	require.NoError(t, err)
+
+	rs2, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	err = rs2.RegisterMetadataType("registered", registered{})
+	require.NoError(t, err)
+
+	// A failing import doesn't leave anything behind
+	broken := export
+	broken.Metadata.Process = map[string]map[string]app.ExportMetadata{
+		"foobar": {"foo": {Type: "string", Data: []byte(`"bar"`)}},
+	}
+
+	err = rs2.Import(broken)
+	require.ErrorIs(t, err, ErrUnknownProcess)
+	require.Empty(t, rs2.GetProcessIDs("", ""))
+	require.Empty(t, rs2.ListMetadataKeys())
+
+	err = rs2.Import(export)
+	require.NoError(t, err)
+
+	config, err := rs2.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", config.Order)
+
+	// The exported order wins over the autostart setting
+	config, err = rs2.GetProcess(stopped.ID)
+	require.NoError(t, err)
+	require.Equal(t, "stop", config.Order)
+	require.True(t, config.Config.Autostart)
+
+	data, err := rs2.GetProcessMetadata(process.ID, "foobar")
+	require.NoError(t, err)
+	require.Equal(t, process, data.(*app.Config))
+
+	data, err = rs2.GetProcessMetadata(process.ID, "registered")
+	require.NoError(t, err)
+	require.Equal(t, &registered{Foo: "foo"}, data)
+
+	data, err = rs2.GetMetadata("string")
+	require.NoError(t, err)
+	require.Equal(t, "bar", data)
+
+	data, err = rs2.GetMetadata("int")
+	require.NoError(t, err)
+	require.Equal(t, 42, data)
+
+	data, err = rs2.GetMetadata("unregistered")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"bar": float64(42)}, data)
+
+	err = rs2.Import(export)
+	require.ErrorIs(t, err, ErrProcessExists)
+
+	err = rs2.StopProcess(process.ID)
+	require.NoError(t, err)
+}
+
+func TestChangeRate(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	// unlimited by default
+	for i := 0; i < 10; i++ {
+		process := getDummyProcess()
+		process.ID = "process" + strconv.Itoa(i)
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+	}
+
+	rs.(*restream).changes = rate.NewLimiter(rate.Every(time.Hour), 2)
+
+	process := getDummyProcess()
+	err = rs.UpdateProcess("process0", process)
+	require.NoError(t, err)
+
+	err = rs.DeleteProcess("process1")
+	require.NoError(t, err)
+
+	err = rs.DeleteProcess("process2")
+	require.ErrorIs(t, err, ErrRateLimited)
+
+	process = getDummyProcess()
+	process.ID = "foobar"
+	err = rs.AddProcess(process)
+	require.ErrorIs(t, err, ErrRateLimited)
+
+	_, err = rs.GetProcess("process2")
+	require.NoError(t, err)
+
+	// reading is not limited
+	require.Equal(t, 9, len(rs.GetProcessIDs("", "")))
+
+	// commands and deleting with other means are limited as well
+	err = rs.StartProcess("process2")
+	require.ErrorIs(t, err, ErrRateLimited)
+
+	err = rs.StopProcess("process2")
+	require.ErrorIs(t, err, ErrRateLimited)
+
+	err = rs.DeleteUnreferencedProcess("process2")
+	require.ErrorIs(t, err, ErrRateLimited)
+
+	_, errs := rs.DeleteProcesses("process*", "", DeleteOptions{})
+	require.Equal(t, []error{ErrRateLimited}, errs)
+
+	err = rs.SetProcessMetadata("process2", "foo", "bar")
+	require.ErrorIs(t, err, ErrRateLimited)
+
+	require.Equal(t, 9, len(rs.GetProcessIDs("", "")))
+}
+
+func TestDeleteRunningProcess(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Autostart = true
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, "start", state.Order)
+
+	err = rs.DeleteProcess(process.ID)
+	require.NoError(t, err)
+
+	_, err = rs.GetProcess(process.ID)
+	require.Equal(t, ErrUnknownProcess, err)
+}
+
+func TestMergeConfigJSON(t *testing.T) {
+	base := map[string]interface{}{}
+	err := json.Unmarshal([]byte(`{
+		"reference": "base",
+		"options": ["-loglevel", "info"],
+		"limits": {"cpu_usage": 10, "memory_mbytes": 50},
+		"input": [
+			{"id": "in", "address": "foo", "options": ["-re"]}
+		],
+		"output": [
+			{"id": "out1", "address": "bar", "options": ["-codec", "copy"]},
+			{"id": "out2", "address": "baz"}
+		]
+	}`), &base)
+	require.NoError(t, err)
+
+	override := map[string]interface{}{}
+	err = json.Unmarshal([]byte(`{
+		"reference": null,
+		"options": ["-loglevel", "error"],
+		"limits": {"cpu_usage": 20},
+		"output": [
+			{"id": "out2", "address": "override"},
+			{"id": "out3", "address": "new"}
+		]
+	}`), &override)
+	require.NoError(t, err)
+
+	expected := map[string]interface{}{}
+	err = json.Unmarshal([]byte(`{
+		"options": ["-loglevel", "error"],
+		"limits": {"cpu_usage": 20, "memory_mbytes": 50},
+		"input": [
+			{"id": "in", "address": "foo", "options": ["-re"]}
+		],
+		"output": [
+			{"id": "out1", "address": "bar", "options": ["-codec", "copy"]},
+			{"id": "out2", "address": "override"},
+			{"id": "out3", "address": "new"}
+		]
+	}`), &expected)
+	require.NoError(t, err)
+
+	require.Equal(t, expected, mergeConfigJSON(base, override))
+
+	// Arrays without IDs are replaced
+	base = map[string]interface{}{"output": []interface{}{map[string]interface{}{"id": "out"}}}
+	override = map[string]interface{}{"output": []interface{}{map[string]interface{}{"address": "foo"}}}
+
+	require.Equal(t, override, mergeConfigJSON(base, override))
+}
+
+func TestStripJSONComments(t *testing.T) {
+	data := stripJSONComments([]byte("{\n// comment\n\"id\": \"a//b /* c */\", /* comment\n */ \"reference\": \"\\\"//\"\n}"))
+
+	config := app.Config{}
+	err := json.Unmarshal(data, &config)
+	require.NoError(t, err)
+	require.Equal(t, "a//b /* c */", config.ID)
+	require.Equal(t, "\"//", config.Reference)
+}
+
+func TestLoadConfigDirLayers(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+
+	data, err := json.Marshal(getDummyProcess())
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "common.base.json"), data, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "staging.base.jsonc"), []byte(`{
+		// staging uses a different log level
+		"$base": "common.base.json",
+		"options": ["-loglevel", "debug"]
+	}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "process1.jsonc"), []byte(`{
+		"$base": "staging.base.jsonc",
+		"id": "process1",
+		/* the output is replaced by its ID */
+		"output": [{"id": "out", "address": "-", "options": ["-f", "mpegts"]}]
+	}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cycle1.json"), []byte(`{"$base": "cycle2.json"}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cycle2.json"), []byte(`{"$base": "cycle1.json"}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "missing.json"), []byte(`{"$base": "missing.base.json"}`), 0644))
+
+	errs := rs.LoadConfigDir(dir, false)
+	require.ElementsMatch(t, []string{"cycle1.json", "cycle2.json", "missing.json"}, mapKeys(errs))
+
+	require.ElementsMatch(t, []string{"process1"}, rs.GetProcessIDs("", ""))
+
+	p, err := rs.GetProcess("process1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"-loglevel", "debug"}, p.Config.Options)
+	require.Equal(t, "testsrc=size=1280x720:rate=25", p.Config.Input[0].Address)
+	require.Equal(t, 1, len(p.Config.Output))
+	require.Equal(t, []string{"-f", "mpegts"}, p.Config.Output[0].Options)
+}
+
+func TestReplacerSections(t *testing.T) {
+	replacer := replace.New()
+
+	replacer.RegisterTemplateFunc("rtmp", func(config *app.Config, section string) string {
+		return "rtmp://localhost/app/{name}"
+	}, nil, "output")
+
+	rs, err := getDummyRestreamer(nil, nil, nil, replacer)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Input[0].Address = "{rtmp,name=foobar}"
+
+	err = rs.AddProcess(process)
+	require.ErrorContains(t, err, "'{rtmp}' is not allowed in the input section")
+
+	process = getDummyProcess()
+	process.Output[0].Address = "{rtmp,name=foobar}"
+
+	_, err = rs.Validate(process)
+	require.NoError(t, err)
+}
+
+func TestExpandOutputs(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Output = []app.ConfigIO{
+		{
+			ID:      "{processid}_{each:name}",
+			Address: "-",
+			Options: []string{"-s", "{each:width}x{each:height}", "-b:v", "{each:bitrate}", "-metadata", "index={each:index}", "-f", "null"},
+			Each: []map[string]string{
+				{"name": "1080p", "width": "1920", "height": "1080", "bitrate": "6000k"},
+				{"name": "720p", "width": "1280", "height": "720", "bitrate": "3000k"},
+			},
+		},
+		{
+			ID:      "audio",
+			Address: "-",
+			Options: []string{"-f", "null"},
 		},
-		Options: []string{
-			"-loglevel",
-			"info",
-			"/mnt/diskfs/foobar_on_disk.txt",
-			"{memfs}/foobar_in_mem.txt",
-			"/mnt/diskfs/foobar_on_disk_aswell.txt",
-			"http://localhost/mnt/memfs/foobar_in_mem_aswell.txt",
+	}
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	config, err := rs.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(config.Config.Output), "the stored config should not be expanded")
+	require.Equal(t, 2, len(config.Config.Output[0].Each))
+
+	task := rs.(*restream).tasks[process.ID]
+	require.Equal(t, 3, len(task.config.Output))
+	require.Equal(t, "process_1080p", task.config.Output[0].ID)
+	require.Equal(t, []string{"-s", "1920x1080", "-b:v", "6000k", "-metadata", "index=0", "-f", "null"}, task.config.Output[0].Options)
+	require.Equal(t, "process_720p", task.config.Output[1].ID)
+	require.Equal(t, []string{"-s", "1280x720", "-b:v", "3000k", "-metadata", "index=1", "-f", "null"}, task.config.Output[1].Options)
+	require.Equal(t, "audio", task.config.Output[2].ID)
+
+	process = getDummyProcess()
+	process.ID = "missing"
+	process.Output[0].ID = "out_{each:name}"
+	process.Output[0].Each = []map[string]string{{"foo": "bar"}}
+
+	err = rs.AddProcess(process)
+	require.ErrorContains(t, err, "unknown key 'name'")
+
+	process.Output[0].ID = "out"
+	process.Output[0].Each = []map[string]string{{"name": "bar"}}
+
+	err = rs.AddProcess(process)
+	require.ErrorContains(t, err, "must contain an {each:...} placeholder")
+
+	process.Output[0].ID = "out_{each:name}"
+	process.Output[0].Each = []map[string]string{{"name": "bar"}, {"name": "bar"}}
+
+	err = rs.AddProcess(process)
+	require.ErrorContains(t, err, "duplicate ID")
+
+	process.Output[0].Each = make([]map[string]string, maxEachElements+1)
+	for i := range process.Output[0].Each {
+		process.Output[0].Each[i] = map[string]string{"name": strconv.Itoa(i)}
+	}
+
+	err = rs.AddProcess(process)
+	require.ErrorContains(t, err, "max.")
+
+	process = getDummyProcess()
+	process.ID = "input"
+	process.Input[0].Each = []map[string]string{{"name": "bar"}}
+
+	err = rs.AddProcess(process)
+	require.ErrorContains(t, err, "only outputs")
+}
+
+func TestReplacerGlobals(t *testing.T) {
+	replacer := replace.New()
+	replacer.SetGlobals(map[string]string{"region": "eu"})
+
+	rs, err := getDummyRestreamer(nil, nil, nil, replacer)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.Output[0].Options = append(process.Output[0].Options, "-metadata", "region={global:region}")
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	task := rs.(*restream).tasks[process.ID]
+	require.Equal(t, "region=eu", task.config.Output[0].Options[5])
+
+	replacer.SetGlobals(map[string]string{"region": "us"})
+
+	ids, errs := rs.ReloadAll()
+	require.Equal(t, 0, len(errs))
+	require.Equal(t, []string{process.ID}, ids)
+
+	task = rs.(*restream).tasks[process.ID]
+	require.Equal(t, "region=us", task.config.Output[0].Options[5])
+}
+
+func TestRenameProcess(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	source := getDummyProcess()
+	source.ID = "source"
+	err = rs.AddProcess(source)
+	require.NoError(t, err)
+
+	consumer := getDummyProcess()
+	consumer.ID = "consumer"
+	consumer.Input[0].Address = "#source:output=out"
+	err = rs.AddProcess(consumer)
+	require.NoError(t, err)
+
+	err = rs.SetProcessMetadata("source", "foo", "bar")
+	require.NoError(t, err)
+
+	err = rs.StartProcess("source")
+	require.NoError(t, err)
+
+	err = rs.RenameProcess("foobar", "renamed")
+	require.ErrorIs(t, err, ErrUnknownProcess)
+
+	err = rs.RenameProcess("source", "consumer")
+	require.ErrorIs(t, err, ErrProcessExists)
+
+	before, err := rs.GetProcess("source")
+	require.NoError(t, err)
+
+	err = rs.RenameProcess("source", "renamed")
+	require.NoError(t, err)
+
+	_, err = rs.GetProcess("source")
+	require.ErrorIs(t, err, ErrUnknownProcess)
+
+	p, err := rs.GetProcess("renamed")
+	require.NoError(t, err)
+	require.Equal(t, "renamed", p.Config.ID)
+	require.Equal(t, "start", p.Order)
+	require.Equal(t, before.CreatedAt, p.CreatedAt)
+
+	data, err := rs.GetProcessMetadata("renamed", "foo")
+	require.NoError(t, err)
+	require.Equal(t, "bar", data)
+
+	p, err = rs.GetProcess("consumer")
+	require.NoError(t, err)
+	require.Equal(t, "#renamed:output=out", p.Config.Input[0].Address)
+	require.Equal(t, uint64(1), p.Version)
+
+	inbound, _, err := rs.GetReferences("renamed")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(inbound))
+	require.True(t, inbound[0].Exists)
+
+	err = rs.StopProcess("renamed")
+	require.NoError(t, err)
+}
+
+func TestInputBuffer(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+	process.InputBuffer = maxInputBuffer + 1
+
+	err = rs.AddProcess(process)
+	require.Error(t, err)
+
+	process.InputBuffer = 2000
+	process.Input = append(process.Input, app.ConfigIO{
+		ID:      "in2",
+		Address: "testsrc2=size=1280x720:rate=25",
+		Options: []string{"-f", "lavfi", "-analyzeduration", "42"},
+	})
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	task := rs.(*restream).tasks[process.ID]
+	require.Equal(t, []string{"-analyzeduration", "2000000", "-rtbufsize", "5000000", "-f", "lavfi", "-re"}, task.config.Input[0].Options)
+	require.Equal(t, []string{"-rtbufsize", "5000000", "-f", "lavfi", "-analyzeduration", "42"}, task.config.Input[1].Options)
+
+	config, err := rs.GetProcess(process.ID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"-f", "lavfi", "-re"}, config.Config.Input[0].Options, "the original config should not be changed")
+
+	progress := app.Progress{
+		Input: []app.ProgressIO{
+			{AVstream: &app.AVstream{Derived: app.AVstreamMetrics{Latency: 0.5}}},
+			{},
 		},
-		Reconnect:      true,
-		ReconnectDelay: 10,
-		Autostart:      false,
-		StaleTimeout:   0,
 	}
 
-	require.Equal(t, process, rs.tasks["314159265359"].config)
+	setBufferFill(&progress, 2000)
+	require.Equal(t, 0.25, progress.Input[0].AVstream.Derived.BufferFill)
+}
+
+func TestProcessMetadataBulk(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	for _, id := range []string{"ingest_1", "ingest_2", "egress_1"} {
+		process := getDummyProcess()
+		process.ID = id
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+	}
+
+	_, err = rs.SetProcessMetadataBulk("", "maintenance", true)
+	require.Error(t, err)
+
+	ids, err := rs.SetProcessMetadataBulk("ingest_*", "maintenance", true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ingest_1", "ingest_2"}, ids)
+
+	data, err := rs.GetProcessMetadataBulk("", "maintenance")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"ingest_1": true, "ingest_2": true}, data)
+
+	value, err := rs.GetProcessMetadata("ingest_2", "maintenance")
+	require.NoError(t, err)
+	require.Equal(t, true, value)
+
+	err = rs.RegisterMetadataSchema("maintenance", []byte(`{"type": "boolean"}`))
+	require.NoError(t, err)
+
+	_, err = rs.SetProcessMetadataBulk("*", "maintenance", "yes")
+	require.Error(t, err)
+
+	ids, err = rs.SetProcessMetadataBulk("*_1", "maintenance", nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"egress_1", "ingest_1"}, ids)
+
+	data, err = rs.GetProcessMetadataBulk("*", "maintenance")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"ingest_2": true}, data)
+}
+
+func TestGetProcessCommand(t *testing.T) {
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	_, err = rs.GetProcessCommand("process")
+	require.Equal(t, ErrUnknownProcess, err)
+
+	process := getDummyProcess()
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	state, err := rs.GetProcessState(process.ID)
+	require.NoError(t, err)
+
+	command, err := rs.GetProcessCommand(process.ID)
+	require.NoError(t, err)
+
+	args := command[1:]
+	if runtime.GOOS != "windows" {
+		require.Equal(t, []string{"-progress", "pipe:3"}, args[:2])
+		args = args[2:]
+	}
+
+	require.Equal(t, []string{"-loglevel", "+level"}, args[:2])
+	args = args[2:]
+
+	require.Equal(t, rs.(*restream).ffmpeg.Binary(), command[0])
+	require.Equal(t, state.Command, args)
+}
+
+func TestAutostartOrder(t *testing.T) {
+	binary, err := testhelper.BuildBinary("ffmpeg", "../internal/testhelper")
+	require.NoError(t, err, "Failed to build helper program")
+
+	ffmpeg, err := ffmpeg.New(ffmpeg.Config{
+		Binary: binary,
+	})
+	require.NoError(t, err)
+
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	jsonstore, err := store.NewJSON(store.JSONConfig{
+		Filesystem: memfs,
+	})
+	require.NoError(t, err)
+
+	data := store.NewStoreData()
+
+	for i, id := range []string{"process1", "process2", "process3", "process4"} {
+		config := getDummyProcess()
+		config.ID = id
+
+		data.Process[id] = &app.Process{
+			ID:        id,
+			Config:    config,
+			CreatedAt: int64(i + 1),
+			Order:     "start",
+		}
+	}
+
+	data.Process["process1"].Config.AutostartOrder = 0
+	data.Process["process2"].Config.AutostartOrder = 5
+	data.Process["process3"].Config.AutostartOrder = 0
+	data.Process["process4"].Config.AutostartOrder = 2
+
+	err = jsonstore.Store(data)
+	require.NoError(t, err)
+
+	rs, err := New(Config{
+		FFmpeg:            ffmpeg,
+		Store:             jsonstore,
+		AutostartInterval: time.Hour,
+	})
+	require.NoError(t, err)
+
+	rs.Start()
+	defer rs.Stop()
+
+	// The first process starts immediately, the others one interval after each other
+	for id, delay := range map[string]float64{"process4": -1, "process2": 3600, "process1": 7200, "process3": 10800} {
+		state, err := rs.GetProcessState(id)
+		require.NoError(t, err)
+		require.InDelta(t, delay, state.StartIn, 1, id)
+	}
+
+	err = rs.SetAutostartOrder([]string{"process3", "unknown"})
+	require.Equal(t, ErrUnknownProcess, err)
+
+	err = rs.SetAutostartOrder([]string{"process3", "process3"})
+	require.Error(t, err)
+
+	err = rs.SetAutostartOrder([]string{"process3", "process1"})
+	require.NoError(t, err)
+
+	order := []string{}
+	for _, task := range rs.(*restream).autostartTasks() {
+		order = append(order, task.id)
+	}
+
+	require.Equal(t, []string{"process3", "process1", "process2", "process4"}, order)
+
+	process, err := rs.GetProcess("process2")
+	require.NoError(t, err)
+	require.Equal(t, 0, process.Config.AutostartOrder)
+}
+
+func TestProcessReason(t *testing.T) {
+	binary, err := testhelper.BuildBinary("ffmpeg", "../internal/testhelper")
+	require.NoError(t, err, "Failed to build helper program")
+
+	ffmpeg, err := ffmpeg.New(ffmpeg.Config{
+		Binary: binary,
+	})
+	require.NoError(t, err)
+
+	rs, err := New(Config{
+		FFmpeg:        ffmpeg,
+		MaxConcurrent: 1,
+	})
+	require.NoError(t, err)
+
+	for _, id := range []string{"process1", "process2"} {
+		process := getDummyProcess()
+		process.ID = id
+
+		err = rs.AddProcess(process)
+		require.NoError(t, err)
+
+		err = rs.StartProcess(id)
+		require.NoError(t, err)
+	}
+
+	defer rs.StopProcess("process1")
+
+	state, err := rs.GetProcessState("process1")
+	require.NoError(t, err)
+	require.Equal(t, "", state.Reason)
+
+	state, err = rs.GetProcessState("process2")
+	require.NoError(t, err)
+	require.Equal(t, ReasonQueued, state.Reason)
+	require.NotEmpty(t, state.Detail)
+
+	err = rs.StopProcess("process2")
+	require.NoError(t, err)
+
+	state, err = rs.GetProcessState("process2")
+	require.NoError(t, err)
+	require.Equal(t, "", state.Reason)
+
+	// The reasons of a process that exited
+	task := rs.(*restream).tasks["process2"]
+
+	tests := []struct {
+		status process.Status
+		state  app.State
+		reason string
+		detail string
+	}{
+		{process.Status{}, app.State{Order: "start", Reconnect: 5}, ReasonBackoff, "restarting in 5 seconds"},
+		{process.Status{}, app.State{Order: "start", Reconnect: -1}, "", ""},
+		{process.Status{Reason: process.ReasonBinaryNotFound, Detail: "not found"}, app.State{Order: "start", Reconnect: 5}, process.ReasonBinaryNotFound, "not found, restarting in 5 seconds"},
+		{process.Status{}, app.State{Order: "start", Reconnect: -1, LastLog: "tcp://127.0.0.1:1935: Connection refused"}, ReasonAddressUnreachable, "tcp://127.0.0.1:1935: Connection refused"},
+		{process.Status{Breaker: true, Reason: process.ReasonMemoryLimitExceeded, Detail: "too much"}, app.State{Order: "start", Reconnect: -1, Restarts: 3}, ReasonFailedMaxRestarts, "3 restarts within the restart window, last failure: too much"},
+		{process.Status{Reason: process.ReasonStale}, app.State{Order: "stop", Reconnect: -1}, "", ""},
+	}
+
+	for _, test := range tests {
+		reason, detail := taskReason(task, test.status, &test.state)
+		require.Equal(t, test.reason, reason, test)
+		require.Equal(t, test.detail, detail, test)
+	}
+}
+
+func TestProbeWithOptions(t *testing.T) {
+	require.Equal(t, []string{}, probeOptions(app.ProbeOptions{}))
+	require.Equal(t, []string{"-analyzeduration", "5000000", "-probesize", "1048576"}, probeOptions(app.ProbeOptions{
+		AnalyzeDuration: 5 * time.Second,
+		ProbeSize:       1024 * 1024,
+	}))
+
+	rs, err := getDummyRestreamer(nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	process := getDummyProcess()
+
+	err = rs.AddProcess(process)
+	require.NoError(t, err)
+
+	probe := rs.ProbeWithOptions(process.ID, app.ProbeOptions{
+		Timeout:         time.Second,
+		AnalyzeDuration: 5 * time.Second,
+		ProbeSize:       1024 * 1024,
+	})
+	require.Equal(t, 3, len(probe.Streams))
+
+	// The streams of the dummy ffmpeg don't belong to any program
+	probe = rs.ProbeWithOptions(process.ID, app.ProbeOptions{
+		Timeout: time.Second,
+		Program: 1,
+	})
+	require.Equal(t, 0, len(probe.Streams))
+
+	r := rs.(*restream)
+	require.Equal(t, 20*time.Second, r.probeTimeoutOf(0))
+	require.Equal(t, time.Second, r.probeTimeoutOf(time.Second))
+	require.Equal(t, ProbeMaxTimeout, r.probeTimeoutOf(24*time.Hour))
+}
+
+func TestDrain(t *testing.T) {
+	binary, err := testhelper.BuildBinary("ffmpeg", "../internal/testhelper")
+	require.NoError(t, err, "Failed to build helper program")
+
+	ffmpeg, err := ffmpeg.New(ffmpeg.Config{
+		Binary: binary,
+	})
+	require.NoError(t, err)
+
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	jsonstore, err := store.NewJSON(store.JSONConfig{
+		Filesystem: memfs,
+	})
+	require.NoError(t, err)
+
+	rs, err := New(Config{
+		FFmpeg:        ffmpeg,
+		Store:         jsonstore,
+		MaxConcurrent: 2,
+	})
+	require.NoError(t, err)
+
+	for _, id := range []string{"process1", "process2", "process3", "process4"} {
+		config := getDummyProcess()
+		config.ID = id
+
+		err = rs.AddProcess(config)
+		require.NoError(t, err)
+	}
+
+	err = rs.StartProcess("process1")
+	require.NoError(t, err)
+
+	err = rs.StartProcess("process2")
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		state1, _ := rs.GetProcessState("process1")
+		state2, _ := rs.GetProcessState("process2")
+		return state1.State == "running" && state2.State == "running"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	// The queued process is drained, but it is not listed as stopped
+	err = rs.StartProcess("process4")
+	require.NoError(t, err)
+
+	stopped, err := rs.Drain()
+	require.NoError(t, err)
+	require.Equal(t, []string{"process1", "process2"}, stopped)
+
+	for id, order := range map[string]string{"process1": "start", "process2": "start", "process3": "stop", "process4": "start"} {
+		state, err := rs.GetProcessState(id)
+		require.NoError(t, err)
+		require.Equal(t, order, state.Order, id)
+		require.NotEqual(t, "running", state.State, id)
+	}
+
+	state, _ := rs.GetProcessState("process1")
+	require.Equal(t, ReasonDrained, state.Reason)
+
+	// A stopped process will not be started by undraining
+	err = rs.StopProcess("process2")
+	require.NoError(t, err)
+
+	stopped, err = rs.Drain()
+	require.NoError(t, err)
+	require.Empty(t, stopped)
+
+	// The drain marker survives a restart
+	rs, err = New(Config{
+		FFmpeg: ffmpeg,
+		Store:  jsonstore,
+	})
+	require.NoError(t, err)
+
+	rs.Start()
+	defer rs.Stop()
+
+	p, err := rs.GetProcess("process1")
+	require.NoError(t, err)
+	require.True(t, p.Drained)
+	require.Equal(t, "start", p.Order)
+
+	state, _ = rs.GetProcessState("process1")
+	require.Equal(t, "finished", state.State)
+
+	started, err := rs.Undrain()
+	require.NoError(t, err)
+	require.Equal(t, []string{"process1", "process4"}, started)
+
+	p, _ = rs.GetProcess("process1")
+	require.False(t, p.Drained)
+
+	require.Eventually(t, func() bool {
+		state, _ := rs.GetProcessState("process1")
+		return state.State == "running"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	for _, id := range []string{"process2", "process3"} {
+		state, _ := rs.GetProcessState(id)
+		require.Equal(t, "stop", state.Order, id)
+	}
+
+	started, err = rs.Undrain()
+	require.NoError(t, err)
+	require.Empty(t, started)
 }
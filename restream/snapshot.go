@@ -0,0 +1,61 @@
+package restream
+
+// snapshot is an immutable copy of the tasks for the read path of GetProcess,
+// GetProcessState, and GetProcessIDs. A new snapshot is published when the
+// write lock is released after the tasks have been changed (copy-on-write), such
+// that these reads never have to wait for the lock while a process is changed.
+type snapshot struct {
+	tasks     map[string]*task // Copies of the tasks with a cloned process
+	positions map[string]int   // Position in the queue per task ID
+	consumers map[string]int   // Number of consumers per on-demand task ID
+}
+
+// publish replaces the snapshot with a copy of the current tasks. The caller
+// must hold the write lock. The copies share the process, parser, and the other
+// parts that are safe for concurrent use with the tasks.
+func (r *restream) publish() {
+	s := &snapshot{
+		tasks:     make(map[string]*task, len(r.tasks)),
+		positions: map[string]int{},
+		consumers: map[string]int{},
+	}
+
+	for id, t := range r.tasks {
+		c := *t
+		c.process = t.process.Clone()
+
+		s.tasks[id] = &c
+
+		if t.valid && t.config.OnDemand {
+			s.consumers[id] = r.consumers(id)
+		}
+	}
+
+	for i, t := range r.queue {
+		s.positions[t.id] = i + 1
+	}
+
+	r.snapshot.Store(s)
+}
+
+// unlock publishes a new snapshot of the tasks if they have been changed, i.e.
+// r.dirty is set, and releases the write lock. It has to be used instead of
+// r.lock.Unlock().
+func (r *restream) unlock() {
+	if r.dirty {
+		r.publish()
+		r.dirty = false
+	}
+
+	r.lock.Unlock()
+}
+
+// view returns the current snapshot of the tasks.
+func (r *restream) view() *snapshot {
+	s, _ := r.snapshot.Load().(*snapshot)
+	if s == nil {
+		return &snapshot{}
+	}
+
+	return s
+}
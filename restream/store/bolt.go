@@ -0,0 +1,272 @@
+package store
+
+import (
+	"bytes"
+	gojson "encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/datarhei/core/v16/log"
+	"github.com/datarhei/core/v16/restream/app"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type BoltConfig struct {
+	Filepath string // Full path to the database file
+	Logger   log.Logger
+
+	// Store to migrate the data from in case the database is empty,
+	// e.g. a JSON store. Optional.
+	Migrate Store
+}
+
+var (
+	boltBucketMeta            = []byte("meta")
+	boltBucketProcess         = []byte("process")
+	boltBucketProcessMetadata = []byte("process_metadata")
+	boltBucketSystemMetadata  = []byte("system_metadata")
+
+	boltKeyVersion = []byte("version")
+)
+
+// boltStore stores each process, the metadata of each process, and each system metadata
+// as separate keys. Only the keys that changed will be written.
+type boltStore struct {
+	db       *bolt.DB
+	filepath string
+	logger   log.Logger
+
+	// Mutex to serialize access to the backend
+	lock sync.RWMutex
+}
+
+func NewBolt(config BoltConfig) (Store, error) {
+	s := &boltStore{
+		filepath: config.Filepath,
+		logger:   config.Logger,
+	}
+
+	if len(s.filepath) == 0 {
+		return nil, fmt.Errorf("no database file provided")
+	}
+
+	if s.logger == nil {
+		s.logger = log.New("")
+	}
+
+	db, err := bolt.Open(s.filepath, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	s.db = db
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltBucketMeta, boltBucketProcess, boltBucketProcessMetadata, boltBucketSystemMetadata} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		s.db.Close()
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	if config.Migrate != nil {
+		if err := s.migrate(config.Migrate); err != nil {
+			s.db.Close()
+			return nil, fmt.Errorf("failed to migrate data: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// migrate copies the data from the given store if this database is empty.
+func (s *boltStore) migrate(from Store) error {
+	data, err := s.Load()
+	if err != nil {
+		return err
+	}
+
+	if !data.IsEmpty() {
+		return nil
+	}
+
+	data, err = from.Load()
+	if err != nil {
+		return err
+	}
+
+	if data.IsEmpty() {
+		return nil
+	}
+
+	if err := s.Store(data); err != nil {
+		return err
+	}
+
+	s.logger.WithField("file", s.filepath).Info().Log("Migrated data")
+
+	return nil
+}
+
+// Close closes the database.
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *boltStore) Load() (StoreData, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	data := NewStoreData()
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(boltBucketMeta).Get(boltKeyVersion); v != nil {
+			have, err := strconv.ParseUint(string(v), 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid version: %w", err)
+			}
+
+			if have != version {
+				return fmt.Errorf("unsupported version of the database (want: %d, have: %d)", version, have)
+			}
+		}
+
+		err := tx.Bucket(boltBucketProcess).ForEach(func(k, v []byte) error {
+			p := &app.Process{}
+			if err := gojson.Unmarshal(v, p); err != nil {
+				return fmt.Errorf("invalid process '%s': %w", string(k), err)
+			}
+
+			data.Process[string(k)] = p
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = tx.Bucket(boltBucketProcessMetadata).ForEach(func(k, v []byte) error {
+			m := map[string]interface{}{}
+			if err := gojson.Unmarshal(v, &m); err != nil {
+				return fmt.Errorf("invalid metadata of process '%s': %w", string(k), err)
+			}
+
+			data.Metadata.Process[string(k)] = m
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(boltBucketSystemMetadata).ForEach(func(k, v []byte) error {
+			var m interface{}
+			if err := gojson.Unmarshal(v, &m); err != nil {
+				return fmt.Errorf("invalid system metadata '%s': %w", string(k), err)
+			}
+
+			data.Metadata.System[string(k)] = m
+
+			return nil
+		})
+	})
+	if err != nil {
+		return NewStoreData(), err
+	}
+
+	s.logger.WithField("file", s.filepath).Debug().Log("Read data")
+
+	return data, nil
+}
+
+func (s *boltStore) Store(data StoreData) error {
+	if data.Version != version {
+		return fmt.Errorf("invalid version (have: %d, want: %d)", data.Version, version)
+	}
+
+	processes := map[string]interface{}{}
+	for id, p := range data.Process {
+		processes[id] = p
+	}
+
+	processMetadata := map[string]interface{}{}
+	for id, m := range data.Metadata.Process {
+		processMetadata[id] = m
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// All changes are written in one transaction, i.e. either all or none are applied.
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltBucketMeta).Put(boltKeyVersion, []byte(strconv.FormatUint(version, 10))); err != nil {
+			return err
+		}
+
+		if err := boltSync(tx.Bucket(boltBucketProcess), processes); err != nil {
+			return err
+		}
+
+		if err := boltSync(tx.Bucket(boltBucketProcessMetadata), processMetadata); err != nil {
+			return err
+		}
+
+		return boltSync(tx.Bucket(boltBucketSystemMetadata), data.Metadata.System)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store data: %w", err)
+	}
+
+	s.logger.WithField("file", s.filepath).Debug().Log("Stored data")
+
+	return nil
+}
+
+// boltSync writes the values to the bucket such that the bucket contains exactly
+// these values afterwards. Unchanged values will not be written.
+func boltSync(b *bolt.Bucket, values map[string]interface{}) error {
+	remove := [][]byte{}
+
+	err := b.ForEach(func(k, v []byte) error {
+		if _, ok := values[string(k)]; !ok {
+			remove = append(remove, k)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range remove {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	for k, value := range values {
+		v, err := gojson.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("failed to encode '%s': %w", k, err)
+		}
+
+		if bytes.Equal(b.Get([]byte(k)), v) {
+			continue
+		}
+
+		if err := b.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
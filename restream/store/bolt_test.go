@@ -0,0 +1,114 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/datarhei/core/v16/io/fs"
+	"github.com/datarhei/core/v16/restream/app"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoltStore(t *testing.T) {
+	dbpath := filepath.Join(t.TempDir(), "db.bolt")
+
+	store, err := NewBolt(BoltConfig{
+		Filepath: dbpath,
+	})
+	require.NoError(t, err)
+
+	data, err := store.Load()
+	require.NoError(t, err)
+	require.Equal(t, true, data.IsEmpty())
+
+	data.Process["foobar"] = &app.Process{
+		ID:        "foobar",
+		Reference: "ref",
+		Config: &app.Config{
+			ID: "foobar",
+		},
+	}
+	data.Metadata.Process["foobar"] = map[string]interface{}{"foo": "bar"}
+	data.Metadata.System["somedata"] = "foobar"
+
+	err = store.Store(data)
+	require.NoError(t, err)
+
+	data2, err := store.Load()
+	require.NoError(t, err)
+	require.Equal(t, data, data2)
+
+	delete(data.Process, "foobar")
+	delete(data.Metadata.Process, "foobar")
+
+	err = store.Store(data)
+	require.NoError(t, err)
+
+	data2, err = store.Load()
+	require.NoError(t, err)
+	require.Equal(t, data, data2)
+
+	err = store.(*boltStore).Close()
+	require.NoError(t, err)
+
+	store, err = NewBolt(BoltConfig{
+		Filepath: dbpath,
+	})
+	require.NoError(t, err)
+
+	defer store.(*boltStore).Close()
+
+	data2, err = store.Load()
+	require.NoError(t, err)
+	require.Equal(t, data, data2)
+}
+
+func TestBoltStoreInvalidVersion(t *testing.T) {
+	store, err := NewBolt(BoltConfig{
+		Filepath: filepath.Join(t.TempDir(), "db.bolt"),
+	})
+	require.NoError(t, err)
+
+	defer store.(*boltStore).Close()
+
+	data := NewStoreData()
+	data.Version = 3
+
+	err = store.Store(data)
+	require.Error(t, err)
+}
+
+func TestBoltStoreMigrate(t *testing.T) {
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	jsonstore, err := NewJSON(JSONConfig{
+		Filesystem: memfs,
+	})
+	require.NoError(t, err)
+
+	data := NewStoreData()
+	data.Process["foobar"] = &app.Process{
+		ID: "foobar",
+		Config: &app.Config{
+			ID: "foobar",
+		},
+	}
+	data.Metadata.System["somedata"] = "foobar"
+
+	err = jsonstore.Store(data)
+	require.NoError(t, err)
+
+	store, err := NewBolt(BoltConfig{
+		Filepath: filepath.Join(t.TempDir(), "db.bolt"),
+		Migrate:  jsonstore,
+	})
+	require.NoError(t, err)
+
+	defer store.(*boltStore).Close()
+
+	data2, err := store.Load()
+	require.NoError(t, err)
+	require.Equal(t, data, data2)
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// Encrypted data starts with this header followed by the version of
+// the encryption format, the nonce, and the sealed data.
+var cryptHeader = []byte("DRCORE-ENC")
+
+const cryptVersion byte = 1
+
+// isEncrypted returns whether the data has been encrypted with encrypt.
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, cryptHeader)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encrypt encrypts the data with AES-GCM. The key must be 16, 24, or 32 bytes long.
+func encrypt(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(cryptHeader)+1+len(nonce)+len(data)+gcm.Overhead())
+	out = append(out, cryptHeader...)
+	out = append(out, cryptVersion)
+	out = append(out, nonce...)
+	out = gcm.Seal(out, nonce, data, cryptHeader)
+
+	return out, nil
+}
+
+// decrypt decrypts data that has been encrypted with encrypt.
+func decrypt(key, data []byte) ([]byte, error) {
+	if !isEncrypted(data) {
+		return nil, fmt.Errorf("data is not encrypted")
+	}
+
+	data = data[len(cryptHeader):]
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("missing version of the encryption format")
+	}
+
+	if data[0] != cryptVersion {
+		return nil, fmt.Errorf("unsupported version of the encryption format (want: %d, have: %d)", cryptVersion, data[0])
+	}
+
+	data = data[1:]
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted data is too short")
+	}
+
+	nonce, data := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, data, cryptHeader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data, possibly the wrong key: %w", err)
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,524 @@
+package store
+
+import (
+	"bytes"
+	gojson "encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/datarhei/core/v16/io/fs"
+	"github.com/datarhei/core/v16/log"
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+type JournalConfig struct {
+	Filesystem fs.Filesystem
+	Filepath   string // Full path to the database file. The journal is stored in the directory Filepath + ".journal"
+	Logger     log.Logger
+
+	// Number of journal entries after which the journal will be compacted into
+	// the database file. Optional. Default value 100.
+	CompactAfter int
+
+	// Key for encrypting the database file and the journal entries. See JSONConfig.
+	EncryptionKey []byte
+}
+
+// Operations that are recorded in the journal
+const (
+	journalOpSnapshot              = "snapshot"
+	journalOpProcess               = "process"
+	journalOpProcessDelete         = "process_delete"
+	journalOpProcessMetadata       = "process_metadata"
+	journalOpProcessMetadataDelete = "process_metadata_delete"
+	journalOpSystemMetadata        = "system_metadata"
+	journalOpSystemMetadataDelete  = "system_metadata_delete"
+)
+
+type journalMutation struct {
+	Op    string            `json:"op"`
+	Key   string            `json:"key,omitempty"`
+	Value gojson.RawMessage `json:"value,omitempty"`
+}
+
+// journalEntry holds all mutations of one call to Store. Each entry is written
+// to its own file such that an entry is either written completely or not at all.
+type journalEntry struct {
+	Seq       uint64            `json:"seq"`
+	Mutations []journalMutation `json:"mutations"`
+}
+
+// journalState is the current state of the data, with each value in its JSON representation.
+type journalState struct {
+	process         map[string]gojson.RawMessage
+	processMetadata map[string]gojson.RawMessage
+	systemMetadata  map[string]gojson.RawMessage
+}
+
+func newJournalState() journalState {
+	return journalState{
+		process:         make(map[string]gojson.RawMessage),
+		processMetadata: make(map[string]gojson.RawMessage),
+		systemMetadata:  make(map[string]gojson.RawMessage),
+	}
+}
+
+// journalStore records every change of the data in an append-only journal. On load, the journal will be
+// replayed in order to reconstruct the data, even if the database file is corrupt. Periodically,
+// the journal is compacted into the database file.
+type journalStore struct {
+	fs           fs.Filesystem
+	filepath     string
+	journalpath  string
+	logger       log.Logger
+	compactAfter int
+	key          []byte
+
+	snapshot *jsonStore
+
+	state   journalState
+	loaded  bool
+	seq     uint64
+	entries int
+
+	// Mutex to serialize access to the backend
+	lock sync.Mutex
+}
+
+func NewJournal(config JournalConfig) (Store, error) {
+	s := &journalStore{
+		fs:           config.Filesystem,
+		filepath:     config.Filepath,
+		logger:       config.Logger,
+		compactAfter: config.CompactAfter,
+		key:          config.EncryptionKey,
+		state:        newJournalState(),
+	}
+
+	if len(s.filepath) == 0 {
+		s.filepath = "/db.json"
+	}
+
+	s.journalpath = s.filepath + ".journal"
+
+	if s.compactAfter <= 0 {
+		s.compactAfter = 100
+	}
+
+	if s.logger == nil {
+		s.logger = log.New("")
+	}
+
+	snapshot, err := NewJSON(JSONConfig{
+		Filesystem:    s.fs,
+		Filepath:      s.filepath,
+		Logger:        s.logger,
+		EncryptionKey: s.key,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.snapshot = snapshot.(*jsonStore)
+
+	return s, nil
+}
+
+func (s *journalStore) Load() (StoreData, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.load(); err != nil {
+		return NewStoreData(), err
+	}
+
+	return s.state.data()
+}
+
+func (s *journalStore) Store(data StoreData) error {
+	if data.Version != version {
+		return fmt.Errorf("invalid version (have: %d, want: %d)", data.Version, version)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.loaded {
+		if err := s.load(); err != nil {
+			return fmt.Errorf("failed to store data: %w", err)
+		}
+	}
+
+	state, err := newJournalStateFromData(data)
+	if err != nil {
+		return fmt.Errorf("failed to store data: %w", err)
+	}
+
+	mutations := s.state.diff(state)
+	if len(mutations) == 0 {
+		return nil
+	}
+
+	if err := s.append(mutations); err != nil {
+		return fmt.Errorf("failed to store data: %w", err)
+	}
+
+	s.state = state
+
+	if s.entries >= s.compactAfter {
+		if err := s.compact(); err != nil {
+			s.logger.Warn().WithError(err).Log("Compacting journal failed")
+		}
+	}
+
+	return nil
+}
+
+// load reads the database file and replays the journal.
+func (s *journalStore) load() error {
+	state := newJournalState()
+
+	data, snapshotErr := s.snapshot.Load()
+	if snapshotErr == nil {
+		var err error
+		state, err = newJournalStateFromData(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	entries, last, err := s.readJournal()
+	if err != nil {
+		return err
+	}
+
+	// The sequence numbers of the removed entries won't be used again
+	s.seq = last
+
+	hasSnapshot := false
+	for _, e := range entries {
+		for _, m := range e.Mutations {
+			if m.Op == journalOpSnapshot {
+				hasSnapshot = true
+			}
+
+			if err := state.apply(m); err != nil {
+				return fmt.Errorf("invalid journal entry %d: %w", e.Seq, err)
+			}
+		}
+	}
+
+	if snapshotErr != nil {
+		if !hasSnapshot {
+			return snapshotErr
+		}
+
+		s.logger.Warn().WithError(snapshotErr).WithField("file", s.filepath).Log("Database file is corrupt, recovered data from the journal")
+	}
+
+	s.state = state
+	s.entries = len(entries)
+	s.loaded = true
+
+	// Make sure the journal contains the complete data such that it doesn't depend on the database file
+	if !hasSnapshot {
+		if err := s.append(s.state.snapshot()); err != nil {
+			return err
+		}
+	}
+
+	s.logger.WithFields(log.Fields{
+		"file":    s.filepath,
+		"entries": len(entries),
+	}).Debug().Log("Read data")
+
+	return nil
+}
+
+// readJournal returns all entries of the journal in order and the highest sequence number
+// of all entry files. Entries that can't be read stop the replay. The invalid entry and all
+// following entries are removed, such that they will not be replayed over newer entries
+// after a later compaction.
+func (s *journalStore) readJournal() ([]journalEntry, uint64, error) {
+	files := s.fs.List(s.journalpath, s.journalpath+"/*.json")
+
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+
+	sort.Strings(names)
+
+	entries := []journalEntry{}
+	last := uint64(0)
+
+	for i, name := range names {
+		if seq, ok := s.entrySeq(name); ok && seq > last {
+			last = seq
+		}
+
+		data, err := s.fs.ReadFile(name)
+		if err == nil && isEncrypted(data) {
+			if len(s.key) == 0 {
+				return nil, 0, fmt.Errorf("the journal is encrypted, but no encryption key has been provided")
+			}
+
+			data, err = decrypt(s.key, data)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+
+		e := journalEntry{}
+
+		if err == nil {
+			err = gojson.Unmarshal(data, &e)
+		}
+
+		if err != nil {
+			s.logger.Warn().WithError(err).WithField("file", name).Log("Invalid journal entry, ignoring this and all following entries")
+
+			for _, name := range names[i:] {
+				if seq, ok := s.entrySeq(name); ok && seq > last {
+					last = seq
+				}
+
+				s.fs.Remove(name)
+			}
+
+			break
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, last, nil
+}
+
+// entrySeq returns the sequence number of a journal entry from its path.
+func (s *journalStore) entrySeq(path string) (uint64, bool) {
+	name := strings.TrimSuffix(strings.TrimPrefix(path, s.journalpath+"/"), ".json")
+
+	seq, err := strconv.ParseUint(name, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return seq, true
+}
+
+// append writes the mutations as a new entry to the journal.
+func (s *journalStore) append(mutations []journalMutation) error {
+	e := journalEntry{
+		Seq:       s.seq + 1,
+		Mutations: mutations,
+	}
+
+	data, err := gojson.Marshal(&e)
+	if err != nil {
+		return err
+	}
+
+	if len(s.key) != 0 {
+		data, err = encrypt(s.key, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, _, err := s.fs.WriteFileSafe(s.entryPath(e.Seq), data); err != nil {
+		return err
+	}
+
+	s.seq = e.Seq
+	s.entries++
+
+	return nil
+}
+
+func (s *journalStore) entryPath(seq uint64) string {
+	return fmt.Sprintf("%s/%020d.json", s.journalpath, seq)
+}
+
+// compact writes the current data to the database file and replaces the journal
+// with a single entry holding the current data.
+func (s *journalStore) compact() error {
+	data, err := s.state.data()
+	if err != nil {
+		return err
+	}
+
+	if err := s.snapshot.Store(data); err != nil {
+		return err
+	}
+
+	last := s.seq
+
+	if err := s.append(s.state.snapshot()); err != nil {
+		return err
+	}
+
+	// Remove the older entries only after the new complete entry has been written
+	for _, f := range s.fs.List(s.journalpath, s.journalpath+"/*.json") {
+		seq, ok := s.entrySeq(f.Name())
+		if !ok || seq > last {
+			continue
+		}
+
+		s.fs.Remove(f.Name())
+	}
+
+	s.entries = 1
+
+	s.logger.WithField("file", s.filepath).Debug().Log("Compacted journal")
+
+	return nil
+}
+
+func newJournalStateFromData(data StoreData) (journalState, error) {
+	state := newJournalState()
+
+	for id, p := range data.Process {
+		v, err := gojson.Marshal(p)
+		if err != nil {
+			return state, err
+		}
+
+		state.process[id] = v
+	}
+
+	for id, m := range data.Metadata.Process {
+		v, err := gojson.Marshal(m)
+		if err != nil {
+			return state, err
+		}
+
+		state.processMetadata[id] = v
+	}
+
+	for key, m := range data.Metadata.System {
+		v, err := gojson.Marshal(m)
+		if err != nil {
+			return state, err
+		}
+
+		state.systemMetadata[key] = v
+	}
+
+	return state, nil
+}
+
+// data returns the state as StoreData.
+func (j journalState) data() (StoreData, error) {
+	data := NewStoreData()
+
+	for id, v := range j.process {
+		p := &app.Process{}
+		if err := gojson.Unmarshal(v, p); err != nil {
+			return NewStoreData(), fmt.Errorf("invalid process '%s': %w", id, err)
+		}
+
+		data.Process[id] = p
+	}
+
+	for id, v := range j.processMetadata {
+		m := map[string]interface{}{}
+		if err := gojson.Unmarshal(v, &m); err != nil {
+			return NewStoreData(), fmt.Errorf("invalid metadata of process '%s': %w", id, err)
+		}
+
+		data.Metadata.Process[id] = m
+	}
+
+	for key, v := range j.systemMetadata {
+		var m interface{}
+		if err := gojson.Unmarshal(v, &m); err != nil {
+			return NewStoreData(), fmt.Errorf("invalid system metadata '%s': %w", key, err)
+		}
+
+		data.Metadata.System[key] = m
+	}
+
+	return data, nil
+}
+
+// snapshot returns the mutations that are necessary to reconstruct the complete state.
+func (j journalState) snapshot() []journalMutation {
+	mutations := []journalMutation{
+		{Op: journalOpSnapshot},
+	}
+
+	return append(mutations, newJournalState().diff(j)...)
+}
+
+// diff returns the mutations that are necessary to get from this state to the other state.
+func (j journalState) diff(other journalState) []journalMutation {
+	mutations := []journalMutation{}
+
+	mutations = append(mutations, diffValues(j.process, other.process, journalOpProcess, journalOpProcessDelete)...)
+	mutations = append(mutations, diffValues(j.processMetadata, other.processMetadata, journalOpProcessMetadata, journalOpProcessMetadataDelete)...)
+	mutations = append(mutations, diffValues(j.systemMetadata, other.systemMetadata, journalOpSystemMetadata, journalOpSystemMetadataDelete)...)
+
+	return mutations
+}
+
+func diffValues(from, to map[string]gojson.RawMessage, opSet, opDelete string) []journalMutation {
+	mutations := []journalMutation{}
+
+	keys := []string{}
+	for key := range to {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if v, ok := from[key]; ok && bytes.Equal(v, to[key]) {
+			continue
+		}
+
+		mutations = append(mutations, journalMutation{Op: opSet, Key: key, Value: to[key]})
+	}
+
+	keys = []string{}
+	for key := range from {
+		if _, ok := to[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		mutations = append(mutations, journalMutation{Op: opDelete, Key: key})
+	}
+
+	return mutations
+}
+
+// apply applies a mutation to the state.
+func (j *journalState) apply(m journalMutation) error {
+	switch m.Op {
+	case journalOpSnapshot:
+		*j = newJournalState()
+	case journalOpProcess:
+		j.process[m.Key] = m.Value
+	case journalOpProcessDelete:
+		delete(j.process, m.Key)
+	case journalOpProcessMetadata:
+		j.processMetadata[m.Key] = m.Value
+	case journalOpProcessMetadataDelete:
+		delete(j.processMetadata, m.Key)
+	case journalOpSystemMetadata:
+		j.systemMetadata[m.Key] = m.Value
+	case journalOpSystemMetadataDelete:
+		delete(j.systemMetadata, m.Key)
+	default:
+		return fmt.Errorf("unknown operation '%s'", m.Op)
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/datarhei/core/v16/io/fs"
+	"github.com/datarhei/core/v16/restream/app"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournalStore(t *testing.T) {
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	store, err := NewJournal(JournalConfig{
+		Filesystem: memfs,
+	})
+	require.NoError(t, err)
+
+	data, err := store.Load()
+	require.NoError(t, err)
+	require.Equal(t, true, data.IsEmpty())
+
+	data.Process["foobar"] = &app.Process{
+		ID: "foobar",
+		Config: &app.Config{
+			ID: "foobar",
+		},
+	}
+	data.Metadata.Process["foobar"] = map[string]interface{}{"foo": "bar"}
+	data.Metadata.System["somedata"] = "foobar"
+
+	err = store.Store(data)
+	require.NoError(t, err)
+
+	delete(data.Metadata.Process, "foobar")
+
+	err = store.Store(data)
+	require.NoError(t, err)
+
+	// The database file has not been written yet
+	_, err = memfs.Stat("/db.json")
+	require.Error(t, err)
+
+	store, err = NewJournal(JournalConfig{
+		Filesystem: memfs,
+	})
+	require.NoError(t, err)
+
+	data2, err := store.Load()
+	require.NoError(t, err)
+	require.Equal(t, data, data2)
+}
+
+func TestJournalStoreRecovery(t *testing.T) {
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	store, err := NewJournal(JournalConfig{
+		Filesystem:   memfs,
+		CompactAfter: 3,
+	})
+	require.NoError(t, err)
+
+	data, err := store.Load()
+	require.NoError(t, err)
+
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		data.Process[id] = &app.Process{
+			ID: id,
+			Config: &app.Config{
+				ID: id,
+			},
+		}
+
+		err = store.Store(data)
+		require.NoError(t, err)
+	}
+
+	// The journal has been compacted
+	_, err = memfs.Stat("/db.json")
+	require.NoError(t, err)
+	require.Less(t, len(memfs.List("/db.json.journal", "/db.json.journal/*.json")), 6)
+
+	// Truncate the database file
+	_, _, err = memfs.WriteFile("/db.json", []byte{})
+	require.NoError(t, err)
+
+	store, err = NewJournal(JournalConfig{
+		Filesystem:   memfs,
+		CompactAfter: 3,
+	})
+	require.NoError(t, err)
+
+	data2, err := store.Load()
+	require.NoError(t, err)
+	require.Equal(t, data, data2)
+}
+
+func TestJournalStoreCorruptEntry(t *testing.T) {
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	store, err := NewJournal(JournalConfig{
+		Filesystem: memfs,
+	})
+	require.NoError(t, err)
+
+	data, err := store.Load()
+	require.NoError(t, err)
+
+	// The first entry is the snapshot of the empty data, the processes are in the entries 2 to 6
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		data.Process[id] = &app.Process{
+			ID: id,
+			Config: &app.Config{
+				ID: id,
+			},
+		}
+
+		err = store.Store(data)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 6, len(memfs.List("/db.json.journal", "/db.json.journal/*.json")))
+
+	// Corrupt the 4th of the 6 entries
+	_, _, err = memfs.WriteFile("/db.json.journal/00000000000000000004.json", []byte("{"))
+	require.NoError(t, err)
+
+	store, err = NewJournal(JournalConfig{
+		Filesystem: memfs,
+	})
+	require.NoError(t, err)
+
+	data, err = store.Load()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"a", "b"}, processIDs(data))
+
+	// The invalid and the following entries are not part of the journal anymore
+	require.Equal(t, 3, len(memfs.List("/db.json.journal", "/db.json.journal/*.json")))
+
+	data.Process["x"] = &app.Process{
+		ID: "x",
+		Config: &app.Config{
+			ID: "x",
+		},
+	}
+
+	err = store.Store(data)
+	require.NoError(t, err)
+
+	// The new entry doesn't reuse the sequence number of a removed entry
+	_, err = memfs.Stat("/db.json.journal/00000000000000000007.json")
+	require.NoError(t, err)
+
+	// Compact the journal and check that none of the removed entries comes back
+	store, err = NewJournal(JournalConfig{
+		Filesystem:   memfs,
+		CompactAfter: 2,
+	})
+	require.NoError(t, err)
+
+	data, err = store.Load()
+	require.NoError(t, err)
+
+	data.Process["y"] = data.Process["a"]
+
+	err = store.Store(data)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, len(memfs.List("/db.json.journal", "/db.json.journal/*.json")))
+
+	store, err = NewJournal(JournalConfig{
+		Filesystem: memfs,
+	})
+	require.NoError(t, err)
+
+	data, err = store.Load()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"a", "b", "x", "y"}, processIDs(data))
+}
+
+func processIDs(data StoreData) []string {
+	ids := []string{}
+	for id := range data.Process {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
@@ -1,10 +1,12 @@
 package store
 
 import (
+	"crypto/sha256"
 	gojson "encoding/json"
 	"fmt"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/datarhei/core/v16/encoding/json"
 	"github.com/datarhei/core/v16/io/fs"
@@ -15,12 +17,23 @@ type JSONConfig struct {
 	Filesystem fs.Filesystem
 	Filepath   string // Full path to the database file
 	Logger     log.Logger
+
+	// Key for encrypting the database file with AES-GCM. It must be 16, 24, or 32 bytes
+	// long. Optional. If not provided, the database file will be stored unencrypted.
+	// An unencrypted database file will be encrypted with the next write.
+	EncryptionKey []byte
 }
 
 type jsonStore struct {
 	fs       fs.Filesystem
 	filepath string
 	logger   log.Logger
+	key      []byte
+
+	// Fingerprint of the database file as it has been read or written last
+	fileHash    [sha256.Size]byte
+	fileModTime time.Time
+	fileSize    int64
 
 	// Mutex to serialize access to the backend
 	lock sync.RWMutex
@@ -33,6 +46,7 @@ func NewJSON(config JSONConfig) (Store, error) {
 		fs:       config.Filesystem,
 		filepath: config.Filepath,
 		logger:   config.Logger,
+		key:      config.EncryptionKey,
 	}
 
 	if len(s.filepath) == 0 {
@@ -47,6 +61,12 @@ func NewJSON(config JSONConfig) (Store, error) {
 		s.logger = log.New("")
 	}
 
+	if len(s.key) != 0 {
+		if _, err := newGCM(s.key); err != nil {
+			return nil, err
+		}
+	}
+
 	return s, nil
 }
 
@@ -69,8 +89,8 @@ func (s *jsonStore) Store(data StoreData) error {
 		return fmt.Errorf("invalid version (have: %d, want: %d)", data.Version, version)
 	}
 
-	s.lock.RLock()
-	defer s.lock.RUnlock()
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
 	err := s.store(s.filepath, data)
 	if err != nil {
@@ -86,11 +106,20 @@ func (s *jsonStore) store(filepath string, data StoreData) error {
 		return err
 	}
 
+	if len(s.key) != 0 {
+		jsondata, err = encrypt(s.key, jsondata)
+		if err != nil {
+			return err
+		}
+	}
+
 	_, _, err = s.fs.WriteFileSafe(filepath, jsondata)
 	if err != nil {
 		return err
 	}
 
+	s.setFingerprint(filepath, jsondata)
+
 	s.logger.WithField("file", filepath).Debug().Log("Stored data")
 
 	return nil
@@ -117,6 +146,19 @@ func (s *jsonStore) load(filepath string, version uint64) (StoreData, error) {
 		return r, err
 	}
 
+	s.setFingerprint(filepath, jsondata)
+
+	if isEncrypted(jsondata) {
+		if len(s.key) == 0 {
+			return r, fmt.Errorf("the DB file is encrypted, but no encryption key has been provided")
+		}
+
+		jsondata, err = decrypt(s.key, jsondata)
+		if err != nil {
+			return r, err
+		}
+	}
+
 	var db storeVersion
 
 	if err = gojson.Unmarshal(jsondata, &db); err != nil {
@@ -135,3 +177,48 @@ func (s *jsonStore) load(filepath string, version uint64) (StoreData, error) {
 
 	return r, nil
 }
+
+// setFingerprint remembers the contents of the database file in order to
+// detect changes by other writers.
+func (s *jsonStore) setFingerprint(filepath string, data []byte) {
+	s.fileHash = sha256.Sum256(data)
+	s.fileModTime = time.Time{}
+	s.fileSize = -1
+
+	if info, err := s.fs.Stat(filepath); err == nil {
+		s.fileModTime = info.ModTime()
+		s.fileSize = info.Size()
+	}
+}
+
+func (s *jsonStore) Changed() (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	info, err := s.fs.Stat(s.filepath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	if info.ModTime().Equal(s.fileModTime) && info.Size() == s.fileSize {
+		return false, nil
+	}
+
+	data, err := s.fs.ReadFile(s.filepath)
+	if err != nil {
+		return false, err
+	}
+
+	if sha256.Sum256(data) == s.fileHash {
+		s.fileModTime = info.ModTime()
+		s.fileSize = info.Size()
+
+		return false, nil
+	}
+
+	return true, nil
+}
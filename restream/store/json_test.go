@@ -110,3 +110,69 @@ func TestInvalidVersion(t *testing.T) {
 	require.Error(t, err)
 	require.Equal(t, true, data.IsEmpty())
 }
+
+func TestStoreEncrypted(t *testing.T) {
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	// Plain database file as it has been written before
+	store, err := NewJSON(JSONConfig{
+		Filesystem: memfs,
+	})
+	require.NoError(t, err)
+
+	data := NewStoreData()
+	data.Metadata.System["passphrase"] = "secret"
+
+	err = store.Store(data)
+	require.NoError(t, err)
+
+	store, err = NewJSON(JSONConfig{
+		Filesystem:    memfs,
+		EncryptionKey: key,
+	})
+	require.NoError(t, err)
+
+	data2, err := store.Load()
+	require.NoError(t, err)
+	require.Equal(t, data, data2)
+
+	err = store.Store(data)
+	require.NoError(t, err)
+
+	raw, err := memfs.ReadFile("/db.json")
+	require.NoError(t, err)
+	require.True(t, isEncrypted(raw))
+	require.NotContains(t, string(raw), "secret")
+
+	data2, err = store.Load()
+	require.NoError(t, err)
+	require.Equal(t, data, data2)
+
+	store, err = NewJSON(JSONConfig{
+		Filesystem:    memfs,
+		EncryptionKey: []byte("fedcba9876543210fedcba9876543210"),
+	})
+	require.NoError(t, err)
+
+	_, err = store.Load()
+	require.ErrorContains(t, err, "wrong key")
+
+	store, err = NewJSON(JSONConfig{
+		Filesystem: memfs,
+	})
+	require.NoError(t, err)
+
+	_, err = store.Load()
+	require.ErrorContains(t, err, "no encryption key")
+}
+
+func TestStoreInvalidEncryptionKey(t *testing.T) {
+	_, err := NewJSON(JSONConfig{
+		Filesystem:    getFS(t),
+		EncryptionKey: []byte("foobar"),
+	})
+	require.Error(t, err)
+}
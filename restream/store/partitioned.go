@@ -0,0 +1,359 @@
+package store
+
+import (
+	"crypto/sha256"
+	gojson "encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/datarhei/core/v16/io/fs"
+	"github.com/datarhei/core/v16/log"
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+type PartitionedConfig struct {
+	Filesystem fs.Filesystem
+	Filepath   string // Full path to the database file for the processes without a tenant, e.g. /db.json. The processes of a tenant are stored in /db.<tenant>.json
+	Logger     log.Logger
+
+	// Key for encrypting the database files. See JSONConfig.
+	EncryptionKey []byte
+}
+
+var reTenant = regexp.MustCompile(`^[A-Za-z0-9_-]*$`)
+
+// IsValidTenant returns whether the tenant can be used as the name of a partition.
+// The empty tenant is valid and selects the default partition.
+func IsValidTenant(tenant string) bool {
+	return reTenant.MatchString(tenant)
+}
+
+// partitionedStore stores the processes of each tenant in a separate JSON file.
+// The processes without a tenant and the system metadata are stored in the
+// default partition. Only the partitions whose data changed will be written.
+type partitionedStore struct {
+	fs     fs.Filesystem
+	prefix string // Path of the database files without the extension
+	ext    string // Extension of the database files, e.g. ".json"
+	logger log.Logger
+	key    []byte
+
+	partitions map[string]*jsonStore
+	hashes     map[string][sha256.Size]byte   // Hash of the data of each partition as it has been read or written last
+	ids        map[string]map[string]struct{} // IDs of the processes in each partition as they have been read or written last
+
+	// Mutex to serialize access to the backend
+	lock sync.Mutex
+}
+
+func NewPartitioned(config PartitionedConfig) (Store, error) {
+	s := &partitionedStore{
+		fs:         config.Filesystem,
+		logger:     config.Logger,
+		key:        config.EncryptionKey,
+		partitions: map[string]*jsonStore{},
+		hashes:     map[string][sha256.Size]byte{},
+		ids:        map[string]map[string]struct{}{},
+	}
+
+	path := config.Filepath
+	if len(path) == 0 {
+		path = "/db.json"
+	}
+
+	s.ext = filepath.Ext(path)
+	s.prefix = strings.TrimSuffix(path, s.ext)
+
+	if s.fs == nil {
+		return nil, fmt.Errorf("no valid filesystem provided")
+	}
+
+	if s.logger == nil {
+		s.logger = log.New("")
+	}
+
+	if len(s.key) != 0 {
+		if _, err := newGCM(s.key); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// filepath returns the path of the database file of the partition of a tenant.
+func (s *partitionedStore) filepath(tenant string) string {
+	if len(tenant) == 0 {
+		return s.prefix + s.ext
+	}
+
+	return s.prefix + "." + tenant + s.ext
+}
+
+// partition returns the store for the partition of a tenant.
+func (s *partitionedStore) partition(tenant string) (*jsonStore, error) {
+	if p, ok := s.partitions[tenant]; ok {
+		return p, nil
+	}
+
+	if !IsValidTenant(tenant) {
+		return nil, fmt.Errorf("invalid tenant '%s'", tenant)
+	}
+
+	js, err := NewJSON(JSONConfig{
+		Filesystem:    s.fs,
+		Filepath:      s.filepath(tenant),
+		Logger:        s.logger.WithField("tenant", tenant),
+		EncryptionKey: s.key,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p := js.(*jsonStore)
+	s.partitions[tenant] = p
+
+	return p, nil
+}
+
+// tenants returns the tenants that have a partition on the filesystem.
+func (s *partitionedStore) tenants() []string {
+	tenants := []string{}
+
+	for _, f := range s.fs.List(filepath.Dir(s.prefix), s.prefix+".*"+s.ext) {
+		tenant := strings.TrimSuffix(strings.TrimPrefix(f.Name(), s.prefix+"."), s.ext)
+
+		if len(tenant) == 0 || !IsValidTenant(tenant) {
+			continue
+		}
+
+		tenants = append(tenants, tenant)
+	}
+
+	sort.Strings(tenants)
+
+	return tenants
+}
+
+func (s *partitionedStore) Load() (StoreData, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data := NewStoreData()
+
+	tenants := append([]string{""}, s.tenants()...)
+	parts := map[string]StoreData{}
+
+	// The tenant of the partition each process will be taken from
+	owners := map[string]string{}
+
+	for _, tenant := range tenants {
+		p, err := s.partition(tenant)
+		if err != nil {
+			return NewStoreData(), err
+		}
+
+		part, err := p.load(p.filepath, version)
+		if err != nil {
+			return NewStoreData(), fmt.Errorf("failed to load the partition of the tenant '%s': %w", tenant, err)
+		}
+
+		part.sanitize()
+
+		parts[tenant] = part
+
+		ids := map[string]struct{}{}
+
+		for id, process := range part.Process {
+			ids[id] = struct{}{}
+
+			owner, ok := owners[id]
+			if !ok {
+				owners[id] = tenant
+				continue
+			}
+
+			// A process may be stored in more than one partition if writing the
+			// partitions has been interrupted after its tenant changed.
+			keep := owner
+			if isPreferredProcess(process, tenant, parts[owner].Process[id], owner) {
+				keep = tenant
+			}
+
+			s.logger.Warn().WithFields(log.Fields{
+				"id":      id,
+				"tenants": []string{owner, tenant},
+				"keep":    keep,
+			}).Log("Process is stored in more than one partition")
+
+			owners[id] = keep
+		}
+
+		s.ids[tenant] = ids
+
+		if hash, err := hashStoreData(part); err == nil {
+			s.hashes[tenant] = hash
+		}
+	}
+
+	for _, tenant := range tenants {
+		part := parts[tenant]
+
+		for id, process := range part.Process {
+			if owners[id] != tenant {
+				continue
+			}
+
+			data.Process[id] = process
+		}
+
+		for id, metadata := range part.Metadata.Process {
+			if owner, ok := owners[id]; ok && owner != tenant {
+				continue
+			}
+
+			data.Metadata.Process[id] = metadata
+		}
+
+		for key, value := range part.Metadata.System {
+			data.Metadata.System[key] = value
+		}
+	}
+
+	return data, nil
+}
+
+// isPreferredProcess returns whether the process a from the partition of the tenant ta is
+// preferred over the same process b from the partition of the tenant tb. A process in the
+// partition of its own tenant is preferred, otherwise the process with the higher version.
+func isPreferredProcess(a *app.Process, ta string, b *app.Process, tb string) bool {
+	ownA := a.Config != nil && a.Config.Tenant == ta
+	ownB := b.Config != nil && b.Config.Tenant == tb
+
+	if ownA != ownB {
+		return ownA
+	}
+
+	return a.Version > b.Version
+}
+
+func (s *partitionedStore) Store(data StoreData) error {
+	if data.Version != version {
+		return fmt.Errorf("invalid version (have: %d, want: %d)", data.Version, version)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	parts := map[string]*StoreData{}
+
+	// Known partitions without processes are written as well, such
+	// that deleting the last process of a tenant will be persisted.
+	for tenant := range s.partitions {
+		p := NewStoreData()
+		parts[tenant] = &p
+	}
+
+	part := func(tenant string) *StoreData {
+		p, ok := parts[tenant]
+		if !ok {
+			d := NewStoreData()
+			p = &d
+			parts[tenant] = p
+		}
+
+		return p
+	}
+
+	tenants := map[string]string{}
+
+	for id, process := range data.Process {
+		tenant := ""
+		if process.Config != nil {
+			tenant = process.Config.Tenant
+		}
+
+		part(tenant).Process[id] = process
+		tenants[id] = tenant
+	}
+
+	for id, metadata := range data.Metadata.Process {
+		part(tenants[id]).Metadata.Process[id] = metadata
+	}
+
+	part("").Metadata.System = data.Metadata.System
+
+	// The partitions that remove processes are written last, such that a process
+	// that moved to another partition is not lost if writing is interrupted. It
+	// will be stored in both partitions instead, which is resolved by Load.
+	adding := []string{}
+	removing := []string{}
+	hashes := map[string][sha256.Size]byte{}
+
+	for tenant, pdata := range parts {
+		hash, err := hashStoreData(*pdata)
+		if err != nil {
+			return fmt.Errorf("failed to store data: %w", err)
+		}
+
+		if s.hashes[tenant] == hash {
+			continue
+		}
+
+		hashes[tenant] = hash
+
+		removes := false
+		for id := range s.ids[tenant] {
+			if _, ok := pdata.Process[id]; !ok {
+				removes = true
+				break
+			}
+		}
+
+		if removes {
+			removing = append(removing, tenant)
+		} else {
+			adding = append(adding, tenant)
+		}
+	}
+
+	sort.Strings(adding)
+	sort.Strings(removing)
+
+	for _, tenant := range append(adding, removing...) {
+		pdata := parts[tenant]
+
+		p, err := s.partition(tenant)
+		if err != nil {
+			return fmt.Errorf("failed to store data: %w", err)
+		}
+
+		if err := p.store(p.filepath, *pdata); err != nil {
+			return fmt.Errorf("failed to store data of the tenant '%s': %w", tenant, err)
+		}
+
+		ids := map[string]struct{}{}
+		for id := range pdata.Process {
+			ids[id] = struct{}{}
+		}
+
+		s.ids[tenant] = ids
+		s.hashes[tenant] = hashes[tenant]
+	}
+
+	return nil
+}
+
+// hashStoreData returns the hash of the JSON representation of the data.
+func hashStoreData(data StoreData) ([sha256.Size]byte, error) {
+	jsondata, err := gojson.Marshal(&data)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+
+	return sha256.Sum256(jsondata), nil
+}
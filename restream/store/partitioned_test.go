@@ -0,0 +1,207 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/datarhei/core/v16/io/fs"
+	"github.com/datarhei/core/v16/restream/app"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitioned(t *testing.T) {
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	store, err := NewPartitioned(PartitionedConfig{
+		Filesystem: memfs,
+		Filepath:   "/data/db.json",
+	})
+	require.NoError(t, err)
+
+	data := NewStoreData()
+
+	for id, tenant := range map[string]string{"process1": "", "process2": "tenant1", "process3": "tenant2", "process4": "tenant2"} {
+		data.Process[id] = &app.Process{
+			ID:     id,
+			Config: &app.Config{ID: id, Tenant: tenant},
+		}
+		data.Metadata.Process[id] = map[string]interface{}{"tenant": tenant}
+	}
+
+	data.Metadata.System["foo"] = "bar"
+
+	err = store.Store(data)
+	require.NoError(t, err)
+
+	files := []string{}
+	for _, f := range memfs.List("/data", "") {
+		files = append(files, f.Name())
+	}
+
+	require.ElementsMatch(t, []string{"/data/db.json", "/data/db.tenant1.json", "/data/db.tenant2.json"}, files)
+
+	// Each partition contains only the processes of its tenant
+	for path, ids := range map[string][]string{"/data/db.json": {"process1"}, "/data/db.tenant1.json": {"process2"}, "/data/db.tenant2.json": {"process3", "process4"}} {
+		js, err := NewJSON(JSONConfig{
+			Filesystem: memfs,
+			Filepath:   path,
+		})
+		require.NoError(t, err)
+
+		part, err := js.Load()
+		require.NoError(t, err)
+
+		partids := []string{}
+		for id := range part.Process {
+			partids = append(partids, id)
+		}
+
+		require.ElementsMatch(t, ids, partids, path)
+		require.Equal(t, len(ids), len(part.Metadata.Process), path)
+	}
+
+	// A change of one tenant writes only its partition
+	info1, err := memfs.Stat("/data/db.tenant1.json")
+	require.NoError(t, err)
+
+	delete(data.Process, "process3")
+	delete(data.Metadata.Process, "process3")
+
+	err = store.Store(data)
+	require.NoError(t, err)
+
+	info, err := memfs.Stat("/data/db.tenant1.json")
+	require.NoError(t, err)
+	require.Equal(t, info1.ModTime(), info.ModTime())
+
+	// Loading sees the processes of all partitions
+	store, err = NewPartitioned(PartitionedConfig{
+		Filesystem: memfs,
+		Filepath:   "/data/db.json",
+	})
+	require.NoError(t, err)
+
+	loaded, err := store.Load()
+	require.NoError(t, err)
+
+	require.Equal(t, 3, len(loaded.Process))
+	require.Equal(t, "tenant2", loaded.Process["process4"].Config.Tenant)
+	require.Equal(t, map[string]interface{}{"tenant": "tenant1"}, loaded.Metadata.Process["process2"])
+	require.Equal(t, map[string]interface{}{"foo": "bar"}, loaded.Metadata.System)
+
+	data.Process["process5"] = &app.Process{
+		ID:     "process5",
+		Config: &app.Config{ID: "process5", Tenant: "../tenant"},
+	}
+
+	err = store.Store(data)
+	require.Error(t, err)
+}
+
+// failingFilesystem fails to write the file at the given path
+type failingFilesystem struct {
+	fs.Filesystem
+
+	path string
+}
+
+func (f *failingFilesystem) WriteFileSafe(path string, data []byte) (int64, bool, error) {
+	if path == f.path {
+		return 0, false, fmt.Errorf("failed to write %s", path)
+	}
+
+	return f.Filesystem.WriteFileSafe(path, data)
+}
+
+func TestPartitionedMove(t *testing.T) {
+	memfs, err := fs.NewMemFilesystem(fs.MemConfig{})
+	require.NoError(t, err)
+
+	failfs := &failingFilesystem{
+		Filesystem: memfs,
+	}
+
+	store, err := NewPartitioned(PartitionedConfig{
+		Filesystem: failfs,
+		Filepath:   "/data/db.json",
+	})
+	require.NoError(t, err)
+
+	data := NewStoreData()
+	data.Process["process1"] = &app.Process{
+		ID:      "process1",
+		Config:  &app.Config{ID: "process1", Tenant: "tenant1"},
+		Version: 1,
+	}
+
+	err = store.Store(data)
+	require.NoError(t, err)
+
+	// The partition that loses the process is written after the partition that gains it
+	failfs.path = "/data/db.tenant1.json"
+
+	data.Process["process1"] = &app.Process{
+		ID:      "process1",
+		Config:  &app.Config{ID: "process1", Tenant: "tenant2"},
+		Version: 2,
+	}
+
+	err = store.Store(data)
+	require.Error(t, err)
+
+	_, err = memfs.Stat("/data/db.tenant2.json")
+	require.NoError(t, err)
+
+	// The process is in both partitions, the one in the partition of its tenant is kept
+	store, err = NewPartitioned(PartitionedConfig{
+		Filesystem: memfs,
+		Filepath:   "/data/db.json",
+	})
+	require.NoError(t, err)
+
+	loaded, err := store.Load()
+	require.NoError(t, err)
+
+	require.Equal(t, 1, len(loaded.Process))
+	require.Equal(t, "tenant2", loaded.Process["process1"].Config.Tenant)
+
+	// Storing the loaded data removes the process from the other partition
+	err = store.Store(loaded)
+	require.NoError(t, err)
+
+	js, err := NewJSON(JSONConfig{
+		Filesystem: memfs,
+		Filepath:   "/data/db.tenant1.json",
+	})
+	require.NoError(t, err)
+
+	part, err := js.Load()
+	require.NoError(t, err)
+	require.Equal(t, 0, len(part.Process))
+
+	// Otherwise the process with the higher version is kept
+	for path, version := range map[string]uint64{"/data/db.tenant1.json": 3, "/data/db.tenant2.json": 4} {
+		js, err := NewJSON(JSONConfig{
+			Filesystem: memfs,
+			Filepath:   path,
+		})
+		require.NoError(t, err)
+
+		part := NewStoreData()
+		part.Process["process1"] = &app.Process{
+			ID:      "process1",
+			Config:  &app.Config{ID: "process1", Tenant: "tenant3"},
+			Version: version,
+		}
+
+		err = js.Store(part)
+		require.NoError(t, err)
+	}
+
+	loaded, err = store.Load()
+	require.NoError(t, err)
+
+	require.Equal(t, 1, len(loaded.Process))
+	require.Equal(t, uint64(4), loaded.Process["process1"].Version)
+}
@@ -7,3 +7,11 @@ type Store interface {
 	// Save data to the store
 	Store(data StoreData) error
 }
+
+// Watcher is implemented by stores that are able to detect
+// changes of the data by other writers.
+type Watcher interface {
+	// Changed returns whether the data has been changed by another
+	// writer since it has been loaded or stored the last time.
+	Changed() (bool, error)
+}
@@ -0,0 +1,419 @@
+package restream
+
+import (
+	"fmt"
+	gonet "net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datarhei/core/v16/ffmpeg"
+	"github.com/datarhei/core/v16/ffmpeg/parse"
+	"github.com/datarhei/core/v16/process"
+	"github.com/datarhei/core/v16/restream/app"
+)
+
+// Policies for a failing branch of the tee muxer. "ignore" and "abort" are handled
+// by ffmpeg itself. ffmpeg can't restart a single branch of a running tee muxer, so a
+// branch with "restart_branch" is sent to a local UDP port instead and a separate
+// process relays it to its actual address. This relay is restarted on its own if it
+// fails, while the other branches continue.
+const (
+	teeOnFailIgnore        = "ignore"
+	teeOnFailAbort         = "abort"
+	teeOnFailRestartBranch = "restart_branch"
+)
+
+// Options of a tee branch that are applied before the branch is sent to the relay.
+var teeSourceOptions = map[string]bool{
+	"select":       true,
+	"bsfs":         true,
+	"use_fifo":     true,
+	"fifo_options": true,
+}
+
+var reTeeOptions = regexp.MustCompile(`^\[[^\]]*\]`)
+
+// isTeeAddress returns whether the address is meant for the tee muxer.
+func isTeeAddress(address string) bool {
+	return strings.Contains(address, "|") || strings.HasPrefix(address, "[")
+}
+
+// teeOption returns the value of an option from the slave options
+// of a tee branch, e.g. "[f=flv:onfail=ignore]".
+func teeOption(options, key string) (string, bool) {
+	options = strings.TrimSuffix(strings.TrimPrefix(options, "["), "]")
+
+	for _, o := range strings.Split(options, ":") {
+		k, v, _ := strings.Cut(o, "=")
+		if k == key {
+			return v, true
+		}
+	}
+
+	return "", false
+}
+
+// splitTeeOptions splits the slave options of a tee branch into the key-value pairs.
+func splitTeeOptions(options string) [][2]string {
+	options = strings.TrimSuffix(strings.TrimPrefix(options, "["), "]")
+
+	pairs := [][2]string{}
+
+	for _, o := range strings.Split(options, ":") {
+		if len(o) == 0 {
+			continue
+		}
+
+		k, v, _ := strings.Cut(o, "=")
+		pairs = append(pairs, [2]string{k, v})
+	}
+
+	return pairs
+}
+
+// validateTeeOnFail checks the onfail option of a tee branch.
+func validateTeeOnFail(options string) error {
+	onfail, ok := teeOption(options, "onfail")
+	if !ok {
+		return nil
+	}
+
+	switch onfail {
+	case teeOnFailIgnore, teeOnFailAbort, teeOnFailRestartBranch:
+	default:
+		return fmt.Errorf("invalid onfail policy '%s', allowed are: %s, %s, %s", onfail, teeOnFailIgnore, teeOnFailAbort, teeOnFailRestartBranch)
+	}
+
+	return nil
+}
+
+// setTeeBranches collects the branches of all outputs that are using the tee muxer. The
+// branches with the "restart_branch" policy are replaced by a branch to a local UDP port
+// and a relay is created for each of them. The relays of a previous config are stopped.
+func (r *restream) setTeeBranches(t *task) error {
+	t.tee.stopRelays()
+
+	t.tee = &teeState{}
+
+	for i, output := range t.config.Output {
+		if !isTeeAddress(output.Address) {
+			continue
+		}
+
+		addresses := strings.Split(output.Address, "|")
+
+		for j, a := range addresses {
+			options := reTeeOptions.FindString(a)
+			address := reTeeOptions.ReplaceAllString(a, "")
+
+			onfail, ok := teeOption(options, "onfail")
+			if !ok {
+				onfail = teeOnFailIgnore
+			}
+
+			t.tee.branches = append(t.tee.branches, app.TeeBranchState{
+				OutputID: output.ID,
+				Index:    j,
+				Address:  address,
+				OnFail:   onfail,
+				State:    "ok",
+			})
+
+			if onfail != teeOnFailRestartBranch {
+				continue
+			}
+
+			branch, err := r.newTeeRelay(t, len(t.tee.branches)-1, options, address)
+			if err != nil {
+				return fmt.Errorf("output '%s': branch %d: %w", output.ID, j, err)
+			}
+
+			addresses[j] = branch
+		}
+
+		output.Address = strings.Join(addresses, "|")
+		t.config.Output[i] = output
+	}
+
+	return nil
+}
+
+// teeRelay relays a branch with the "restart_branch" policy from a local UDP port to
+// its actual address.
+type teeRelay struct {
+	index   int // Index of the branch in the state of the tee branches
+	process process.Process
+}
+
+// newTeeRelay creates the relay for a branch of the tee muxer. It returns the branch
+// that the tee muxer writes to instead. The options that select and filter the streams
+// are kept for the tee muxer, the others, e.g. the format, are used for the relay.
+func (r *restream) newTeeRelay(t *task, index int, options, address string) (string, error) {
+	port, err := freeUDPPort()
+	if err != nil {
+		return "", err
+	}
+
+	local := "udp://127.0.0.1:" + strconv.Itoa(port)
+
+	source := []string{}
+	command := []string{"-f", "mpegts", "-i", local + "?overrun_nonfatal=1&fifo_size=50000", "-map", "0", "-codec", "copy"}
+
+	for _, o := range splitTeeOptions(options) {
+		switch {
+		case o[0] == "onfail":
+		case teeSourceOptions[o[0]]:
+			source = append(source, o[0]+"="+o[1])
+		default:
+			command = append(command, "-"+o[0], o[1])
+		}
+	}
+
+	command = append(command, address)
+	source = append(source, "f=mpegts", "onfail="+teeOnFailIgnore)
+
+	delay := time.Duration(t.config.ReconnectDelay) * time.Second
+	if delay == 0 {
+		delay = time.Second
+	}
+
+	relay := &teeRelay{
+		index: index,
+	}
+
+	relay.process, err = t.binary.New(ffmpeg.ProcessConfig{
+		Reconnect:      true,
+		ReconnectDelay: delay,
+		Command:        command,
+		Environment:    t.config.Environment,
+		WorkingDir:     t.config.WorkingDir,
+		Logger:         t.logger.WithField("branch", index),
+		OnStateChange:  t.tee.onRelayStateChange(relay),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	t.tee.relays = append(t.tee.relays, relay)
+
+	return "[" + strings.Join(source, ":") + "]" + local + "?pkt_size=1316", nil
+}
+
+// freeUDPPort returns a UDP port on the loopback interface that is currently not in use.
+func freeUDPPort() (int, error) {
+	conn, err := gonet.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+
+	defer conn.Close()
+
+	return conn.LocalAddr().(*gonet.UDPAddr).Port, nil
+}
+
+// teeState holds the current state of all tee branches of a process.
+type teeState struct {
+	branches []app.TeeBranchState
+	relays   []*teeRelay
+	running  bool // Whether the relays should be running
+	lock     sync.RWMutex
+}
+
+// startRelays starts the relays of the branches with the "restart_branch" policy.
+func (s *teeState) startRelays() {
+	if s == nil || len(s.relays) == 0 {
+		return
+	}
+
+	s.lock.Lock()
+	s.running = true
+	s.lock.Unlock()
+
+	for _, relay := range s.relays {
+		relay.process.Start()
+	}
+}
+
+// stopRelays stops the relays of the branches with the "restart_branch" policy.
+func (s *teeState) stopRelays() {
+	if s == nil || len(s.relays) == 0 {
+		return
+	}
+
+	s.lock.Lock()
+	s.running = false
+	s.lock.Unlock()
+
+	for _, relay := range s.relays {
+		relay.process.Stop(false)
+	}
+}
+
+// relaysRunning returns whether the relays should be running.
+func (s *teeState) relaysRunning() bool {
+	if s == nil {
+		return false
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.running
+}
+
+// onRelayStateChange returns the callback for the state changes of the process of a relay,
+// in order to reflect them in the state of its branch. A relay that exits while it should be
+// running has failed and will be restarted.
+func (s *teeState) onRelayStateChange(relay *teeRelay) func(from, to string) {
+	return func(from, to string) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		if !s.running {
+			return
+		}
+
+		branch := &s.branches[relay.index]
+
+		switch to {
+		case "running":
+			branch.State = "ok"
+			branch.Error = ""
+		case "failed", "killed", "finished":
+			branch.State = "failed"
+			branch.Error = "relay exited with code " + strconv.Itoa(relay.process.Status().ExitCode) + ", restarting"
+			branch.Failures++
+		}
+	}
+}
+
+// Branches returns a copy of the current state of the tee branches.
+func (s *teeState) Branches() []app.TeeBranchState {
+	if s == nil {
+		return nil
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if len(s.branches) == 0 {
+		return nil
+	}
+
+	branches := make([]app.TeeBranchState, len(s.branches))
+	copy(branches, s.branches)
+
+	return branches
+}
+
+// teeParser wraps a parser in order to detect failing branches of the tee muxer.
+type teeParser struct {
+	parse.Parser
+
+	state  *teeState
+	onFail func(branch app.TeeBranchState)
+
+	re *regexp.Regexp
+
+	// Each tee muxer has its own context address in the logs. The order of
+	// appearance corresponds to the order of the outputs using the tee muxer.
+	contexts map[string]string
+	lock     sync.Mutex
+}
+
+func newTeeParser(parser parse.Parser, state *teeState, onFail func(branch app.TeeBranchState)) parse.Parser {
+	if state == nil || len(state.branches) == 0 {
+		return parser
+	}
+
+	p := &teeParser{
+		Parser:   parser,
+		state:    state,
+		onFail:   onFail,
+		re:       regexp.MustCompile(`^\[tee @ (0x[0-9a-f]+)\] (?:\[[a-z]+\] )?Slave muxer #([0-9]+) failed(?:: (.+?), continuing with [0-9]+/[0-9]+ slaves\.|, aborting\.)`),
+		contexts: make(map[string]string),
+	}
+
+	return p
+}
+
+func (p *teeParser) Parse(line string) uint64 {
+	if matches := p.re.FindStringSubmatch(line); matches != nil {
+		p.branchFailed(matches[1], matches[2], matches[3])
+	}
+
+	return p.Parser.Parse(line)
+}
+
+func (p *teeParser) branchFailed(context, slave, reason string) {
+	index, err := strconv.Atoi(slave)
+	if err != nil {
+		return
+	}
+
+	p.lock.Lock()
+	outputid, ok := p.contexts[context]
+	if !ok {
+		outputids := []string{}
+		for _, b := range p.state.branches {
+			if len(outputids) == 0 || outputids[len(outputids)-1] != b.OutputID {
+				outputids = append(outputids, b.OutputID)
+			}
+		}
+
+		if len(p.contexts) < len(outputids) {
+			outputid = outputids[len(p.contexts)]
+			p.contexts[context] = outputid
+		}
+	}
+	p.lock.Unlock()
+
+	if len(reason) == 0 {
+		reason = "failed"
+	}
+
+	var branch *app.TeeBranchState
+
+	p.state.lock.Lock()
+	for i, b := range p.state.branches {
+		if b.OutputID != outputid || b.Index != index {
+			continue
+		}
+
+		p.state.branches[i].State = "failed"
+		p.state.branches[i].Error = reason
+		p.state.branches[i].Failures++
+
+		b := p.state.branches[i]
+		branch = &b
+
+		break
+	}
+	p.state.lock.Unlock()
+
+	if branch == nil {
+		return
+	}
+
+	if p.onFail != nil {
+		p.onFail(*branch)
+	}
+}
+
+func (p *teeParser) ResetLog() {
+	p.lock.Lock()
+	p.contexts = make(map[string]string)
+	p.lock.Unlock()
+
+	p.state.lock.Lock()
+	for i := range p.state.branches {
+		p.state.branches[i].State = "ok"
+		p.state.branches[i].Error = ""
+	}
+	p.state.lock.Unlock()
+
+	p.Parser.ResetLog()
+}
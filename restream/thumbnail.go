@@ -0,0 +1,173 @@
+package restream
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/datarhei/core/v16/ffmpeg"
+)
+
+var ErrUnknownIO = errors.New("unknown input or output")
+var ErrProcessNotRunning = errors.New("the process is not running")
+var ErrTooManyThumbnails = errors.New("too many thumbnails are being extracted, try again later")
+
+// thumbnailDefaultTimeout is the timeout for extracting a thumbnail if none is given
+const thumbnailDefaultTimeout = 10 * time.Second
+
+// ThumbnailMaxTimeout is the max. timeout for extracting a thumbnail. Longer timeouts are clamped.
+const ThumbnailMaxTimeout = 30 * time.Second
+
+// thumbnailMaxConcurrent is the max. number of thumbnails that are extracted at the same time
+const thumbnailMaxConcurrent = 2
+
+// Thumbnail extracts a single frame as JPEG from the stream of an output or an input of a running
+// process, e.g. for a preview without requiring a playout input. The stream is read by a separate
+// ffmpeg, so the output must be accessible, e.g. a file or a stream on a server. Tee outputs and
+// pipes are not supported. The address is read like an input, so it must be an allowed input
+// address. The ffmpeg counts against the max. number of processes and will be killed if it doesn't
+// finish within the timeout. If the timeout is 0, a default of 10 seconds is used, it is at most
+// ThumbnailMaxTimeout. If too many thumbnails are extracted already, ErrTooManyThumbnails is returned.
+func (r *restream) Thumbnail(id, ioid string, timeout time.Duration) ([]byte, error) {
+	if timeout <= 0 {
+		timeout = thumbnailDefaultTimeout
+	} else if timeout > ThumbnailMaxTimeout {
+		timeout = ThumbnailMaxTimeout
+	}
+
+	select {
+	case r.thumbs <- struct{}{}:
+		defer func() { <-r.thumbs }()
+	default:
+		return nil, ErrTooManyThumbnails
+	}
+
+	r.lock.Lock()
+	t, address, err := r.thumbnailSource(id, ioid)
+	if err == nil {
+		if r.maxProc > 0 && r.nProc >= r.maxProc {
+			err = fmt.Errorf("max. number of running processes (%d) reached", r.maxProc)
+		} else {
+			r.nProc++
+		}
+	}
+	r.unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		r.lock.Lock()
+		r.nProc--
+		r.startQueued()
+		r.unlock()
+	}()
+
+	address, err = r.validateInputAddress(address, "")
+	if err != nil {
+		return nil, fmt.Errorf("the address of '%s' is not allowed: %w", ioid, err)
+	}
+
+	file, err := os.CreateTemp("", "thumbnail_*.jpg")
+	if err != nil {
+		return nil, fmt.Errorf("extracting the thumbnail failed: %w", err)
+	}
+
+	file.Close()
+	defer os.Remove(file.Name())
+
+	prober := t.binary.NewProbeParser(t.logger)
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	ffmpeg, err := t.binary.New(ffmpeg.ProcessConfig{
+		Reconnect:  false,
+		Command:    []string{"-hide_banner", "-loglevel", "error", "-i", address, "-frames:v", "1", "-f", "image2", "-c:v", "mjpeg", "-y", file.Name()},
+		WorkingDir: t.config.WorkingDir,
+		Parser:     prober,
+		Logger:     t.logger,
+		OnExit: func() {
+			wg.Done()
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("extracting the thumbnail failed: %w", err)
+	}
+
+	if err := ffmpeg.Start(); err != nil {
+		return nil, fmt.Errorf("extracting the thumbnail failed: %w", err)
+	}
+
+	var timedout int32
+
+	timer := time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&timedout, 1)
+		ffmpeg.Kill(false)
+	})
+
+	wg.Wait()
+	timer.Stop()
+
+	if atomic.LoadInt32(&timedout) == 1 {
+		return nil, fmt.Errorf("extracting the thumbnail timed out after %s", timeout)
+	}
+
+	data, err := os.ReadFile(file.Name())
+	if err != nil || len(data) == 0 {
+		if lines := prober.Probe().Log; len(lines) != 0 {
+			return nil, fmt.Errorf("extracting the thumbnail failed: %s", lines[len(lines)-1])
+		}
+
+		return nil, fmt.Errorf("extracting the thumbnail failed: no frame found")
+	}
+
+	return data, nil
+}
+
+// thumbnailSource returns the task of the process and the resolved address of the output or input
+// with the given ID. Outputs are preferred. The caller must hold the lock.
+func (r *restream) thumbnailSource(id, ioid string) (*task, string, error) {
+	t, ok := r.tasks[id]
+	if !ok {
+		return nil, "", ErrUnknownProcess
+	}
+
+	if !t.valid || t.ffmpeg.Status().State != "running" {
+		return nil, "", ErrProcessNotRunning
+	}
+
+	address := ""
+
+	for _, io := range t.config.Output {
+		if io.ID == ioid {
+			address = io.Address
+			break
+		}
+	}
+
+	if len(address) == 0 {
+		for _, io := range t.config.Input {
+			if io.ID == ioid {
+				address = io.Address
+				break
+			}
+		}
+	}
+
+	if len(address) == 0 {
+		return nil, "", ErrUnknownIO
+	}
+
+	if isTeeAddress(address) || address == "-" || strings.HasPrefix(address, "pipe:") {
+		return nil, "", fmt.Errorf("the stream of '%s' is not accessible", ioid)
+	}
+
+	return t, address, nil
+}
@@ -0,0 +1,329 @@
+package restream
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/datarhei/core/v16/log"
+)
+
+// Events of a process the webhook can notify about.
+const (
+	EventCrash   = "crash"   // The process failed or has been killed while it should be running
+	EventRecover = "recover" // The process is running again after a crash or a stale timeout
+	EventStale   = "stale"   // The process gets stopped because it didn't make any progress
+	EventStart   = "start"   // The process is running
+	EventStop    = "stop"    // The process has been stopped
+	EventAdd     = "add"     // The process has been added by another writer of the store
+	EventUpdate  = "update"  // The process has been changed by another writer of the store
+	EventRemove  = "remove"  // The process has been removed by another writer of the store
+)
+
+var webhookEvents = []string{EventCrash, EventRecover, EventStale, EventStart, EventStop, EventAdd, EventUpdate, EventRemove}
+
+// WebhookConfig is the configuration for the notifications about process events.
+type WebhookConfig struct {
+	// URL the events will be POSTed to. Optional. If not provided, no notifications will be sent.
+	URL string
+
+	// Events to notify about. Optional. If not provided, all events will be sent.
+	Events []string
+
+	// Timeout for a single request. Optional. Default value 10 seconds.
+	Timeout time.Duration
+
+	// Number of additional attempts if a request fails. Optional. Default value 0.
+	Retries int
+
+	// Delay between the attempts. Optional. Default value 1 second.
+	RetryDelay time.Duration
+}
+
+// WebhookEvent is the payload of a notification about a process event.
+type WebhookEvent struct {
+	CoreID    string `json:"core_id"`
+	Event     string `json:"event"`
+	ID        string `json:"id"`
+	Reference string `json:"reference"`
+	OldState  string `json:"old_state"`
+	NewState  string `json:"new_state"`
+	Timestamp int64  `json:"timestamp"` // unix timestamp
+}
+
+// webhook sends the events one after the other in the order they occurred.
+type webhook struct {
+	url        string
+	events     map[string]struct{}
+	retries    int
+	retryDelay time.Duration
+	client     *http.Client
+	queue      chan WebhookEvent
+	done       chan struct{} // Closed in order to stop the sender
+	stopOnce   sync.Once
+	logger     log.Logger
+}
+
+func newWebhook(config WebhookConfig, logger log.Logger) (*webhook, error) {
+	if len(config.URL) == 0 {
+		return nil, nil
+	}
+
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("the URL must be an http or https URL")
+	}
+
+	w := &webhook{
+		url:        config.URL,
+		events:     map[string]struct{}{},
+		retries:    config.Retries,
+		retryDelay: config.RetryDelay,
+		queue:      make(chan WebhookEvent, 1024),
+		done:       make(chan struct{}),
+		logger:     logger,
+	}
+
+	events := config.Events
+	if len(events) == 0 {
+		events = webhookEvents
+	}
+
+	for _, e := range events {
+		known := false
+		for _, k := range webhookEvents {
+			if e == k {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			return nil, fmt.Errorf("unknown event '%s'", e)
+		}
+
+		w.events[e] = struct{}{}
+	}
+
+	if w.retries < 0 {
+		w.retries = 0
+	}
+
+	if w.retryDelay <= 0 {
+		w.retryDelay = time.Second
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	w.client = &http.Client{
+		Timeout: timeout,
+	}
+
+	go w.sender()
+
+	return w, nil
+}
+
+// notify queues the event if it matches the filter. Events will be
+// dropped if the endpoint can't keep up.
+func (w *webhook) notify(event WebhookEvent) {
+	if w == nil {
+		return
+	}
+
+	if _, ok := w.events[event.Event]; !ok {
+		return
+	}
+
+	select {
+	case w.queue <- event:
+	default:
+		w.logger.Warn().WithFields(log.Fields{
+			"id":    event.ID,
+			"event": event.Event,
+		}).Log("Dropping webhook event, too many pending events")
+	}
+}
+
+// stop stops sending the events. The events that are still pending are dropped.
+func (w *webhook) stop() {
+	if w == nil {
+		return
+	}
+
+	w.stopOnce.Do(func() {
+		close(w.done)
+	})
+}
+
+func (w *webhook) sender() {
+	for {
+		var event WebhookEvent
+
+		select {
+		case <-w.done:
+			return
+		case event = <-w.queue:
+		}
+
+		var err error
+
+		for attempt := 0; attempt <= w.retries; attempt++ {
+			if attempt != 0 {
+				time.Sleep(w.retryDelay)
+			}
+
+			if err = w.send(event); err == nil {
+				break
+			}
+		}
+
+		if err != nil {
+			w.logger.Warn().WithFields(log.Fields{
+				"id":    event.ID,
+				"event": event.Event,
+			}).WithError(err).Log("Sending webhook event failed")
+		}
+	}
+}
+
+func (w *webhook) send(event WebhookEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// processEvents derives the events of a process from its state changes.
+type processEvents struct {
+	crashed bool // Whether the process crashed or has been stale since it was last running
+	stale   bool // Whether the process is being stopped because it is stale
+	lock    sync.Mutex
+}
+
+// setStale marks the process as stale and returns the corresponding event.
+func (e *processEvents) setStale() string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.stale = true
+	e.crashed = true
+
+	return EventStale
+}
+
+// event returns the event for the change of the state, or an empty string if
+// the change is not of interest. The order is the order of the process at the
+// time of the change, i.e. "stop" if the process has been stopped deliberately.
+func (e *processEvents) event(from, to, order string) string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	switch to {
+	case "running":
+		e.stale = false
+
+		if e.crashed {
+			e.crashed = false
+			return EventRecover
+		}
+
+		return EventStart
+	case "finished", "failed", "killed":
+		if order == "stop" {
+			e.stale = false
+			e.crashed = false
+			return EventStop
+		}
+
+		if e.stale {
+			// The stale event has already been sent
+			e.stale = false
+			return ""
+		}
+
+		if to == "finished" {
+			return ""
+		}
+
+		e.crashed = true
+
+		return EventCrash
+	}
+
+	return ""
+}
+
+// onStateChange returns the callbacks for the process of the task in order to notify
+// the webhook about state changes. They are nil if no webhook is configured.
+func (r *restream) onStateChange(t *task) (func(from, to string), func()) {
+	if r.webhook == nil {
+		return nil, nil
+	}
+
+	events := &processEvents{}
+
+	notify := func(event, from, to string) {
+		r.webhook.notify(WebhookEvent{
+			CoreID:    r.id,
+			Event:     event,
+			ID:        t.id,
+			Reference: t.reference,
+			OldState:  from,
+			NewState:  to,
+			Timestamp: time.Now().Unix(),
+		})
+	}
+
+	onStateChange := func(from, to string) {
+		r.lock.RLock()
+		order := t.process.Order
+		r.lock.RUnlock()
+
+		if event := events.event(from, to, order); len(event) != 0 {
+			notify(event, from, to)
+		}
+	}
+
+	onStale := func() {
+		notify(events.setStale(), "running", "running")
+	}
+
+	return onStateChange, onStale
+}
+
+// notifyChange notifies the webhook about a process that has been added, updated,
+// or removed because of a change of the store.
+func (r *restream) notifyChange(event string, t *task) {
+	r.webhook.notify(WebhookEvent{
+		CoreID:    r.id,
+		Event:     event,
+		ID:        t.id,
+		Reference: t.reference,
+		Timestamp: time.Now().Unix(),
+	})
+}